@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"slices"
+	"sort"
+	"strings"
 )
 
 // AllocationStrategy defines how values are distributed.
@@ -24,6 +27,10 @@ const (
 
 	// StrategyRatio distributes by ratio (e.g., 2:3:5).
 	StrategyRatio
+
+	// StrategyWaterfall fills targets in order, each up to its amount,
+	// until the source is exhausted. Targets left unfilled are set to 0.
+	StrategyWaterfall
 )
 
 func (s AllocationStrategy) String() string {
@@ -38,6 +45,8 @@ func (s AllocationStrategy) String() string {
 		return "equal"
 	case StrategyRatio:
 		return "ratio"
+	case StrategyWaterfall:
+		return "waterfall"
 	default:
 		return "unknown"
 	}
@@ -56,25 +65,96 @@ func ParseAllocationStrategy(s string) (AllocationStrategy, error) {
 		return StrategyEqual, nil
 	case "ratio":
 		return StrategyRatio, nil
+	case "waterfall":
+		return StrategyWaterfall, nil
 	default:
 		return 0, fmt.Errorf("%w: unknown allocation strategy %q", ErrInvalidRule, s)
 	}
 }
 
+// RoundingMethod determines how allocations are rounded to the rule's
+// precision.
+type RoundingMethod int
+
+const (
+	// RoundLargestRemainder (the Hamilton method) floors each share to the
+	// precision and gives the leftover units, one each, to the shares with
+	// the largest fractional remainders, so the allocations sum exactly to
+	// the rounded total: 100.00 split three ways is 33.34, 33.33, 33.33.
+	RoundLargestRemainder RoundingMethod = iota
+
+	// RoundDown rounds each share on its own, half away from zero, so the
+	// allocations may not sum to the total; the difference is left for
+	// Remainder. This was the only behavior before RoundLargestRemainder.
+	RoundDown
+)
+
+func (m RoundingMethod) String() string {
+	switch m {
+	case RoundLargestRemainder:
+		return "largest_remainder"
+	case RoundDown:
+		return "round_down"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseRoundingMethod parses a string into a RoundingMethod. The empty
+// string is RoundLargestRemainder.
+func ParseRoundingMethod(s string) (RoundingMethod, error) {
+	switch s {
+	case "", "largest_remainder":
+		return RoundLargestRemainder, nil
+	case "round_down":
+		return RoundDown, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown rounding method %q", ErrInvalidRule, s)
+	}
+}
+
 // AllocationTarget specifies a single allocation destination.
 type AllocationTarget struct {
 	Key    string  // context key to set
-	Amount float64 // percentage, fixed amount, weight, or ratio (based on strategy)
+	Amount float64 // percentage, fixed amount, weight, ratio, or requested amount (based on strategy)
+
+	// AmountFrom is an optional context key whose value replaces Amount at
+	// evaluation time, such as a share written by an earlier allocation.
+	// Only the weighted and ratio strategies allow it.
+	AmountFrom string
+
+	// Min and Max bound the target's share for the percentage, weighted,
+	// ratio and equal strategies (0 = no bound). A share that would fall
+	// outside its bounds is clamped and the difference is redistributed
+	// among the other targets in proportion to their amounts.
+	Min float64
+	Max float64
+}
+
+// AllocationShare is an allocated amount together with its fraction of
+// the source, written when AllocationConfig.ShareSuffix is set.
+type AllocationShare struct {
+	Amount float64
+	Share  float64 // Amount / source; 0 if the source is 0
 }
 
 // AllocationRule distributes a value across multiple targets.
 type AllocationRule struct {
 	baseRule
-	source    string
-	strategy  AllocationStrategy
-	targets   []AllocationTarget
-	remainder string // optional: key for rounding remainder
-	precision int    // decimal precision
+	source      string
+	strategy    AllocationStrategy
+	targets     []AllocationTarget
+	remainder   string // optional: key for rounding remainder
+	target      string // optional: key for the map of all allocations
+	shareSuffix string // optional: suffix of per-target AllocationShare keys
+	weightsFrom string // optional: key for a slice of weights
+	dynamic     bool   // some amount is read from the context
+	precision   int    // decimal precision
+	rounding    RoundingMethod
+	integerOnly bool // allocate whole units only
+	drawDown    bool // write the remainder back to source
+	absorb      bool // last waterfall target takes what is left
+	bounded     bool // some target has a Min or Max
 }
 
 // AllocationConfig configures an allocation rule.
@@ -83,6 +163,7 @@ type AllocationConfig struct {
 	Name        string
 	Description string
 	Deps        []string
+	When        string // guard expression; the rule is skipped when it is false
 
 	// Source is the context key containing the value to allocate.
 	Source string
@@ -93,11 +174,50 @@ type AllocationConfig struct {
 	// Targets are the allocation destinations.
 	Targets []AllocationTarget
 
+	// WeightsFrom is an optional context key holding a slice of weights,
+	// one per target in order, that replace the targets' amounts at
+	// evaluation time. A target's AmountFrom takes precedence. Only the
+	// weighted and ratio strategies allow it.
+	WeightsFrom string
+
 	// Remainder is an optional context key for the rounding remainder.
 	Remainder string
 
+	// Target is an optional context key that also receives every
+	// allocation as a map[string]float64 keyed by target key. Each
+	// target key is still set individually.
+	Target string
+
+	// ShareSuffix, if set, also writes an AllocationShare for each
+	// target under a parallel key: the target key followed by this
+	// suffix, such as "eng_share" for target "eng" and suffix "_share".
+	ShareSuffix string
+
 	// Precision is the decimal precision (default 2).
 	Precision int
+
+	// Rounding is how shares are rounded to Precision (default
+	// RoundLargestRemainder). It is ignored when IntegerOnly is set.
+	Rounding RoundingMethod
+
+	// IntegerOnly allocates whole units. Each target is floored and the
+	// leftover units are assigned by largest remainder; any amount that
+	// cannot be assigned in whole units goes to Remainder.
+	IntegerOnly bool
+
+	// AbsorbRemainder gives the last target of a waterfall allocation
+	// whatever is left after the others are filled, regardless of its
+	// Amount.
+	AbsorbRemainder bool
+
+	// WriteRemainderToSource sets the source key to what is left after
+	// distributing (zero if fully allocated), so a later allocation on
+	// the same source continues from the leftover.
+	WriteRemainderToSource bool
+
+	// RequiredTargets lists keys that must be among the targets, such as
+	// mandatory accounts. NewAllocation fails if any is missing.
+	RequiredTargets []string
 }
 
 // NewAllocation creates a new allocation rule.
@@ -125,7 +245,7 @@ func NewAllocation(cfg AllocationConfig) (*AllocationRule, error) {
 	case StrategyEqual:
 		// No amounts needed
 	default:
-		// Weighted, Ratio, Fixed: amounts should be positive
+		// Weighted, Ratio, Fixed, Waterfall: amounts should be positive
 		for _, t := range cfg.Targets {
 			if t.Amount < 0 {
 				return nil, fmt.Errorf("%w: allocation rule %q has negative amount for %q", ErrInvalidRule, cfg.ID, t.Key)
@@ -133,10 +253,48 @@ func NewAllocation(cfg AllocationConfig) (*AllocationRule, error) {
 		}
 	}
 
+	dynamic := cfg.WeightsFrom != ""
+	for _, t := range cfg.Targets {
+		dynamic = dynamic || t.AmountFrom != ""
+	}
+	if dynamic && cfg.Strategy != StrategyWeighted && cfg.Strategy != StrategyRatio {
+		return nil, fmt.Errorf("%w: allocation rule %q: weights from context require the weighted or ratio strategy", ErrInvalidRule, cfg.ID)
+	}
+
+	if cfg.AbsorbRemainder && cfg.Strategy != StrategyWaterfall {
+		return nil, fmt.Errorf("%w: allocation rule %q: absorb remainder requires the waterfall strategy", ErrInvalidRule, cfg.ID)
+	}
+
+	if missing := missingTargets(cfg.Targets, cfg.RequiredTargets); len(missing) > 0 {
+		return nil, fmt.Errorf("%w: allocation rule %q is missing required targets %s", ErrInvalidRule, cfg.ID, strings.Join(missing, ", "))
+	}
+
+	bounded := false
+	for _, t := range cfg.Targets {
+		if t.Min == 0 && t.Max == 0 {
+			continue
+		}
+		bounded = true
+		if cfg.Strategy == StrategyFixed || cfg.Strategy == StrategyWaterfall {
+			return nil, fmt.Errorf("%w: allocation rule %q: %s allocations cannot have bounds (%q)", ErrInvalidRule, cfg.ID, cfg.Strategy, t.Key)
+		}
+		if t.Min < 0 || t.Max < 0 || (t.Max > 0 && t.Min > t.Max) {
+			return nil, fmt.Errorf("%w: allocation rule %q has invalid bounds for %q", ErrInvalidRule, cfg.ID, t.Key)
+		}
+	}
+
 	precision := cfg.Precision
 	if precision <= 0 {
 		precision = 2
 	}
+	if cfg.Rounding < RoundLargestRemainder || cfg.Rounding > RoundDown {
+		return nil, fmt.Errorf("%w: allocation rule %q has unknown rounding method %d", ErrInvalidRule, cfg.ID, cfg.Rounding)
+	}
+
+	when, err := compileWhen(cfg.ID, cfg.When)
+	if err != nil {
+		return nil, err
+	}
 
 	return &AllocationRule{
 		baseRule: baseRule{
@@ -144,12 +302,22 @@ func NewAllocation(cfg AllocationConfig) (*AllocationRule, error) {
 			name:        cfg.Name,
 			description: cfg.Description,
 			deps:        cfg.Deps,
+			when:        when,
 		},
-		source:    cfg.Source,
-		strategy:  cfg.Strategy,
-		targets:   cfg.Targets,
-		remainder: cfg.Remainder,
-		precision: precision,
+		source:      cfg.Source,
+		strategy:    cfg.Strategy,
+		targets:     cfg.Targets,
+		remainder:   cfg.Remainder,
+		target:      cfg.Target,
+		shareSuffix: cfg.ShareSuffix,
+		weightsFrom: cfg.WeightsFrom,
+		dynamic:     dynamic,
+		precision:   precision,
+		rounding:    cfg.Rounding,
+		integerOnly: cfg.IntegerOnly,
+		drawDown:    cfg.WriteRemainderToSource,
+		absorb:      cfg.AbsorbRemainder,
+		bounded:     bounded,
 	}, nil
 }
 
@@ -169,81 +337,320 @@ func (r *AllocationRule) Evaluate(ctx context.Context, evalCtx *EvalContext) err
 		return NewRuleError(r.id, string(RuleTypeAllocation), "evaluate", err)
 	}
 
-	allocations, remainder := r.calculate(source)
+	targets, err := r.resolveTargets(evalCtx)
+	if err != nil {
+		return NewRuleError(r.id, string(RuleTypeAllocation), "evaluate", err)
+	}
+
+	allocations, remainder, err := r.calculate(source, targets)
+	if err != nil {
+		return NewRuleError(r.id, string(RuleTypeAllocation), "evaluate", err)
+	}
 
 	for i, t := range r.targets {
 		evalCtx.Set(t.Key, allocations[i])
 	}
 
+	if r.target != "" {
+		byKey := make(map[string]float64, len(r.targets))
+		for i, t := range r.targets {
+			byKey[t.Key] = allocations[i]
+		}
+		evalCtx.Set(r.target, byKey)
+	}
+
+	if r.shareSuffix != "" {
+		for i, t := range r.targets {
+			share := AllocationShare{Amount: allocations[i]}
+			if source != 0 {
+				share.Share = allocations[i] / source
+			}
+			evalCtx.Set(t.Key+r.shareSuffix, share)
+		}
+	}
+
 	if r.remainder != "" && remainder != 0 {
 		evalCtx.Set(r.remainder, remainder)
 	}
 
+	if r.drawDown {
+		evalCtx.Set(r.source, r.round(remainder))
+	}
+
 	return nil
 }
 
-func (r *AllocationRule) calculate(source float64) ([]float64, float64) {
-	n := len(r.targets)
-	allocations := make([]float64, n)
+// resolveTargets returns the targets with amounts read from WeightsFrom
+// and AmountFrom applied.
+func (r *AllocationRule) resolveTargets(evalCtx *EvalContext) ([]AllocationTarget, error) {
+	if !r.dynamic {
+		return r.targets, nil
+	}
+
+	targets := slices.Clone(r.targets)
+	if r.weightsFrom != "" {
+		v, ok := evalCtx.Get(r.weightsFrom)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrValueNotFound, r.weightsFrom)
+		}
+		weights, err := toFloat64Slice(v)
+		if err != nil {
+			return nil, fmt.Errorf("weights %q: %w", r.weightsFrom, err)
+		}
+		if len(weights) != len(targets) {
+			return nil, fmt.Errorf("%w: weights %q has %d elements for %d targets", ErrTypeMismatch, r.weightsFrom, len(weights), len(targets))
+		}
+		for i := range targets {
+			targets[i].Amount = weights[i]
+		}
+	}
+
+	for i, t := range targets {
+		if t.AmountFrom != "" {
+			amount, err := evalCtx.GetFloat64(t.AmountFrom)
+			if err != nil {
+				return nil, err
+			}
+			targets[i].Amount = amount
+		}
+		if targets[i].Amount < 0 {
+			return nil, fmt.Errorf("%w: negative weight %v for %q", ErrEvaluation, targets[i].Amount, t.Key)
+		}
+	}
+	return targets, nil
+}
+
+func (r *AllocationRule) calculate(source float64, targets []AllocationTarget) ([]float64, float64, error) {
+	shares, err := r.shares(source, targets)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch {
+	case r.integerOnly:
+		allocations, remainder := allocateUnits(source, shares, 1, true)
+		return allocations, remainder, nil
+	case r.rounding == RoundLargestRemainder:
+		allocations, remainder := allocateUnits(source, shares, math.Pow(10, float64(r.precision)), false)
+		return allocations, remainder, nil
+	}
+
+	var total float64
+	for i := range shares {
+		shares[i] = r.round(shares[i])
+		total += shares[i]
+	}
+	return shares, source - total, nil
+}
+
+// shares returns the unrounded allocation for each target.
+func (r *AllocationRule) shares(source float64, targets []AllocationTarget) ([]float64, error) {
+	n := len(targets)
+	shares := make([]float64, n)
+
+	if r.bounded {
+		weights := make([]float64, n)
+		for i, t := range targets {
+			weights[i] = t.Amount
+			if r.strategy == StrategyEqual {
+				weights[i] = 1
+			}
+		}
+		return boundedShares(source, targets, weights)
+	}
 
 	switch r.strategy {
 	case StrategyPercentage:
-		var total float64
-		for i, t := range r.targets {
-			allocations[i] = r.round(source * t.Amount / 100)
-			total += allocations[i]
+		for i, t := range targets {
+			shares[i] = source * t.Amount / 100
 		}
-		return allocations, source - total
 
 	case StrategyFixed:
-		var total float64
-		for i, t := range r.targets {
-			allocations[i] = r.round(t.Amount)
-			total += allocations[i]
+		for i, t := range targets {
+			shares[i] = t.Amount
 		}
-		return allocations, source - total
 
-	case StrategyWeighted:
+	case StrategyWeighted, StrategyRatio:
 		var totalWeight float64
-		for _, t := range r.targets {
+		for _, t := range targets {
 			totalWeight += t.Amount
 		}
 		if totalWeight == 0 {
-			return allocations, source
+			return shares, nil
 		}
-		var total float64
-		for i, t := range r.targets {
-			allocations[i] = r.round(source * t.Amount / totalWeight)
-			total += allocations[i]
+		for i, t := range targets {
+			shares[i] = source * t.Amount / totalWeight
 		}
-		return allocations, source - total
 
 	case StrategyEqual:
-		each := r.round(source / float64(n))
-		var total float64
-		for i := range r.targets {
-			allocations[i] = each
-			total += each
+		for i := range targets {
+			shares[i] = source / float64(n)
 		}
-		return allocations, source - total
 
-	case StrategyRatio:
-		var totalRatio float64
-		for _, t := range r.targets {
-			totalRatio += t.Amount
+	case StrategyWaterfall:
+		left := math.Max(source, 0)
+		for i, t := range targets {
+			shares[i] = math.Min(t.Amount, left)
+			if r.absorb && i == n-1 {
+				shares[i] = left
+			}
+			left -= shares[i]
 		}
-		if totalRatio == 0 {
-			return allocations, source
+	}
+
+	return shares, nil
+}
+
+// missingTargets returns the required keys that no target writes, in the
+// order they are required.
+func missingTargets(targets []AllocationTarget, required []string) []string {
+	var missing []string
+	for _, key := range required {
+		found := false
+		for _, t := range targets {
+			if t.Key == key {
+				found = true
+				break
+			}
 		}
-		var total float64
-		for i, t := range r.targets {
-			allocations[i] = r.round(source * t.Amount / totalRatio)
-			total += allocations[i]
+		if !found {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// boundedShares distributes source in proportion to weights while keeping
+// each share within its target's Min and Max. Each round computes the
+// proportional shares of what is left among the unfixed targets; if
+// clamping them would add to the total, the targets below their Min are
+// fixed there, and if it would take from the total, those above their
+// Max are fixed there. This repeats until no unfixed share is clamped.
+func boundedShares(source float64, targets []AllocationTarget, weights []float64) ([]float64, error) {
+	var floors, caps float64
+	uncapped := false
+	for _, t := range targets {
+		floors += t.Min
+		caps += t.Max
+		uncapped = uncapped || t.Max == 0
+	}
+	if source < floors {
+		return nil, fmt.Errorf("%w: minimums total %v, more than %v to allocate", ErrAllocationInfeasible, floors, source)
+	}
+	if !uncapped && source > caps {
+		return nil, fmt.Errorf("%w: maximums total %v, less than %v to allocate", ErrAllocationInfeasible, caps, source)
+	}
+
+	clamp := func(i int, s float64) float64 {
+		t := targets[i]
+		if t.Max > 0 && s > t.Max {
+			return t.Max
+		}
+		return math.Max(s, t.Min)
+	}
+
+	shares := make([]float64, len(weights))
+	fixed := make([]bool, len(weights))
+	remaining := source
+	for {
+		var weight float64
+		for i, w := range weights {
+			if !fixed[i] {
+				weight += w
+			}
+		}
+
+		var violation float64
+		for i, w := range weights {
+			if fixed[i] {
+				continue
+			}
+			shares[i] = 0
+			if weight > 0 {
+				shares[i] = remaining * w / weight
+			}
+			violation += clamp(i, shares[i]) - shares[i]
+		}
+		if violation == 0 {
+			return shares, nil
+		}
+
+		for i := range weights {
+			if fixed[i] {
+				continue
+			}
+			c := clamp(i, shares[i])
+			if (violation > 0 && c > shares[i]) || (violation < 0 && c < shares[i]) {
+				shares[i] = c
+				fixed[i] = true
+				remaining -= c
+			}
+		}
+	}
+}
+
+// allocateUnits rounds shares to multiples of 1/scale by largest
+// remainder: each share is floored to whole units and the leftover units
+// go, one each, to the shares with the largest fractional remainders, so
+// the allocations add up to the shares' rounded total. With capped, no
+// more units are handed out than source holds. Whatever of source is not
+// allocated is returned as the remainder.
+func allocateUnits(source float64, shares []float64, scale float64, capped bool) ([]float64, float64) {
+	var sum float64
+	for _, s := range shares {
+		sum += s
+	}
+	sign := 1.0
+	if (capped && source < 0) || (!capped && sum < 0) {
+		sign = -1
+	}
+
+	units := make([]float64, len(shares))
+	fractions := make([]float64, len(shares))
+	var assigned, wanted float64
+	for i, s := range shares {
+		u := snapUnits(s * sign * scale)
+		units[i] = math.Floor(u)
+		fractions[i] = u - units[i]
+		assigned += units[i]
+		wanted += u
+	}
+
+	sourceUnits := snapUnits(source * sign * scale)
+	total := math.Round(wanted)
+	if capped {
+		total = math.Min(math.Floor(sourceUnits), total)
+	}
+	leftover := int(total - assigned)
+
+	order := make([]int, len(shares))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return fractions[order[a]] > fractions[order[b]]
+	})
+
+	for _, i := range order {
+		if leftover <= 0 || fractions[i] <= 0 {
+			break
 		}
-		return allocations, source - total
+		units[i]++
+		assigned++
+		leftover--
 	}
 
-	return allocations, 0
+	allocations := make([]float64, len(shares))
+	for i, u := range units {
+		allocations[i] = sign * u / scale
+	}
+	return allocations, sign * (sourceUnits - assigned) / scale
+}
+
+// snapUnits removes floating-point noise from a value in allocation units,
+// so that 0.29 * 100 floors to 29 rather than 28.
+func snapUnits(u float64) float64 {
+	return math.Round(u*1e6) / 1e6
 }
 
 func (r *AllocationRule) round(v float64) float64 {
@@ -265,3 +672,54 @@ func (r *AllocationRule) Strategy() AllocationStrategy {
 func (r *AllocationRule) Targets() []AllocationTarget {
 	return r.targets
 }
+
+// AmountsToPercentages converts fixed amounts to the percentages of source
+// they represent, for use with StrategyPercentage. A zero source yields
+// zero percentages.
+func AmountsToPercentages(source float64, targets []AllocationTarget) []AllocationTarget {
+	out := make([]AllocationTarget, len(targets))
+	for i, t := range targets {
+		out[i] = AllocationTarget{Key: t.Key}
+		if source != 0 {
+			out[i].Amount = t.Amount / source * 100
+		}
+	}
+	return out
+}
+
+// PercentagesToAmounts converts percentages to the fixed amounts they
+// represent of source, for use with StrategyFixed.
+func PercentagesToAmounts(source float64, targets []AllocationTarget) []AllocationTarget {
+	out := make([]AllocationTarget, len(targets))
+	for i, t := range targets {
+		out[i] = AllocationTarget{Key: t.Key, Amount: source * t.Amount / 100}
+	}
+	return out
+}
+
+// PercentagesToWeights converts percentages to weights that sum to 1,
+// for use with StrategyWeighted.
+func PercentagesToWeights(targets []AllocationTarget) []AllocationTarget {
+	out := make([]AllocationTarget, len(targets))
+	for i, t := range targets {
+		out[i] = AllocationTarget{Key: t.Key, Amount: t.Amount / 100}
+	}
+	return out
+}
+
+// WeightsToPercentages converts relative weights (or ratios) to
+// percentages that sum to 100. All-zero weights yield zero percentages.
+func WeightsToPercentages(targets []AllocationTarget) []AllocationTarget {
+	var total float64
+	for _, t := range targets {
+		total += t.Amount
+	}
+	out := make([]AllocationTarget, len(targets))
+	for i, t := range targets {
+		out[i] = AllocationTarget{Key: t.Key}
+		if total != 0 {
+			out[i].Amount = t.Amount / total * 100
+		}
+	}
+	return out
+}
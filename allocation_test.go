@@ -3,6 +3,8 @@ package cortex_test
 import (
 	"context"
 	"errors"
+	"math"
+	"strings"
 	"testing"
 
 	"github.com/kolosys/cortex"
@@ -178,6 +180,61 @@ func TestAllocationRatio(t *testing.T) {
 	}
 }
 
+func TestAllocationWaterfall(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    float64
+		absorb    bool
+		expected  []float64
+		remainder float64
+	}{
+		{"all filled", 100, false, []float64{50, 30, 10}, 10},
+		{"partially filled", 65, false, []float64{50, 15, 0}, 0},
+		{"exhausted by first", 40, false, []float64{40, 0, 0}, 0},
+		{"zero source", 0, false, []float64{0, 0, 0}, 0},
+		{"last absorbs remainder", 100, true, []float64{50, 30, 20}, 0},
+		{"last absorbs shortfall", 65, true, []float64{50, 15, 0}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets := []cortex.AllocationTarget{{Key: "a", Amount: 50}, {Key: "b", Amount: 30}, {Key: "c", Amount: 10}}
+			rule := cortex.MustAllocation(cortex.AllocationConfig{
+				ID:              "alloc",
+				Source:          "total",
+				Strategy:        cortex.StrategyWaterfall,
+				Targets:         targets,
+				Remainder:       "leftover",
+				AbsorbRemainder: tt.absorb,
+			})
+
+			evalCtx := cortex.NewEvalContext()
+			evalCtx.Set("total", tt.source)
+			if err := rule.Evaluate(context.Background(), evalCtx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for i, want := range tt.expected {
+				got, err := evalCtx.GetFloat64(targets[i].Key)
+				if err != nil || got != want {
+					t.Errorf("expected %s=%v, got %v (%v)", targets[i].Key, want, got, err)
+				}
+			}
+			leftover, _ := evalCtx.GetFloat64("leftover")
+			if leftover != tt.remainder {
+				t.Errorf("expected leftover=%v, got %v", tt.remainder, leftover)
+			}
+		})
+	}
+
+	if _, err := cortex.NewAllocation(cortex.AllocationConfig{
+		ID: "bad", Source: "s", Strategy: cortex.StrategyEqual,
+		Targets: []cortex.AllocationTarget{{Key: "a"}}, AbsorbRemainder: true,
+	}); !errors.Is(err, cortex.ErrInvalidRule) {
+		t.Errorf("expected ErrInvalidRule for absorb remainder without waterfall, got %v", err)
+	}
+}
+
 func TestAllocationInvalidPercentage(t *testing.T) {
 	_, err := cortex.NewAllocation(cortex.AllocationConfig{
 		ID:       "alloc",
@@ -213,6 +270,414 @@ func TestAllocationMissingSource(t *testing.T) {
 	}
 }
 
+func TestAllocationIntegerOnly(t *testing.T) {
+	tests := []struct {
+		name      string
+		strategy  cortex.AllocationStrategy
+		source    float64
+		amounts   []float64
+		expected  []float64
+		remainder float64
+	}{
+		{"weighted 1:1:1", cortex.StrategyWeighted, 10, []float64{1, 1, 1}, []float64{4, 3, 3}, 0},
+		{"largest remainder wins", cortex.StrategyRatio, 10, []float64{1, 2, 3}, []float64{2, 3, 5}, 0},
+		{"fractional source", cortex.StrategyEqual, 10.5, []float64{0, 0, 0}, []float64{4, 3, 3}, 0.5},
+		{"fixed", cortex.StrategyFixed, 10, []float64{2.4, 3.6}, []float64{2, 4}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets := make([]cortex.AllocationTarget, len(tt.amounts))
+			for i, a := range tt.amounts {
+				targets[i] = cortex.AllocationTarget{Key: string(rune('a' + i)), Amount: a}
+			}
+
+			rule := cortex.MustAllocation(cortex.AllocationConfig{
+				ID:          "alloc",
+				Source:      "total",
+				Strategy:    tt.strategy,
+				Targets:     targets,
+				Remainder:   "leftover",
+				IntegerOnly: true,
+			})
+
+			evalCtx := cortex.NewEvalContext()
+			evalCtx.Set("total", tt.source)
+
+			if err := rule.Evaluate(context.Background(), evalCtx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for i, want := range tt.expected {
+				got, _ := evalCtx.GetFloat64(targets[i].Key)
+				if got != want {
+					t.Errorf("expected %s=%v, got %v", targets[i].Key, want, got)
+				}
+			}
+
+			leftover, _ := evalCtx.GetFloat64("leftover")
+			if leftover != tt.remainder {
+				t.Errorf("expected leftover=%v, got %v", tt.remainder, leftover)
+			}
+		})
+	}
+}
+
+func TestAllocationRounding(t *testing.T) {
+	tests := []struct {
+		name      string
+		strategy  cortex.AllocationStrategy
+		rounding  cortex.RoundingMethod
+		source    float64
+		amounts   []float64
+		expected  []float64
+		remainder float64
+	}{
+		{"equal thirds", cortex.StrategyEqual, cortex.RoundLargestRemainder, 100, []float64{0, 0, 0}, []float64{33.34, 33.33, 33.33}, 0},
+		{"equal thirds, round down", cortex.StrategyEqual, cortex.RoundDown, 100, []float64{0, 0, 0}, []float64{33.33, 33.33, 33.33}, 0.01},
+		{"percentages", cortex.StrategyPercentage, cortex.RoundLargestRemainder, 0.29, []float64{50, 50}, []float64{0.15, 0.14}, 0},
+		{"largest remainder wins", cortex.StrategyWeighted, cortex.RoundLargestRemainder, 1, []float64{1, 2}, []float64{0.33, 0.67}, 0},
+		{"negative", cortex.StrategyEqual, cortex.RoundLargestRemainder, -100, []float64{0, 0, 0}, []float64{-33.34, -33.33, -33.33}, 0},
+		{"fixed shortfall", cortex.StrategyFixed, cortex.RoundLargestRemainder, 10, []float64{2.5, 3.5}, []float64{2.5, 3.5}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets := make([]cortex.AllocationTarget, len(tt.amounts))
+			for i, a := range tt.amounts {
+				targets[i] = cortex.AllocationTarget{Key: string(rune('a' + i)), Amount: a}
+			}
+
+			rule := cortex.MustAllocation(cortex.AllocationConfig{
+				ID:        "alloc",
+				Source:    "total",
+				Strategy:  tt.strategy,
+				Targets:   targets,
+				Remainder: "leftover",
+				Rounding:  tt.rounding,
+			})
+
+			evalCtx := cortex.NewEvalContext()
+			evalCtx.Set("total", tt.source)
+			if err := rule.Evaluate(context.Background(), evalCtx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for i, want := range tt.expected {
+				got, _ := evalCtx.GetFloat64(targets[i].Key)
+				if got != want {
+					t.Errorf("expected %s=%v, got %v", targets[i].Key, want, got)
+				}
+			}
+			leftover, _ := evalCtx.GetFloat64("leftover")
+			if math.Abs(leftover-tt.remainder) > 1e-9 {
+				t.Errorf("expected leftover=%v, got %v", tt.remainder, leftover)
+			}
+		})
+	}
+
+	if _, err := cortex.NewAllocation(cortex.AllocationConfig{
+		ID: "bad", Source: "s", Strategy: cortex.StrategyEqual,
+		Targets: []cortex.AllocationTarget{{Key: "a"}}, Rounding: cortex.RoundDown + 1,
+	}); !errors.Is(err, cortex.ErrInvalidRule) {
+		t.Errorf("expected ErrInvalidRule for unknown rounding method, got %v", err)
+	}
+}
+
+func TestAllocationBounds(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy cortex.AllocationStrategy
+		source   float64
+		targets  []cortex.AllocationTarget
+		expected []float64
+	}{
+		{"cap redistributes", cortex.StrategyWeighted, 300, []cortex.AllocationTarget{
+			{Key: "a", Amount: 1, Max: 50}, {Key: "b", Amount: 1}, {Key: "c", Amount: 1},
+		}, []float64{50, 125, 125}},
+		{"floor takes from others", cortex.StrategyRatio, 100, []cortex.AllocationTarget{
+			{Key: "a", Amount: 1, Min: 40}, {Key: "b", Amount: 1}, {Key: "c", Amount: 2},
+		}, []float64{40, 20, 40}},
+		{"cascading caps", cortex.StrategyEqual, 90, []cortex.AllocationTarget{
+			{Key: "a", Max: 10}, {Key: "b", Max: 25}, {Key: "c"},
+		}, []float64{10, 25, 55}},
+		{"within bounds", cortex.StrategyPercentage, 100, []cortex.AllocationTarget{
+			{Key: "a", Amount: 60, Min: 10, Max: 70}, {Key: "b", Amount: 40, Min: 10},
+		}, []float64{60, 40}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := cortex.MustAllocation(cortex.AllocationConfig{
+				ID:       "alloc",
+				Source:   "total",
+				Strategy: tt.strategy,
+				Targets:  tt.targets,
+			})
+
+			evalCtx := cortex.NewEvalContext()
+			evalCtx.Set("total", tt.source)
+			if err := rule.Evaluate(context.Background(), evalCtx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for i, want := range tt.expected {
+				got, _ := evalCtx.GetFloat64(tt.targets[i].Key)
+				if got != want {
+					t.Errorf("expected %s=%v, got %v", tt.targets[i].Key, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestAllocationBoundsInfeasible(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []cortex.AllocationTarget
+	}{
+		{"floors exceed source", []cortex.AllocationTarget{{Key: "a", Amount: 1, Min: 60}, {Key: "b", Amount: 1, Min: 60}}},
+		{"caps below source", []cortex.AllocationTarget{{Key: "a", Amount: 1, Max: 30}, {Key: "b", Amount: 1, Max: 30}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := cortex.MustAllocation(cortex.AllocationConfig{
+				ID:       "alloc",
+				Source:   "total",
+				Strategy: cortex.StrategyWeighted,
+				Targets:  tt.targets,
+			})
+
+			evalCtx := cortex.NewEvalContext()
+			evalCtx.Set("total", 100.0)
+			if err := rule.Evaluate(context.Background(), evalCtx); !errors.Is(err, cortex.ErrAllocationInfeasible) {
+				t.Errorf("expected ErrAllocationInfeasible, got %v", err)
+			}
+		})
+	}
+
+	invalid := []struct {
+		name     string
+		strategy cortex.AllocationStrategy
+		target   cortex.AllocationTarget
+	}{
+		{"negative min", cortex.StrategyWeighted, cortex.AllocationTarget{Key: "a", Amount: 1, Min: -1}},
+		{"min above max", cortex.StrategyWeighted, cortex.AllocationTarget{Key: "a", Amount: 1, Min: 10, Max: 5}},
+		{"fixed", cortex.StrategyFixed, cortex.AllocationTarget{Key: "a", Amount: 1, Max: 5}},
+	}
+	for _, tt := range invalid {
+		_, err := cortex.NewAllocation(cortex.AllocationConfig{
+			ID: "bad", Source: "s", Strategy: tt.strategy,
+			Targets: []cortex.AllocationTarget{tt.target},
+		})
+		if !errors.Is(err, cortex.ErrInvalidRule) {
+			t.Errorf("%s: expected ErrInvalidRule, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestAllocationRequiredTargets(t *testing.T) {
+	targets := []cortex.AllocationTarget{{Key: "payroll", Amount: 70}, {Key: "tax", Amount: 30}}
+
+	if _, err := cortex.NewAllocation(cortex.AllocationConfig{
+		ID: "alloc", Source: "total", Strategy: cortex.StrategyPercentage,
+		Targets: targets, RequiredTargets: []string{"tax", "payroll"},
+	}); err != nil {
+		t.Errorf("unexpected error for complete targets: %v", err)
+	}
+
+	_, err := cortex.NewAllocation(cortex.AllocationConfig{
+		ID: "alloc", Source: "total", Strategy: cortex.StrategyPercentage,
+		Targets: targets, RequiredTargets: []string{"tax", "pension", "escrow"},
+	})
+	if !errors.Is(err, cortex.ErrInvalidRule) {
+		t.Fatalf("expected ErrInvalidRule, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "pension, escrow") {
+		t.Errorf("expected missing keys in error, got %v", err)
+	}
+}
+
+func TestAllocationMapTarget(t *testing.T) {
+	rule := cortex.MustAllocation(cortex.AllocationConfig{
+		ID:       "alloc",
+		Source:   "total",
+		Strategy: cortex.StrategyPercentage,
+		Targets:  []cortex.AllocationTarget{{Key: "a", Amount: 25}, {Key: "b", Amount: 75}},
+		Target:   "allocations",
+	})
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("total", 200.0)
+	if err := rule.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	allocations, ok := cortex.GetTyped[map[string]float64](evalCtx, "allocations")
+	if !ok {
+		t.Fatal("expected allocations map")
+	}
+	if len(allocations) != 2 || allocations["a"] != 50 || allocations["b"] != 150 {
+		t.Errorf("expected map[a:50 b:150], got %v", allocations)
+	}
+	if a, _ := evalCtx.GetFloat64("a"); a != 50 {
+		t.Errorf("expected per-key target a=50, got %v", a)
+	}
+}
+
+func TestAllocationShares(t *testing.T) {
+	rule := cortex.MustAllocation(cortex.AllocationConfig{
+		ID:          "alloc",
+		Source:      "total",
+		Strategy:    cortex.StrategyEqual,
+		Targets:     []cortex.AllocationTarget{{Key: "a"}, {Key: "b"}, {Key: "c"}},
+		ShareSuffix: "_share",
+	})
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("total", 100.0)
+	if err := rule.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var amounts, shares float64
+	for _, key := range []string{"a", "b", "c"} {
+		share, ok := cortex.GetTyped[cortex.AllocationShare](evalCtx, key+"_share")
+		if !ok {
+			t.Fatalf("expected AllocationShare under %q", key+"_share")
+		}
+		if amount, _ := evalCtx.GetFloat64(key); share.Amount != amount {
+			t.Errorf("%s: expected share amount %v to match target %v", key, share.Amount, amount)
+		}
+		amounts += share.Amount
+		shares += share.Share
+	}
+	if math.Abs(amounts-100) > 1e-9 {
+		t.Errorf("expected amounts to sum to the source, got %v", amounts)
+	}
+	if math.Abs(shares-1) > 1e-9 {
+		t.Errorf("expected shares to sum to 1, got %v", shares)
+	}
+
+	// A zero source gives zero shares rather than NaN.
+	evalCtx.Set("total", 0.0)
+	if err := rule.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if share, _ := cortex.GetTyped[cortex.AllocationShare](evalCtx, "a_share"); share != (cortex.AllocationShare{}) {
+		t.Errorf("expected zero share for a zero source, got %+v", share)
+	}
+}
+
+func TestAllocationCascadingWeights(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	err := engine.AddRules(
+		cortex.MustAllocation(cortex.AllocationConfig{
+			ID:       "budget",
+			Source:   "budget",
+			Strategy: cortex.StrategyPercentage,
+			Targets: []cortex.AllocationTarget{
+				{Key: "engineering", Amount: 50},
+				{Key: "sales", Amount: 30},
+				{Key: "support", Amount: 20},
+			},
+		}),
+		cortex.MustAllocation(cortex.AllocationConfig{
+			ID:       "bonus",
+			Source:   "bonus_pool",
+			Strategy: cortex.StrategyWeighted,
+			Deps:     []string{"budget"},
+			Targets: []cortex.AllocationTarget{
+				{Key: "engineering_bonus", AmountFrom: "engineering"},
+				{Key: "sales_bonus", AmountFrom: "sales"},
+				{Key: "support_bonus", AmountFrom: "support"},
+			},
+		}),
+		cortex.MustAllocation(cortex.AllocationConfig{
+			ID:          "training",
+			Source:      "training_pool",
+			Strategy:    cortex.StrategyRatio,
+			WeightsFrom: "headcount",
+			Targets: []cortex.AllocationTarget{
+				{Key: "engineering_training"},
+				{Key: "sales_training"},
+				{Key: "support_training"},
+			},
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("budget", 1000000.0)
+	evalCtx.Set("bonus_pool", 10000.0)
+	evalCtx.Set("training_pool", 900.0)
+	evalCtx.Set("headcount", []any{4, 3, 2})
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for key, want := range map[string]float64{
+		"engineering_bonus": 5000, "sales_bonus": 3000, "support_bonus": 2000,
+		"engineering_training": 400, "sales_training": 300, "support_training": 200,
+	} {
+		if got, _ := evalCtx.GetFloat64(key); got != want {
+			t.Errorf("expected %s=%v, got %v", key, want, got)
+		}
+	}
+
+	evalCtx = cortex.NewEvalContext()
+	evalCtx.Set("budget", 1000.0)
+	evalCtx.Set("bonus_pool", 10.0)
+	evalCtx.Set("training_pool", 10.0)
+	evalCtx.Set("headcount", []float64{1, 2})
+	if _, err := engine.Evaluate(context.Background(), evalCtx); !errors.Is(err, cortex.ErrTypeMismatch) {
+		t.Errorf("expected ErrTypeMismatch for wrong number of weights, got %v", err)
+	}
+
+	if _, err := cortex.NewAllocation(cortex.AllocationConfig{
+		ID: "bad", Source: "s", Strategy: cortex.StrategyFixed,
+		Targets: []cortex.AllocationTarget{{Key: "a", AmountFrom: "w"}},
+	}); !errors.Is(err, cortex.ErrInvalidRule) {
+		t.Errorf("expected ErrInvalidRule for fixed amounts from context, got %v", err)
+	}
+}
+
+func TestAllocationWriteRemainderToSource(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRules(
+		cortex.MustAllocation(cortex.AllocationConfig{
+			ID:                     "rent",
+			Source:                 "budget",
+			Strategy:               cortex.StrategyFixed,
+			Targets:                []cortex.AllocationTarget{{Key: "rent", Amount: 600}},
+			WriteRemainderToSource: true,
+		}),
+		cortex.MustAllocation(cortex.AllocationConfig{
+			ID:                     "savings",
+			Source:                 "budget",
+			Strategy:               cortex.StrategyPercentage,
+			Targets:                []cortex.AllocationTarget{{Key: "savings", Amount: 25}, {Key: "spending", Amount: 75}},
+			WriteRemainderToSource: true,
+		}),
+	)
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("budget", 1000.0)
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]float64{"rent": 600, "savings": 100, "spending": 300, "budget": 0}
+	for key, want := range expected {
+		if got, _ := evalCtx.GetFloat64(key); got != want {
+			t.Errorf("expected %s=%v, got %v", key, want, got)
+		}
+	}
+}
+
 func TestParseAllocationStrategy(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -224,6 +689,7 @@ func TestParseAllocationStrategy(t *testing.T) {
 		{"weighted", cortex.StrategyWeighted, false},
 		{"equal", cortex.StrategyEqual, false},
 		{"ratio", cortex.StrategyRatio, false},
+		{"waterfall", cortex.StrategyWaterfall, false},
 		{"invalid", 0, true},
 	}
 
@@ -245,3 +711,66 @@ func TestParseAllocationStrategy(t *testing.T) {
 		})
 	}
 }
+
+func TestAllocationConversions(t *testing.T) {
+	fixed := []cortex.AllocationTarget{
+		{Key: "a", Amount: 250},
+		{Key: "b", Amount: 750},
+	}
+
+	pct := cortex.AmountsToPercentages(1000, fixed)
+	if pct[0].Key != "a" || pct[0].Amount != 25 || pct[1].Amount != 75 {
+		t.Errorf("expected 25/75 percentages, got %v", pct)
+	}
+
+	back := cortex.PercentagesToAmounts(1000, pct)
+	for i := range fixed {
+		if back[i] != fixed[i] {
+			t.Errorf("expected %v, got %v", fixed[i], back[i])
+		}
+	}
+
+	weights := cortex.PercentagesToWeights(pct)
+	if weights[0].Amount != 0.25 || weights[1].Amount != 0.75 {
+		t.Errorf("expected 0.25/0.75 weights, got %v", weights)
+	}
+
+	ratio := cortex.WeightsToPercentages([]cortex.AllocationTarget{
+		{Key: "a", Amount: 1},
+		{Key: "b", Amount: 3},
+	})
+	if ratio[0].Amount != 25 || ratio[1].Amount != 75 {
+		t.Errorf("expected 25/75 percentages, got %v", ratio)
+	}
+
+	// The converted percentages drive an equivalent allocation
+	rule := cortex.MustAllocation(cortex.AllocationConfig{
+		ID:       "alloc",
+		Source:   "total",
+		Strategy: cortex.StrategyPercentage,
+		Targets:  pct,
+	})
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("total", 1000.0)
+	if err := rule.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b, _ := evalCtx.GetFloat64("b"); b != 750 {
+		t.Errorf("expected b=750, got %f", b)
+	}
+}
+
+func TestAllocationConversionsZero(t *testing.T) {
+	targets := []cortex.AllocationTarget{{Key: "a", Amount: 0}, {Key: "b", Amount: 0}}
+
+	for _, out := range [][]cortex.AllocationTarget{
+		cortex.AmountsToPercentages(0, []cortex.AllocationTarget{{Key: "a", Amount: 10}}),
+		cortex.WeightsToPercentages(targets),
+	} {
+		for _, tgt := range out {
+			if tgt.Amount != 0 {
+				t.Errorf("expected zero amount, got %v", tgt)
+			}
+		}
+	}
+}
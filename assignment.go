@@ -14,6 +14,7 @@ type AssignmentRule struct {
 	target    string
 	value     any
 	valueFunc ValueFunc
+	valueType ValueType
 }
 
 // AssignmentConfig configures an assignment rule.
@@ -22,6 +23,7 @@ type AssignmentConfig struct {
 	Name        string
 	Description string
 	Deps        []string
+	When        string // guard expression; the rule is skipped when it is false
 
 	// Target is the context key to set.
 	Target string
@@ -31,6 +33,10 @@ type AssignmentConfig struct {
 
 	// ValueFunc computes the value dynamically (mutually exclusive with Value).
 	ValueFunc ValueFunc
+
+	// ValueType, if set, converts the value before it is stored: Value
+	// when the rule is created, and ValueFunc results on each evaluation.
+	ValueType ValueType
 }
 
 // NewAssignment creates a new assignment rule.
@@ -45,16 +51,34 @@ func NewAssignment(cfg AssignmentConfig) (*AssignmentRule, error) {
 		return nil, fmt.Errorf("%w: assignment rule %q requires value or value function", ErrInvalidRule, cfg.ID)
 	}
 
+	if !cfg.ValueType.valid() {
+		return nil, fmt.Errorf("%w: assignment rule %q has unknown value type %q", ErrInvalidRule, cfg.ID, string(cfg.ValueType))
+	}
+	value := cfg.Value
+	if value != nil {
+		var err error
+		if value, err = cfg.ValueType.Convert(value); err != nil {
+			return nil, fmt.Errorf("assignment rule %q value: %w", cfg.ID, err)
+		}
+	}
+
+	when, err := compileWhen(cfg.ID, cfg.When)
+	if err != nil {
+		return nil, err
+	}
+
 	return &AssignmentRule{
 		baseRule: baseRule{
 			id:          cfg.ID,
 			name:        cfg.Name,
 			description: cfg.Description,
 			deps:        cfg.Deps,
+			when:        when,
 		},
 		target:    cfg.Target,
-		value:     cfg.Value,
+		value:     value,
 		valueFunc: cfg.ValueFunc,
+		valueType: cfg.ValueType,
 	}, nil
 }
 
@@ -74,6 +98,9 @@ func (r *AssignmentRule) Evaluate(ctx context.Context, evalCtx *EvalContext) err
 
 	if r.valueFunc != nil {
 		value, err = r.valueFunc(ctx, evalCtx)
+		if err == nil {
+			value, err = r.valueType.Convert(value)
+		}
 		if err != nil {
 			return NewRuleError(r.id, string(RuleTypeAssignment), "evaluate", err)
 		}
@@ -0,0 +1,138 @@
+package cortex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// EvaluateBatch evaluates each context against the rule set and returns
+// one result per context, in the same order. Up to Config.BatchWorkers
+// contexts are evaluated concurrently; the contexts must be distinct.
+//
+// A failing context does not stop the batch: errors returned by Evaluate
+// are joined, each prefixed with the context index. In fail-fast mode the
+// first failing context stops the batch, contexts not yet started are left
+// with a nil result, and only that error is returned.
+func (e *Engine) EvaluateBatch(ctx context.Context, contexts []*EvalContext) ([]*Result, error) {
+	results := make([]*Result, len(contexts))
+	errs := make([]error, len(contexts))
+
+	workers := min(max(e.config.BatchWorkers, 1), len(contexts))
+	failFast := e.config.Mode == ModeFailFast
+
+	var (
+		failed   atomic.Bool
+		firstErr error
+		once     sync.Once
+		wg       sync.WaitGroup
+	)
+
+	jobs := make(chan int)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if failFast && failed.Load() {
+					continue
+				}
+				results[i], errs[i] = e.Evaluate(ctx, contexts[i])
+				if errs[i] != nil {
+					errs[i] = fmt.Errorf("context %d: %w", i, errs[i])
+					if failFast {
+						once.Do(func() { firstErr = errs[i] })
+						failed.Store(true)
+					}
+				}
+			}
+		}()
+	}
+
+	for i := range contexts {
+		if failFast && failed.Load() {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if failFast {
+		return results, firstErr
+	}
+	return results, errors.Join(errs...)
+}
+
+// EvaluateBatchShared evaluates the contexts as EvaluateBatch does, but
+// with every context's buildups taken from shared: buildup rules in all
+// contexts accumulate into the same buildups, such as a payroll total
+// across employees, while values stay per context. Read the totals from
+// shared once the batch returns; a buildup rule's Target sees the running
+// total at that moment, which depends on scheduling when BatchWorkers is
+// above 1. Config.Transactional does not roll back shared buildups.
+func (e *Engine) EvaluateBatchShared(ctx context.Context, contexts []*EvalContext, shared *BuildupSet) ([]*Result, error) {
+	if shared == nil {
+		return nil, fmt.Errorf("%w: nil buildup set", ErrInvalidRule)
+	}
+	for _, evalCtx := range contexts {
+		if evalCtx == nil {
+			continue
+		}
+		evalCtx.setSharedBuildups(shared)
+		defer evalCtx.setSharedBuildups(nil)
+	}
+	return e.EvaluateBatch(ctx, contexts)
+}
+
+// AggregateFunc folds a single evaluation result into an accumulator.
+type AggregateFunc func(acc, result *Result)
+
+// EvaluateBatchAggregate evaluates the contexts with EvaluateBatch, so up
+// to Config.BatchWorkers run concurrently, then folds the results into an
+// accumulator in input order. The accumulator's counters and errors are
+// maintained by the engine; aggregate is called afterwards for each result
+// in turn, typically writing totals into acc.Context. The error is the one
+// EvaluateBatch returns.
+//
+// In fail-fast mode folding stops at the first failed context, and the
+// accumulator so far is returned with the error.
+func (e *Engine) EvaluateBatchAggregate(ctx context.Context, contexts []*EvalContext, aggregate AggregateFunc) (*Result, error) {
+	acc := &Result{
+		ID:      generateID(),
+		Success: true,
+		Context: NewEvalContext(),
+	}
+
+	results, err := e.EvaluateBatch(ctx, contexts)
+	failFast := e.config.Mode == ModeFailFast
+	for _, result := range results {
+		if result == nil {
+			if failFast {
+				break
+			}
+			continue
+		}
+		acc.RulesEvaluated += result.RulesEvaluated
+		acc.RulesSkipped += result.RulesSkipped
+		acc.RulesFailed += result.RulesFailed
+		acc.Errors = append(acc.Errors, result.Errors...)
+		acc.Warnings = append(acc.Warnings, result.Warnings...)
+		acc.RuleTimings = append(acc.RuleTimings, result.RuleTimings...)
+		acc.Duration += result.Duration
+		acc.Success = acc.Success && result.Success
+		if aggregate != nil {
+			aggregate(acc, result)
+		}
+		if failFast && !result.Success {
+			break
+		}
+	}
+	if err != nil {
+		acc.Success = false
+	}
+
+	return acc, err
+}
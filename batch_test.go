@@ -0,0 +1,250 @@
+package cortex_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kolosys/cortex"
+)
+
+func newTaxEngine(config *cortex.Config) *cortex.Engine {
+	engine := cortex.New("tax", config)
+	engine.AddRule(cortex.MustFormula(cortex.FormulaConfig{
+		ID:         "calc-tax",
+		Target:     "tax",
+		Expression: "salary * 0.1",
+	}))
+	return engine
+}
+
+func TestEvaluateBatchAggregate(t *testing.T) {
+	engine := newTaxEngine(cortex.DefaultConfig())
+
+	contexts := make([]*cortex.EvalContext, 3)
+	for i, salary := range []float64{1000, 2000, 3000} {
+		contexts[i] = cortex.NewEvalContext()
+		contexts[i].Set("salary", salary)
+	}
+
+	acc, err := engine.EvaluateBatchAggregate(context.Background(), contexts, func(acc, result *cortex.Result) {
+		tax, _ := result.Context.GetFloat64("tax")
+		total, _ := acc.Context.GetFloat64("total_tax")
+		acc.Context.Set("total_tax", total+tax)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	total, _ := acc.Context.GetFloat64("total_tax")
+	if total != 600 {
+		t.Errorf("expected total_tax=600, got %f", total)
+	}
+	if acc.RulesEvaluated != 3 {
+		t.Errorf("expected 3 rules evaluated, got %d", acc.RulesEvaluated)
+	}
+	if !acc.Success {
+		t.Error("expected success")
+	}
+}
+
+func TestEvaluateBatchAggregateWorkers(t *testing.T) {
+	config := cortex.DefaultConfig()
+	config.BatchWorkers = 4
+	engine := newTaxEngine(config)
+
+	contexts := make([]*cortex.EvalContext, 20)
+	for i := range contexts {
+		contexts[i] = cortex.NewEvalContext()
+		contexts[i].Set("salary", float64(i*100))
+	}
+
+	var order []string
+	acc, err := engine.EvaluateBatchAggregate(context.Background(), contexts, func(acc, result *cortex.Result) {
+		order = append(order, result.ID)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if acc.RulesEvaluated != len(contexts) {
+		t.Errorf("expected %d rules evaluated, got %d", len(contexts), acc.RulesEvaluated)
+	}
+	for i, id := range order {
+		if id != contexts[i].ID {
+			t.Fatalf("expected results folded in input order, got %q at %d", id, i)
+		}
+	}
+	if len(order) != len(contexts) {
+		t.Errorf("expected %d aggregate calls, got %d", len(contexts), len(order))
+	}
+}
+
+func TestEvaluateBatchAggregateErrors(t *testing.T) {
+	contexts := func() []*cortex.EvalContext {
+		ok := cortex.NewEvalContext()
+		ok.Set("salary", 1000.0)
+		return []*cortex.EvalContext{cortex.NewEvalContext(), ok}
+	}
+
+	t.Run("fail fast", func(t *testing.T) {
+		engine := newTaxEngine(cortex.DefaultConfig())
+		calls := 0
+		acc, err := engine.EvaluateBatchAggregate(context.Background(), contexts(), func(acc, result *cortex.Result) {
+			calls++
+		})
+		var ruleErr *cortex.RuleError
+		if !errors.As(err, &ruleErr) || ruleErr.RuleID != "calc-tax" {
+			t.Fatalf("expected calc-tax rule error, got %v", err)
+		}
+		if calls != 1 || acc.Success {
+			t.Errorf("expected batch to stop after first failure, calls=%d success=%v", calls, acc.Success)
+		}
+	})
+
+	t.Run("collect all", func(t *testing.T) {
+		config := cortex.DefaultConfig()
+		config.Mode = cortex.ModeCollectAll
+		engine := newTaxEngine(config)
+		calls := 0
+		acc, err := engine.EvaluateBatchAggregate(context.Background(), contexts(), func(acc, result *cortex.Result) {
+			calls++
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if calls != 2 {
+			t.Errorf("expected 2 aggregate calls, got %d", calls)
+		}
+		if acc.RulesFailed != 1 || len(acc.Errors) != 1 || acc.Success {
+			t.Errorf("expected one collected failure, got failed=%d errors=%d success=%v", acc.RulesFailed, len(acc.Errors), acc.Success)
+		}
+	})
+}
+
+func TestEvaluateBatch(t *testing.T) {
+	for _, workers := range []int{0, 1, 4} {
+		config := cortex.DefaultConfig()
+		config.BatchWorkers = workers
+		engine := newTaxEngine(config)
+
+		contexts := make([]*cortex.EvalContext, 100)
+		for i := range contexts {
+			contexts[i] = cortex.NewEvalContext()
+			contexts[i].Set("salary", float64(i*100))
+		}
+
+		results, err := engine.EvaluateBatch(context.Background(), contexts)
+		if err != nil {
+			t.Fatalf("workers=%d: unexpected error: %v", workers, err)
+		}
+		if len(results) != len(contexts) {
+			t.Fatalf("workers=%d: expected %d results, got %d", workers, len(contexts), len(results))
+		}
+		for i, result := range results {
+			if result.Context != contexts[i] {
+				t.Fatalf("workers=%d: result %d does not match its context", workers, i)
+			}
+			if tax, _ := result.Context.GetFloat64("tax"); tax != float64(i*10) {
+				t.Errorf("workers=%d: context %d: expected tax=%d, got %f", workers, i, i*10, tax)
+			}
+		}
+	}
+}
+
+func TestEvaluateBatchErrors(t *testing.T) {
+	contexts := func() []*cortex.EvalContext {
+		ok := cortex.NewEvalContext()
+		ok.Set("salary", 1000.0)
+		return []*cortex.EvalContext{cortex.NewEvalContext(), ok}
+	}
+
+	t.Run("fail fast", func(t *testing.T) {
+		engine := newTaxEngine(cortex.DefaultConfig())
+		results, err := engine.EvaluateBatch(context.Background(), contexts())
+		var ruleErr *cortex.RuleError
+		if !errors.As(err, &ruleErr) || ruleErr.RuleID != "calc-tax" {
+			t.Fatalf("expected calc-tax rule error, got %v", err)
+		}
+		if results[0] == nil || results[1] != nil {
+			t.Errorf("expected batch to stop after the first context, got %v", results)
+		}
+	})
+
+	t.Run("collect all", func(t *testing.T) {
+		config := cortex.DefaultConfig()
+		config.Mode = cortex.ModeCollectAll
+		engine := newTaxEngine(config)
+		results, err := engine.EvaluateBatch(context.Background(), contexts())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !results[0].HasErrors() || !results[1].Success {
+			t.Errorf("expected only the first context to fail")
+		}
+	})
+
+	t.Run("context error", func(t *testing.T) {
+		config := cortex.DefaultConfig()
+		config.Mode = cortex.ModeCollectAll
+		engine := newTaxEngine(config)
+		results, err := engine.EvaluateBatch(context.Background(), []*cortex.EvalContext{nil, contexts()[1]})
+		if !errors.Is(err, cortex.ErrNilContext) {
+			t.Fatalf("expected ErrNilContext, got %v", err)
+		}
+		if results[1] == nil || !results[1].Success {
+			t.Error("expected remaining contexts to be evaluated")
+		}
+	})
+}
+
+func TestEvaluateBatchShared(t *testing.T) {
+	for _, workers := range []int{0, 4} {
+		config := cortex.DefaultConfig()
+		config.BatchWorkers = workers
+		engine := newTaxEngine(config)
+		engine.AddRules(
+			cortex.MustBuildup(cortex.BuildupConfig{ID: "total-payroll", Buildup: "payroll", Operation: cortex.BuildupSum, Source: "salary"}),
+			cortex.MustBuildup(cortex.BuildupConfig{ID: "headcount", Buildup: "headcount", Operation: cortex.BuildupCount}),
+		)
+
+		contexts := make([]*cortex.EvalContext, 100)
+		var want float64
+		for i := range contexts {
+			contexts[i] = cortex.NewEvalContext()
+			contexts[i].Set("salary", float64(i*100))
+			want += float64(i * 100)
+		}
+
+		shared := cortex.NewBuildupSet()
+		if _, err := engine.EvaluateBatchShared(context.Background(), contexts, shared); err != nil {
+			t.Fatalf("workers=%d: unexpected error: %v", workers, err)
+		}
+
+		payroll, ok := shared.Get("payroll")
+		if !ok {
+			t.Fatalf("workers=%d: expected a shared payroll buildup", workers)
+		}
+		if payroll.Current() != want || payroll.Count() != 100 {
+			t.Errorf("workers=%d: expected payroll=%v over 100 contexts, got %v over %d", workers, want, payroll.Current(), payroll.Count())
+		}
+		if headcount, _ := shared.Get("headcount"); headcount.Current() != 100 {
+			t.Errorf("workers=%d: expected headcount=100, got %v", workers, headcount.Current())
+		}
+
+		// Values stay per context, and the contexts get their own
+		// buildups back afterwards.
+		for i, evalCtx := range contexts {
+			if tax, _ := evalCtx.GetFloat64("tax"); tax != float64(i*10) {
+				t.Errorf("workers=%d: context %d: expected tax=%d, got %v", workers, i, i*10, tax)
+			}
+			if _, ok := evalCtx.GetBuildup("payroll"); ok {
+				t.Fatalf("workers=%d: context %d: expected no per-context payroll buildup", workers, i)
+			}
+		}
+	}
+
+	engine := newTaxEngine(cortex.DefaultConfig())
+	if _, err := engine.EvaluateBatchShared(context.Background(), nil, nil); !errors.Is(err, cortex.ErrInvalidRule) {
+		t.Errorf("expected ErrInvalidRule for a nil buildup set, got %v", err)
+	}
+}
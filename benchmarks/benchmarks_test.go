@@ -185,3 +185,104 @@ func BenchmarkEvalContextGet(b *testing.B) {
 		evalCtx.Get("key")
 	}
 }
+
+func benchmarkSingleRule(b *testing.B, enableMetrics bool) {
+	config := cortex.DefaultConfig()
+	config.EnableMetrics = enableMetrics
+	engine := cortex.New("bench", config)
+
+	engine.AddRule(cortex.MustAssignment(cortex.AssignmentConfig{
+		ID:     "x",
+		Target: "x",
+		Value:  10.0,
+	}))
+
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for b.Loop() {
+		evalCtx := cortex.NewEvalContext()
+		engine.Evaluate(ctx, evalCtx)
+	}
+}
+
+func BenchmarkSingleRuleFastPath(b *testing.B) {
+	benchmarkSingleRule(b, false)
+}
+
+func BenchmarkSingleRuleGeneral(b *testing.B) {
+	benchmarkSingleRule(b, true)
+}
+
+var seedValues = map[string]any{
+	"a": 1.0, "b": 2.0, "c": 3.0, "d": 4.0,
+	"e": 5.0, "f": 6.0, "g": 7.0, "h": 8.0,
+}
+
+func BenchmarkEvalContextSetLoop(b *testing.B) {
+	evalCtx := cortex.NewEvalContext()
+
+	b.ResetTimer()
+	for b.Loop() {
+		for k, v := range seedValues {
+			evalCtx.Set(k, v)
+		}
+	}
+}
+
+func BenchmarkEvalContextSetAll(b *testing.B) {
+	evalCtx := cortex.NewEvalContext()
+
+	b.ResetTimer()
+	for b.Loop() {
+		evalCtx.SetAll(seedValues)
+	}
+}
+
+func BenchmarkEngineEvaluatePooled(b *testing.B) {
+	engine := cortex.New("bench", cortex.DefaultConfig())
+
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{
+			ID:     "x",
+			Target: "x",
+			Value:  10.0,
+		}),
+		cortex.MustAssignment(cortex.AssignmentConfig{
+			ID:     "y",
+			Target: "y",
+			Value:  20.0,
+		}),
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID:         "sum",
+			Target:     "sum",
+			Expression: "x + y",
+		}),
+	)
+
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for b.Loop() {
+		evalCtx := cortex.AcquireEvalContext()
+		engine.Evaluate(ctx, evalCtx)
+		cortex.ReleaseEvalContext(evalCtx)
+	}
+}
+
+func BenchmarkRangeLookupGet(b *testing.B) {
+	ranges := make([]cortex.RangeEntry[float64], 64)
+	for i := range ranges {
+		ranges[i] = cortex.RangeEntry[float64]{
+			Min:   float64(i * 1000),
+			Max:   float64((i + 1) * 1000),
+			Value: float64(i),
+		}
+	}
+	lookup := cortex.NewRangeLookup("bands", ranges)
+
+	b.ResetTimer()
+	for b.Loop() {
+		lookup.Get(60500.0)
+	}
+}
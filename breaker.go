@@ -0,0 +1,65 @@
+package cortex
+
+import (
+	"sync"
+	"time"
+)
+
+// breaker tracks consecutive failures per rule across evaluations and
+// opens a circuit for rules that keep failing.
+type breaker struct {
+	mu    sync.Mutex
+	rules map[string]*breakerState
+}
+
+type breakerState struct {
+	failures  int
+	openUntil time.Time // zero unless the breaker has tripped
+}
+
+// allow reports whether the rule may run at now.
+func (b *breaker) allow(id string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.rules[id]
+	return !ok || !now.Before(s.openUntil)
+}
+
+// record updates the rule's state after it ran. It reports whether the
+// breaker tripped, or reset after having tripped.
+func (b *breaker) record(id string, failed bool, threshold int, cooldown time.Duration, now time.Time) (tripped, reset bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, ok := b.rules[id]
+	if !failed {
+		if !ok {
+			return false, false
+		}
+		reset = !s.openUntil.IsZero()
+		delete(b.rules, id)
+		return false, reset
+	}
+
+	if !ok {
+		if b.rules == nil {
+			b.rules = make(map[string]*breakerState)
+		}
+		s = &breakerState{}
+		b.rules[id] = s
+	}
+	s.failures++
+	if s.failures >= threshold {
+		// A rule that fails again after the cooldown trips immediately.
+		s.openUntil = now.Add(cooldown)
+		return true, false
+	}
+	return false, false
+}
+
+// forget discards any state for the rule.
+func (b *breaker) forget(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.rules, id)
+}
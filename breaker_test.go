@@ -0,0 +1,173 @@
+package cortex_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kolosys/cortex"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	config := cortex.DefaultConfig()
+	config.BreakerThreshold = 2
+	config.BreakerCooldown = 50 * time.Millisecond
+
+	var calls int
+	failing := true
+	metrics := newRecordingMetrics()
+	engine := cortex.New("test", config).WithObservability(&cortex.Observability{Metrics: metrics})
+	engine.AddRule(cortex.MustFormula(cortex.FormulaConfig{
+		ID:     "remote",
+		Target: "rate",
+		Formula: func(ctx context.Context, evalCtx *cortex.EvalContext) (any, error) {
+			calls++
+			if failing {
+				return nil, errors.New("upstream unavailable")
+			}
+			return 0.1, nil
+		},
+	}))
+
+	evaluate := func() error {
+		_, err := engine.Evaluate(context.Background(), cortex.NewEvalContext())
+		return err
+	}
+
+	// Failures below the threshold keep calling the rule.
+	for i := 0; i < 2; i++ {
+		if err := evaluate(); err == nil || errors.Is(err, cortex.ErrCircuitOpen) {
+			t.Fatalf("evaluation %d: expected rule failure, got %v", i, err)
+		}
+	}
+	if metrics.counters["cortex.breaker.trip"] != 1 {
+		t.Errorf("expected 1 trip, got %v", metrics.counters["cortex.breaker.trip"])
+	}
+
+	// Tripped: the rule is not called.
+	if err := evaluate(); !errors.Is(err, cortex.ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls while open, got %d", calls)
+	}
+
+	// After the cooldown the rule is retried and a success resets it.
+	time.Sleep(60 * time.Millisecond)
+	failing = false
+	if err := evaluate(); err != nil {
+		t.Fatalf("expected success after cooldown, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+	if metrics.counters["cortex.breaker.reset"] != 1 {
+		t.Errorf("expected 1 reset, got %v", metrics.counters["cortex.breaker.reset"])
+	}
+}
+
+func TestCircuitBreakerRetripsAfterCooldown(t *testing.T) {
+	config := cortex.DefaultConfig()
+	config.BreakerThreshold = 3
+	config.BreakerCooldown = 20 * time.Millisecond
+
+	engine := cortex.New("test", config)
+	engine.AddRule(cortex.MustFormula(cortex.FormulaConfig{
+		ID:     "remote",
+		Target: "rate",
+		Formula: func(ctx context.Context, evalCtx *cortex.EvalContext) (any, error) {
+			return nil, errors.New("upstream unavailable")
+		},
+	}))
+
+	for i := 0; i < 3; i++ {
+		engine.Evaluate(context.Background(), cortex.NewEvalContext())
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// One more failure after the cooldown reopens the breaker at once.
+	if _, err := engine.Evaluate(context.Background(), cortex.NewEvalContext()); errors.Is(err, cortex.ErrCircuitOpen) {
+		t.Fatalf("expected the rule to be retried, got %v", err)
+	}
+	if _, err := engine.Evaluate(context.Background(), cortex.NewEvalContext()); !errors.Is(err, cortex.ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	var calls int
+	engine := cortex.New("test", nil)
+	engine.AddRule(cortex.MustFormula(cortex.FormulaConfig{
+		ID:     "remote",
+		Target: "rate",
+		Formula: func(ctx context.Context, evalCtx *cortex.EvalContext) (any, error) {
+			calls++
+			return nil, errors.New("upstream unavailable")
+		},
+	}))
+
+	for i := 0; i < 10; i++ {
+		if _, err := engine.Evaluate(context.Background(), cortex.NewEvalContext()); errors.Is(err, cortex.ErrCircuitOpen) {
+			t.Fatal("breaker should be disabled by default")
+		}
+	}
+	if calls != 10 {
+		t.Errorf("expected 10 calls, got %d", calls)
+	}
+}
+
+func TestCircuitBreakerSingleRuleNoMetrics(t *testing.T) {
+	config := cortex.DefaultConfig()
+	config.EnableMetrics = false
+	config.BreakerThreshold = 2
+	config.BreakerCooldown = 20 * time.Millisecond
+
+	var calls int
+	failing := true
+	engine := cortex.New("test", config)
+	engine.AddRule(cortex.MustFormula(cortex.FormulaConfig{
+		ID:     "remote",
+		Target: "rate",
+		Formula: func(ctx context.Context, evalCtx *cortex.EvalContext) (any, error) {
+			calls++
+			if failing {
+				return nil, errors.New("upstream unavailable")
+			}
+			return 0.1, nil
+		},
+	}))
+
+	evaluate := func() error {
+		_, err := engine.Evaluate(context.Background(), cortex.NewEvalContext())
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := evaluate(); err == nil || errors.Is(err, cortex.ErrCircuitOpen) {
+			t.Fatalf("evaluation %d: expected rule failure, got %v", i, err)
+		}
+	}
+
+	// Tripped: the rule is skipped during the cooldown.
+	if err := evaluate(); !errors.Is(err, cortex.ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls while open, got %d", calls)
+	}
+
+	// After the cooldown a success resets the breaker.
+	time.Sleep(30 * time.Millisecond)
+	failing = false
+	if err := evaluate(); err != nil {
+		t.Fatalf("expected success after cooldown, got %v", err)
+	}
+	failing = true
+	if err := evaluate(); err == nil || errors.Is(err, cortex.ErrCircuitOpen) {
+		t.Fatalf("expected the reset breaker to call the rule again, got %v", err)
+	}
+	if calls != 4 {
+		t.Errorf("expected 4 calls, got %d", calls)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"math/rand/v2"
+	"slices"
 	"sync"
 )
 
@@ -28,6 +30,32 @@ const (
 
 	// BuildupProduct multiplies values together.
 	BuildupProduct
+
+	// BuildupVariance computes the running sample variance.
+	BuildupVariance
+
+	// BuildupStdDev computes the running sample standard deviation.
+	BuildupStdDev
+
+	// BuildupPercentile computes a running quantile, the median by
+	// default; see Buildup.SetQuantile.
+	BuildupPercentile
+
+	// BuildupCollect gathers values of any type into a slice, returned by
+	// CurrentValue.
+	BuildupCollect
+
+	// BuildupDistinctCount counts distinct values of any type; see
+	// Buildup.SetDistinctLimit.
+	BuildupDistinctCount
+
+	// BuildupFirst keeps the first value added. After Reset, the next
+	// value added becomes the first.
+	BuildupFirst
+
+	// BuildupLast keeps the most recent value added. Until a value is
+	// added, including after Reset, it holds the initial value.
+	BuildupLast
 )
 
 func (op BuildupOperation) String() string {
@@ -44,6 +72,20 @@ func (op BuildupOperation) String() string {
 		return "count"
 	case BuildupProduct:
 		return "product"
+	case BuildupVariance:
+		return "variance"
+	case BuildupStdDev:
+		return "stddev"
+	case BuildupPercentile:
+		return "percentile"
+	case BuildupCollect:
+		return "collect"
+	case BuildupDistinctCount:
+		return "distinct_count"
+	case BuildupFirst:
+		return "first"
+	case BuildupLast:
+		return "last"
 	default:
 		return "unknown"
 	}
@@ -64,6 +106,20 @@ func ParseBuildupOperation(s string) (BuildupOperation, error) {
 		return BuildupCount, nil
 	case "product":
 		return BuildupProduct, nil
+	case "variance":
+		return BuildupVariance, nil
+	case "stddev":
+		return BuildupStdDev, nil
+	case "percentile":
+		return BuildupPercentile, nil
+	case "collect":
+		return BuildupCollect, nil
+	case "distinct_count":
+		return BuildupDistinctCount, nil
+	case "first":
+		return BuildupFirst, nil
+	case "last":
+		return BuildupLast, nil
 	default:
 		return 0, fmt.Errorf("%w: unknown buildup operation %q", ErrInvalidRule, s)
 	}
@@ -77,10 +133,69 @@ type Buildup struct {
 	mu    sync.Mutex
 	value float64
 	count int64
+
+	// running mean and sum of squared deviations (Welford's algorithm)
+	// for BuildupVariance and BuildupStdDev
+	mean float64
+	m2   float64
+
+	// observed values and settings for BuildupPercentile
+	samples    []float64
+	quantile   float64
+	maxSamples int
+
+	// values gathered by BuildupCollect
+	items []any
+
+	// values seen by BuildupDistinctCount
+	distinct *distinctSet
+}
+
+// SetQuantile configures a BuildupPercentile buildup to report quantile q
+// (0.5 is the median, 0.95 the 95th percentile; 0 means the median). A q
+// outside [0, 1] is clamped to it, and NaN is treated as 0.
+//
+// With maxSamples 0 the buildup is exact: it keeps every value added,
+// so memory grows with the stream. Otherwise it keeps a uniform random
+// sample (a reservoir) of at most maxSamples values, bounding memory at
+// the cost of an approximate result; a few thousand samples are usually
+// enough for p50 and p95.
+func (b *Buildup) SetQuantile(q float64, maxSamples int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case math.IsNaN(q):
+		q = 0
+	case q < 0:
+		q = 0
+	case q > 1:
+		q = 1
+	}
+	b.quantile = q
+	b.maxSamples = maxSamples
+}
+
+// SetDistinctLimit bounds the memory of a BuildupDistinctCount buildup.
+// It counts exactly until more than limit distinct values are seen, then
+// switches to a HyperLogLog estimate (about 0.8% standard error) that
+// uses 16 KiB however many values follow. 0, the default, always counts
+// exactly, keeping every distinct value.
+func (b *Buildup) SetDistinctLimit(limit int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.distinct == nil {
+		b.distinct = &distinctSet{}
+	}
+	b.distinct.limit = limit
 }
 
 // Add adds a value to the buildup.
 func (b *Buildup) Add(value float64) {
+	if b.Operation == BuildupCollect || b.Operation == BuildupDistinctCount {
+		b.Collect(value)
+		return
+	}
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
@@ -107,20 +222,113 @@ func (b *Buildup) Add(value float64) {
 		} else {
 			b.value *= value
 		}
+	case BuildupVariance, BuildupStdDev:
+		delta := value - b.mean
+		b.mean += delta / float64(b.count)
+		b.m2 += delta * (value - b.mean)
+	case BuildupFirst:
+		if b.count == 1 {
+			b.value = value
+		}
+	case BuildupLast:
+		b.value = value
+	case BuildupPercentile:
+		if b.maxSamples <= 0 || len(b.samples) < b.maxSamples {
+			b.samples = append(b.samples, value)
+		} else if i := rand.Int64N(b.count); i < int64(b.maxSamples) {
+			b.samples[i] = value
+		}
+	}
+}
+
+// Collect adds a value of any type to a BuildupCollect or
+// BuildupDistinctCount buildup. For other operations it adds v if it is
+// numeric and ignores it otherwise.
+func (b *Buildup) Collect(v any) {
+	if b.Operation != BuildupCollect && b.Operation != BuildupDistinctCount {
+		if f, err := toFloat64(v); err == nil {
+			b.Add(f)
+		}
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.count++
+	if b.Operation == BuildupCollect {
+		b.items = append(b.items, v)
+		return
+	}
+	if b.distinct == nil {
+		b.distinct = &distinctSet{}
+	}
+	b.distinct.add(v)
+}
+
+// CurrentValue returns the current accumulated value: a copy of the
+// collected []any for BuildupCollect, and Current for every other
+// operation.
+func (b *Buildup) CurrentValue() any {
+	if b.Operation != BuildupCollect {
+		return b.Current()
 	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.items)
 }
 
-// Current returns the current accumulated value.
+// Current returns the current accumulated value. For BuildupCollect it
+// is the number of values collected; use CurrentValue for the values.
 func (b *Buildup) Current() float64 {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if b.Operation == BuildupAvg && b.count > 0 {
-		return b.value / float64(b.count)
+	switch b.Operation {
+	case BuildupAvg:
+		if b.count > 0 {
+			return b.value / float64(b.count)
+		}
+	case BuildupVariance, BuildupStdDev:
+		// sample statistics are undefined for fewer than two values
+		if b.count < 2 {
+			return 0
+		}
+		variance := b.m2 / float64(b.count-1)
+		if b.Operation == BuildupStdDev {
+			return math.Sqrt(variance)
+		}
+		return variance
+	case BuildupPercentile:
+		if len(b.samples) > 0 {
+			return quantile(b.samples, b.quantile)
+		}
+	case BuildupCollect:
+		return float64(len(b.items))
+	case BuildupDistinctCount:
+		if b.distinct == nil {
+			return 0
+		}
+		return b.distinct.count()
 	}
 	return b.value
 }
 
+// quantile returns the q-quantile of values, interpolating linearly
+// between the closest ranks. A q of 0 is the median.
+func quantile(values []float64, q float64) float64 {
+	if q == 0 {
+		q = 0.5
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
 // Count returns the number of values added.
 func (b *Buildup) Count() int64 {
 	b.mu.Lock()
@@ -134,6 +342,119 @@ func (b *Buildup) Reset(initial float64) {
 	defer b.mu.Unlock()
 	b.value = initial
 	b.count = 0
+	b.mean = 0
+	b.m2 = 0
+	b.samples = nil
+	b.items = nil
+	if b.distinct != nil {
+		b.distinct = &distinctSet{limit: b.distinct.limit}
+	}
+}
+
+// BuildupState is a point-in-time copy of a buildup's internal state.
+type BuildupState struct {
+	Name      string
+	Operation BuildupOperation
+	Value     float64
+	Count     int64
+	Mean      float64 // running mean, for variance and stddev
+	M2        float64 // sum of squared deviations, for variance and stddev
+	Samples   []float64
+	Items     []any // collected values, for collect
+
+	// distinct_count state: the values seen while counting exactly, or
+	// the HyperLogLog registers once over DistinctLimit
+	Distinct      []any
+	Sketch        []uint8
+	DistinctLimit int
+}
+
+// Snapshot returns a copy of the buildup's current state.
+func (b *Buildup) Snapshot() BuildupState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := BuildupState{
+		Name:      b.Name,
+		Operation: b.Operation,
+		Value:     b.value,
+		Count:     b.count,
+		Mean:      b.mean,
+		M2:        b.m2,
+		Samples:   slices.Clone(b.samples),
+		Items:     slices.Clone(b.items),
+	}
+	if d := b.distinct; d != nil {
+		state.DistinctLimit = d.limit
+		state.Sketch = slices.Clone(d.registers)
+		for k := range d.seen {
+			state.Distinct = append(state.Distinct, k)
+		}
+	}
+	return state
+}
+
+// Restore replaces the buildup's state with a previously taken snapshot.
+func (b *Buildup) Restore(state BuildupState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.Name = state.Name
+	b.Operation = state.Operation
+	b.value = state.Value
+	b.count = state.Count
+	b.mean = state.Mean
+	b.m2 = state.M2
+	b.samples = slices.Clone(state.Samples)
+	b.items = slices.Clone(state.Items)
+	b.distinct = nil
+	if state.Distinct != nil || state.Sketch != nil || state.DistinctLimit != 0 {
+		b.distinct = &distinctSet{limit: state.DistinctLimit, registers: slices.Clone(state.Sketch)}
+		for _, k := range state.Distinct {
+			if b.distinct.seen == nil {
+				b.distinct.seen = make(map[any]struct{}, len(state.Distinct))
+			}
+			b.distinct.seen[k] = struct{}{}
+		}
+	}
+}
+
+// BuildupSet is a set of named buildups shared by every context of an
+// Engine.EvaluateBatchShared batch, so buildup rules aggregate across the
+// batch. It is safe for concurrent use.
+type BuildupSet struct {
+	mu       sync.Mutex
+	buildups map[string]*Buildup
+}
+
+// NewBuildupSet creates an empty buildup set.
+func NewBuildupSet() *BuildupSet {
+	return &BuildupSet{buildups: make(map[string]*Buildup)}
+}
+
+// Get returns the named buildup.
+func (s *BuildupSet) Get(name string) (*Buildup, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buildups[name]
+	return b, ok
+}
+
+// Names returns the names of the buildups in the set, sorted.
+func (s *BuildupSet) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedKeys(s.buildups)
+}
+
+// getOrCreate returns the named buildup, creating it if needed.
+func (s *BuildupSet) getOrCreate(name string, op BuildupOperation, initial float64) *Buildup {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b, ok := s.buildups[name]; ok {
+		return b
+	}
+	b := &Buildup{Name: name, Operation: op, value: initial}
+	s.buildups[name] = b
+	return b
 }
 
 // BuildupRule accumulates values (running totals, aggregations).
@@ -144,6 +465,11 @@ type BuildupRule struct {
 	source    string // context key containing value to add
 	initial   float64
 	target    string // optional: write current value to this key after adding
+
+	quantile   float64 // percentile only
+	maxSamples int     // percentile only; 0 = exact
+
+	distinctLimit int // distinct_count only; 0 = exact
 }
 
 // BuildupConfig configures a buildup rule.
@@ -152,6 +478,7 @@ type BuildupConfig struct {
 	Name        string
 	Description string
 	Deps        []string
+	When        string // guard expression; the rule is skipped when it is false
 
 	// Buildup is the buildup accumulator name (created if not exists).
 	Buildup string
@@ -167,6 +494,21 @@ type BuildupConfig struct {
 
 	// Target is an optional context key to write the current value after adding.
 	Target string
+
+	// Quantile is the quantile a BuildupPercentile reports, between 0 and
+	// 1 (default 0.5, the median).
+	Quantile float64
+
+	// MaxSamples bounds the values a BuildupPercentile keeps. 0 keeps
+	// them all for an exact result; otherwise a random sample of this
+	// size gives an approximate one in fixed memory. See
+	// Buildup.SetQuantile.
+	MaxSamples int
+
+	// DistinctLimit bounds the memory of a BuildupDistinctCount: above
+	// this many distinct values the count becomes an estimate. 0 always
+	// counts exactly. See Buildup.SetDistinctLimit.
+	DistinctLimit int
 }
 
 // NewBuildup creates a new buildup rule.
@@ -181,17 +523,21 @@ func NewBuildup(cfg BuildupConfig) (*BuildupRule, error) {
 		return nil, fmt.Errorf("%w: buildup rule %q requires source (except for count)", ErrInvalidRule, cfg.ID)
 	}
 
-	// Set sensible initial values based on operation
-	initial := cfg.Initial
-	if initial == 0 {
-		switch cfg.Operation {
-		case BuildupMin:
-			initial = math.Inf(1)
-		case BuildupMax:
-			initial = math.Inf(-1)
-		case BuildupProduct:
-			initial = 1
-		}
+	if !(cfg.Quantile >= 0 && cfg.Quantile <= 1) {
+		return nil, fmt.Errorf("%w: buildup rule %q quantile %v is outside [0, 1]", ErrInvalidRule, cfg.ID, cfg.Quantile)
+	}
+	if cfg.MaxSamples < 0 {
+		return nil, fmt.Errorf("%w: buildup rule %q has negative max samples", ErrInvalidRule, cfg.ID)
+	}
+	if cfg.DistinctLimit < 0 {
+		return nil, fmt.Errorf("%w: buildup rule %q has negative distinct limit", ErrInvalidRule, cfg.ID)
+	}
+
+	initial := defaultInitial(cfg.Operation, cfg.Initial)
+
+	when, err := compileWhen(cfg.ID, cfg.When)
+	if err != nil {
+		return nil, err
 	}
 
 	return &BuildupRule{
@@ -200,12 +546,17 @@ func NewBuildup(cfg BuildupConfig) (*BuildupRule, error) {
 			name:        cfg.Name,
 			description: cfg.Description,
 			deps:        cfg.Deps,
+			when:        when,
 		},
-		buildup:   cfg.Buildup,
-		operation: cfg.Operation,
-		source:    cfg.Source,
-		initial:   initial,
-		target:    cfg.Target,
+		buildup:    cfg.Buildup,
+		operation:  cfg.Operation,
+		source:     cfg.Source,
+		initial:    initial,
+		target:     cfg.Target,
+		quantile:   cfg.Quantile,
+		maxSamples: cfg.MaxSamples,
+
+		distinctLimit: cfg.DistinctLimit,
 	}, nil
 }
 
@@ -220,6 +571,22 @@ func MustBuildup(cfg BuildupConfig) *BuildupRule {
 
 // Evaluate adds to the buildup accumulator.
 func (r *BuildupRule) Evaluate(ctx context.Context, evalCtx *EvalContext) error {
+	if r.operation == BuildupCollect || r.operation == BuildupDistinctCount {
+		v, ok := evalCtx.Get(r.source)
+		if !ok {
+			return NewRuleError(r.id, string(RuleTypeBuildup), "evaluate", fmt.Errorf("%w: %s", ErrValueNotFound, r.source))
+		}
+		b := evalCtx.GetOrCreateBuildup(r.buildup, r.operation, r.initial)
+		if r.operation == BuildupDistinctCount {
+			b.SetDistinctLimit(r.distinctLimit)
+		}
+		b.Collect(v)
+		if r.target != "" {
+			evalCtx.Set(r.target, b.CurrentValue())
+		}
+		return nil
+	}
+
 	var value float64
 
 	if r.operation == BuildupCount {
@@ -233,6 +600,9 @@ func (r *BuildupRule) Evaluate(ctx context.Context, evalCtx *EvalContext) error
 	}
 
 	b := evalCtx.GetOrCreateBuildup(r.buildup, r.operation, r.initial)
+	if r.operation == BuildupPercentile {
+		b.SetQuantile(r.quantile, r.maxSamples)
+	}
 	b.Add(value)
 
 	if r.target != "" {
@@ -246,3 +616,97 @@ func (r *BuildupRule) Evaluate(ctx context.Context, evalCtx *EvalContext) error
 func (r *BuildupRule) BuildupName() string {
 	return r.buildup
 }
+
+// defaultInitial returns initial, or a sensible starting value for op if
+// initial is 0.
+func defaultInitial(op BuildupOperation, initial float64) float64 {
+	if initial != 0 {
+		return initial
+	}
+	switch op {
+	case BuildupMin:
+		return math.Inf(1)
+	case BuildupMax:
+		return math.Inf(-1)
+	case BuildupProduct:
+		return 1
+	}
+	return initial
+}
+
+// BuildupResetRule resets a buildup accumulator, so one evaluation can
+// aggregate several groups in turn.
+type BuildupResetRule struct {
+	baseRule
+	buildup string
+	initial float64
+}
+
+// BuildupResetConfig configures a buildup reset rule.
+type BuildupResetConfig struct {
+	ID          string
+	Name        string
+	Description string
+	Deps        []string
+	When        string // guard expression; the rule is skipped when it is false
+
+	// Buildup is the name of the buildup accumulator to reset.
+	Buildup string
+
+	// Initial is the value the buildup restarts from. As with
+	// BuildupConfig, 0 means the usual starting value for the buildup's
+	// operation (+Inf for min, -Inf for max, 1 for product).
+	Initial float64
+}
+
+// NewBuildupReset creates a new buildup reset rule.
+func NewBuildupReset(cfg BuildupResetConfig) (*BuildupResetRule, error) {
+	if cfg.ID == "" {
+		return nil, fmt.Errorf("%w: buildup reset rule requires ID", ErrInvalidRule)
+	}
+	if cfg.Buildup == "" {
+		return nil, fmt.Errorf("%w: buildup reset rule %q requires buildup name", ErrInvalidRule, cfg.ID)
+	}
+
+	when, err := compileWhen(cfg.ID, cfg.When)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BuildupResetRule{
+		baseRule: baseRule{
+			id:          cfg.ID,
+			name:        cfg.Name,
+			description: cfg.Description,
+			deps:        cfg.Deps,
+			when:        when,
+		},
+		buildup: cfg.Buildup,
+		initial: cfg.Initial,
+	}, nil
+}
+
+// MustBuildupReset creates a new buildup reset rule, panicking on error.
+func MustBuildupReset(cfg BuildupResetConfig) *BuildupResetRule {
+	r, err := NewBuildupReset(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Evaluate resets the buildup accumulator. A buildup that does not exist
+// yet has nothing to reset and is left uncreated.
+func (r *BuildupResetRule) Evaluate(ctx context.Context, evalCtx *EvalContext) error {
+	b, ok := evalCtx.GetBuildup(r.buildup)
+	if !ok {
+		return nil
+	}
+	b.Reset(defaultInitial(b.Operation, r.initial))
+	return nil
+}
+
+// BuildupName returns the name of the buildup accumulator it resets.
+func (r *BuildupResetRule) BuildupName() string {
+	return r.buildup
+}
@@ -2,6 +2,9 @@ package cortex_test
 
 import (
 	"context"
+	"errors"
+	"math"
+	"reflect"
 	"testing"
 
 	"github.com/kolosys/cortex"
@@ -88,6 +91,264 @@ func TestBuildupProduct(t *testing.T) {
 	}
 }
 
+func TestBuildupVarianceStdDev(t *testing.T) {
+	evalCtx := cortex.NewEvalContext()
+	variance := evalCtx.GetOrCreateBuildup("variance", cortex.BuildupVariance, 0)
+	stddev := evalCtx.GetOrCreateBuildup("stddev", cortex.BuildupStdDev, 0)
+
+	variance.Add(2)
+	if variance.Current() != 0 {
+		t.Errorf("expected 0 for a single value, got %f", variance.Current())
+	}
+
+	for _, v := range []float64{4, 4, 4, 5, 5, 7, 9} {
+		variance.Add(v)
+	}
+	for _, v := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		stddev.Add(v)
+	}
+
+	if got, want := variance.Current(), 32.0/7; math.Abs(got-want) > 1e-12 {
+		t.Errorf("expected variance %f, got %f", want, got)
+	}
+	if got, want := stddev.Current(), math.Sqrt(32.0/7); math.Abs(got-want) > 1e-12 {
+		t.Errorf("expected stddev %f, got %f", want, got)
+	}
+
+	state := variance.Snapshot()
+	variance.Add(100)
+	variance.Restore(state)
+	if got, want := variance.Current(), 32.0/7; math.Abs(got-want) > 1e-12 {
+		t.Errorf("expected variance %f after restore, got %f", want, got)
+	}
+
+	variance.Reset(0)
+	variance.Add(1)
+	variance.Add(3)
+	if variance.Current() != 2 {
+		t.Errorf("expected variance 2 after reset, got %f", variance.Current())
+	}
+}
+
+func TestBuildupPercentile(t *testing.T) {
+	evalCtx := cortex.NewEvalContext()
+	median := evalCtx.GetOrCreateBuildup("median", cortex.BuildupPercentile, 0)
+	p95 := evalCtx.GetOrCreateBuildup("p95", cortex.BuildupPercentile, 0)
+	p95.SetQuantile(0.95, 0)
+
+	for i := 100; i >= 1; i-- {
+		median.Add(float64(i))
+		p95.Add(float64(i))
+	}
+
+	if got := median.Current(); got != 50.5 {
+		t.Errorf("expected median 50.5, got %f", got)
+	}
+	if got := p95.Current(); math.Abs(got-95.05) > 1e-9 {
+		t.Errorf("expected p95 95.05, got %f", got)
+	}
+
+	approx := evalCtx.GetOrCreateBuildup("approx", cortex.BuildupPercentile, 0)
+	approx.SetQuantile(0.5, 1000)
+	for i := 1; i <= 100000; i++ {
+		approx.Add(float64(i))
+	}
+	if n := len(approx.Snapshot().Samples); n != 1000 {
+		t.Errorf("expected 1000 samples kept, got %d", n)
+	}
+	if approx.Count() != 100000 {
+		t.Errorf("expected count 100000, got %d", approx.Count())
+	}
+	if got := approx.Current(); math.Abs(got-50000) > 10000 {
+		t.Errorf("expected approximate median near 50000, got %f", got)
+	}
+
+	// Out-of-range quantiles are clamped, and NaN means the median.
+	for _, tt := range []struct {
+		name    string
+		q, want float64
+	}{
+		{"above", 1.5, 100},
+		{"below", -0.5, 50.5},
+		{"nan", math.NaN(), 50.5},
+	} {
+		b := evalCtx.GetOrCreateBuildup(tt.name, cortex.BuildupPercentile, 0)
+		b.SetQuantile(tt.q, 0)
+		for i := 1; i <= 100; i++ {
+			b.Add(float64(i))
+		}
+		if got := b.Current(); got != tt.want {
+			t.Errorf("q=%v: expected %v, got %v", tt.q, tt.want, got)
+		}
+	}
+}
+
+func TestBuildupPercentileRule(t *testing.T) {
+	rule := cortex.MustBuildup(cortex.BuildupConfig{
+		ID:        "latency",
+		Buildup:   "latency",
+		Operation: cortex.BuildupPercentile,
+		Source:    "ms",
+		Target:    "p90",
+		Quantile:  0.9,
+	})
+
+	evalCtx := cortex.NewEvalContext()
+	for _, ms := range []float64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100, 110} {
+		evalCtx.Set("ms", ms)
+		if err := rule.Evaluate(context.Background(), evalCtx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if got, _ := evalCtx.GetFloat64("p90"); got != 100 {
+		t.Errorf("expected p90=100, got %v", got)
+	}
+
+	for _, cfg := range []cortex.BuildupConfig{
+		{ID: "q", Buildup: "b", Operation: cortex.BuildupPercentile, Source: "s", Quantile: 1.5},
+		{ID: "nan", Buildup: "b", Operation: cortex.BuildupPercentile, Source: "s", Quantile: math.NaN()},
+		{ID: "n", Buildup: "b", Operation: cortex.BuildupPercentile, Source: "s", MaxSamples: -1},
+	} {
+		if _, err := cortex.NewBuildup(cfg); !errors.Is(err, cortex.ErrInvalidRule) {
+			t.Errorf("%s: expected ErrInvalidRule, got %v", cfg.ID, err)
+		}
+	}
+}
+
+func TestBuildupCollect(t *testing.T) {
+	rule := cortex.MustBuildup(cortex.BuildupConfig{
+		ID:        "collect",
+		Buildup:   "names",
+		Operation: cortex.BuildupCollect,
+		Source:    "name",
+		Target:    "all_names",
+	})
+
+	evalCtx := cortex.NewEvalContext()
+	for _, v := range []any{"alice", 42, "bob"} {
+		evalCtx.Set("name", v)
+		if err := rule.Evaluate(context.Background(), evalCtx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	want := []any{"alice", 42, "bob"}
+	if got, _ := evalCtx.Get("all_names"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	b, _ := evalCtx.GetBuildup("names")
+	if b.Current() != 3 || b.Count() != 3 {
+		t.Errorf("expected 3 collected values, got Current=%v Count=%d", b.Current(), b.Count())
+	}
+
+	state := b.Snapshot()
+	b.Add(1.5)
+	if got := b.CurrentValue(); !reflect.DeepEqual(got, []any{"alice", 42, "bob", 1.5}) {
+		t.Errorf("unexpected values after Add: %v", got)
+	}
+	b.Restore(state)
+	if got := b.CurrentValue(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v after restore, got %v", want, got)
+	}
+
+	evalCtx.Delete("name")
+	if err := rule.Evaluate(context.Background(), evalCtx); !errors.Is(err, cortex.ErrValueNotFound) {
+		t.Errorf("expected ErrValueNotFound, got %v", err)
+	}
+
+	sum := evalCtx.GetOrCreateBuildup("sum", cortex.BuildupSum, 0)
+	sum.Collect(2)
+	sum.Collect("x")
+	if got := sum.CurrentValue(); got != 2.0 {
+		t.Errorf("expected numeric buildup CurrentValue 2, got %v", got)
+	}
+}
+
+func TestBuildupDistinctCount(t *testing.T) {
+	rule := cortex.MustBuildup(cortex.BuildupConfig{
+		ID:        "ips",
+		Buildup:   "ips",
+		Operation: cortex.BuildupDistinctCount,
+		Source:    "ip",
+		Target:    "unique_ips",
+	})
+
+	evalCtx := cortex.NewEvalContext()
+	for _, ip := range []any{"10.0.0.1", "10.0.0.2", "10.0.0.1", 7, []int{1, 2}, []int{1, 2}, "10.0.0.3"} {
+		evalCtx.Set("ip", ip)
+		if err := rule.Evaluate(context.Background(), evalCtx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if got, _ := evalCtx.GetFloat64("unique_ips"); got != 5 {
+		t.Errorf("expected 5 distinct values, got %v", got)
+	}
+
+	b, _ := evalCtx.GetBuildup("ips")
+	state := b.Snapshot()
+	b.Collect("10.0.0.9")
+	b.Restore(state)
+	if b.Current() != 5 || b.Count() != 7 {
+		t.Errorf("expected 5 distinct of 7 after restore, got %v of %d", b.Current(), b.Count())
+	}
+}
+
+func TestBuildupDistinctCountLimit(t *testing.T) {
+	evalCtx := cortex.NewEvalContext()
+	b := evalCtx.GetOrCreateBuildup("cards", cortex.BuildupDistinctCount, 0)
+	b.SetDistinctLimit(1000)
+
+	for i := range 1000 {
+		b.Collect(i)
+	}
+	if b.Current() != 1000 {
+		t.Errorf("expected exact count 1000 at the limit, got %v", b.Current())
+	}
+
+	const n = 100000
+	for i := range n {
+		b.Collect(i) // the first 1000 repeat
+	}
+	if got := b.Current(); math.Abs(got-n)/n > 0.03 {
+		t.Errorf("expected estimate within 3%% of %d, got %v", n, got)
+	}
+	if state := b.Snapshot(); len(state.Distinct) != 0 || len(state.Sketch) == 0 {
+		t.Errorf("expected only the sketch to be kept over the limit, got %d values", len(state.Distinct))
+	}
+
+	if _, err := cortex.NewBuildup(cortex.BuildupConfig{
+		ID: "bad", Buildup: "b", Operation: cortex.BuildupDistinctCount, Source: "s", DistinctLimit: -1,
+	}); !errors.Is(err, cortex.ErrInvalidRule) {
+		t.Errorf("expected ErrInvalidRule for negative limit, got %v", err)
+	}
+}
+
+func TestBuildupFirstLast(t *testing.T) {
+	evalCtx := cortex.NewEvalContext()
+	open := evalCtx.GetOrCreateBuildup("open", cortex.BuildupFirst, 0)
+	closing := evalCtx.GetOrCreateBuildup("close", cortex.BuildupLast, 0)
+
+	for _, price := range []float64{101.5, 99, 103.25} {
+		open.Add(price)
+		closing.Add(price)
+	}
+	if open.Current() != 101.5 || closing.Current() != 103.25 {
+		t.Errorf("expected open=101.5 close=103.25, got %v %v", open.Current(), closing.Current())
+	}
+
+	open.Reset(0)
+	closing.Reset(0)
+	if open.Current() != 0 || closing.Current() != 0 {
+		t.Errorf("expected initial values after reset, got %v %v", open.Current(), closing.Current())
+	}
+	open.Add(98)
+	open.Add(97)
+	if open.Current() != 98 {
+		t.Errorf("expected first value after reset 98, got %v", open.Current())
+	}
+}
+
 func TestBuildupReset(t *testing.T) {
 	evalCtx := cortex.NewEvalContext()
 	buildup := evalCtx.GetOrCreateBuildup("total", cortex.BuildupSum, 0)
@@ -162,6 +423,73 @@ func TestBuildupCountRule(t *testing.T) {
 	}
 }
 
+func TestBuildupResetRule(t *testing.T) {
+	sum := cortex.MustBuildup(cortex.BuildupConfig{
+		ID:        "add",
+		Buildup:   "total",
+		Operation: cortex.BuildupSum,
+		Source:    "value",
+		Target:    "running_total",
+	})
+	low := cortex.MustBuildup(cortex.BuildupConfig{
+		ID:        "min",
+		Buildup:   "lowest",
+		Operation: cortex.BuildupMin,
+		Source:    "value",
+		Target:    "lowest",
+	})
+	resetSum := cortex.MustBuildupReset(cortex.BuildupResetConfig{ID: "reset-total", Buildup: "total"})
+	resetLow := cortex.MustBuildupReset(cortex.BuildupResetConfig{ID: "reset-lowest", Buildup: "lowest"})
+
+	evalCtx := cortex.NewEvalContext()
+	ctx := context.Background()
+
+	// Resetting a buildup that does not exist yet is a no-op.
+	if err := resetSum.Evaluate(ctx, evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := evalCtx.GetBuildup("total"); ok {
+		t.Error("expected reset not to create the buildup")
+	}
+
+	for _, v := range []float64{10, 20} {
+		evalCtx.Set("value", v)
+		for _, rule := range []cortex.Rule{sum, low} {
+			if err := rule.Evaluate(ctx, evalCtx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+	for _, rule := range []cortex.Rule{resetSum, resetLow} {
+		if err := rule.Evaluate(ctx, evalCtx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	b, _ := evalCtx.GetBuildup("total")
+	if b.Current() != 0 || b.Count() != 0 {
+		t.Errorf("expected total reset to 0 with count 0, got %v (count %d)", b.Current(), b.Count())
+	}
+
+	// The min buildup restarts from +Inf, so the next value becomes the minimum.
+	evalCtx.Set("value", 50.0)
+	for _, rule := range []cortex.Rule{sum, low} {
+		if err := rule.Evaluate(ctx, evalCtx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if total, _ := evalCtx.GetFloat64("running_total"); total != 50 {
+		t.Errorf("expected running_total=50 after reset, got %v", total)
+	}
+	if lowest, _ := evalCtx.GetFloat64("lowest"); lowest != 50 {
+		t.Errorf("expected lowest=50 after reset, got %v", lowest)
+	}
+
+	if _, err := cortex.NewBuildupReset(cortex.BuildupResetConfig{ID: "reset"}); !errors.Is(err, cortex.ErrInvalidRule) {
+		t.Errorf("expected ErrInvalidRule without a buildup name, got %v", err)
+	}
+}
+
 func TestParseBuildupOperation(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -175,6 +503,13 @@ func TestParseBuildupOperation(t *testing.T) {
 		{"average", cortex.BuildupAvg, false},
 		{"count", cortex.BuildupCount, false},
 		{"product", cortex.BuildupProduct, false},
+		{"variance", cortex.BuildupVariance, false},
+		{"stddev", cortex.BuildupStdDev, false},
+		{"percentile", cortex.BuildupPercentile, false},
+		{"collect", cortex.BuildupCollect, false},
+		{"distinct_count", cortex.BuildupDistinctCount, false},
+		{"first", cortex.BuildupFirst, false},
+		{"last", cortex.BuildupLast, false},
 		{"invalid", 0, true},
 	}
 
@@ -196,3 +531,25 @@ func TestParseBuildupOperation(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildupSnapshotRestore(t *testing.T) {
+	evalCtx := cortex.NewEvalContext()
+	buildup := evalCtx.GetOrCreateBuildup("total", cortex.BuildupAvg, 0)
+
+	buildup.Add(10)
+	buildup.Add(20)
+	state := buildup.Snapshot()
+
+	buildup.Add(60)
+	if buildup.Current() != 30 {
+		t.Fatalf("expected 30, got %f", buildup.Current())
+	}
+
+	buildup.Restore(state)
+	if buildup.Current() != 15 {
+		t.Errorf("expected 15 after restore, got %f", buildup.Current())
+	}
+	if buildup.Count() != 2 {
+		t.Errorf("expected count=2 after restore, got %d", buildup.Count())
+	}
+}
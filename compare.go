@@ -0,0 +1,221 @@
+package cortex
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// EnginesEqual reports whether two engines have equivalent rules and
+// lookups and, if not, describes each difference: rules added, removed,
+// changed (per field) or reordered, and lookups added, removed or changed.
+// Expressions are compared in canonical form, so "a+b" equals "a + b".
+// Go functions (FormulaFunc, ValueFunc) cannot be compared and are only
+// checked for presence.
+func EnginesEqual(a, b *Engine) (bool, []string) {
+	var diffs []string
+
+	aSpecs, aOrder := engineSpecs(a)
+	bSpecs, bOrder := engineSpecs(b)
+
+	for _, id := range aOrder {
+		if _, ok := bSpecs[id]; !ok {
+			diffs = append(diffs, fmt.Sprintf("rule %q removed", id))
+		}
+	}
+	for _, id := range bOrder {
+		as, ok := aSpecs[id]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("rule %q added", id))
+			continue
+		}
+		bs := bSpecs[id]
+		for _, field := range sortedKeys(mergeKeys(as, bs)) {
+			if as[field] != bs[field] {
+				diffs = append(diffs, fmt.Sprintf("rule %q: %s changed from %s to %s", id, field, as[field], bs[field]))
+			}
+		}
+	}
+
+	// Rules run in order, so the relative order of shared rules matters.
+	aCommon := commonOrder(aOrder, bSpecs)
+	bCommon := commonOrder(bOrder, aSpecs)
+	if !reflect.DeepEqual(aCommon, bCommon) {
+		diffs = append(diffs, fmt.Sprintf("rule order changed from %v to %v", aCommon, bCommon))
+	}
+
+	aLookups := a.lookupTables()
+	bLookups := b.lookupTables()
+
+	for _, name := range sortedKeys(aLookups) {
+		if _, ok := bLookups[name]; !ok {
+			diffs = append(diffs, fmt.Sprintf("lookup %q removed", name))
+		}
+	}
+	for _, name := range sortedKeys(bLookups) {
+		al, ok := aLookups[name]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("lookup %q added", name))
+			continue
+		}
+		if !reflect.DeepEqual(al, bLookups[name]) {
+			diffs = append(diffs, fmt.Sprintf("lookup %q changed", name))
+		}
+	}
+
+	return len(diffs) == 0, diffs
+}
+
+// engineSpecs returns the canonical description of each rule by ID, and
+// the rule IDs in evaluation order.
+func engineSpecs(e *Engine) (map[string]map[string]string, []string) {
+	e.mu.RLock()
+	rules := e.rules
+	disabled := e.disabled
+	groupSkipped := e.groupSkipped
+	groups := make(map[string]string, len(e.groups))
+	for id, group := range e.groups {
+		groups[id] = group
+	}
+	e.mu.RUnlock()
+
+	specs := make(map[string]map[string]string, len(rules))
+	order := make([]string, len(rules))
+	for i, rule := range rules {
+		spec := ruleSpec(rule)
+		_, off := disabled[rule.ID()]
+		_, groupOff := groupSkipped[rule.ID()]
+		spec["enabled"] = fmt.Sprint(!off && !groupOff)
+		spec["group"] = groups[rule.ID()]
+		specs[rule.ID()] = spec
+		order[i] = rule.ID()
+	}
+	return specs, order
+}
+
+// ruleSpec describes a rule's configuration as canonical field values.
+func ruleSpec(rule Rule) map[string]string {
+	spec := map[string]string{
+		"type": string(ruleTypeOf(rule)),
+		"deps": fmt.Sprintf("%q", ruleDeps(rule)),
+	}
+	if m, ok := rule.(RuleMetadata); ok {
+		spec["name"] = fmt.Sprintf("%q", m.Name())
+		spec["description"] = fmt.Sprintf("%q", m.Description())
+	}
+	if guard := ruleGuard(rule); guard != nil {
+		spec["when"] = guard.String()
+	}
+
+	switch r := rule.(type) {
+	case *AssignmentRule:
+		spec["target"] = r.target
+		spec["value"] = specValue(r.value)
+		spec["value_func"] = fmt.Sprint(r.valueFunc != nil)
+		spec["value_type"] = string(r.valueType)
+	case *FormulaRule:
+		spec["target"] = r.target
+		spec["inputs"] = fmt.Sprintf("%q", r.inputs)
+		if r.compiledExpr != nil {
+			spec["expression"] = r.compiledExpr.String()
+		}
+		spec["formula_func"] = fmt.Sprint(r.formula != nil)
+		spec["result_type"] = string(r.resultType)
+	case *VectorFormulaRule:
+		spec["target"] = r.target
+		spec["inputs"] = fmt.Sprintf("%q", r.inputs)
+		spec["expression"] = r.compiledExpr.String()
+	case *AllocationRule:
+		spec["source"] = r.source
+		spec["strategy"] = r.strategy.String()
+		spec["targets"] = fmt.Sprintf("%+v", r.targets)
+		spec["remainder"] = r.remainder
+		spec["target"] = r.target
+		spec["share_suffix"] = r.shareSuffix
+		spec["weights_from"] = r.weightsFrom
+		spec["precision"] = fmt.Sprint(r.precision)
+		spec["rounding"] = r.rounding.String()
+		spec["integer_only"] = fmt.Sprint(r.integerOnly)
+		spec["write_remainder_to_source"] = fmt.Sprint(r.drawDown)
+		spec["absorb_remainder"] = fmt.Sprint(r.absorb)
+	case *LookupRule:
+		spec["table"] = r.table
+		spec["key"] = r.keySource
+		spec["keys"] = fmt.Sprintf("%q", r.keySources)
+		spec["target"] = r.target
+		spec["default"] = specValue(r.defaultVal)
+		spec["required"] = fmt.Sprint(r.required)
+		spec["required_key"] = fmt.Sprint(r.requiredKey)
+		spec["default_on_missing_key"] = fmt.Sprint(r.onMissing)
+	case *RecordLookupRule:
+		spec["table"] = r.table
+		spec["key"] = r.keySource
+		spec["fields"] = fmt.Sprintf("%+v", r.fields)
+		spec["required"] = fmt.Sprint(r.required)
+	case *BuildupRule:
+		spec["buildup"] = r.buildup
+		spec["operation"] = r.operation.String()
+		spec["source"] = r.source
+		spec["initial"] = fmt.Sprint(r.initial)
+		spec["target"] = r.target
+		spec["quantile"] = fmt.Sprint(r.quantile)
+		spec["max_samples"] = fmt.Sprint(r.maxSamples)
+		spec["distinct_limit"] = fmt.Sprint(r.distinctLimit)
+	case *BuildupResetRule:
+		spec["buildup"] = r.buildup
+		spec["initial"] = fmt.Sprint(r.initial)
+	case *SubEngineRule:
+		spec["namespace"] = r.namespace
+		spec["engine"] = engineDigest(r.engine)
+	default:
+		spec["type"] = fmt.Sprintf("custom %T", rule)
+	}
+	return spec
+}
+
+// engineDigest renders an engine's rules as one canonical string, so
+// nested engines compare as a single field.
+func engineDigest(e *Engine) string {
+	specs, order := engineSpecs(e)
+	var sb strings.Builder
+	sb.WriteString(e.name)
+	for _, id := range order {
+		spec := specs[id]
+		fmt.Fprintf(&sb, " %s{", id)
+		for _, field := range sortedKeys(spec) {
+			fmt.Fprintf(&sb, "%s=%s;", field, spec[field])
+		}
+		sb.WriteString("}")
+	}
+	return sb.String()
+}
+
+// specValue renders a value with its type, so 1 and "1" differ.
+func specValue(v any) string {
+	if v == nil {
+		return "nil"
+	}
+	return fmt.Sprintf("%T(%v)", v, v)
+}
+
+func mergeKeys(a, b map[string]string) map[string]struct{} {
+	keys := make(map[string]struct{}, len(a))
+	for k := range a {
+		keys[k] = struct{}{}
+	}
+	for k := range b {
+		keys[k] = struct{}{}
+	}
+	return keys
+}
+
+// commonOrder returns the IDs in order that are also in other.
+func commonOrder(order []string, other map[string]map[string]string) []string {
+	common := make([]string, 0, len(order))
+	for _, id := range order {
+		if _, ok := other[id]; ok {
+			common = append(common, id)
+		}
+	}
+	return common
+}
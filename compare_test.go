@@ -0,0 +1,78 @@
+package cortex_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kolosys/cortex"
+)
+
+func comparedEngine(expression string, rate float64) *cortex.Engine {
+	engine := cortex.New("payroll", nil)
+	engine.RegisterLookup(cortex.NewMapLookup("rates", map[string]float64{"CA": rate}))
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "base", Target: "base", Value: 100.0}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "gross", Target: "gross", Expression: expression}),
+		cortex.MustLookup(cortex.LookupConfig{ID: "rate", Table: "rates", Key: "state", Target: "rate"}),
+	)
+	return engine
+}
+
+func TestEnginesEqualIdentical(t *testing.T) {
+	a := comparedEngine("base*2", 0.1)
+	b := comparedEngine("base * 2", 0.1) // same expression, different spacing
+
+	equal, diffs := cortex.EnginesEqual(a, b)
+	if !equal || len(diffs) != 0 {
+		t.Errorf("expected equal engines, got %v", diffs)
+	}
+}
+
+func TestEnginesEqualModified(t *testing.T) {
+	a := comparedEngine("base * 2", 0.1)
+	b := comparedEngine("base * 3", 0.2)
+	b.AddRule(cortex.MustAssignment(cortex.AssignmentConfig{ID: "extra", Target: "x", Value: 1}))
+	b.SetRuleEnabled("base", false)
+
+	equal, diffs := cortex.EnginesEqual(a, b)
+	if equal {
+		t.Fatal("expected engines to differ")
+	}
+
+	want := []string{
+		`rule "base": enabled changed from true to false`,
+		`rule "gross": expression changed from base * 2 to base * 3`,
+		`rule "extra" added`,
+		`lookup "rates" changed`,
+	}
+	joined := strings.Join(diffs, "\n")
+	for _, w := range want {
+		if !strings.Contains(joined, w) {
+			t.Errorf("expected difference %q, got:\n%s", w, joined)
+		}
+	}
+
+	equal, diffs = cortex.EnginesEqual(b, a)
+	if equal || !strings.Contains(strings.Join(diffs, "\n"), `rule "extra" removed`) {
+		t.Errorf("expected extra to be reported as removed, got %v", diffs)
+	}
+}
+
+func TestEnginesEqualReordered(t *testing.T) {
+	a := cortex.New("a", nil)
+	a.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "x", Target: "x", Value: 1}),
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "y", Target: "y", Value: 2}),
+	)
+	b := cortex.New("b", nil)
+	b.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "y", Target: "y", Value: 2}),
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "x", Target: "x", Value: 1}),
+	)
+
+	// Rules run in sequence, so order is significant.
+	equal, diffs := cortex.EnginesEqual(a, b)
+	if equal || len(diffs) != 1 || !strings.Contains(diffs[0], "rule order changed") {
+		t.Errorf("expected a single order difference, got %v", diffs)
+	}
+}
@@ -1,6 +1,12 @@
 package cortex
 
-import "time"
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/kolosys/cortex/expr"
+)
 
 // EvalMode determines how errors are handled during evaluation.
 type EvalMode int
@@ -29,6 +35,35 @@ func (m EvalMode) String() string {
 	}
 }
 
+// OverwritePolicy determines what happens when a rule writes a key that
+// another rule already set during the same evaluation.
+type OverwritePolicy int
+
+const (
+	// OverwriteAllow silently replaces the previous value.
+	OverwriteAllow OverwritePolicy = iota
+
+	// OverwriteWarn replaces the value and logs a warning and metric.
+	OverwriteWarn
+
+	// OverwriteError fails the rule with ErrOverwrite. The new value
+	// has already been written when the error is reported.
+	OverwriteError
+)
+
+func (p OverwritePolicy) String() string {
+	switch p {
+	case OverwriteAllow:
+		return "allow"
+	case OverwriteWarn:
+		return "warn"
+	case OverwriteError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
 // Config configures the engine behavior.
 type Config struct {
 	// Mode determines error handling behavior.
@@ -45,6 +80,83 @@ type Config struct {
 
 	// MaxRules limits the number of rules (0 = unlimited).
 	MaxRules int
+
+	// ValueMetrics maps context keys to metric names. After a successful
+	// evaluation, a histogram is emitted for each mapped key's final
+	// numeric value. Missing or non-numeric values are skipped.
+	ValueMetrics map[string]string
+
+	// CheckLookups verifies before evaluation that every lookup and record
+	// lookup rule's table is registered, failing up front instead of mid-evaluation.
+	CheckLookups bool
+
+	// Overwrite determines how rules writing a key already set by
+	// another rule in the same evaluation are handled.
+	Overwrite OverwritePolicy
+
+	// BatchWorkers bounds how many contexts EvaluateBatch evaluates
+	// concurrently (0 or 1 = sequential).
+	BatchWorkers int
+
+	// BreakerThreshold is the number of consecutive failed evaluations
+	// after which a rule's circuit breaker trips (0 = disabled). While
+	// tripped, the rule fails immediately with ErrCircuitOpen.
+	BreakerThreshold int
+
+	// BreakerCooldown is how long a tripped breaker stays open before
+	// the rule is tried again.
+	BreakerCooldown time.Duration
+
+	// RequiredOutputs lists context keys that must be set when
+	// evaluation completes; if any is missing, evaluation fails with
+	// ErrMissingOutput.
+	RequiredOutputs []string
+
+	// RecoverPanics converts a panic in a rule, such as a custom
+	// FormulaFunc, into a RuleError wrapping ErrEvaluation instead of
+	// crashing the evaluation. It is on in DefaultConfig.
+	RecoverPanics bool
+
+	// MaxEvaluatedRules caps how many rules run in one evaluation
+	// (0 = unlimited). Reaching the cap stops evaluation with
+	// ErrBudgetExceeded and the partial result.
+	MaxEvaluatedRules int
+
+	// DivisionByZero sets what division and modulo by zero produce in
+	// every expression in the engine, including When guards. The zero
+	// value, expr.DivisionError, fails the rule.
+	DivisionByZero expr.DivisionPolicy
+
+	// DivisionFallback is the result of dividing by zero when
+	// DivisionByZero is expr.DivisionFallback.
+	DivisionFallback float64
+
+	// TimeFuncs makes the expression time functions now, days_between
+	// and add_days available in every expression in the engine. now()
+	// reads the EvalContext's clock; see NewEvalContextWithClock.
+	TimeFuncs bool
+
+	// StopWhen is an expression checked after each rule that succeeds.
+	// Once it is true, evaluation stops early and successfully, with the
+	// result's HaltedBy set to "stop_when: " followed by the expression.
+	// A condition reading a key that is not set yet is not met.
+	StopWhen string
+
+	// Transactional gives ModeFailFast evaluations all-or-nothing
+	// semantics: the context is snapshotted before the rules run and, if
+	// evaluation fails, restored to that state, with the Result's
+	// RolledBack set. See EvalContext.Snapshot.
+	Transactional bool
+
+	// TrackProvenance records which rule last set each key during
+	// evaluation, available from Result.Provenance. It is off by default
+	// to avoid the bookkeeping cost. See EvalContext.EnableProvenance.
+	TrackProvenance bool
+
+	// TrackRuleTimings records how long each rule took in
+	// Result.RuleTimings, to find slow rules without a tracer.
+	// EvalOptions.DisableMetrics turns it off for one evaluation.
+	TrackRuleTimings bool
 }
 
 // DefaultConfig returns a Config with sensible defaults.
@@ -55,6 +167,7 @@ func DefaultConfig() *Config {
 		ShortCircuit:  true,
 		EnableMetrics: true,
 		MaxRules:      0,
+		RecoverPanics: true,
 	}
 }
 
@@ -66,11 +179,75 @@ func (c *Config) Validate() error {
 	if c.MaxRules < 0 {
 		return ErrInvalidRule
 	}
+	if c.BatchWorkers < 0 {
+		return ErrInvalidRule
+	}
+	if c.BreakerThreshold < 0 || c.BreakerCooldown < 0 {
+		return ErrInvalidRule
+	}
+	if c.MaxEvaluatedRules < 0 {
+		return ErrInvalidRule
+	}
+	if c.DivisionByZero < expr.DivisionError || c.DivisionByZero > expr.DivisionFallback {
+		return ErrInvalidRule
+	}
+	if c.StopWhen != "" {
+		if _, err := expr.Compile(c.StopWhen); err != nil {
+			return fmt.Errorf("%w: stop condition: %v", ErrInvalidExpression, err)
+		}
+	}
 	return nil
 }
 
+// FieldChange is a Config field that differs between two configs.
+type FieldChange struct {
+	Field string
+	Old   any
+	New   any
+}
+
+func (f FieldChange) String() string {
+	return fmt.Sprintf("%s: %v -> %v", f.Field, f.Old, f.New)
+}
+
+// Diff returns the fields of c that differ in other, in declaration
+// order, for logging what changed when an engine is reconfigured. A nil
+// config compares as the zero Config.
+func (c *Config) Diff(other *Config) []FieldChange {
+	if c == nil {
+		c = &Config{}
+	}
+	if other == nil {
+		other = &Config{}
+	}
+
+	var changes []FieldChange
+	old, updated := reflect.ValueOf(c).Elem(), reflect.ValueOf(other).Elem()
+	for i := range old.NumField() {
+		ov, nv := old.Field(i).Interface(), updated.Field(i).Interface()
+		if !reflect.DeepEqual(ov, nv) {
+			changes = append(changes, FieldChange{Field: old.Type().Field(i).Name, Old: ov, New: nv})
+		}
+	}
+	return changes
+}
+
 func (c *Config) applyDefaults() {
 	if c.Mode < ModeFailFast || c.Mode > ModeContinueOnError {
 		c.Mode = ModeFailFast
 	}
 }
+
+// EvalOptions overrides engine configuration for a single evaluation.
+type EvalOptions struct {
+	// DisableMetrics suppresses all metrics for this evaluation, including
+	// the OnRuleMetric callback and Result.RuleTimings.
+	DisableMetrics bool
+
+	// DisableTrace suppresses tracing spans for this evaluation.
+	DisableTrace bool
+
+	// Explain records a derivation for each value set during this
+	// evaluation; see EvalContext.EnableExplain.
+	Explain bool
+}
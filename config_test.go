@@ -1,9 +1,12 @@
 package cortex_test
 
 import (
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/kolosys/cortex"
+	"github.com/kolosys/cortex/expr"
 )
 
 func TestEvalModeString(t *testing.T) {
@@ -64,4 +67,39 @@ func TestConfigValidate(t *testing.T) {
 	if err := cfg.Validate(); err == nil {
 		t.Error("expected error for negative max rules")
 	}
+
+	cfg = cortex.DefaultConfig()
+	cfg.DivisionByZero = expr.DivisionFallback + 1
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for unknown division policy")
+	}
+}
+
+func TestConfigDiff(t *testing.T) {
+	old := cortex.DefaultConfig()
+	updated := cortex.DefaultConfig()
+	if changes := old.Diff(updated); len(changes) != 0 {
+		t.Errorf("expected no changes between equal configs, got %v", changes)
+	}
+
+	updated.Mode = cortex.ModeCollectAll
+	updated.Timeout = 5 * time.Second
+	updated.RequiredOutputs = []string{"net"}
+
+	want := []cortex.FieldChange{
+		{Field: "Mode", Old: cortex.ModeFailFast, New: cortex.ModeCollectAll},
+		{Field: "Timeout", Old: time.Duration(0), New: 5 * time.Second},
+		{Field: "RequiredOutputs", Old: []string(nil), New: []string{"net"}},
+	}
+	changes := old.Diff(updated)
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("expected %v, got %v", want, changes)
+	}
+	if got := changes[0].String(); got != "Mode: fail_fast -> collect_all" {
+		t.Errorf("unexpected change string %q", got)
+	}
+
+	if changes := old.Diff(nil); len(changes) == 0 {
+		t.Error("expected a nil config to differ from the defaults")
+	}
 }
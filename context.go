@@ -1,10 +1,19 @@
 package cortex
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/kolosys/cortex/expr"
 )
 
 // EvalContext holds the state during rule evaluation.
@@ -14,16 +23,50 @@ type EvalContext struct {
 
 	mu       sync.RWMutex
 	values   map[string]any
+	ordered  bool     // track insertion order of values
+	order    []string // keys in insertion order (ordered mode only)
 	buildups map[string]*Buildup
+	shared   *BuildupSet // replaces buildups during EvaluateBatchShared
 	lookups  map[string]Lookup
 	metadata map[string]string
+	inputs   *EvalContext // read-only fallback for values and lookups
 
 	halted   bool
 	haltedBy string
+	warnings []Warning
+
+	// provenance and overwrite tracking
+	trackProvenance atomic.Bool
+	trackWrites     atomic.Bool
+	currentRule     string
+	producers       map[string]string
+	writers         map[string]string // key -> rule that set it in this evaluation
+	overwrites      []overwrite
+
+	// explain mode
+	trackExplain   atomic.Bool
+	currentExplain string            // default derivation for values set by the current rule
+	explanations   map[string]string // key -> derivation
+
+	// step log recorded by Engine.Explain
+	trackSteps  atomic.Bool
+	currentType RuleType
+	steps       []ExplanationStep
+
+	// output transform applied to values rules write
+	transform atomic.Pointer[OutputTransform]
 
 	rulesEvaluated atomic.Int64
+	rulesSkipped   atomic.Int64
 	errCount       atomic.Int64
 	startTime      time.Time
+	clock          Clock // nil means the real clock
+}
+
+// Clock supplies the current time. Inject a fake one with
+// NewEvalContextWithClock to make durations and now() deterministic.
+type Clock interface {
+	Now() time.Time
 }
 
 // NewEvalContext creates a new evaluation context.
@@ -38,11 +81,101 @@ func NewEvalContext() *EvalContext {
 	}
 }
 
+// NewEvalContextWithClock creates a new evaluation context that takes
+// the time from clock: its start time and Duration, Now, and the now()
+// expression function all use it.
+func NewEvalContextWithClock(clock Clock) *EvalContext {
+	e := NewEvalContext()
+	if clock != nil {
+		e.clock = clock
+		e.startTime = clock.Now()
+	}
+	return e
+}
+
+// NewOrderedEvalContext creates a new evaluation context that remembers
+// the order in which keys were first set. Keys and MarshalJSON then
+// follow insertion order instead of map order.
+func NewOrderedEvalContext() *EvalContext {
+	e := NewEvalContext()
+	e.ordered = true
+	return e
+}
+
+var evalContextPool = sync.Pool{
+	New: func() any { return NewEvalContext() },
+}
+
+// AcquireEvalContext returns an empty evaluation context from a pool.
+// Return it with ReleaseEvalContext once its results are no longer needed.
+func AcquireEvalContext() *EvalContext {
+	e := evalContextPool.Get().(*EvalContext)
+	e.ID = generateID()
+	e.startTime = time.Now()
+	return e
+}
+
+// ReleaseEvalContext resets e and returns it to the pool. The values,
+// buildups and metadata maps are cleared rather than reallocated, so their
+// backing storage is reused; the lookups map is replaced, since clones of
+// e share it. Neither e nor any Result referring to it may be used after
+// release.
+func ReleaseEvalContext(e *EvalContext) {
+	if e == nil {
+		return
+	}
+	e.reset()
+	evalContextPool.Put(e)
+}
+
+// reset clears all state so the context can be reused.
+func (e *EvalContext) reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.ID = ""
+	clear(e.values)
+	e.ordered = false
+	e.order = e.order[:0]
+	clear(e.buildups)
+	e.shared = nil
+	e.lookups = make(map[string]Lookup) // may be shared with a Clone
+	clear(e.metadata)
+	e.inputs = nil
+
+	e.halted = false
+	e.haltedBy = ""
+	e.warnings = nil
+
+	e.trackProvenance.Store(false)
+	e.trackWrites.Store(false)
+	e.currentRule = ""
+	e.producers = nil
+	e.writers = nil
+	e.overwrites = nil
+	e.trackExplain.Store(false)
+	e.currentExplain = ""
+	e.explanations = nil
+	e.trackSteps.Store(false)
+	e.currentType = ""
+	e.steps = nil
+	e.transform.Store(nil)
+
+	e.rulesEvaluated.Store(0)
+	e.rulesSkipped.Store(0)
+	e.errCount.Store(0)
+	e.startTime = time.Time{}
+	e.clock = nil
+}
+
 // Get retrieves a value from the context.
 func (e *EvalContext) Get(key string) (any, bool) {
 	e.mu.RLock()
-	defer e.mu.RUnlock()
 	v, ok := e.values[key]
+	e.mu.RUnlock()
+	if !ok && e.inputs != nil {
+		return e.inputs.Get(key)
+	}
 	return v, ok
 }
 
@@ -65,7 +198,56 @@ func GetTyped[T any](e *EvalContext, key string) (T, bool) {
 func (e *EvalContext) Set(key string, value any) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
+	e.setLocked(key, value)
+}
+
+// SetAll stores all values under a single lock acquisition. Keys are
+// applied in sorted order so ordered contexts are deterministic.
+func (e *EvalContext) SetAll(values map[string]any) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, k := range sortedKeys(values) {
+		e.setLocked(k, values[k])
+	}
+}
+
+// setLocked stores a value; the caller must hold the write lock.
+func (e *EvalContext) setLocked(key string, value any) {
+	if e.currentRule != "" {
+		if fn := e.transform.Load(); fn != nil {
+			value = (*fn)(key, value)
+		}
+	}
+	if e.ordered {
+		if _, exists := e.values[key]; !exists {
+			e.order = append(e.order, key)
+		}
+	}
+	if e.currentRule != "" && e.trackSteps.Load() {
+		e.steps = append(e.steps, ExplanationStep{
+			RuleID:   e.currentRule,
+			Type:     e.currentType,
+			Target:   key,
+			OldValue: e.values[key],
+			NewValue: value,
+		})
+	}
 	e.values[key] = value
+	if e.currentRule == "" {
+		return
+	}
+	if e.trackProvenance.Load() {
+		e.producers[key] = e.currentRule
+	}
+	if e.writers != nil {
+		if prev, ok := e.writers[key]; ok && prev != e.currentRule {
+			e.overwrites = append(e.overwrites, overwrite{key: key, prevRule: prev})
+		}
+		e.writers[key] = e.currentRule
+	}
+	if e.explanations != nil {
+		e.explanations[key] = e.currentExplain
+	}
 }
 
 // SetTyped stores a typed value in the context.
@@ -77,21 +259,59 @@ func SetTyped[T any](e *EvalContext, key string, value T) {
 func (e *EvalContext) Delete(key string) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
+	if _, exists := e.values[key]; exists && e.ordered {
+		for i, k := range e.order {
+			if k == key {
+				e.order = append(e.order[:i], e.order[i+1:]...)
+				break
+			}
+		}
+	}
 	delete(e.values, key)
 }
 
 // Has checks if a key exists in the context.
 func (e *EvalContext) Has(key string) bool {
-	e.mu.RLock()
-	defer e.mu.RUnlock()
-	_, ok := e.values[key]
+	_, ok := e.Get(key)
 	return ok
 }
 
-// Keys returns all keys in the context.
+// GetPath retrieves a value by a dotted path into nested map[string]any
+// values (e.g., "customer.address.zip"). The first segment is looked up
+// as a context key. It returns false if any segment is missing or an
+// intermediate value is not a map.
+func (e *EvalContext) GetPath(path string) (any, bool) {
+	segments := strings.Split(path, ".")
+
+	current, ok := e.Get(segments[0])
+	if !ok {
+		return nil, false
+	}
+
+	for _, seg := range segments[1:] {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[seg]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// Keys returns all keys in the context. For ordered contexts the keys
+// are returned in insertion order.
 func (e *EvalContext) Keys() []string {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
+	if e.ordered {
+		keys := make([]string, len(e.order))
+		copy(keys, e.order)
+		return keys
+	}
 	keys := make([]string, 0, len(e.values))
 	for k := range e.values {
 		keys = append(keys, k)
@@ -110,6 +330,38 @@ func (e *EvalContext) Values() map[string]any {
 	return cp
 }
 
+// MarshalJSON encodes the context values as a JSON object. Ordered
+// contexts emit keys in insertion order; otherwise keys are sorted.
+func (e *EvalContext) MarshalJSON() ([]byte, error) {
+	if !e.ordered {
+		return json.Marshal(e.Values())
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range e.order {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		val, err := json.Marshal(e.values[k])
+		if err != nil {
+			return nil, fmt.Errorf("cortex: marshal value %q: %w", k, err)
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
 // GetFloat64 retrieves a float64 value from the context.
 func (e *EvalContext) GetFloat64(key string) (float64, error) {
 	v, ok := e.Get(key)
@@ -161,11 +413,15 @@ func (e *EvalContext) RegisterLookup(lookup Lookup) {
 	e.lookups[lookup.Name()] = lookup
 }
 
+// HasLookup checks if a lookup table is registered in the context.
+func (e *EvalContext) HasLookup(tableName string) bool {
+	_, ok := e.lookup(tableName)
+	return ok
+}
+
 // Lookup performs a lookup in the specified table.
 func (e *EvalContext) Lookup(tableName string, key any) (any, bool, error) {
-	e.mu.RLock()
-	lookup, ok := e.lookups[tableName]
-	e.mu.RUnlock()
+	lookup, ok := e.lookup(tableName)
 	if !ok {
 		return nil, false, fmt.Errorf("%w: %s", ErrLookupNotFound, tableName)
 	}
@@ -173,18 +429,44 @@ func (e *EvalContext) Lookup(tableName string, key any) (any, bool, error) {
 	return v, found, nil
 }
 
-// GetBuildup returns a buildup accumulator for the given key.
+// lookup returns the named table, falling back to the input context.
+func (e *EvalContext) lookup(tableName string) (Lookup, bool) {
+	e.mu.RLock()
+	lookup, ok := e.lookups[tableName]
+	e.mu.RUnlock()
+	if !ok && e.inputs != nil {
+		return e.inputs.lookup(tableName)
+	}
+	return lookup, ok
+}
+
+// Inputs returns the read-only input context of a context created by
+// Engine.EvaluateToNew, or nil.
+func (e *EvalContext) Inputs() *EvalContext {
+	return e.inputs
+}
+
+// GetBuildup returns a buildup accumulator for the given key. During
+// Engine.EvaluateBatchShared it is looked up in the shared BuildupSet.
 func (e *EvalContext) GetBuildup(key string) (*Buildup, bool) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
+	if e.shared != nil {
+		return e.shared.Get(key)
+	}
 	b, ok := e.buildups[key]
 	return b, ok
 }
 
 // GetOrCreateBuildup returns an existing buildup or creates a new one.
+// During Engine.EvaluateBatchShared it is taken from the shared
+// BuildupSet.
 func (e *EvalContext) GetOrCreateBuildup(key string, op BuildupOperation, initial float64) *Buildup {
 	e.mu.Lock()
 	defer e.mu.Unlock()
+	if e.shared != nil {
+		return e.shared.getOrCreate(key, op, initial)
+	}
 	if b, ok := e.buildups[key]; ok {
 		return b
 	}
@@ -197,6 +479,14 @@ func (e *EvalContext) GetOrCreateBuildup(key string, op BuildupOperation, initia
 	return b
 }
 
+// setSharedBuildups makes the context's buildups come from s; nil
+// restores its own.
+func (e *EvalContext) setSharedBuildups(s *BuildupSet) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.shared = s
+}
+
 // SetMetadata sets a metadata key-value pair.
 func (e *EvalContext) SetMetadata(key, value string) {
 	e.mu.Lock()
@@ -212,6 +502,160 @@ func (e *EvalContext) GetMetadata(key string) (string, bool) {
 	return v, ok
 }
 
+// EnableProvenance turns on recording of which rule set each key.
+func (e *EvalContext) EnableProvenance() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.producers == nil {
+		e.producers = make(map[string]string)
+	}
+	e.trackProvenance.Store(true)
+}
+
+// ProducerOf returns the ID of the rule that last set the key.
+// It returns false if provenance is disabled or the key was not set by a rule.
+func (e *EvalContext) ProducerOf(key string) (string, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	id, ok := e.producers[key]
+	return id, ok
+}
+
+// Provenance returns a copy of the key → rule ID map recorded since
+// provenance was enabled, or nil if it is disabled.
+func (e *EvalContext) Provenance() map[string]string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return maps.Clone(e.producers)
+}
+
+// EnableExplain turns on explain mode: each value set by a rule is
+// annotated with a human-readable derivation, retrieved with Explain.
+func (e *EvalContext) EnableExplain() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.explanations == nil {
+		e.explanations = make(map[string]string)
+	}
+	e.trackExplain.Store(true)
+}
+
+// Explain returns how the key's value was derived. Formula expressions
+// are explained with their inputs substituted, e.g. "x + y = 3 + 4 = 7";
+// other rules by their description, or their ID if they have none.
+// It returns false if explain mode is off or the key was not set by a rule.
+func (e *EvalContext) Explain(key string) (string, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	s, ok := e.explanations[key]
+	return s, ok
+}
+
+// explaining reports whether explain mode is on.
+func (e *EvalContext) explaining() bool {
+	return e.trackExplain.Load()
+}
+
+// setExplanation records the derivation of a key, replacing the default.
+func (e *EvalContext) setExplanation(key, explanation string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.explanations != nil {
+		e.explanations[key] = explanation
+	}
+}
+
+// setCurrentRule sets the rule recorded as the producer of values; nil
+// clears it.
+func (e *EvalContext) setCurrentRule(rule Rule) {
+	if !e.trackProvenance.Load() && !e.trackWrites.Load() && !e.trackExplain.Load() && !e.trackSteps.Load() && e.transform.Load() == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if rule == nil {
+		e.currentRule = ""
+		e.currentType = ""
+		e.currentExplain = ""
+		return
+	}
+	e.currentRule = rule.ID()
+	e.currentType = ruleTypeOf(rule)
+	if e.trackExplain.Load() {
+		e.currentExplain = ruleExplanation(rule)
+	}
+}
+
+// ruleExplanation returns the default derivation for values set by a rule.
+func ruleExplanation(rule Rule) string {
+	if m, ok := rule.(RuleMetadata); ok && m.Description() != "" {
+		return m.Description()
+	}
+	return fmt.Sprintf("set by rule %q", rule.ID())
+}
+
+// setTransform sets the transform applied to values rules write; nil
+// removes it.
+func (e *EvalContext) setTransform(fn OutputTransform) {
+	if fn == nil {
+		e.transform.Store(nil)
+		return
+	}
+	e.transform.Store(&fn)
+}
+
+// overwrite records a rule writing a key already set by another rule.
+type overwrite struct {
+	key      string
+	prevRule string
+}
+
+// startWriteTracking begins recording which rule writes each key,
+// discarding any record from a previous evaluation.
+func (e *EvalContext) startWriteTracking() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.writers = make(map[string]string)
+	e.overwrites = nil
+	e.trackWrites.Store(true)
+}
+
+// takeOverwrites returns and clears the overwrites recorded since the last call.
+func (e *EvalContext) takeOverwrites() []overwrite {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ow := e.overwrites
+	e.overwrites = nil
+	return ow
+}
+
+// Warning is a non-fatal issue recorded by a rule, such as falling back
+// to a default rate.
+type Warning struct {
+	RuleID  string
+	Message string
+}
+
+// String returns the warning formatted for display.
+func (w Warning) String() string {
+	return fmt.Sprintf("rule %q: %s", w.RuleID, w.Message)
+}
+
+// AddWarning records a non-fatal issue. Warnings do not stop evaluation
+// or affect Result.Success; they are reported in Result.Warnings.
+func (e *EvalContext) AddWarning(ruleID, msg string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.warnings = append(e.warnings, Warning{RuleID: ruleID, Message: msg})
+}
+
+// Warnings returns the warnings recorded so far, in the order they were added.
+func (e *EvalContext) Warnings() []Warning {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return slices.Clone(e.warnings)
+}
+
 // Halt stops evaluation with the given rule ID.
 func (e *EvalContext) Halt(ruleID string) {
 	e.mu.Lock()
@@ -239,6 +683,11 @@ func (e *EvalContext) incRulesEvaluated() {
 	e.rulesEvaluated.Add(1)
 }
 
+// incRulesSkipped increments the rules skipped counter.
+func (e *EvalContext) incRulesSkipped() {
+	e.rulesSkipped.Add(1)
+}
+
 // incErrors increments the error counter.
 func (e *EvalContext) incErrors() {
 	e.errCount.Add(1)
@@ -249,6 +698,11 @@ func (e *EvalContext) RulesEvaluated() int64 {
 	return e.rulesEvaluated.Load()
 }
 
+// RulesSkipped returns the number of rules skipped by their When guard.
+func (e *EvalContext) RulesSkipped() int64 {
+	return e.rulesSkipped.Load()
+}
+
 // ErrorCount returns the number of errors encountered.
 func (e *EvalContext) ErrorCount() int64 {
 	return e.errCount.Load()
@@ -256,10 +710,28 @@ func (e *EvalContext) ErrorCount() int64 {
 
 // Duration returns the time since context creation.
 func (e *EvalContext) Duration() time.Duration {
-	return time.Since(e.startTime)
+	return e.Now().Sub(e.startTime)
+}
+
+// Now returns the current time from the context's clock.
+func (e *EvalContext) Now() time.Time {
+	if e.clock != nil {
+		return e.clock.Now()
+	}
+	return time.Now()
+}
+
+// exprContext returns ctx with the context's clock injected for the
+// now() expression function, if a clock was set.
+func (e *EvalContext) exprContext(ctx context.Context) context.Context {
+	if e.clock == nil {
+		return ctx
+	}
+	return expr.WithNow(ctx, e.clock.Now())
 }
 
-// Clone creates a shallow copy of the context.
+// Clone creates a shallow copy of the context. Values and metadata are
+// copied, lookups are shared, and buildups are not copied; see DeepClone.
 func (e *EvalContext) Clone() *EvalContext {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
@@ -267,22 +739,166 @@ func (e *EvalContext) Clone() *EvalContext {
 	clone := &EvalContext{
 		ID:        generateID(),
 		values:    make(map[string]any, len(e.values)),
+		ordered:   e.ordered,
 		buildups:  make(map[string]*Buildup, len(e.buildups)),
 		lookups:   e.lookups, // share lookups
 		metadata:  make(map[string]string, len(e.metadata)),
-		startTime: time.Now(),
+		inputs:    e.inputs,
+		startTime: e.Now(),
+		clock:     e.clock,
 	}
 
 	for k, v := range e.values {
 		clone.values[k] = v
 	}
+	if e.ordered {
+		clone.order = make([]string, len(e.order))
+		copy(clone.order, e.order)
+	}
 	for k, v := range e.metadata {
 		clone.metadata[k] = v
 	}
+	if e.trackProvenance.Load() {
+		clone.producers = make(map[string]string, len(e.producers))
+		for k, v := range e.producers {
+			clone.producers[k] = v
+		}
+		clone.trackProvenance.Store(true)
+	}
+	if e.trackExplain.Load() {
+		clone.explanations = make(map[string]string, len(e.explanations))
+		for k, v := range e.explanations {
+			clone.explanations[k] = v
+		}
+		clone.trackExplain.Store(true)
+	}
+
+	return clone
+}
+
+// DeepClone creates a copy of the context that also copies buildups.
+// Unlike Clone, which leaves the copy with no buildups, each buildup is
+// duplicated so accumulators in the copy are independent of the original.
+// Values are copied by reference and lookups are shared, as with Clone.
+func (e *EvalContext) DeepClone() *EvalContext {
+	clone := e.Clone()
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for k, b := range e.buildups {
+		cp := &Buildup{}
+		cp.Restore(b.Snapshot())
+		clone.buildups[k] = cp
+	}
 
 	return clone
 }
 
+// Snapshot is a point-in-time copy of an EvalContext's values, buildups,
+// metadata, warnings and halt state, taken with EvalContext.Snapshot.
+type Snapshot struct {
+	values    map[string]any
+	order     []string
+	buildups  map[string]BuildupState
+	metadata  map[string]string
+	producers map[string]string
+	warnings  []Warning
+	halted    bool
+	haltedBy  string
+
+	explanations map[string]string
+	writers      map[string]string
+	overwrites   []overwrite
+	steps        []ExplanationStep
+}
+
+// Snapshot captures the context's state, including each buildup's
+// internal state, so it can later be rolled back with Restore. Values are
+// copied by reference, as with Clone.
+func (e *EvalContext) Snapshot() *Snapshot {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	snap := &Snapshot{
+		values:    maps.Clone(e.values),
+		order:     slices.Clone(e.order),
+		buildups:  make(map[string]BuildupState, len(e.buildups)),
+		metadata:  maps.Clone(e.metadata),
+		producers: maps.Clone(e.producers),
+		warnings:  slices.Clone(e.warnings),
+		halted:    e.halted,
+		haltedBy:  e.haltedBy,
+
+		explanations: maps.Clone(e.explanations),
+		writers:      maps.Clone(e.writers),
+		overwrites:   slices.Clone(e.overwrites),
+		steps:        slices.Clone(e.steps),
+	}
+	for k, b := range e.buildups {
+		snap.buildups[k] = b.Snapshot()
+	}
+	return snap
+}
+
+// Restore rolls the context back to snap in place, keeping its ID, lookups
+// and inputs. Values, buildups and metadata added since the snapshot are
+// removed, as are the explanations, overwrites and Explain steps recorded
+// for them. Buildups that existed at the snapshot are restored in place,
+// so *Buildup pointers obtained earlier remain valid.
+func (e *EvalContext) Restore(snap *Snapshot) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.values = maps.Clone(snap.values)
+	if e.values == nil {
+		e.values = make(map[string]any)
+	}
+	if e.ordered {
+		e.order = slices.Clone(snap.order)
+	}
+	for k := range e.buildups {
+		if _, ok := snap.buildups[k]; !ok {
+			delete(e.buildups, k)
+		}
+	}
+	for k, state := range snap.buildups {
+		b, ok := e.buildups[k]
+		if !ok {
+			b = &Buildup{}
+			e.buildups[k] = b
+		}
+		b.Restore(state)
+	}
+	e.metadata = maps.Clone(snap.metadata)
+	if e.metadata == nil {
+		e.metadata = make(map[string]string)
+	}
+	if e.producers != nil {
+		e.producers = maps.Clone(snap.producers)
+		if e.producers == nil {
+			e.producers = make(map[string]string)
+		}
+	}
+	if e.explanations != nil {
+		e.explanations = maps.Clone(snap.explanations)
+		if e.explanations == nil {
+			e.explanations = make(map[string]string)
+		}
+	}
+	if e.writers != nil {
+		e.writers = maps.Clone(snap.writers)
+		if e.writers == nil {
+			e.writers = make(map[string]string)
+		}
+	}
+	e.overwrites = slices.Clone(snap.overwrites)
+	e.steps = slices.Clone(snap.steps)
+	e.warnings = slices.Clone(snap.warnings)
+	e.halted = snap.halted
+	e.haltedBy = snap.haltedBy
+}
+
 // toFloat64 converts various numeric types to float64.
 func toFloat64(v any) (float64, error) {
 	switch n := v.(type) {
@@ -347,8 +963,13 @@ func toInt(v any) (int, error) {
 	}
 }
 
-var idCounter atomic.Uint64
-
-func generateID() string {
-	return fmt.Sprintf("eval-%d-%d", time.Now().UnixNano(), idCounter.Add(1))
+// sortedKeys returns the keys of a map in sorted order, for deterministic
+// iteration wherever map contents produce output.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
@@ -1,8 +1,12 @@
 package cortex_test
 
 import (
+	"context"
+	"encoding/json"
+	"reflect"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/kolosys/cortex"
 )
@@ -196,6 +200,47 @@ func TestEvalContextHalt(t *testing.T) {
 	}
 }
 
+func TestEvalContextWarnings(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRules(
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID: "rate", Target: "rate",
+			Formula: func(ctx context.Context, evalCtx *cortex.EvalContext) (any, error) {
+				if !evalCtx.Has("custom_rate") {
+					evalCtx.AddWarning("rate", "used default rate")
+					return 0.05, nil
+				}
+				return evalCtx.GetFloat64("custom_rate")
+			},
+		}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "fee", Target: "fee", Expression: "amount * rate"}),
+	)
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("amount", 100.0)
+	result, err := engine.Evaluate(context.Background(), evalCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Success || result.RulesEvaluated != 2 {
+		t.Errorf("expected warnings not to affect success, got %+v", result)
+	}
+	want := []cortex.Warning{{RuleID: "rate", Message: "used default rate"}}
+	if !reflect.DeepEqual(result.Warnings, want) {
+		t.Errorf("expected warnings %v, got %v", want, result.Warnings)
+	}
+	if got := result.Warnings[0].String(); got != `rule "rate": used default rate` {
+		t.Errorf("unexpected warning string %q", got)
+	}
+
+	evalCtx = cortex.NewEvalContext()
+	evalCtx.Set("amount", 100.0)
+	evalCtx.Set("custom_rate", 0.1)
+	if result, _ := engine.Evaluate(context.Background(), evalCtx); len(result.Warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", result.Warnings)
+	}
+}
+
 func TestEvalContextMetadata(t *testing.T) {
 	ctx := cortex.NewEvalContext()
 
@@ -260,3 +305,368 @@ func TestEvalContextConcurrency(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestEvalContextGetPath(t *testing.T) {
+	ctx := cortex.NewEvalContext()
+	ctx.Set("customer", map[string]any{
+		"name": "Ada",
+		"address": map[string]any{
+			"zip": "94107",
+		},
+	})
+	ctx.Set("flat", 42)
+
+	tests := []struct {
+		path     string
+		expected any
+		found    bool
+	}{
+		{"flat", 42, true},
+		{"customer.name", "Ada", true},
+		{"customer.address.zip", "94107", true},
+		{"missing", nil, false},
+		{"customer.phone", nil, false},
+		{"customer.address.zip.extra", nil, false},
+		{"flat.child", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			v, ok := ctx.GetPath(tt.path)
+			if ok != tt.found {
+				t.Fatalf("expected found=%v, got %v", tt.found, ok)
+			}
+			if ok && v != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, v)
+			}
+		})
+	}
+}
+
+func TestEvalContextDeepClone(t *testing.T) {
+	ctx := cortex.NewEvalContext()
+	ctx.Set("x", 42)
+	total := ctx.GetOrCreateBuildup("total", cortex.BuildupSum, 0)
+	total.Add(10)
+	total.Add(20)
+
+	clone := ctx.DeepClone()
+
+	cloned, ok := clone.GetBuildup("total")
+	if !ok {
+		t.Fatal("expected deep clone to copy buildups")
+	}
+	if cloned.Current() != 30 || cloned.Count() != 2 {
+		t.Errorf("expected total=30 count=2, got %f count=%d", cloned.Current(), cloned.Count())
+	}
+
+	// Accumulators must be independent
+	cloned.Add(5)
+	total.Add(100)
+	if cloned.Current() != 35 {
+		t.Errorf("expected clone total=35, got %f", cloned.Current())
+	}
+	if total.Current() != 130 {
+		t.Errorf("expected original total=130, got %f", total.Current())
+	}
+
+	// Shallow clone does not copy buildups
+	if _, ok := ctx.Clone().GetBuildup("total"); ok {
+		t.Error("expected shallow clone to have no buildups")
+	}
+}
+
+func TestEvalContextSnapshotRestore(t *testing.T) {
+	ctx := cortex.NewOrderedEvalContext()
+	id := ctx.ID
+	ctx.Set("x", 1)
+	ctx.SetMetadata("scenario", "base")
+	total := ctx.GetOrCreateBuildup("total", cortex.BuildupSum, 0)
+	total.Add(10)
+
+	snap := ctx.Snapshot()
+
+	ctx.Set("x", 2)
+	ctx.Set("y", 3)
+	ctx.SetMetadata("scenario", "what-if")
+	total.Add(5)
+	ctx.GetOrCreateBuildup("extra", cortex.BuildupCount, 0).Add(1)
+	ctx.AddWarning("rule", "careful")
+	ctx.Halt("rule")
+
+	ctx.Restore(snap)
+
+	if ctx.ID != id {
+		t.Errorf("expected Restore to keep ID %q, got %q", id, ctx.ID)
+	}
+	if v, _ := ctx.Get("x"); v != 1 {
+		t.Errorf("expected x=1, got %v", v)
+	}
+	if ctx.Has("y") {
+		t.Error("expected y to be removed")
+	}
+	if !reflect.DeepEqual(ctx.Keys(), []string{"x"}) {
+		t.Errorf("expected keys [x], got %v", ctx.Keys())
+	}
+	if v, _ := ctx.GetMetadata("scenario"); v != "base" {
+		t.Errorf("expected scenario=base, got %q", v)
+	}
+	if total.Current() != 10 || total.Count() != 1 {
+		t.Errorf("expected total=10 count=1 on the same buildup, got %f count=%d", total.Current(), total.Count())
+	}
+	if _, ok := ctx.GetBuildup("extra"); ok {
+		t.Error("expected buildup created after the snapshot to be removed")
+	}
+	if len(ctx.Warnings()) != 0 || ctx.IsHalted() {
+		t.Errorf("expected no warnings and no halt, got %v halted=%v", ctx.Warnings(), ctx.IsHalted())
+	}
+
+	// A snapshot can be restored more than once.
+	ctx.Set("x", 9)
+	total.Add(1)
+	ctx.Restore(snap)
+	if v, _ := ctx.Get("x"); v != 1 || total.Current() != 10 {
+		t.Errorf("expected second restore to give x=1 total=10, got %v %f", v, total.Current())
+	}
+}
+
+func TestEvalContextRestoreExplanationsAndWriters(t *testing.T) {
+	config := cortex.DefaultConfig()
+	config.Overwrite = cortex.OverwriteError
+	engine := cortex.New("test", config)
+	engine.AddRules(
+		// "scratch" writes y, then rolls its own write back.
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID: "scratch", Target: "x",
+			Formula: func(ctx context.Context, evalCtx *cortex.EvalContext) (any, error) {
+				snap := evalCtx.Snapshot()
+				evalCtx.Set("y", 1.0)
+				evalCtx.Restore(snap)
+				return 1.0, nil
+			},
+		}),
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "set-y", Target: "y", Value: 2.0, Deps: []string{"scratch"}}),
+	)
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.EnableExplain()
+	snap := evalCtx.Snapshot()
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("expected the rolled-back write not to count as an overwrite, got %v", err)
+	}
+	if _, ok := evalCtx.Explain("y"); !ok {
+		t.Fatal("expected y to be explained after evaluation")
+	}
+
+	evalCtx.Restore(snap)
+	if _, ok := evalCtx.Explain("y"); ok {
+		t.Error("expected Restore to remove explanations recorded after the snapshot")
+	}
+}
+
+func TestOrderedEvalContext(t *testing.T) {
+	ctx := cortex.NewOrderedEvalContext()
+	ctx.Set("zeta", 1)
+	ctx.Set("alpha", 2)
+	ctx.Set("mid", 3)
+	ctx.Set("zeta", 4) // overwrite keeps original position
+	ctx.Set("tmp", 5)
+	ctx.Delete("tmp")
+	ctx.Set("omega", 6)
+
+	expected := []string{"zeta", "alpha", "mid", "omega"}
+	keys := ctx.Keys()
+	if len(keys) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, keys)
+	}
+	for i, k := range expected {
+		if keys[i] != k {
+			t.Errorf("key %d: expected %q, got %q", i, k, keys[i])
+		}
+	}
+
+	data, err := json.Marshal(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != `{"zeta":4,"alpha":2,"mid":3,"omega":6}` {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+
+	// Clones keep the order
+	clone := ctx.Clone()
+	clone.Set("new", 7)
+	keys = clone.Keys()
+	if keys[0] != "zeta" || keys[len(keys)-1] != "new" {
+		t.Errorf("expected clone to preserve order, got %v", keys)
+	}
+}
+
+func TestEvalContextMarshalJSON(t *testing.T) {
+	ctx := cortex.NewEvalContext()
+	ctx.Set("b", 2)
+	ctx.Set("a", "x")
+
+	data, err := json.Marshal(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != `{"a":"x","b":2}` {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+}
+
+func TestEvalContextSetAll(t *testing.T) {
+	ctx := cortex.NewEvalContext()
+	ctx.Set("a", 0)
+	ctx.SetAll(map[string]any{"a": 1, "b": 2, "c": 3})
+
+	for k, want := range map[string]int{"a": 1, "b": 2, "c": 3} {
+		got, _ := ctx.GetInt(k)
+		if got != want {
+			t.Errorf("expected %s=%d, got %d", k, want, got)
+		}
+	}
+}
+
+func TestEvalContextSetAllConcurrency(t *testing.T) {
+	ctx := cortex.NewEvalContext()
+	var wg sync.WaitGroup
+
+	for i := range 50 {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx.SetAll(map[string]any{"x": i, "y": i})
+		}(i)
+	}
+
+	// Readers must never observe a half-applied batch
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			values := ctx.Values()
+			if x, ok := values["x"]; ok && x != values["y"] {
+				t.Errorf("observed partial update: x=%v y=%v", x, values["y"])
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestOrderedEvalContextSetAllDeterministic(t *testing.T) {
+	values := map[string]any{"d": 1, "b": 2, "e": 3, "a": 4, "c": 5}
+
+	for range 20 {
+		ctx := cortex.NewOrderedEvalContext()
+		ctx.SetAll(values)
+		keys := ctx.Keys()
+		for i, want := range []string{"a", "b", "c", "d", "e"} {
+			if keys[i] != want {
+				t.Fatalf("expected sorted insertion order, got %v", keys)
+			}
+		}
+	}
+}
+
+func TestAcquireReleaseEvalContext(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.RegisterLookup(cortex.NewMapLookup("codes", map[string]int{"a": 1}))
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "x", Target: "x", Value: 1.0}),
+		cortex.MustBuildup(cortex.BuildupConfig{ID: "sum", Buildup: "total", Operation: cortex.BuildupSum, Source: "x"}),
+	)
+
+	for range 10 {
+		ctx := cortex.AcquireEvalContext()
+		if ctx.ID == "" {
+			t.Fatal("expected acquired context to have an ID")
+		}
+		if len(ctx.Keys()) != 0 || ctx.HasLookup("codes") || ctx.IsHalted() || ctx.RulesEvaluated() != 0 || len(ctx.Warnings()) != 0 {
+			t.Fatal("expected acquired context to be empty")
+		}
+		if _, ok := ctx.GetBuildup("total"); ok {
+			t.Fatal("expected acquired context to have no buildups")
+		}
+
+		result, err := engine.Evaluate(context.Background(), ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.RulesEvaluated != 2 {
+			t.Fatalf("expected 2 rules evaluated, got %d", result.RulesEvaluated)
+		}
+		if b, _ := ctx.GetBuildup("total"); b.Current() != 1 {
+			t.Fatalf("expected fresh buildup total=1, got %v", b.Current())
+		}
+
+		ctx.Halt("x")
+		ctx.AddWarning("x", "w")
+		ctx.SetMetadata("k", "v")
+		cortex.ReleaseEvalContext(ctx)
+	}
+
+	cortex.ReleaseEvalContext(nil)
+}
+
+func TestReleaseEvalContextKeepsCloneLookups(t *testing.T) {
+	ctx := cortex.AcquireEvalContext()
+	ctx.RegisterLookup(cortex.NewMapLookup("codes", map[string]int{"a": 1}))
+	clone := ctx.Clone()
+	cortex.ReleaseEvalContext(ctx)
+
+	if !clone.HasLookup("codes") {
+		t.Error("expected releasing the original to leave the clone's lookups")
+	}
+}
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func TestEvalContextWithClock(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+
+	cfg := cortex.DefaultConfig()
+	cfg.TimeFuncs = true
+	engine := cortex.New("test", cfg)
+	engine.AddRules(
+		cortex.MustFormula(cortex.FormulaConfig{ID: "age", Target: "age_days", Expression: `days_between(opened, now())`}),
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID: "stamp", Target: "stamp",
+			Formula: func(ctx context.Context, evalCtx *cortex.EvalContext) (any, error) {
+				return evalCtx.Now(), nil
+			},
+		}),
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID: "tick", Target: "tick",
+			Formula: func(ctx context.Context, evalCtx *cortex.EvalContext) (any, error) {
+				clock.now = clock.now.Add(1500 * time.Millisecond)
+				return true, nil
+			},
+		}),
+	)
+
+	evalCtx := cortex.NewEvalContextWithClock(clock)
+	evalCtx.Set("opened", "2024-02-20")
+	result, err := engine.Evaluate(context.Background(), evalCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, _ := evalCtx.GetFloat64("age_days"); got != 10.5 {
+		t.Errorf("expected age_days=10.5, got %v", got)
+	}
+	if got, _ := cortex.GetTyped[time.Time](evalCtx, "stamp"); !got.Equal(start) {
+		t.Errorf("expected stamp=%v, got %v", start, got)
+	}
+	if evalCtx.Duration() != 1500*time.Millisecond || result.Duration != 1500*time.Millisecond {
+		t.Errorf("expected duration 1.5s, got %v (result %v)", evalCtx.Duration(), result.Duration)
+	}
+	if clone := evalCtx.Clone(); clone.Now() != clock.now || clone.Duration() != 0 {
+		t.Errorf("expected clone to share the clock, got now=%v duration=%v", clone.Now(), clone.Duration())
+	}
+}
@@ -1,10 +1,13 @@
 // Package cortex provides a rules engine for business logic evaluation.
 //
-// Cortex supports five rule types:
+// Cortex supports the following rule types:
 //   - Assignment: Set values directly on the context
 //   - Formula: Calculate values using expressions or functions
+//   - VectorFormula: Apply an expression element-wise across slices
 //   - Allocation: Distribute values across multiple targets
 //   - Lookup: Retrieve values from lookup tables
+//   - RecordLookup: Unpack the fields of a lookup record into several values
+//   - SubEngine: Evaluate another engine as a single step
 //   - Buildup: Accumulate/aggregate values (running totals, sums, etc.)
 //
 // Example:
@@ -20,10 +23,16 @@ package cortex
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"runtime/debug"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/kolosys/cortex/expr"
 )
 
 // Logger provides a simple logging interface.
@@ -90,7 +99,28 @@ type Engine struct {
 	mu      sync.RWMutex
 	rules   []Rule
 	ruleIDs map[string]struct{}
-	lookups map[string]Lookup
+
+	// lookups maps table names to lookups. The map is replaced, never
+	// modified, so evaluations load a snapshot without taking mu.
+	lookups atomic.Pointer[map[string]Lookup]
+
+	// disabled holds IDs of rules skipped during evaluation. It is
+	// replaced, never modified, so evaluations can hold a snapshot.
+	disabled map[string]struct{}
+
+	groups       map[string]string   // rule ID -> group
+	groupsOff    map[string]struct{} // disabled groups
+	groupSkipped map[string]struct{} // IDs of rules in disabled groups; replaced, never modified
+
+	breaker    breaker
+	ruleMetric atomic.Pointer[RuleMetricFunc]
+	transform  atomic.Pointer[OutputTransform]
+
+	exprFuncs map[string]expr.Func // functions registered via RegisterExprFunc
+	exprEval  *expr.Evaluator      // shared by StopWhen and formulas compiled with it
+
+	stopWhen *expr.Expression // compiled Config.StopWhen
+	stopErr  error            // error compiling Config.StopWhen
 }
 
 // New creates a new rules engine.
@@ -100,14 +130,36 @@ func New(name string, config *Config) *Engine {
 	}
 	config.applyDefaults()
 
-	return &Engine{
+	e := &Engine{
 		name:    name,
 		config:  config,
 		obs:     newObservability(),
 		rules:   make([]Rule, 0),
 		ruleIDs: make(map[string]struct{}),
-		lookups: make(map[string]Lookup),
 	}
+	e.lookups.Store(&map[string]Lookup{})
+	e.exprEval = expr.NewEvaluator()
+	if config.TimeFuncs {
+		e.exprEval.RegisterTimeFuncs()
+	}
+	if config.DivisionByZero != expr.DivisionError {
+		e.exprEval.SetDivisionPolicy(config.DivisionByZero, config.DivisionFallback)
+	}
+	if config.StopWhen != "" {
+		e.stopWhen, e.stopErr = expr.CompileWith(config.StopWhen, e.exprEval)
+		if e.stopErr != nil {
+			e.stopErr = fmt.Errorf("%w: stop condition: %v", ErrInvalidExpression, e.stopErr)
+		}
+	}
+	return e
+}
+
+// Evaluator returns the engine's expression evaluator. Formulas compiled
+// with it (see FormulaConfig.Evaluator) share one function table: a
+// function registered with RegisterExprFunc reaches all of them through a
+// single registration, and they are not reconfigured when added.
+func (e *Engine) Evaluator() *expr.Evaluator {
+	return e.exprEval
 }
 
 // Name returns the engine name.
@@ -136,7 +188,44 @@ func (e *Engine) WithObservability(obs *Observability) *Engine {
 	return e
 }
 
-// AddRule adds a rule to the engine.
+// RuleMetricFunc receives the duration and error of one rule evaluation.
+type RuleMetricFunc func(ruleID string, d time.Duration, err error)
+
+// OnRuleMetric sets fn to be called after each rule runs, with how long
+// it took and the error it returned, if any. It is a lightweight, typed
+// alternative to the cortex.rule.duration histogram. fn is called
+// synchronously on the evaluating goroutine, without holding engine locks,
+// and must be safe for concurrent use if evaluations run concurrently.
+// Rules skipped by their When guard are not reported. A nil fn removes it.
+func (e *Engine) OnRuleMetric(fn RuleMetricFunc) {
+	if fn == nil {
+		e.ruleMetric.Store(nil)
+		return
+	}
+	e.ruleMetric.Store(&fn)
+}
+
+// OutputTransform rewrites a value a rule writes before it is stored.
+type OutputTransform func(key string, value any) any
+
+// WithOutputTransform sets fn to be applied to every value a rule writes
+// during evaluation, such as rounding monetary outputs or clamping
+// negatives to zero. Input values set before evaluation are not
+// transformed. fn must be safe for concurrent use if evaluations run
+// concurrently. A nil fn removes it.
+func (e *Engine) WithOutputTransform(fn OutputTransform) *Engine {
+	if fn == nil {
+		e.transform.Store(nil)
+		return e
+	}
+	e.transform.Store(&fn)
+	return e
+}
+
+// AddRule adds a rule to the engine. The rule's expressions are configured
+// in place with the engine's expression functions, time functions and
+// division policy, so a rule with expressions must not be added to more
+// than one engine; build a separate rule for each.
 func (e *Engine) AddRule(rule Rule) error {
 	if e.closed.Load() {
 		return ErrEngineClosed
@@ -153,6 +242,7 @@ func (e *Engine) AddRule(rule Rule) error {
 		return fmt.Errorf("%w: max rules limit reached (%d)", ErrInvalidRule, e.config.MaxRules)
 	}
 
+	e.configureExpressions(rule)
 	e.rules = append(e.rules, rule)
 	e.ruleIDs[rule.ID()] = struct{}{}
 	return nil
@@ -168,6 +258,128 @@ func (e *Engine) AddRules(rules ...Rule) error {
 	return nil
 }
 
+// RemoveRule removes the rule with the given ID.
+// In-flight evaluations keep running against the previous rule set.
+func (e *Engine) RemoveRule(id string) error {
+	if e.closed.Load() {
+		return ErrEngineClosed
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	i := e.indexOf(id)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrRuleNotFound, id)
+	}
+
+	// Copy rather than modify in place: evaluations may hold the old slice.
+	rules := make([]Rule, 0, len(e.rules)-1)
+	rules = append(rules, e.rules[:i]...)
+	rules = append(rules, e.rules[i+1:]...)
+	e.rules = rules
+	delete(e.ruleIDs, id)
+	if _, ok := e.disabled[id]; ok {
+		e.setDisabledLocked(id, false)
+	}
+	if _, ok := e.groups[id]; ok {
+		delete(e.groups, id)
+		e.rebuildGroupSkippedLocked()
+	}
+	e.breaker.forget(id)
+	return nil
+}
+
+// ReplaceRule replaces the rule with the same ID, keeping its position.
+// In-flight evaluations keep running against the previous rule set.
+func (e *Engine) ReplaceRule(rule Rule) error {
+	if e.closed.Load() {
+		return ErrEngineClosed
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	i := e.indexOf(rule.ID())
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrRuleNotFound, rule.ID())
+	}
+
+	e.configureExpressions(rule)
+	rules := make([]Rule, len(e.rules))
+	copy(rules, e.rules)
+	rules[i] = rule
+	e.rules = rules
+	return nil
+}
+
+// SetRuleEnabled enables or disables the rule with the given ID. Disabled
+// rules stay in the engine but are skipped during evaluation and do not
+// count toward RulesEvaluated.
+func (e *Engine) SetRuleEnabled(id string, enabled bool) error {
+	if e.closed.Load() {
+		return ErrEngineClosed
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.ruleIDs[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrRuleNotFound, id)
+	}
+	e.setDisabledLocked(id, !enabled)
+	return nil
+}
+
+// RuleEnabled reports whether the rule with the given ID is enabled.
+// It returns false if the rule does not exist.
+func (e *Engine) RuleEnabled(id string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if _, ok := e.ruleIDs[id]; !ok {
+		return false
+	}
+	_, off := e.disabled[id]
+	return !off
+}
+
+// setDisabledLocked copies the disabled set with id added or removed.
+// The caller must hold e.mu.
+func (e *Engine) setDisabledLocked(id string, disabled bool) {
+	if _, off := e.disabled[id]; off == disabled {
+		return
+	}
+
+	next := make(map[string]struct{}, len(e.disabled)+1)
+	for k := range e.disabled {
+		next[k] = struct{}{}
+	}
+	if disabled {
+		next[id] = struct{}{}
+	} else {
+		delete(next, id)
+	}
+	if len(next) == 0 {
+		next = nil
+	}
+	e.disabled = next
+}
+
+// indexOf returns the position of the rule with the given ID, or -1.
+// The caller must hold e.mu.
+func (e *Engine) indexOf(id string) int {
+	if _, ok := e.ruleIDs[id]; !ok {
+		return -1
+	}
+	for i, r := range e.rules {
+		if r.ID() == id {
+			return i
+		}
+	}
+	return -1
+}
+
 // RegisterLookup registers a lookup table.
 func (e *Engine) RegisterLookup(lookup Lookup) error {
 	if e.closed.Load() {
@@ -177,14 +389,48 @@ func (e *Engine) RegisterLookup(lookup Lookup) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	if _, exists := e.lookups[lookup.Name()]; exists {
+	if _, exists := e.lookupTables()[lookup.Name()]; exists {
 		return fmt.Errorf("%w: %s", ErrDuplicateLookup, lookup.Name())
 	}
 
-	e.lookups[lookup.Name()] = lookup
+	e.storeLookup(lookup)
 	return nil
 }
 
+// UpdateLookup atomically replaces the registered lookup with the same
+// name, such as after reloading reference data. It does not wait for
+// in-flight evaluations: each evaluation uses the lookups registered when
+// it started, so it sees either the old table or the new one throughout,
+// never a mix. It returns ErrLookupNotFound if no lookup has that name.
+func (e *Engine) UpdateLookup(lookup Lookup) error {
+	if e.closed.Load() {
+		return ErrEngineClosed
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, exists := e.lookupTables()[lookup.Name()]; !exists {
+		return fmt.Errorf("%w: %s", ErrLookupNotFound, lookup.Name())
+	}
+
+	e.storeLookup(lookup)
+	return nil
+}
+
+// lookupTables returns the current lookups. The map must not be modified.
+func (e *Engine) lookupTables() map[string]Lookup {
+	return *e.lookups.Load()
+}
+
+// storeLookup publishes a copy of the lookups with lookup added or
+// replaced. The caller must hold e.mu.
+func (e *Engine) storeLookup(lookup Lookup) {
+	lookups := maps.Clone(e.lookupTables())
+	lookups[lookup.Name()] = lookup
+	e.lookups.Store(&lookups)
+}
+
 // RegisterLookups registers multiple lookup tables.
 func (e *Engine) RegisterLookups(lookups ...Lookup) error {
 	for _, lookup := range lookups {
@@ -204,13 +450,83 @@ func (e *Engine) Rules() int {
 
 // Lookups returns the number of registered lookups.
 func (e *Engine) Lookups() int {
-	e.mu.RLock()
-	defer e.mu.RUnlock()
-	return len(e.lookups)
+	return len(e.lookupTables())
 }
 
 // Evaluate runs all rules against the provided context.
 func (e *Engine) Evaluate(ctx context.Context, evalCtx *EvalContext) (*Result, error) {
+	return e.EvaluateWithOptions(ctx, evalCtx, EvalOptions{})
+}
+
+// EvaluateToNew runs all rules reading from inputs, which is left
+// unmodified, and writing to a fresh context returned as result.Context.
+// Reads check the new context first and fall back to inputs, so a rule
+// that updates an input key reads the input value and writes the output.
+// The result context holds only the values produced by the evaluation.
+func (e *Engine) EvaluateToNew(ctx context.Context, inputs *EvalContext) (*Result, error) {
+	if inputs == nil {
+		return nil, ErrNilContext
+	}
+	out := NewEvalContext()
+	out.ordered = inputs.ordered
+	out.inputs = inputs
+	return e.Evaluate(ctx, out)
+}
+
+// TestRule evaluates a single rule in isolation against a new context
+// seeded with inputs and the engine's lookups, and returns that context.
+// The rule's When guard is honoured; disabled rules still run. It is
+// intended for unit-testing rules of a fully built engine.
+func (e *Engine) TestRule(ctx context.Context, ruleID string, inputs map[string]any) (*EvalContext, error) {
+	if e.closed.Load() {
+		return nil, ErrEngineClosed
+	}
+
+	e.mu.RLock()
+	i := e.indexOf(ruleID)
+	if i < 0 {
+		e.mu.RUnlock()
+		return nil, fmt.Errorf("%w: %s", ErrRuleNotFound, ruleID)
+	}
+	rule := e.rules[i]
+	e.mu.RUnlock()
+
+	evalCtx := NewEvalContext()
+	for _, lookup := range e.lookupTables() {
+		evalCtx.RegisterLookup(lookup)
+	}
+
+	evalCtx.SetAll(inputs)
+
+	ok, err := shouldRun(ctx, rule, evalCtx)
+	if err == nil && ok {
+		err = rule.Evaluate(ctx, evalCtx)
+	}
+	if err != nil {
+		return evalCtx, toRuleError(rule, err)
+	}
+	return evalCtx, nil
+}
+
+// EvaluateWithOptions runs all rules against the provided context, with
+// options overriding the engine configuration for this call only.
+func (e *Engine) EvaluateWithOptions(ctx context.Context, evalCtx *EvalContext, opts EvalOptions) (*Result, error) {
+	return e.evaluate(ctx, evalCtx, opts, nil)
+}
+
+// EvaluateInOrder runs the rules in the given order instead of the order
+// they were added, for back-calculations that need a sequence of their
+// own. order must name every rule exactly once, disabled rules included;
+// otherwise nothing runs and ErrInvalidRule or ErrRuleNotFound is returned.
+func (e *Engine) EvaluateInOrder(ctx context.Context, evalCtx *EvalContext, order []string) (*Result, error) {
+	if order == nil {
+		order = []string{}
+	}
+	return e.evaluate(ctx, evalCtx, EvalOptions{}, order)
+}
+
+// evaluate runs the rules in order, or in the order added if order is nil.
+func (e *Engine) evaluate(ctx context.Context, evalCtx *EvalContext, opts EvalOptions, order []string) (result *Result, err error) {
 	if e.closed.Load() {
 		return nil, ErrEngineClosed
 	}
@@ -218,6 +534,9 @@ func (e *Engine) Evaluate(ctx context.Context, evalCtx *EvalContext) (*Result, e
 	if evalCtx == nil {
 		return nil, ErrNilContext
 	}
+	if e.stopErr != nil {
+		return nil, e.stopErr
+	}
 
 	// Apply timeout if configured
 	if e.config.Timeout > 0 {
@@ -226,27 +545,89 @@ func (e *Engine) Evaluate(ctx context.Context, evalCtx *EvalContext) (*Result, e
 		defer cancel()
 	}
 
-	// Start trace
-	ctx, endTrace := e.obs.Tracer.Start(ctx, "cortex.evaluate", "engine", e.name)
-	startTime := time.Now()
-
-	// Copy lookups to eval context
 	e.mu.RLock()
 	rules := e.rules
-	for _, lookup := range e.lookups {
+	disabled := e.disabled
+	groupSkipped := e.groupSkipped
+	e.mu.RUnlock()
+
+	// Copy lookups to eval context; this evaluation keeps using them even
+	// if UpdateLookup swaps a table meanwhile.
+	for _, lookup := range e.lookupTables() {
 		evalCtx.RegisterLookup(lookup)
 	}
-	e.mu.RUnlock()
+
+	if order != nil {
+		if rules, err = orderRules(rules, order); err != nil {
+			return nil, err
+		}
+	}
+
+	if e.config.CheckLookups {
+		if err := checkLookups(rules, evalCtx); err != nil {
+			return nil, err
+		}
+	}
+
+	if e.config.Transactional && e.config.Mode == ModeFailFast {
+		snap := evalCtx.Snapshot()
+		defer func() {
+			if err == nil {
+				return
+			}
+			evalCtx.Restore(snap)
+			if result != nil {
+				result.RolledBack = true
+			}
+		}()
+	}
+
+	run := e.newRun(opts)
+	if run.trackTimings {
+		defer func() {
+			if result != nil {
+				result.RuleTimings = run.timings
+			}
+		}()
+	}
+	if opts.Explain {
+		evalCtx.EnableExplain()
+	}
+	if e.config.TrackProvenance {
+		evalCtx.EnableProvenance()
+	}
+
+	if e.config.Overwrite != OverwriteAllow {
+		evalCtx.startWriteTracking()
+	}
+
+	if fn := e.transform.Load(); fn != nil {
+		evalCtx.setTransform(*fn)
+		defer evalCtx.setTransform(nil)
+	}
+
+	// Fast path for single-rule engines without metrics, tracing, timing
+	// or circuit breakers
+	if len(rules) == 1 && len(disabled) == 0 && len(groupSkipped) == 0 && !run.enableMetrics && run.tracingDisabled() &&
+		run.ruleMetric == nil && !run.trackTimings && e.stopWhen == nil && e.config.BreakerThreshold == 0 {
+		return e.evaluateSingle(ctx, run, rules[0], evalCtx)
+	}
+
+	// Start trace
+	ctx, endTrace := run.obs.Tracer.Start(ctx, "cortex.evaluate", "engine", e.name)
+	startTime := time.Now()
 
 	var errors []RuleError
+	var ran int
+	var stopped bool
 
 	for _, rule := range rules {
 		// Check context cancellation
 		select {
 		case <-ctx.Done():
 			endTrace(ctx.Err())
-			e.obs.Metrics.Inc("cortex.evaluation.timeout", "engine", e.name)
-			return nil, fmt.Errorf("%w: %v", ErrTimeout, ctx.Err())
+			run.obs.Metrics.Inc("cortex.evaluation.timeout", "engine", e.name)
+			return e.timeoutResult(ctx, evalCtx, errors)
 		default:
 		}
 
@@ -255,21 +636,37 @@ func (e *Engine) Evaluate(ctx context.Context, evalCtx *EvalContext) (*Result, e
 			break
 		}
 
-		// Evaluate rule
-		err := e.evaluateRule(ctx, rule, evalCtx)
+		if _, off := disabled[rule.ID()]; off {
+			continue
+		}
+		if _, off := groupSkipped[rule.ID()]; off {
+			evalCtx.incRulesSkipped()
+			continue
+		}
+
+		// Evaluate rule unless its guard says otherwise
+		ok, err := shouldRun(ctx, rule, evalCtx)
+		if err == nil && !ok {
+			evalCtx.incRulesSkipped()
+			continue
+		}
+		if err == nil && e.config.MaxEvaluatedRules > 0 && ran >= e.config.MaxEvaluatedRules {
+			err = fmt.Errorf("%w: limit of %d rules reached before rule %q", ErrBudgetExceeded, e.config.MaxEvaluatedRules, rule.ID())
+			endTrace(err)
+			result := newResult(evalCtx, errors)
+			result.Success = false
+			return result, err
+		}
+		if err == nil {
+			ran++
+			err = e.evaluateRule(ctx, run, rule, evalCtx)
+		}
 		if err != nil {
 			evalCtx.incErrors()
 
-			var ruleErr *RuleError
-			if re, ok := err.(*RuleError); ok {
-				ruleErr = re
-			} else {
-				ruleErr = NewRuleError(rule.ID(), "", "evaluate", err)
-			}
-
-			errors = append(errors, *ruleErr)
-			e.obs.Logger.Error("rule evaluation failed", err, "rule_id", rule.ID())
-			e.obs.Metrics.Inc("cortex.rules.failed", "engine", e.name, "rule_id", rule.ID())
+			errors = append(errors, *toRuleError(rule, err))
+			run.obs.Logger.Error("rule evaluation failed", err, "rule_id", rule.ID())
+			run.obs.Metrics.Inc("cortex.rules.failed", "engine", e.name, "rule_id", rule.ID())
 
 			switch e.config.Mode {
 			case ModeFailFast:
@@ -281,33 +678,329 @@ func (e *Engine) Evaluate(ctx context.Context, evalCtx *EvalContext) (*Result, e
 		}
 
 		evalCtx.incRulesEvaluated()
+
+		if stop, err := e.stopConditionMet(ctx, evalCtx); err != nil {
+			endTrace(err)
+			result := newResult(evalCtx, errors)
+			result.Success = false
+			return result, err
+		} else if stop {
+			evalCtx.Halt("stop_when: " + e.stopWhen.Raw())
+			stopped = true
+			break
+		}
 	}
 
 	duration := time.Since(startTime)
 	endTrace(nil)
 
 	// Emit metrics
-	if e.config.EnableMetrics {
-		e.obs.Metrics.Inc("cortex.evaluations", "engine", e.name)
-		e.obs.Metrics.Add("cortex.rules.evaluated", float64(evalCtx.RulesEvaluated()), "engine", e.name)
-		e.obs.Metrics.Histogram("cortex.evaluation.duration", duration.Seconds(), "engine", e.name)
+	if run.enableMetrics {
+		run.obs.Metrics.Inc("cortex.evaluations", "engine", e.name)
+		run.obs.Metrics.Add("cortex.rules.evaluated", float64(evalCtx.RulesEvaluated()), "engine", e.name)
+		run.obs.Metrics.Histogram("cortex.evaluation.duration", duration.Seconds(), "engine", e.name)
+	}
+	e.emitValueMetrics(run, evalCtx)
+
+	result, err = e.finishResult(evalCtx, errors)
+	if stopped {
+		// Stopping on Config.StopWhen is a successful early exit.
+		result.Success = len(errors) == 0
+	}
+	return result, err
+}
+
+// stopConditionMet reports whether Config.StopWhen is set and true.
+func (e *Engine) stopConditionMet(ctx context.Context, evalCtx *EvalContext) (bool, error) {
+	if e.stopWhen == nil {
+		return false, nil
+	}
+	for _, name := range e.stopWhen.Variables() {
+		if !evalCtx.Has(name) {
+			return false, nil
+		}
+	}
+	v, err := e.stopWhen.Eval(evalCtx.exprContext(ctx), evalCtx)
+	if err != nil {
+		return false, fmt.Errorf("%w: stop condition: %v", ErrEvaluation, err)
+	}
+	stop, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%w: stop condition returned %T, expected bool", ErrTypeMismatch, v)
+	}
+	return stop, nil
+}
+
+// evaluateSingle evaluates a single rule without the per-rule trace span
+// and timing. It must behave identically to the general loop in Evaluate.
+func (e *Engine) evaluateSingle(ctx context.Context, run *evalRun, rule Rule, evalCtx *EvalContext) (*Result, error) {
+	select {
+	case <-ctx.Done():
+		run.obs.Metrics.Inc("cortex.evaluation.timeout", "engine", e.name)
+		return e.timeoutResult(ctx, evalCtx, nil)
+	default:
+	}
+
+	if e.config.ShortCircuit && evalCtx.IsHalted() {
+		e.emitValueMetrics(run, evalCtx)
+		return e.finishResult(evalCtx, nil)
 	}
 
+	ok, err := shouldRun(ctx, rule, evalCtx)
+	if err == nil && !ok {
+		evalCtx.incRulesSkipped()
+		e.emitValueMetrics(run, evalCtx)
+		return e.finishResult(evalCtx, nil)
+	}
+	if err == nil {
+		err = e.runRule(ctx, rule, evalCtx)
+	}
+	if err == nil {
+		err = e.checkOverwrites(run, rule, evalCtx)
+	}
+
+	if err != nil {
+		evalCtx.incErrors()
+
+		errors := []RuleError{*toRuleError(rule, err)}
+		run.obs.Logger.Error("rule evaluation failed", err, "rule_id", rule.ID())
+		run.obs.Metrics.Inc("cortex.rules.failed", "engine", e.name, "rule_id", rule.ID())
+
+		if e.config.Mode == ModeFailFast {
+			return newResult(evalCtx, errors), err
+		}
+		e.emitValueMetrics(run, evalCtx)
+		return e.finishResult(evalCtx, errors)
+	}
+
+	evalCtx.incRulesEvaluated()
+	e.emitValueMetrics(run, evalCtx)
+	return e.finishResult(evalCtx, nil)
+}
+
+// timeoutResult returns ErrTimeout for an evaluation cut short by ctx. In
+// ModeCollectAll the partial result is returned too, flagged TimedOut, so
+// callers can use the values of the rules that did run.
+func (e *Engine) timeoutResult(ctx context.Context, evalCtx *EvalContext, errors []RuleError) (*Result, error) {
+	err := fmt.Errorf("%w: %v", ErrTimeout, ctx.Err())
+	if e.config.Mode != ModeCollectAll {
+		return nil, err
+	}
 	result := newResult(evalCtx, errors)
+	result.Success = false
+	result.TimedOut = true
+	return result, err
+}
+
+// finishResult builds the result of an evaluation that ran to completion,
+// failing it if any of Config.RequiredOutputs is missing. Halted
+// evaluations are not checked.
+func (e *Engine) finishResult(evalCtx *EvalContext, errors []RuleError) (*Result, error) {
+	result := newResult(evalCtx, errors)
+	if len(e.config.RequiredOutputs) == 0 || evalCtx.IsHalted() {
+		return result, nil
+	}
+
+	var missing []string
+	for _, key := range e.config.RequiredOutputs {
+		if !evalCtx.Has(key) {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		result.Success = false
+		return result, fmt.Errorf("%w: %s", ErrMissingOutput, strings.Join(missing, ", "))
+	}
 	return result, nil
 }
 
-func (e *Engine) evaluateRule(ctx context.Context, rule Rule, evalCtx *EvalContext) error {
-	ctx, endTrace := e.obs.Tracer.Start(ctx, "cortex.rule", "rule_id", rule.ID())
+// emitValueMetrics emits a histogram for each key in Config.ValueMetrics.
+func (e *Engine) emitValueMetrics(run *evalRun, evalCtx *EvalContext) {
+	for _, key := range sortedKeys(e.config.ValueMetrics) {
+		name := e.config.ValueMetrics[key]
+		v, err := evalCtx.GetFloat64(key)
+		if err != nil {
+			continue
+		}
+		run.obs.Metrics.Histogram(name, v, "engine", e.name, "key", key)
+	}
+}
+
+// checkOverwrites applies the overwrite policy to keys the rule just
+// wrote that another rule had already set.
+func (e *Engine) checkOverwrites(run *evalRun, rule Rule, evalCtx *EvalContext) error {
+	if e.config.Overwrite == OverwriteAllow {
+		return nil
+	}
+
+	var errs []error
+	for _, ow := range evalCtx.takeOverwrites() {
+		if e.config.Overwrite == OverwriteError {
+			errs = append(errs, fmt.Errorf("%w: key %q set by rule %q", ErrOverwrite, ow.key, ow.prevRule))
+			continue
+		}
+		run.obs.Logger.Warn("rule overwrote value", "rule_id", rule.ID(), "key", ow.key, "previous_rule", ow.prevRule)
+		run.obs.Metrics.Inc("cortex.rules.overwrite", "engine", e.name, "rule_id", rule.ID(), "key", ow.key)
+	}
+	if len(errs) > 0 {
+		return NewRuleError(rule.ID(), "", "evaluate", errors.Join(errs...))
+	}
+	return nil
+}
+
+// checkLookups verifies that every lookup and record lookup rule
+// references a registered table.
+func checkLookups(rules []Rule, evalCtx *EvalContext) error {
+	var errs []error
+	for _, rule := range rules {
+		var table string
+		var ruleType RuleType
+		switch r := rule.(type) {
+		case *LookupRule:
+			table, ruleType = r.Table(), RuleTypeLookup
+		case *RecordLookupRule:
+			table, ruleType = r.Table(), RuleTypeRecordLookup
+		default:
+			continue
+		}
+		if !evalCtx.HasLookup(table) {
+			errs = append(errs, NewRuleError(rule.ID(), string(ruleType), "validate",
+				fmt.Errorf("%w: %s", ErrLookupNotFound, table)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// evalRun holds the settings for a single evaluation, derived from the
+// engine configuration and the call's EvalOptions.
+type evalRun struct {
+	obs           Observability
+	enableMetrics bool
+	ruleMetric    RuleMetricFunc
+	trackTimings  bool
+	timings       []RuleTiming
+}
+
+func (e *Engine) newRun(opts EvalOptions) *evalRun {
+	run := &evalRun{
+		obs:           *e.obs,
+		enableMetrics: e.config.EnableMetrics,
+		trackTimings:  e.config.TrackRuleTimings,
+	}
+	if fn := e.ruleMetric.Load(); fn != nil {
+		run.ruleMetric = *fn
+	}
+	if opts.DisableMetrics {
+		run.obs.Metrics = nopMetrics{}
+		run.enableMetrics = false
+		run.ruleMetric = nil
+		run.trackTimings = false
+	}
+	if opts.DisableTrace {
+		run.obs.Tracer = nopTracer{}
+	}
+	return run
+}
+
+// tracingDisabled reports whether the run uses the no-op tracer.
+func (r *evalRun) tracingDisabled() bool {
+	_, ok := r.obs.Tracer.(nopTracer)
+	return ok
+}
+
+// orderRules returns rules rearranged to match order, which must name
+// each of them exactly once.
+func orderRules(rules []Rule, order []string) ([]Rule, error) {
+	byID := make(map[string]Rule, len(rules))
+	for _, rule := range rules {
+		byID[rule.ID()] = rule
+	}
+
+	ordered := make([]Rule, 0, len(order))
+	for _, id := range order {
+		rule, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q in evaluation order", ErrRuleNotFound, id)
+		}
+		if rule == nil {
+			return nil, fmt.Errorf("%w: rule %q appears more than once in evaluation order", ErrInvalidRule, id)
+		}
+		byID[id] = nil
+		ordered = append(ordered, rule)
+	}
+
+	if len(ordered) != len(rules) {
+		for _, rule := range rules {
+			if byID[rule.ID()] != nil {
+				return nil, fmt.Errorf("%w: evaluation order omits rule %q", ErrInvalidRule, rule.ID())
+			}
+		}
+	}
+	return ordered, nil
+}
+
+// toRuleError returns err as a *RuleError, wrapping it if necessary.
+func toRuleError(rule Rule, err error) *RuleError {
+	if re, ok := err.(*RuleError); ok {
+		return re
+	}
+	return NewRuleError(rule.ID(), "", "evaluate", err)
+}
+
+// runRule calls the rule's Evaluate with it recorded as the current rule.
+// If Config.RecoverPanics is set, a panic is returned as a RuleError
+// wrapping ErrEvaluation.
+func (e *Engine) runRule(ctx context.Context, rule Rule, evalCtx *EvalContext) (err error) {
+	evalCtx.setCurrentRule(rule)
+	defer evalCtx.setCurrentRule(nil)
+
+	if e.config.RecoverPanics {
+		defer func() {
+			if p := recover(); p != nil {
+				err = NewRuleError(rule.ID(), string(ruleTypeOf(rule)), "evaluate",
+					fmt.Errorf("%w: panic: %v\n%s", ErrEvaluation, p, debug.Stack()))
+			}
+		}()
+	}
+	return rule.Evaluate(ctx, evalCtx)
+}
+
+func (e *Engine) evaluateRule(ctx context.Context, run *evalRun, rule Rule, evalCtx *EvalContext) error {
+	useBreaker := e.config.BreakerThreshold > 0
+	if useBreaker && !e.breaker.allow(rule.ID(), time.Now()) {
+		return NewRuleError(rule.ID(), string(ruleTypeOf(rule)), "evaluate", ErrCircuitOpen)
+	}
+
+	ctx, endTrace := run.obs.Tracer.Start(ctx, "cortex.rule", "rule_id", rule.ID())
 	startTime := time.Now()
 
-	err := rule.Evaluate(ctx, evalCtx)
+	err := e.runRule(ctx, rule, evalCtx)
+	if err == nil {
+		err = e.checkOverwrites(run, rule, evalCtx)
+	}
 
 	duration := time.Since(startTime)
 	endTrace(err)
 
-	if e.config.EnableMetrics {
-		e.obs.Metrics.Histogram("cortex.rule.duration", duration.Seconds(), "rule_id", rule.ID())
+	if run.enableMetrics {
+		run.obs.Metrics.Histogram("cortex.rule.duration", duration.Seconds(), "rule_id", rule.ID())
+	}
+	if run.ruleMetric != nil {
+		run.ruleMetric(rule.ID(), duration, err)
+	}
+	if run.trackTimings {
+		run.timings = append(run.timings, RuleTiming{RuleID: rule.ID(), Duration: duration})
+	}
+
+	if useBreaker {
+		tripped, reset := e.breaker.record(rule.ID(), err != nil, e.config.BreakerThreshold, e.config.BreakerCooldown, time.Now())
+		if tripped {
+			run.obs.Logger.Warn("rule circuit breaker tripped", "rule_id", rule.ID())
+			run.obs.Metrics.Inc("cortex.breaker.trip", "engine", e.name, "rule_id", rule.ID())
+		}
+		if reset {
+			run.obs.Metrics.Inc("cortex.breaker.reset", "engine", e.name, "rule_id", rule.ID())
+		}
 	}
 
 	return err
@@ -319,8 +1012,8 @@ func (e *Engine) Close() error {
 	return nil
 }
 
-// Clone creates a copy of the engine with the same configuration and lookups,
-// but without any rules.
+// Clone creates a copy of the engine with the same configuration, lookups
+// and expression functions, but without any rules.
 func (e *Engine) Clone(name string) *Engine {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
@@ -328,8 +1021,11 @@ func (e *Engine) Clone(name string) *Engine {
 	clone := New(name, e.config)
 	clone.obs = e.obs
 
-	for k, v := range e.lookups {
-		clone.lookups[k] = v
+	clone.lookups.Store(e.lookups.Load()) // never modified, so safe to share
+
+	clone.exprFuncs = maps.Clone(e.exprFuncs)
+	for _, name := range sortedKeys(clone.exprFuncs) {
+		clone.exprEval.RegisterFunc(name, clone.exprFuncs[name])
 	}
 
 	return clone
@@ -3,7 +3,11 @@ package cortex_test
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -132,6 +136,56 @@ func TestEngineTimeout(t *testing.T) {
 	}
 }
 
+func TestEngineTimeoutPartialResult(t *testing.T) {
+	config := cortex.DefaultConfig()
+	config.Mode = cortex.ModeCollectAll
+	config.Timeout = 5 * time.Millisecond
+
+	engine := cortex.New("test", config)
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "fast", Target: "score", Value: 0.7}),
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID:     "slow",
+			Target: "bonus",
+			Formula: func(ctx context.Context, evalCtx *cortex.EvalContext) (any, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		}),
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "after", Target: "late", Value: 1.0}),
+	)
+
+	result, err := engine.Evaluate(context.Background(), cortex.NewEvalContext())
+	if !errors.Is(err, cortex.ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+	if result == nil {
+		t.Fatal("expected partial result")
+	}
+	if !result.TimedOut || result.Success {
+		t.Errorf("expected TimedOut and unsuccessful result, got %+v", result)
+	}
+	if result.RulesEvaluated != 1 || result.RulesFailed != 1 {
+		t.Errorf("expected 1 evaluated and 1 failed rule, got %d and %d", result.RulesEvaluated, result.RulesFailed)
+	}
+	if score, _ := result.Context.GetFloat64("score"); score != 0.7 {
+		t.Errorf("expected score=0.7 in partial result, got %v", score)
+	}
+	if result.Context.Has("late") {
+		t.Error("rules after the timeout should not run")
+	}
+
+	// Fail-fast mode still returns no result.
+	config.Mode = cortex.ModeFailFast
+	engine = cortex.New("test", config)
+	engine.AddRule(cortex.MustAssignment(cortex.AssignmentConfig{ID: "fast", Target: "score", Value: 0.7}))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if result, err := engine.Evaluate(ctx, cortex.NewEvalContext()); result != nil || !errors.Is(err, cortex.ErrTimeout) {
+		t.Errorf("expected nil result and ErrTimeout, got %v, %v", result, err)
+	}
+}
+
 func TestEngineFailFast(t *testing.T) {
 	config := cortex.DefaultConfig()
 	config.Mode = cortex.ModeFailFast
@@ -374,3 +428,1406 @@ func TestEngineNilContext(t *testing.T) {
 		t.Errorf("expected ErrNilContext, got %v", err)
 	}
 }
+
+func TestEngineSingleRuleFastPath(t *testing.T) {
+	newEngine := func(mode cortex.EvalMode, enableMetrics bool, rule cortex.Rule) *cortex.Engine {
+		config := cortex.DefaultConfig()
+		config.Mode = mode
+		config.EnableMetrics = enableMetrics
+		engine := cortex.New("test", config)
+		engine.AddRule(rule)
+		return engine
+	}
+
+	ok := cortex.MustAssignment(cortex.AssignmentConfig{
+		ID:     "set-x",
+		Target: "x",
+		Value:  42.0,
+	})
+	fail := cortex.MustFormula(cortex.FormulaConfig{
+		ID:     "fail",
+		Target: "x",
+		Formula: func(ctx context.Context, evalCtx *cortex.EvalContext) (any, error) {
+			return nil, errors.New("intentional error")
+		},
+	})
+
+	tests := []struct {
+		name    string
+		mode    cortex.EvalMode
+		rule    cortex.Rule
+		wantErr bool
+	}{
+		{"success", cortex.ModeFailFast, ok, false},
+		{"fail fast", cortex.ModeFailFast, fail, true},
+		{"collect all", cortex.ModeCollectAll, fail, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fast, fastErr := newEngine(tt.mode, false, tt.rule).Evaluate(context.Background(), cortex.NewEvalContext())
+			slow, slowErr := newEngine(tt.mode, true, tt.rule).Evaluate(context.Background(), cortex.NewEvalContext())
+
+			if (fastErr != nil) != tt.wantErr || (slowErr != nil) != tt.wantErr {
+				t.Fatalf("expected error=%v, got fast=%v slow=%v", tt.wantErr, fastErr, slowErr)
+			}
+			if fast.Success != slow.Success {
+				t.Errorf("success mismatch: fast=%v slow=%v", fast.Success, slow.Success)
+			}
+			if fast.RulesEvaluated != slow.RulesEvaluated {
+				t.Errorf("rules evaluated mismatch: fast=%d slow=%d", fast.RulesEvaluated, slow.RulesEvaluated)
+			}
+			if len(fast.Errors) != len(slow.Errors) {
+				t.Errorf("errors mismatch: fast=%d slow=%d", len(fast.Errors), len(slow.Errors))
+			}
+		})
+	}
+}
+
+func TestEngineSingleRuleHalted(t *testing.T) {
+	config := cortex.DefaultConfig()
+	config.EnableMetrics = false
+	engine := cortex.New("test", config)
+	engine.AddRule(cortex.MustAssignment(cortex.AssignmentConfig{
+		ID:     "set-x",
+		Target: "x",
+		Value:  42.0,
+	}))
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Halt("external")
+
+	result, err := engine.Evaluate(context.Background(), evalCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.RulesEvaluated != 0 {
+		t.Errorf("expected 0 rules evaluated, got %d", result.RulesEvaluated)
+	}
+	if evalCtx.Has("x") {
+		t.Error("rule should not run on a halted context")
+	}
+}
+
+func TestEngineSingleRuleHaltedMatchesGeneralPath(t *testing.T) {
+	evaluate := func(general bool) (*cortex.Result, map[string][]float64, error) {
+		config := cortex.DefaultConfig()
+		config.EnableMetrics = false
+		config.ValueMetrics = map[string]string{"x": "x.value"}
+		config.RequiredOutputs = []string{"y"}
+		metrics := newRecordingMetrics()
+		engine := cortex.New("test", config).WithObservability(&cortex.Observability{Metrics: metrics})
+		engine.AddRule(cortex.MustAssignment(cortex.AssignmentConfig{ID: "set-y", Target: "y", Value: 1.0}))
+		if general {
+			// A disabled rule changes nothing but rules out the fast path.
+			engine.AddRule(cortex.MustAssignment(cortex.AssignmentConfig{ID: "off", Target: "z", Value: 2.0}))
+			engine.SetRuleEnabled("off", false)
+		}
+
+		evalCtx := cortex.NewEvalContext()
+		evalCtx.Set("x", 5.0)
+		evalCtx.Halt("external")
+		result, err := engine.Evaluate(context.Background(), evalCtx)
+		if result != nil {
+			result.ID, result.Duration, result.Context = "", 0, nil
+		}
+		return result, metrics.histograms, err
+	}
+
+	fast, fastMetrics, fastErr := evaluate(false)
+	slow, slowMetrics, slowErr := evaluate(true)
+	if fastErr != nil || slowErr != nil {
+		t.Fatalf("unexpected errors: fast=%v slow=%v", fastErr, slowErr)
+	}
+	if !reflect.DeepEqual(fast, slow) {
+		t.Errorf("result mismatch:\nfast=%+v\nslow=%+v", fast, slow)
+	}
+	if !reflect.DeepEqual(fastMetrics, slowMetrics) || len(fastMetrics["x.value"]) != 1 {
+		t.Errorf("value metrics mismatch: fast=%v slow=%v", fastMetrics, slowMetrics)
+	}
+}
+
+type recordingMetrics struct {
+	mu         sync.Mutex
+	counters   map[string]float64
+	histograms map[string][]float64
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{
+		counters:   make(map[string]float64),
+		histograms: make(map[string][]float64),
+	}
+}
+
+func (m *recordingMetrics) Inc(name string, kv ...any) {
+	m.Add(name, 1, kv...)
+}
+
+func (m *recordingMetrics) Add(name string, v float64, kv ...any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[name] += v
+}
+
+func (m *recordingMetrics) Histogram(name string, v float64, kv ...any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.histograms[name] = append(m.histograms[name], v)
+}
+
+func TestEngineValueMetrics(t *testing.T) {
+	config := cortex.DefaultConfig()
+	config.ValueMetrics = map[string]string{
+		"tax":     "payroll.tax",
+		"status":  "payroll.status",
+		"missing": "payroll.missing",
+	}
+
+	metrics := newRecordingMetrics()
+	engine := cortex.New("test", config).WithObservability(&cortex.Observability{Metrics: metrics})
+
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{
+			ID:     "set-tax",
+			Target: "tax",
+			Value:  1250.5,
+		}),
+		cortex.MustAssignment(cortex.AssignmentConfig{
+			ID:     "set-status",
+			Target: "status",
+			Value:  "active",
+		}),
+	)
+
+	if _, err := engine.Evaluate(context.Background(), cortex.NewEvalContext()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tax := metrics.histograms["payroll.tax"]
+	if len(tax) != 1 || tax[0] != 1250.5 {
+		t.Errorf("expected payroll.tax=[1250.5], got %v", tax)
+	}
+	if _, ok := metrics.histograms["payroll.status"]; ok {
+		t.Error("non-numeric value should not be emitted")
+	}
+	if _, ok := metrics.histograms["payroll.missing"]; ok {
+		t.Error("missing value should not be emitted")
+	}
+}
+
+func TestEngineProvenance(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{
+			ID:     "set-x",
+			Target: "x",
+			Value:  10.0,
+		}),
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID:         "calc-y",
+			Target:     "y",
+			Expression: "x * 2",
+		}),
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID:         "override-x",
+			Target:     "x",
+			Expression: "y + 1",
+		}),
+	)
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.EnableProvenance()
+	evalCtx.Set("seed", 1.0)
+
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := map[string]string{"x": "override-x", "y": "calc-y"}
+	for key, want := range tests {
+		got, ok := evalCtx.ProducerOf(key)
+		if !ok || got != want {
+			t.Errorf("expected producer of %q to be %q, got %q", key, want, got)
+		}
+	}
+
+	if _, ok := evalCtx.ProducerOf("seed"); ok {
+		t.Error("values set outside evaluation should have no producer")
+	}
+}
+
+func TestEngineProvenanceDisabled(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRule(cortex.MustAssignment(cortex.AssignmentConfig{
+		ID:     "set-x",
+		Target: "x",
+		Value:  10.0,
+	}))
+
+	evalCtx := cortex.NewEvalContext()
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := evalCtx.ProducerOf("x"); ok {
+		t.Error("expected no producer when provenance is disabled")
+	}
+}
+
+func TestEngineTrackProvenance(t *testing.T) {
+	cfg := cortex.DefaultConfig()
+	cfg.TrackProvenance = true
+	engine := cortex.New("test", cfg)
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "set-rate", Target: "rate", Value: 0.2}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "calc-tax", Target: "tax", Expression: "income * rate"}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "adjust-tax", Target: "tax", Expression: "tax - 10"}),
+	)
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("income", 1000.0)
+	result, err := engine.Evaluate(context.Background(), evalCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[string]string{"rate": "set-rate", "tax": "adjust-tax"}
+	if got := result.Provenance(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected provenance %v, got %v", want, got)
+	}
+
+	// The returned map is a copy.
+	result.Provenance()["tax"] = "changed"
+	if id, _ := evalCtx.ProducerOf("tax"); id != "adjust-tax" {
+		t.Errorf("expected producer adjust-tax, got %q", id)
+	}
+
+	plain := cortex.New("plain", cortex.DefaultConfig())
+	plain.AddRule(cortex.MustAssignment(cortex.AssignmentConfig{ID: "set-rate", Target: "rate", Value: 0.2}))
+	result, err = plain.Evaluate(context.Background(), cortex.NewEvalContext())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p := result.Provenance(); p != nil {
+		t.Errorf("expected nil provenance when not tracked, got %v", p)
+	}
+}
+
+func TestEngineCheckLookups(t *testing.T) {
+	config := cortex.DefaultConfig()
+	config.CheckLookups = true
+
+	engine := cortex.New("test", config)
+	engine.RegisterLookup(cortex.NewMapLookup("known", map[string]float64{"a": 1}))
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{
+			ID:     "set-key",
+			Target: "key",
+			Value:  "a",
+		}),
+		cortex.MustLookup(cortex.LookupConfig{
+			ID:     "ok",
+			Table:  "known",
+			Key:    "key",
+			Target: "v1",
+		}),
+		cortex.MustLookup(cortex.LookupConfig{
+			ID:     "missing-1",
+			Table:  "unknown1",
+			Key:    "key",
+			Target: "v2",
+		}),
+		cortex.MustLookup(cortex.LookupConfig{
+			ID:     "missing-2",
+			Table:  "unknown2",
+			Key:    "key",
+			Target: "v3",
+		}),
+		cortex.MustRecordLookup(cortex.RecordLookupConfig{
+			ID:     "missing-record",
+			Table:  "unknown3",
+			Key:    "key",
+			Fields: []cortex.RecordField{{Field: "name", Target: "name"}},
+		}),
+	)
+
+	evalCtx := cortex.NewEvalContext()
+	_, err := engine.Evaluate(context.Background(), evalCtx)
+	if !errors.Is(err, cortex.ErrLookupNotFound) {
+		t.Fatalf("expected ErrLookupNotFound, got %v", err)
+	}
+	for _, table := range []string{"unknown1", "unknown2", "unknown3"} {
+		if !strings.Contains(err.Error(), table) {
+			t.Errorf("expected error to mention %q, got %v", table, err)
+		}
+	}
+	if evalCtx.Has("key") {
+		t.Error("no rules should run when the pre-check fails")
+	}
+
+	// Tables registered on the context satisfy the check
+	evalCtx = cortex.NewEvalContext()
+	evalCtx.RegisterLookup(cortex.NewMapLookup("unknown1", map[string]float64{"a": 2}))
+	evalCtx.RegisterLookup(cortex.NewMapLookup("unknown2", map[string]float64{"a": 3}))
+	evalCtx.RegisterLookup(cortex.NewMapLookup("unknown3", map[string]map[string]any{"a": {"name": "ada"}}))
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+type recordingTracer struct {
+	spans int
+}
+
+func (tr *recordingTracer) Start(ctx context.Context, name string, kv ...any) (context.Context, func(err error)) {
+	tr.spans++
+	return ctx, func(err error) {}
+}
+
+func TestEngineEvaluateWithOptions(t *testing.T) {
+	config := cortex.DefaultConfig()
+	config.ValueMetrics = map[string]string{"x": "value.x"}
+
+	metrics := newRecordingMetrics()
+	tracer := &recordingTracer{}
+	engine := cortex.New("test", config).WithObservability(&cortex.Observability{
+		Metrics: metrics,
+		Tracer:  tracer,
+	})
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{
+			ID:     "set-x",
+			Target: "x",
+			Value:  1.0,
+		}),
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID:     "fail",
+			Target: "y",
+			Formula: func(ctx context.Context, evalCtx *cortex.EvalContext) (any, error) {
+				return nil, errors.New("intentional error")
+			},
+		}),
+	)
+
+	opts := cortex.EvalOptions{DisableMetrics: true, DisableTrace: true}
+	if _, err := engine.EvaluateWithOptions(context.Background(), cortex.NewEvalContext(), opts); err == nil {
+		t.Fatal("expected error")
+	}
+	if len(metrics.counters) != 0 || len(metrics.histograms) != 0 {
+		t.Errorf("expected no metrics, got counters=%v histograms=%v", metrics.counters, metrics.histograms)
+	}
+	if tracer.spans != 0 {
+		t.Errorf("expected no spans, got %d", tracer.spans)
+	}
+
+	// Without options the engine config applies
+	if _, err := engine.Evaluate(context.Background(), cortex.NewEvalContext()); err == nil {
+		t.Fatal("expected error")
+	}
+	if metrics.counters["cortex.rules.failed"] != 1 {
+		t.Errorf("expected cortex.rules.failed=1, got %v", metrics.counters["cortex.rules.failed"])
+	}
+	if len(metrics.histograms["cortex.rule.duration"]) == 0 {
+		t.Error("expected rule duration histogram")
+	}
+	if tracer.spans == 0 {
+		t.Error("expected trace spans")
+	}
+}
+
+type recordingLogger struct {
+	mu    sync.Mutex
+	warns []string
+}
+
+func (l *recordingLogger) Debug(msg string, kv ...any) {}
+func (l *recordingLogger) Info(msg string, kv ...any)  {}
+func (l *recordingLogger) Warn(msg string, kv ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warns = append(l.warns, msg)
+}
+func (l *recordingLogger) Error(msg string, err error, kv ...any) {}
+
+func TestEngineOverwritePolicy(t *testing.T) {
+	newEngine := func(policy cortex.OverwritePolicy) (*cortex.Engine, *recordingLogger, *recordingMetrics) {
+		config := cortex.DefaultConfig()
+		config.Overwrite = policy
+		logger := &recordingLogger{}
+		metrics := newRecordingMetrics()
+		engine := cortex.New("test", config).WithObservability(&cortex.Observability{
+			Logger:  logger,
+			Metrics: metrics,
+		})
+		engine.AddRules(
+			cortex.MustAssignment(cortex.AssignmentConfig{
+				ID:     "first",
+				Target: "x",
+				Value:  1.0,
+			}),
+			cortex.MustAssignment(cortex.AssignmentConfig{
+				ID:     "second",
+				Target: "x",
+				Value:  2.0,
+			}),
+		)
+		return engine, logger, metrics
+	}
+
+	t.Run("allow", func(t *testing.T) {
+		engine, logger, _ := newEngine(cortex.OverwriteAllow)
+		evalCtx := cortex.NewEvalContext()
+		if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if x, _ := evalCtx.GetFloat64("x"); x != 2 {
+			t.Errorf("expected x=2, got %f", x)
+		}
+		if len(logger.warns) != 0 {
+			t.Errorf("expected no warnings, got %v", logger.warns)
+		}
+	})
+
+	t.Run("warn", func(t *testing.T) {
+		engine, logger, metrics := newEngine(cortex.OverwriteWarn)
+		evalCtx := cortex.NewEvalContext()
+		evalCtx.Set("x", 0.0) // caller-seeded values are not tracked
+		if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(logger.warns) != 1 {
+			t.Errorf("expected 1 warning, got %v", logger.warns)
+		}
+		if metrics.counters["cortex.rules.overwrite"] != 1 {
+			t.Errorf("expected overwrite metric, got %v", metrics.counters)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		engine, _, _ := newEngine(cortex.OverwriteError)
+		result, err := engine.Evaluate(context.Background(), cortex.NewEvalContext())
+		if !errors.Is(err, cortex.ErrOverwrite) {
+			t.Fatalf("expected ErrOverwrite, got %v", err)
+		}
+		if result.Errors[0].RuleID != "second" {
+			t.Errorf("expected error from rule 'second', got %q", result.Errors[0].RuleID)
+		}
+	})
+
+	t.Run("repeated evaluation", func(t *testing.T) {
+		config := cortex.DefaultConfig()
+		config.Overwrite = cortex.OverwriteError
+		evalCtx := cortex.NewEvalContext()
+		single := cortex.New("single", config)
+		single.AddRule(cortex.MustAssignment(cortex.AssignmentConfig{
+			ID:     "only",
+			Target: "x",
+			Value:  1.0,
+		}))
+		for range 2 {
+			if _, err := single.Evaluate(context.Background(), evalCtx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	})
+}
+
+func TestEngineRemoveRule(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRules(
+		recordRule("a"),
+		recordRule("b"),
+		recordRule("c"),
+	)
+
+	if err := engine.RemoveRule("b"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if engine.Rules() != 2 {
+		t.Errorf("expected 2 rules, got %d", engine.Rules())
+	}
+	if got := evaluationOrder(t, engine); strings.Join(got, ",") != "a,c" {
+		t.Errorf("expected a,c, got %v", got)
+	}
+
+	if err := engine.RemoveRule("b"); !errors.Is(err, cortex.ErrRuleNotFound) {
+		t.Errorf("expected ErrRuleNotFound, got %v", err)
+	}
+
+	// The ID can be reused after removal
+	if err := engine.AddRule(recordRule("b")); err != nil {
+		t.Errorf("unexpected error re-adding rule: %v", err)
+	}
+}
+
+func TestEngineReplaceRule(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "x", Target: "x", Value: 1.0}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "y", Target: "y", Expression: "x * 2"}),
+	)
+
+	err := engine.ReplaceRule(cortex.MustAssignment(cortex.AssignmentConfig{ID: "x", Target: "x", Value: 5.0}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	evalCtx := cortex.NewEvalContext()
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if y, _ := evalCtx.GetFloat64("y"); y != 10 {
+		t.Errorf("expected replaced rule to keep its position (y=10), got %f", y)
+	}
+
+	err = engine.ReplaceRule(cortex.MustAssignment(cortex.AssignmentConfig{ID: "z", Target: "z", Value: 1.0}))
+	if !errors.Is(err, cortex.ErrRuleNotFound) {
+		t.Errorf("expected ErrRuleNotFound, got %v", err)
+	}
+}
+
+func TestEngineRemoveRuleConcurrent(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	for i := range 20 {
+		engine.AddRule(cortex.MustAssignment(cortex.AssignmentConfig{
+			ID:     string(rune('a' + i)),
+			Target: "x",
+			Value:  float64(i),
+		}))
+	}
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			engine.Evaluate(context.Background(), cortex.NewEvalContext())
+		}()
+	}
+	for i := range 20 {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if i%2 == 0 {
+				engine.RemoveRule(id)
+			} else {
+				engine.ReplaceRule(cortex.MustAssignment(cortex.AssignmentConfig{ID: id, Target: "y", Value: 1.0}))
+			}
+		}(string(rune('a' + i)))
+	}
+	wg.Wait()
+
+	if engine.Rules() != 10 {
+		t.Errorf("expected 10 rules, got %d", engine.Rules())
+	}
+}
+
+func TestEngineSetRuleEnabled(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "x", Target: "x", Value: 1.0}),
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "bonus", Target: "bonus", Value: 100.0}),
+	)
+
+	if err := engine.SetRuleEnabled("bonus", false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if engine.RuleEnabled("bonus") {
+		t.Error("expected bonus to be disabled")
+	}
+
+	evalCtx := cortex.NewEvalContext()
+	result, err := engine.Evaluate(context.Background(), evalCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if evalCtx.Has("bonus") {
+		t.Error("expected disabled rule to be skipped")
+	}
+	if result.RulesEvaluated != 1 {
+		t.Errorf("expected 1 rule evaluated, got %d", result.RulesEvaluated)
+	}
+	if engine.Rules() != 2 {
+		t.Errorf("expected disabled rule to remain in engine, got %d rules", engine.Rules())
+	}
+
+	engine.SetRuleEnabled("bonus", true)
+	evalCtx = cortex.NewEvalContext()
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bonus, _ := evalCtx.GetFloat64("bonus"); bonus != 100 {
+		t.Errorf("expected re-enabled rule to run, got bonus=%f", bonus)
+	}
+
+	if err := engine.SetRuleEnabled("missing", false); !errors.Is(err, cortex.ErrRuleNotFound) {
+		t.Errorf("expected ErrRuleNotFound, got %v", err)
+	}
+}
+
+func TestEngineSetRuleEnabledSingleRule(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRule(cortex.MustAssignment(cortex.AssignmentConfig{ID: "x", Target: "x", Value: 1.0}))
+	engine.SetRuleEnabled("x", false)
+
+	evalCtx := cortex.NewEvalContext()
+	result, err := engine.Evaluate(context.Background(), evalCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if evalCtx.Has("x") || result.RulesEvaluated != 0 {
+		t.Errorf("expected disabled single rule to be skipped, got %d evaluated", result.RulesEvaluated)
+	}
+}
+
+func TestEngineWhenGuard(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRules(
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID:         "gold-bonus",
+			Target:     "bonus",
+			Expression: "salary * 0.1",
+			When:       `tier == "gold"`,
+		}),
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "done", Target: "done", Value: true}),
+	)
+
+	gold := cortex.NewEvalContext()
+	gold.SetAll(map[string]any{"tier": "gold", "salary": 1000.0})
+	result, err := engine.Evaluate(context.Background(), gold)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bonus, _ := gold.GetFloat64("bonus"); bonus != 100 {
+		t.Errorf("expected bonus=100, got %f", bonus)
+	}
+	if result.RulesEvaluated != 2 || result.RulesSkipped != 0 {
+		t.Errorf("expected 2 evaluated and 0 skipped, got %d and %d", result.RulesEvaluated, result.RulesSkipped)
+	}
+
+	silver := cortex.NewEvalContext()
+	silver.SetAll(map[string]any{"tier": "silver", "salary": 1000.0})
+	result, err = engine.Evaluate(context.Background(), silver)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if silver.Has("bonus") {
+		t.Error("expected guarded rule to be skipped")
+	}
+	if result.RulesEvaluated != 1 || result.RulesSkipped != 1 {
+		t.Errorf("expected 1 evaluated and 1 skipped, got %d and %d", result.RulesEvaluated, result.RulesSkipped)
+	}
+}
+
+func TestEngineWhenGuardErrors(t *testing.T) {
+	_, err := cortex.NewAssignment(cortex.AssignmentConfig{ID: "x", Target: "x", Value: 1.0, When: "a &&"})
+	if !errors.Is(err, cortex.ErrInvalidExpression) {
+		t.Errorf("expected ErrInvalidExpression, got %v", err)
+	}
+
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRule(cortex.MustAssignment(cortex.AssignmentConfig{ID: "x", Target: "x", Value: 1.0, When: "amount"}))
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("amount", 5.0)
+	_, err = engine.Evaluate(context.Background(), evalCtx)
+	if !errors.Is(err, cortex.ErrTypeMismatch) {
+		t.Errorf("expected ErrTypeMismatch for non-bool guard, got %v", err)
+	}
+	if evalCtx.Has("x") {
+		t.Error("expected rule not to run when its guard fails")
+	}
+}
+
+func TestEngineExplain(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "set-x", Target: "x", Value: 3.0}),
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "set-y", Description: "Base allowance", Target: "y", Value: 4.0}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "sum", Target: "sum", Expression: "x + y"}),
+	)
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("input", 1.0)
+	result, err := engine.EvaluateWithOptions(context.Background(), evalCtx, cortex.EvalOptions{Explain: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := map[string]string{
+		"sum": "x + y = 3 + 4 = 7",
+		"y":   "Base allowance",
+		"x":   `set by rule "set-x"`,
+	}
+	for key, want := range tests {
+		got, ok := result.Explain(key)
+		if !ok || got != want {
+			t.Errorf("%s: expected %q, got %q (ok=%v)", key, want, got, ok)
+		}
+	}
+
+	if _, ok := result.Explain("input"); ok {
+		t.Error("expected no explanation for a value not set by a rule")
+	}
+}
+
+func TestEngineExplainDisabled(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRule(cortex.MustFormula(cortex.FormulaConfig{ID: "sum", Target: "sum", Expression: "1 + 2"}))
+
+	result, err := engine.Evaluate(context.Background(), cortex.NewEvalContext())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := result.Explain("sum"); ok {
+		t.Error("expected no explanation when explain mode is off")
+	}
+}
+
+func TestEngineEvaluateToNew(t *testing.T) {
+	engine := cortex.New("test", nil)
+	engine.RegisterLookup(cortex.NewMapLookup("rates", map[string]float64{"gold": 0.2}))
+	engine.AddRules(
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID: "gross", Target: "gross", Expression: "price * qty",
+		}),
+		cortex.MustLookup(cortex.LookupConfig{
+			ID: "rate", Table: "rates", Key: "tier", Target: "rate", Deps: []string{"gross"},
+		}),
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID: "discount", Target: "price", Expression: "price * (1 - rate)", Deps: []string{"rate"},
+		}),
+	)
+
+	inputs := cortex.NewEvalContext()
+	inputs.SetAll(map[string]any{"price": 10.0, "qty": 3.0, "tier": "gold"})
+
+	result, err := engine.EvaluateToNew(context.Background(), inputs)
+	if err != nil {
+		t.Fatalf("evaluation error: %v", err)
+	}
+
+	out := result.Context
+	if out == inputs {
+		t.Fatal("expected a fresh output context")
+	}
+	if out.Inputs() != inputs {
+		t.Error("expected output context to reference inputs")
+	}
+
+	// Inputs are untouched, including the key the rules rewrote.
+	want := map[string]any{"price": 10.0, "qty": 3.0, "tier": "gold"}
+	if got := inputs.Values(); !reflect.DeepEqual(got, want) {
+		t.Errorf("inputs modified: got %v, want %v", got, want)
+	}
+
+	// Outputs hold only produced values.
+	want = map[string]any{"gross": 30.0, "rate": 0.2, "price": 8.0}
+	if got := out.Values(); !reflect.DeepEqual(got, want) {
+		t.Errorf("outputs: got %v, want %v", got, want)
+	}
+
+	// Reads still see inputs through the output context.
+	if qty, err := out.GetFloat64("qty"); err != nil || qty != 3 {
+		t.Errorf("expected qty=3 via inputs, got %v (%v)", qty, err)
+	}
+}
+
+func TestEngineEvaluateToNewNil(t *testing.T) {
+	engine := cortex.New("test", nil)
+	if _, err := engine.EvaluateToNew(context.Background(), nil); !errors.Is(err, cortex.ErrNilContext) {
+		t.Errorf("expected ErrNilContext, got %v", err)
+	}
+}
+
+func TestEngineRequiredOutputs(t *testing.T) {
+	config := cortex.DefaultConfig()
+	config.RequiredOutputs = []string{"gross", "net_pay", "bonus"}
+
+	engine := cortex.New("test", config)
+	engine.AddRules(
+		cortex.MustFormula(cortex.FormulaConfig{ID: "gross", Target: "gross", Expression: "hours * rate"}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "net", Target: "net_pay", Expression: "gross * 0.8"}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "bonus", Target: "bonus", Expression: "gross * 0.1", When: "eligible"}),
+	)
+	engine.SetRuleEnabled("net", false)
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.SetAll(map[string]any{"hours": 10.0, "rate": 20.0, "eligible": false})
+
+	result, err := engine.Evaluate(context.Background(), evalCtx)
+	if !errors.Is(err, cortex.ErrMissingOutput) {
+		t.Fatalf("expected ErrMissingOutput, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "net_pay, bonus") {
+		t.Errorf("expected missing keys in error, got %v", err)
+	}
+	if result == nil || result.Success {
+		t.Errorf("expected unsuccessful result, got %+v", result)
+	}
+
+	engine.SetRuleEnabled("net", true)
+	evalCtx.Set("eligible", true)
+	result, err = engine.Evaluate(context.Background(), evalCtx)
+	if err != nil || !result.Success {
+		t.Errorf("expected success once all outputs are produced, got %v", err)
+	}
+}
+
+func TestEngineRequiredOutputsSingleRule(t *testing.T) {
+	config := cortex.DefaultConfig()
+	config.EnableMetrics = false
+	config.RequiredOutputs = []string{"y"}
+
+	engine := cortex.New("test", config)
+	engine.AddRule(cortex.MustFormula(cortex.FormulaConfig{ID: "y", Target: "y", Expression: "x * 2", When: "x > 0"}))
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("x", 0.0)
+	if _, err := engine.Evaluate(context.Background(), evalCtx); !errors.Is(err, cortex.ErrMissingOutput) {
+		t.Errorf("expected ErrMissingOutput, got %v", err)
+	}
+}
+
+func TestEngineTestRule(t *testing.T) {
+	engine := cortex.New("test", nil)
+	engine.RegisterLookup(cortex.NewMapLookup("rates", map[string]float64{"CA": 0.1}))
+	engine.AddRules(
+		cortex.MustFormula(cortex.FormulaConfig{ID: "gross", Target: "gross", Expression: "hours * rate"}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "net", Target: "net", Expression: "gross - tax"}),
+		cortex.MustLookup(cortex.LookupConfig{ID: "tax_rate", Table: "rates", Key: "state", Target: "tax_rate"}),
+	)
+
+	evalCtx, err := engine.TestRule(context.Background(), "net", map[string]any{"gross": 1000.0, "tax": 150.0})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if net, _ := evalCtx.GetFloat64("net"); net != 850 {
+		t.Errorf("expected net=850, got %v", net)
+	}
+	if evalCtx.Has("tax_rate") {
+		t.Error("expected only the tested rule to run")
+	}
+
+	// Lookups registered on the engine are available.
+	evalCtx, err = engine.TestRule(context.Background(), "tax_rate", map[string]any{"state": "CA"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rate, _ := evalCtx.GetFloat64("tax_rate"); rate != 0.1 {
+		t.Errorf("expected tax_rate=0.1, got %v", rate)
+	}
+
+	// Missing inputs surface as rule errors.
+	_, err = engine.TestRule(context.Background(), "net", map[string]any{"gross": 1000.0})
+	var ruleErr *cortex.RuleError
+	if !errors.As(err, &ruleErr) || ruleErr.RuleID != "net" {
+		t.Errorf("expected RuleError for net, got %v", err)
+	}
+
+	if _, err := engine.TestRule(context.Background(), "missing", nil); !errors.Is(err, cortex.ErrRuleNotFound) {
+		t.Errorf("expected ErrRuleNotFound, got %v", err)
+	}
+}
+
+func TestEngineRecoverPanics(t *testing.T) {
+	panicky := func(ctx context.Context, evalCtx *cortex.EvalContext) (any, error) {
+		panic("boom")
+	}
+
+	t.Run("fail fast", func(t *testing.T) {
+		engine := cortex.New("test", nil)
+		engine.AddRule(cortex.MustFormula(cortex.FormulaConfig{ID: "bad", Target: "x", Formula: panicky}))
+
+		_, err := engine.Evaluate(context.Background(), cortex.NewEvalContext())
+		var ruleErr *cortex.RuleError
+		if !errors.As(err, &ruleErr) || ruleErr.RuleID != "bad" {
+			t.Fatalf("expected RuleError for bad, got %v", err)
+		}
+		if !errors.Is(err, cortex.ErrEvaluation) || !strings.Contains(err.Error(), "panic: boom") {
+			t.Errorf("expected wrapped ErrEvaluation with panic value, got %v", err)
+		}
+	})
+
+	t.Run("continue on error", func(t *testing.T) {
+		config := cortex.DefaultConfig()
+		config.Mode = cortex.ModeContinueOnError
+		engine := cortex.New("test", config)
+		engine.AddRules(
+			cortex.MustFormula(cortex.FormulaConfig{ID: "bad", Target: "x", Formula: panicky}),
+			cortex.MustAssignment(cortex.AssignmentConfig{ID: "good", Target: "y", Value: 1.0}),
+		)
+
+		evalCtx := cortex.NewEvalContext()
+		result, err := engine.Evaluate(context.Background(), evalCtx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.RulesFailed != 1 || !evalCtx.Has("y") {
+			t.Errorf("expected evaluation to continue past the panic, got %+v", result)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		config := cortex.DefaultConfig()
+		config.RecoverPanics = false
+		engine := cortex.New("test", config)
+		engine.AddRule(cortex.MustFormula(cortex.FormulaConfig{ID: "bad", Target: "x", Formula: panicky}))
+
+		defer func() {
+			if recover() == nil {
+				t.Error("expected panic to propagate")
+			}
+		}()
+		engine.Evaluate(context.Background(), cortex.NewEvalContext())
+	})
+}
+
+func TestEngineMaxEvaluatedRules(t *testing.T) {
+	config := cortex.DefaultConfig()
+	config.MaxEvaluatedRules = 2
+
+	engine := cortex.New("test", config)
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "a", Target: "a", Value: 1.0}),
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "skipped", Target: "s", Value: 1.0, When: "false"}),
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "b", Target: "b", Value: 2.0}),
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "c", Target: "c", Value: 3.0}),
+	)
+
+	result, err := engine.Evaluate(context.Background(), cortex.NewEvalContext())
+	if !errors.Is(err, cortex.ErrBudgetExceeded) {
+		t.Fatalf("expected ErrBudgetExceeded, got %v", err)
+	}
+	if !strings.Contains(err.Error(), `"c"`) {
+		t.Errorf("expected error to name the next rule, got %v", err)
+	}
+	if result == nil || result.Success {
+		t.Fatalf("expected unsuccessful partial result, got %+v", result)
+	}
+	if result.RulesEvaluated != 2 || result.RulesSkipped != 1 {
+		t.Errorf("expected 2 evaluated and 1 skipped, got %d and %d", result.RulesEvaluated, result.RulesSkipped)
+	}
+	if !result.Context.Has("b") || result.Context.Has("c") {
+		t.Error("expected evaluation to stop after the budget")
+	}
+
+	config.MaxEvaluatedRules = 3
+	if _, err := engine.Evaluate(context.Background(), cortex.NewEvalContext()); err != nil {
+		t.Errorf("expected budget equal to rule count to pass, got %v", err)
+	}
+}
+
+func TestEngineOnRuleMetric(t *testing.T) {
+	cfg := cortex.DefaultConfig()
+	cfg.Mode = cortex.ModeCollectAll
+	cfg.EnableMetrics = false
+
+	engine := cortex.New("test", cfg)
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "a", Target: "a", Value: 1.0}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "bad", Target: "b", Expression: "missing * 2"}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "skipped", Target: "s", Expression: "a", When: "a > 5"}),
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID: "slow", Target: "c",
+			Formula: func(ctx context.Context, evalCtx *cortex.EvalContext) (any, error) {
+				time.Sleep(5 * time.Millisecond)
+				return 3.0, nil
+			},
+		}),
+	)
+
+	type metric struct {
+		id  string
+		d   time.Duration
+		err error
+	}
+	var metrics []metric
+	engine.OnRuleMetric(func(ruleID string, d time.Duration, err error) {
+		metrics = append(metrics, metric{ruleID, d, err})
+	})
+
+	engine.Evaluate(context.Background(), cortex.NewEvalContext())
+
+	if len(metrics) != 3 {
+		t.Fatalf("expected 3 metrics, got %+v", metrics)
+	}
+	for i, id := range []string{"a", "bad", "slow"} {
+		if metrics[i].id != id {
+			t.Errorf("metric %d: expected rule %q, got %q", i, id, metrics[i].id)
+		}
+	}
+	if metrics[0].err != nil || metrics[1].err == nil || metrics[2].err != nil {
+		t.Errorf("unexpected errors: %+v", metrics)
+	}
+	if metrics[2].d < 5*time.Millisecond {
+		t.Errorf("expected slow rule to take at least 5ms, got %v", metrics[2].d)
+	}
+
+	metrics = nil
+	engine.EvaluateWithOptions(context.Background(), cortex.NewEvalContext(), cortex.EvalOptions{DisableMetrics: true})
+	engine.OnRuleMetric(nil)
+	engine.Evaluate(context.Background(), cortex.NewEvalContext())
+	if len(metrics) != 0 {
+		t.Errorf("expected no metrics, got %+v", metrics)
+	}
+
+	// Single-rule engines report too.
+	single := cortex.New("single", cfg)
+	single.AddRule(cortex.MustAssignment(cortex.AssignmentConfig{ID: "only", Target: "x", Value: 1.0}))
+	single.OnRuleMetric(func(ruleID string, d time.Duration, err error) {
+		metrics = append(metrics, metric{ruleID, d, err})
+	})
+	single.Evaluate(context.Background(), cortex.NewEvalContext())
+	if len(metrics) != 1 || metrics[0].id != "only" {
+		t.Errorf("expected a metric for the single rule, got %+v", metrics)
+	}
+}
+
+func TestEngineWithOutputTransform(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRules(
+		cortex.MustFormula(cortex.FormulaConfig{ID: "tax", Target: "tax", Expression: "income * 0.0725"}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "net", Target: "net", Expression: "income - tax", Deps: []string{"tax"}}),
+		cortex.MustAllocation(cortex.AllocationConfig{
+			ID: "split", Source: "net", Strategy: cortex.StrategyEqual, Precision: 4,
+			Targets: []cortex.AllocationTarget{{Key: "x"}, {Key: "y"}, {Key: "z"}},
+			Deps:    []string{"net"},
+		}),
+	)
+	engine.WithOutputTransform(func(key string, value any) any {
+		if f, ok := value.(float64); ok {
+			return math.Round(f*100) / 100
+		}
+		return value
+	})
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("income", 1000.123)
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for key, want := range map[string]float64{"income": 1000.123, "tax": 72.51, "net": 927.61, "x": 309.2, "y": 309.2, "z": 309.2} {
+		if got, _ := evalCtx.GetFloat64(key); got != want {
+			t.Errorf("expected %s=%v, got %v", key, want, got)
+		}
+	}
+
+	evalCtx.Set("after", 1.234)
+	if got, _ := evalCtx.GetFloat64("after"); got != 1.234 {
+		t.Errorf("expected values set after evaluation to be untransformed, got %v", got)
+	}
+
+	engine.WithOutputTransform(nil)
+	evalCtx = cortex.NewEvalContext()
+	evalCtx.Set("income", 1000.123)
+	engine.Evaluate(context.Background(), evalCtx)
+	if got, _ := evalCtx.GetFloat64("tax"); got == 72.51 {
+		t.Errorf("expected transform to be removed, got %v", got)
+	}
+}
+
+func TestEngineEvaluateInOrder(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRules(
+		cortex.MustFormula(cortex.FormulaConfig{ID: "gross", Target: "gross", Expression: "net / (1 - rate)"}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "rate", Target: "rate", Expression: "0.2"}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "net", Target: "net", Expression: "target_net"}),
+	)
+
+	newCtx := func() *cortex.EvalContext {
+		evalCtx := cortex.NewEvalContext()
+		evalCtx.Set("target_net", 800.0)
+		evalCtx.Set("net", 0.0)
+		evalCtx.Set("rate", 0.0)
+		return evalCtx
+	}
+
+	inserted := newCtx()
+	if _, err := engine.Evaluate(context.Background(), inserted); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gross, _ := inserted.GetFloat64("gross"); gross != 0 {
+		t.Errorf("expected gross=0 in insertion order, got %v", gross)
+	}
+
+	custom := newCtx()
+	if _, err := engine.EvaluateInOrder(context.Background(), custom, []string{"net", "rate", "gross"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gross, _ := custom.GetFloat64("gross"); gross != 1000 {
+		t.Errorf("expected gross=1000 in custom order, got %v", gross)
+	}
+
+	tests := []struct {
+		name  string
+		order []string
+		want  error
+	}{
+		{"unknown rule", []string{"net", "rate", "gross", "tax"}, cortex.ErrRuleNotFound},
+		{"duplicate rule", []string{"net", "net", "gross"}, cortex.ErrInvalidRule},
+		{"missing rule", []string{"net", "gross"}, cortex.ErrInvalidRule},
+		{"empty", nil, cortex.ErrInvalidRule},
+	}
+	for _, tt := range tests {
+		evalCtx := newCtx()
+		if _, err := engine.EvaluateInOrder(context.Background(), evalCtx, tt.order); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+		if evalCtx.Has("gross") {
+			t.Errorf("%s: expected no rules to run", tt.name)
+		}
+	}
+}
+
+func TestEngineStopWhen(t *testing.T) {
+	cfg := cortex.DefaultConfig()
+	cfg.StopWhen = "risk_score > 50"
+	engine := cortex.New("test", cfg)
+	for i := range 4 {
+		engine.AddRule(cortex.MustBuildup(cortex.BuildupConfig{
+			ID:        fmt.Sprintf("factor-%d", i+1),
+			Buildup:   "risk",
+			Operation: cortex.BuildupSum,
+			Source:    fmt.Sprintf("points_%d", i+1),
+			Target:    "risk_score",
+		}))
+	}
+
+	evalCtx := cortex.NewEvalContext()
+	for i := 1; i <= 4; i++ {
+		evalCtx.Set(fmt.Sprintf("points_%d", i), 20.0)
+	}
+
+	result, err := engine.Evaluate(context.Background(), evalCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Success {
+		t.Error("expected stopping on the condition to succeed")
+	}
+	if result.HaltedBy != "stop_when: risk_score > 50" {
+		t.Errorf("expected HaltedBy to name the stop condition, got %q", result.HaltedBy)
+	}
+	if result.RulesEvaluated != 3 {
+		t.Errorf("expected 3 rules evaluated, got %d", result.RulesEvaluated)
+	}
+	if score, _ := evalCtx.GetFloat64("risk_score"); score != 60 {
+		t.Errorf("expected risk_score=60, got %v", score)
+	}
+
+	// The condition is never met: every rule runs and nothing halts.
+	low := cortex.NewEvalContext()
+	for i := 1; i <= 4; i++ {
+		low.Set(fmt.Sprintf("points_%d", i), 1.0)
+	}
+	result, err = engine.Evaluate(context.Background(), low)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.RulesEvaluated != 4 || result.HaltedBy != "" {
+		t.Errorf("expected all 4 rules and no halt, got %d halted by %q", result.RulesEvaluated, result.HaltedBy)
+	}
+
+	bad := cortex.DefaultConfig()
+	bad.StopWhen = "risk_score >"
+	if err := bad.Validate(); !errors.Is(err, cortex.ErrInvalidExpression) {
+		t.Errorf("expected Validate to reject the stop condition, got %v", err)
+	}
+	if _, err := cortex.New("bad", bad).Evaluate(context.Background(), cortex.NewEvalContext()); !errors.Is(err, cortex.ErrInvalidExpression) {
+		t.Errorf("expected ErrInvalidExpression, got %v", err)
+	}
+}
+
+func TestEngineTransactional(t *testing.T) {
+	newEngine := func(transactional bool) *cortex.Engine {
+		cfg := cortex.DefaultConfig()
+		cfg.Transactional = transactional
+		engine := cortex.New("test", cfg)
+		engine.AddRules(
+			cortex.MustAssignment(cortex.AssignmentConfig{ID: "fee", Target: "fee", Value: 5.0}),
+			cortex.MustBuildup(cortex.BuildupConfig{ID: "total", Buildup: "total", Operation: cortex.BuildupSum, Source: "amount"}),
+			cortex.MustFormula(cortex.FormulaConfig{ID: "net", Target: "net", Expression: "amount - missing"}),
+		)
+		return engine
+	}
+
+	newCtx := func() *cortex.EvalContext {
+		evalCtx := cortex.NewEvalContext()
+		evalCtx.Set("amount", 100.0)
+		evalCtx.GetOrCreateBuildup("total", cortex.BuildupSum, 0).Add(1)
+		return evalCtx
+	}
+
+	evalCtx := newCtx()
+	result, err := newEngine(true).Evaluate(context.Background(), evalCtx)
+	if err == nil {
+		t.Fatal("expected the net rule to fail")
+	}
+	if result == nil || !result.RolledBack {
+		t.Fatalf("expected a rolled back result, got %+v", result)
+	}
+	if evalCtx.Has("fee") {
+		t.Error("expected fee to be rolled back")
+	}
+	if amount, _ := evalCtx.GetFloat64("amount"); amount != 100 {
+		t.Errorf("expected amount=100 to survive the rollback, got %v", amount)
+	}
+	if b, _ := evalCtx.GetBuildup("total"); b.Current() != 1 || b.Count() != 1 {
+		t.Errorf("expected buildup rolled back to 1, got %v (count %d)", b.Current(), b.Count())
+	}
+
+	// The Explain steps of the rolled back writes are discarded too.
+	explanation, err := newEngine(true).Explain(context.Background(), newCtx())
+	if err == nil || !explanation.Result.RolledBack {
+		t.Fatalf("expected a rolled back explanation, got %v", err)
+	}
+	if len(explanation.Steps) != 0 {
+		t.Errorf("expected no steps after rollback, got %+v", explanation.Steps)
+	}
+
+	// Without Transactional the context keeps the partial writes.
+	partial := newCtx()
+	result, err = newEngine(false).Evaluate(context.Background(), partial)
+	if err == nil || result.RolledBack {
+		t.Fatalf("expected an error without rollback, got %v, %+v", err, result)
+	}
+	if !partial.Has("fee") {
+		t.Error("expected fee to remain set without Transactional")
+	}
+
+	// A successful evaluation is not rolled back.
+	ok := newCtx()
+	ok.Set("missing", 10.0)
+	result, err = newEngine(true).Evaluate(context.Background(), ok)
+	if err != nil || result.RolledBack {
+		t.Fatalf("expected success without rollback, got %v, %+v", err, result)
+	}
+	if net, _ := ok.GetFloat64("net"); net != 90 {
+		t.Errorf("expected net=90, got %v", net)
+	}
+}
+
+func TestEngineUpdateLookup(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	if err := engine.RegisterLookup(cortex.NewMapLookup("rates", map[string]float64{"usd": 1})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	engine.AddRules(
+		cortex.MustLookup(cortex.LookupConfig{ID: "rate1", Table: "rates", Key: "currency", Target: "rate1"}),
+		cortex.MustLookup(cortex.LookupConfig{ID: "rate2", Table: "rates", Key: "currency", Target: "rate2"}),
+	)
+
+	if err := engine.UpdateLookup(cortex.NewMapLookup("fx", map[string]float64{})); !errors.Is(err, cortex.ErrLookupNotFound) {
+		t.Errorf("expected ErrLookupNotFound for an unregistered table, got %v", err)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 2; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := engine.UpdateLookup(cortex.NewMapLookup("rates", map[string]float64{"usd": float64(i)})); err != nil {
+				t.Errorf("update error: %v", err)
+				return
+			}
+		}
+	}()
+
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				evalCtx := cortex.NewEvalContext()
+				evalCtx.Set("currency", "usd")
+				if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+					t.Errorf("evaluation error: %v", err)
+					return
+				}
+				// Both rules read the same snapshot of the table.
+				rate1, _ := evalCtx.GetFloat64("rate1")
+				rate2, _ := evalCtx.GetFloat64("rate2")
+				if rate1 != rate2 {
+					t.Errorf("expected one table per evaluation, got %v and %v", rate1, rate2)
+					return
+				}
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(done)
+	wg.Wait()
+
+	if err := engine.UpdateLookup(cortex.NewMapLookup("rates", map[string]float64{"usd": 0.5})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("currency", "usd")
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rate, _ := evalCtx.GetFloat64("rate1"); rate != 0.5 {
+		t.Errorf("expected the updated rate 0.5, got %v", rate)
+	}
+	if engine.Lookups() != 1 {
+		t.Errorf("expected 1 lookup, got %d", engine.Lookups())
+	}
+}
+
+func TestEngineRuleTimings(t *testing.T) {
+	cfg := cortex.DefaultConfig()
+	cfg.Mode = cortex.ModeCollectAll
+	cfg.TrackRuleTimings = true
+
+	engine := cortex.New("test", cfg)
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "a", Target: "a", Value: 1.0}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "skipped", Target: "s", Expression: "a", When: "a > 5"}),
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID: "slow", Target: "b",
+			Formula: func(ctx context.Context, evalCtx *cortex.EvalContext) (any, error) {
+				time.Sleep(5 * time.Millisecond)
+				return 2.0, nil
+			},
+		}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "bad", Target: "c", Expression: "missing * 2"}),
+	)
+
+	result, _ := engine.Evaluate(context.Background(), cortex.NewEvalContext())
+	var ids []string
+	for _, timing := range result.RuleTimings {
+		ids = append(ids, timing.RuleID)
+	}
+	if !reflect.DeepEqual(ids, []string{"a", "slow", "bad"}) {
+		t.Fatalf("expected timings for a, slow and bad in order, got %v", ids)
+	}
+	if result.RuleTimings[1].Duration < 5*time.Millisecond {
+		t.Errorf("expected slow to take at least 5ms, got %v", result.RuleTimings[1].Duration)
+	}
+
+	result, _ = engine.EvaluateWithOptions(context.Background(), cortex.NewEvalContext(), cortex.EvalOptions{DisableMetrics: true})
+	if result.RuleTimings != nil {
+		t.Errorf("expected no timings with DisableMetrics, got %v", result.RuleTimings)
+	}
+
+	// Single-rule engines skip the fast path when timing.
+	single := cortex.New("single", cfg)
+	single.AddRule(cortex.MustAssignment(cortex.AssignmentConfig{ID: "a", Target: "a", Value: 1.0}))
+	result, err := single.Evaluate(context.Background(), cortex.NewEvalContext())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.RuleTimings) != 1 || result.RuleTimings[0].RuleID != "a" {
+		t.Errorf("expected one timing for a, got %v", result.RuleTimings)
+	}
+}
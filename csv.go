@@ -0,0 +1,114 @@
+package cortex
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// NewMapLookupFromCSV builds a map lookup from CSV data with a header row.
+// Keys are read from keyCol as strings. Values in valCol are stored as
+// float64 when they parse as numbers and as strings otherwise.
+func NewMapLookupFromCSV(name string, r io.Reader, keyCol, valCol string) (Lookup, error) {
+	rows, cols, err := readCSV(r, keyCol, valCol)
+	if err != nil {
+		return nil, fmt.Errorf("lookup %q: %w", name, err)
+	}
+
+	items := make(map[string]any, len(rows))
+	for i, row := range rows {
+		key := row[cols[0]]
+		if _, dup := items[key]; dup {
+			return nil, fmt.Errorf("%w: lookup %q: row %d: duplicate key %q", ErrInvalidRule, name, i+2, key)
+		}
+		val := row[cols[1]]
+		if f, err := parseCSVFloat(val); err == nil {
+			items[key] = f
+		} else {
+			items[key] = val
+		}
+	}
+	return NewMapLookup(name, items), nil
+}
+
+// NewRangeLookupFromCSV builds a range lookup from CSV data with a header
+// row. All three columns must be numeric, except that an empty max cell
+// means the range is unbounded.
+func NewRangeLookupFromCSV(name string, r io.Reader, minCol, maxCol, valCol string) (Lookup, error) {
+	rows, cols, err := readCSV(r, minCol, maxCol, valCol)
+	if err != nil {
+		return nil, fmt.Errorf("lookup %q: %w", name, err)
+	}
+
+	ranges := make([]RangeEntry[float64], len(rows))
+	for i, row := range rows {
+		minV, err := parseCSVFloat(row[cols[0]])
+		if err != nil {
+			return nil, fmt.Errorf("lookup %q: row %d: %s: %w", name, i+2, minCol, err)
+		}
+		maxV := math.Inf(1)
+		if row[cols[1]] != "" {
+			if maxV, err = parseCSVFloat(row[cols[1]]); err != nil {
+				return nil, fmt.Errorf("lookup %q: row %d: %s: %w", name, i+2, maxCol, err)
+			}
+		}
+		val, err := parseCSVFloat(row[cols[2]])
+		if err != nil {
+			return nil, fmt.Errorf("lookup %q: row %d: %s: %w", name, i+2, valCol, err)
+		}
+		ranges[i] = RangeEntry[float64]{Min: minV, Max: maxV, Value: val}
+	}
+	return NewRangeLookup(name, ranges), nil
+}
+
+// readCSV reads all records after the header and returns the index of
+// each requested column.
+func readCSV(r io.Reader, columns ...string) ([][]string, []int, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil, fmt.Errorf("%w: csv has no header row", ErrInvalidRule)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("csv: %w", err)
+	}
+
+	idx := make([]int, len(columns))
+	for i, col := range columns {
+		idx[i] = -1
+		for j, h := range header {
+			if strings.TrimSpace(h) == col {
+				idx[i] = j
+				break
+			}
+		}
+		if idx[i] < 0 {
+			return nil, nil, fmt.Errorf("%w: csv column %q not found", ErrInvalidRule, col)
+		}
+	}
+
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("csv: %w", err)
+	}
+	for _, row := range rows {
+		for j := range row {
+			row[j] = strings.TrimSpace(row[j])
+		}
+	}
+	return rows, idx, nil
+}
+
+// parseCSVFloat parses a CSV cell as a number.
+func parseCSVFloat(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: expected numeric, got %q", ErrTypeMismatch, s)
+	}
+	return f, nil
+}
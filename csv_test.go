@@ -0,0 +1,94 @@
+package cortex_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kolosys/cortex"
+)
+
+func TestMapLookupFromCSV(t *testing.T) {
+	data := "state,rate,label\nCA,0.0725,West\nNY,0.04,East\nTX,n/a,South\n"
+
+	lookup, err := cortex.NewMapLookupFromCSV("rates", strings.NewReader(data), "state", "rate")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lookup.Name() != "rates" {
+		t.Errorf("expected name 'rates', got %q", lookup.Name())
+	}
+	if v, ok := lookup.Get("CA"); !ok || v != 0.0725 {
+		t.Errorf("expected CA=0.0725, got %v (%v)", v, ok)
+	}
+	if v, ok := lookup.Get("TX"); !ok || v != "n/a" {
+		t.Errorf("expected non-numeric value kept as string, got %v", v)
+	}
+	if _, ok := lookup.Get("WA"); ok {
+		t.Error("expected WA to be missing")
+	}
+
+	engine := cortex.New("test", nil)
+	if err := engine.RegisterLookup(lookup); err != nil {
+		t.Fatalf("register error: %v", err)
+	}
+	engine.AddRule(cortex.MustLookup(cortex.LookupConfig{
+		ID: "rate", Table: "rates", Key: "state", Target: "rate",
+	}))
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("state", "NY")
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("evaluation error: %v", err)
+	}
+	if rate, _ := evalCtx.GetFloat64("rate"); rate != 0.04 {
+		t.Errorf("expected rate=0.04, got %v", rate)
+	}
+}
+
+func TestMapLookupFromCSVErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"missing column", "state,value\nCA,1\n"},
+		{"duplicate key", "state,rate\nCA,1\nCA,2\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := cortex.NewMapLookupFromCSV("rates", strings.NewReader(tt.data), "state", "rate")
+			if !errors.Is(err, cortex.ErrInvalidRule) {
+				t.Errorf("expected ErrInvalidRule, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRangeLookupFromCSV(t *testing.T) {
+	data := "min,max,rate\n0,10000,0.10\n10000,50000,0.20\n50000,,0.30\n"
+
+	lookup, err := cortex.NewRangeLookupFromCSV("brackets", strings.NewReader(data), "min", "max", "rate")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		key  float64
+		want float64
+	}{
+		{5000, 0.10},
+		{10000, 0.20},
+		{1e9, 0.30},
+	}
+	for _, tt := range tests {
+		if v, ok := lookup.Get(tt.key); !ok || v != tt.want {
+			t.Errorf("Get(%v) = %v, want %v", tt.key, v, tt.want)
+		}
+	}
+
+	_, err = cortex.NewRangeLookupFromCSV("brackets", strings.NewReader("min,max,rate\nzero,10,0.1\n"), "min", "max", "rate")
+	if !errors.Is(err, cortex.ErrTypeMismatch) {
+		t.Errorf("expected ErrTypeMismatch, got %v", err)
+	}
+}
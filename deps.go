@@ -0,0 +1,234 @@
+package cortex
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Sort reorders the engine's rules so that every rule runs after the
+// rules listed in its Deps. Rules whose dependencies allow it keep their
+// relative insertion order. Dependencies on unknown rule IDs are ignored.
+// It returns ErrCircularDep if the dependencies form a cycle, leaving the
+// rule order unchanged.
+func (e *Engine) Sort() error {
+	if e.closed.Load() {
+		return ErrEngineClosed
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	sorted, err := sortRules(e.rules)
+	if err != nil {
+		return err
+	}
+	e.rules = sorted
+	return nil
+}
+
+// Validate checks the rule dependency graph. It reports dependencies on
+// rule IDs that are not in the engine (ErrRuleNotFound) and dependency
+// cycles (ErrCircularDep, wrapped with the cycle path such as "a -> b -> a").
+func (e *Engine) Validate() error {
+	e.mu.RLock()
+	rules := e.rules
+	e.mu.RUnlock()
+
+	ids := make(map[string]struct{}, len(rules))
+	for _, r := range rules {
+		ids[r.ID()] = struct{}{}
+	}
+
+	var errs []error
+	for _, r := range rules {
+		for _, dep := range ruleDeps(r) {
+			if _, ok := ids[dep]; !ok {
+				errs = append(errs, NewRuleError(r.ID(), "", "validate",
+					fmt.Errorf("%w: dependency %q", ErrRuleNotFound, dep)))
+			}
+		}
+	}
+
+	if cycle := findCycle(rules); cycle != nil {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrCircularDep, strings.Join(cycle, " -> ")))
+	}
+
+	return errors.Join(errs...)
+}
+
+// DependencyGraph returns the engine's rule IDs in evaluation order and
+// the dependency edges between them. Each edge is {from, to}, meaning
+// from must run before to. Edges come from declared Deps and are also
+// inferred from expressions: a rule reading a key depends on the rules
+// that set it. Dependencies on unknown rule IDs are omitted.
+func (e *Engine) DependencyGraph() (nodes []string, edges [][2]string) {
+	e.mu.RLock()
+	rules := e.rules
+	e.mu.RUnlock()
+
+	producers := make(map[string][]string)
+	ids := make(map[string]struct{}, len(rules))
+	for _, r := range rules {
+		nodes = append(nodes, r.ID())
+		ids[r.ID()] = struct{}{}
+		if or, ok := r.(outputRule); ok {
+			for _, key := range or.outputs() {
+				producers[key] = append(producers[key], r.ID())
+			}
+		}
+	}
+
+	seen := make(map[[2]string]struct{})
+	addEdge := func(from, to string) {
+		edge := [2]string{from, to}
+		if _, ok := seen[edge]; ok || from == to {
+			return
+		}
+		seen[edge] = struct{}{}
+		edges = append(edges, edge)
+	}
+
+	for _, r := range rules {
+		for _, dep := range ruleDeps(r) {
+			if _, ok := ids[dep]; ok {
+				addEdge(dep, r.ID())
+			}
+		}
+
+		for _, ex := range ruleExpressions(r) {
+			for _, name := range ex.Variables() {
+				for _, producer := range producers[name] {
+					addEdge(producer, r.ID())
+				}
+			}
+		}
+	}
+
+	return nodes, edges
+}
+
+// GraphDOT renders the dependency graph in Graphviz DOT format.
+func (e *Engine) GraphDOT() string {
+	nodes, edges := e.DependencyGraph()
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "digraph %s {\n", strconv.Quote(e.name))
+	for _, n := range nodes {
+		fmt.Fprintf(&sb, "\t%s;\n", strconv.Quote(n))
+	}
+	for _, edge := range edges {
+		fmt.Fprintf(&sb, "\t%s -> %s;\n", strconv.Quote(edge[0]), strconv.Quote(edge[1]))
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// sortRules returns rules in dependency order, preferring insertion order
+// among rules that are ready to run.
+func sortRules(rules []Rule) ([]Rule, error) {
+	index := make(map[string]int, len(rules))
+	for i, r := range rules {
+		index[r.ID()] = i
+	}
+
+	// pending[i] is the number of unsatisfied dependencies of rules[i];
+	// dependents[i] lists the rules that depend on rules[i].
+	pending := make([]int, len(rules))
+	dependents := make([][]int, len(rules))
+	for i, r := range rules {
+		for _, dep := range ruleDeps(r) {
+			j, ok := index[dep]
+			if !ok {
+				continue
+			}
+			pending[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	sorted := make([]Rule, 0, len(rules))
+	done := make([]bool, len(rules))
+	for len(sorted) < len(rules) {
+		next := -1
+		for i := range rules {
+			if !done[i] && pending[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrCircularDep, strings.Join(findCycle(rules), " -> "))
+		}
+
+		done[next] = true
+		sorted = append(sorted, rules[next])
+		for _, d := range dependents[next] {
+			pending[d]--
+		}
+	}
+
+	return sorted, nil
+}
+
+// ruleDeps returns a rule's declared dependencies, if it exposes any.
+func ruleDeps(r Rule) []string {
+	if m, ok := r.(interface{ Dependencies() []string }); ok {
+		return m.Dependencies()
+	}
+	return nil
+}
+
+// findCycle returns the rule IDs along a dependency cycle, starting and
+// ending with the same ID, or nil if the rules are acyclic.
+func findCycle(rules []Rule) []string {
+	deps := make(map[string][]string, len(rules))
+	for _, r := range rules {
+		deps[r.ID()] = ruleDeps(r)
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(rules))
+	var path []string
+
+	var visit func(id string) []string
+	visit = func(id string) []string {
+		state[id] = visiting
+		path = append(path, id)
+		for _, dep := range deps[id] {
+			if _, ok := deps[dep]; !ok {
+				continue
+			}
+			switch state[dep] {
+			case visiting:
+				for i, p := range path {
+					if p == dep {
+						cycle := append([]string{}, path[i:]...)
+						return append(cycle, dep)
+					}
+				}
+			case unvisited:
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[id] = visited
+		return nil
+	}
+
+	for _, r := range rules {
+		if state[r.ID()] == unvisited {
+			if cycle := visit(r.ID()); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
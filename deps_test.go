@@ -0,0 +1,162 @@
+package cortex_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kolosys/cortex"
+)
+
+// evaluationOrder evaluates the engine and returns the IDs of the
+// recordRule rules in the order they ran.
+func evaluationOrder(t *testing.T, engine *cortex.Engine) []string {
+	t.Helper()
+	evalCtx := cortex.NewEvalContext()
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	order, _ := evalCtx.GetString("order")
+	return strings.Split(strings.TrimPrefix(order, ","), ",")
+}
+
+// recordRule appends its ID to the "order" key when evaluated.
+func recordRule(id string, deps ...string) cortex.Rule {
+	return cortex.MustAssignment(cortex.AssignmentConfig{
+		ID:     id,
+		Deps:   deps,
+		Target: "_" + id,
+		ValueFunc: func(ctx context.Context, evalCtx *cortex.EvalContext) (any, error) {
+			order, _ := evalCtx.GetString("order")
+			evalCtx.Set("order", order+","+id)
+			return true, nil
+		},
+	})
+}
+
+func TestEngineSort(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRules(
+		recordRule("net", "tax", "gross"),
+		recordRule("a"),
+		recordRule("tax", "gross"),
+		recordRule("b"),
+		recordRule("gross"),
+		recordRule("c", "missing"),
+	)
+
+	if err := engine.Sort(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"a", "b", "gross", "tax", "net", "c"}
+	got := evaluationOrder(t, engine)
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected order %v, got %v", expected, got)
+	}
+}
+
+func TestEngineSortCycle(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRules(
+		recordRule("a", "c"),
+		recordRule("b", "a"),
+		recordRule("c", "b"),
+		recordRule("d"),
+	)
+
+	err := engine.Sort()
+	if !errors.Is(err, cortex.ErrCircularDep) {
+		t.Fatalf("expected ErrCircularDep, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "a -> c -> b -> a") {
+		t.Errorf("expected cycle path in error, got %v", err)
+	}
+
+	// Order is unchanged on failure
+	got := evaluationOrder(t, engine)
+	if strings.Join(got, ",") != "a,b,c,d" {
+		t.Errorf("expected original order, got %v", got)
+	}
+}
+
+func TestEngineValidate(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRules(
+		recordRule("gross"),
+		recordRule("tax", "gross"),
+		recordRule("net", "tax", "gross"),
+	)
+	if err := engine.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestEngineValidateCycle(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRules(
+		recordRule("a", "b"),
+		recordRule("b", "a"),
+	)
+
+	err := engine.Validate()
+	if !errors.Is(err, cortex.ErrCircularDep) {
+		t.Fatalf("expected ErrCircularDep, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "a -> b -> a") {
+		t.Errorf("expected cycle path in error, got %v", err)
+	}
+}
+
+func TestEngineValidateUnknownDep(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRules(
+		recordRule("tax", "gross"),
+	)
+
+	err := engine.Validate()
+	if !errors.Is(err, cortex.ErrRuleNotFound) {
+		t.Fatalf("expected ErrRuleNotFound, got %v", err)
+	}
+	if errors.Is(err, cortex.ErrCircularDep) {
+		t.Errorf("unexpected cycle error: %v", err)
+	}
+	if !strings.Contains(err.Error(), `"gross"`) {
+		t.Errorf("expected missing dependency in error, got %v", err)
+	}
+}
+
+func TestEngineDependencyGraph(t *testing.T) {
+	engine := cortex.New("payroll", nil)
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "rate", Target: "rate", Value: 0.2}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "gross", Target: "gross", Expression: "hours * 20"}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "tax", Target: "tax", Expression: "gross * rate"}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "net", Target: "net", Expression: "gross - tax", Deps: []string{"tax", "missing"}}),
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "flag", Target: "done", Value: true, When: "net > 0"}),
+	)
+
+	nodes, edges := engine.DependencyGraph()
+	if want := []string{"rate", "gross", "tax", "net", "flag"}; !reflect.DeepEqual(nodes, want) {
+		t.Errorf("nodes: got %v, want %v", nodes, want)
+	}
+	want := [][2]string{
+		{"gross", "tax"},
+		{"rate", "tax"},
+		{"tax", "net"},
+		{"gross", "net"},
+		{"net", "flag"},
+	}
+	if !reflect.DeepEqual(edges, want) {
+		t.Errorf("edges: got %v, want %v", edges, want)
+	}
+
+	dot := engine.GraphDOT()
+	for _, line := range []string{`digraph "payroll" {`, `"rate";`, `"gross" -> "tax";`, `"net" -> "flag";`} {
+		if !strings.Contains(dot, line) {
+			t.Errorf("expected DOT output to contain %q, got:\n%s", line, dot)
+		}
+	}
+}
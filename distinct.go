@@ -0,0 +1,99 @@
+package cortex
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"reflect"
+)
+
+// hllPrecision is the number of hash bits that select a HyperLogLog
+// register: 2^14 one-byte registers (16 KiB) give a standard error of
+// about 0.8%.
+const hllPrecision = 14
+
+// distinctSet counts distinct values exactly until it holds more than
+// limit of them (0 = no limit), then switches to a HyperLogLog estimate
+// in fixed memory.
+type distinctSet struct {
+	limit     int
+	seen      map[any]struct{}
+	registers []uint8 // non-nil once the set has overflowed
+}
+
+// add records v.
+func (d *distinctSet) add(v any) {
+	key := distinctKey(v)
+	if d.registers != nil {
+		d.addHash(key)
+		return
+	}
+	if d.seen == nil {
+		d.seen = make(map[any]struct{})
+	}
+	d.seen[key] = struct{}{}
+	if d.limit > 0 && len(d.seen) > d.limit {
+		d.registers = make([]uint8, 1<<hllPrecision)
+		for k := range d.seen {
+			d.addHash(k)
+		}
+		d.seen = nil
+	}
+}
+
+// addHash updates the HyperLogLog registers for key.
+func (d *distinctSet) addHash(key any) {
+	h := hashKey(key)
+	idx := h >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(h<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > d.registers[idx] {
+		d.registers[idx] = rank
+	}
+}
+
+// count returns the number of distinct values, estimated once the set has
+// overflowed.
+func (d *distinctSet) count() float64 {
+	if d.registers == nil {
+		return float64(len(d.seen))
+	}
+
+	m := float64(len(d.registers))
+	var sum float64
+	var zeros int
+	for _, r := range d.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros)) // linear counting for small cardinalities
+	}
+	return math.Round(estimate)
+}
+
+// distinctKey returns v if it can be a map key, and its printed form
+// otherwise, so slices and maps are compared by content.
+func distinctKey(v any) any {
+	if v == nil || reflect.TypeOf(v).Comparable() {
+		return v
+	}
+	return fmt.Sprintf("%T:%v", v, v)
+}
+
+// hashKey returns a well-mixed 64-bit hash of a distinct key.
+func hashKey(key any) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%T:%v", key, key)
+	x := h.Sum64()
+	// splitmix64 finalizer, so every bit depends on the whole input
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -7,24 +7,30 @@ import (
 
 // Sentinel errors for common failure cases.
 var (
-	ErrRuleNotFound      = errors.New("cortex: rule not found")
-	ErrLookupNotFound    = errors.New("cortex: lookup table not found")
-	ErrKeyNotFound       = errors.New("cortex: key not found in lookup")
-	ErrValueNotFound     = errors.New("cortex: value not found in context")
-	ErrBuildupNotFound   = errors.New("cortex: buildup not found")
-	ErrInvalidRule       = errors.New("cortex: invalid rule configuration")
-	ErrInvalidExpression = errors.New("cortex: invalid expression")
-	ErrTypeMismatch      = errors.New("cortex: type mismatch")
-	ErrDivisionByZero    = errors.New("cortex: division by zero")
-	ErrAllocationSum     = errors.New("cortex: allocation percentages must sum to 100")
-	ErrCircularDep       = errors.New("cortex: circular dependency detected")
-	ErrEvaluation        = errors.New("cortex: evaluation failed")
-	ErrShortCircuit      = errors.New("cortex: evaluation short-circuited")
-	ErrEngineClosed      = errors.New("cortex: engine is closed")
-	ErrTimeout           = errors.New("cortex: evaluation timeout")
-	ErrNilContext        = errors.New("cortex: nil evaluation context")
-	ErrDuplicateRule     = errors.New("cortex: duplicate rule ID")
-	ErrDuplicateLookup   = errors.New("cortex: duplicate lookup table name")
+	ErrRuleNotFound         = errors.New("cortex: rule not found")
+	ErrLookupNotFound       = errors.New("cortex: lookup table not found")
+	ErrKeyNotFound          = errors.New("cortex: key not found in lookup")
+	ErrValueNotFound        = errors.New("cortex: value not found in context")
+	ErrBuildupNotFound      = errors.New("cortex: buildup not found")
+	ErrInvalidRule          = errors.New("cortex: invalid rule configuration")
+	ErrInvalidExpression    = errors.New("cortex: invalid expression")
+	ErrTypeMismatch         = errors.New("cortex: type mismatch")
+	ErrDivisionByZero       = errors.New("cortex: division by zero")
+	ErrAllocationSum        = errors.New("cortex: allocation percentages must sum to 100")
+	ErrAllocationInfeasible = errors.New("cortex: allocation bounds cannot be met")
+	ErrCircularDep          = errors.New("cortex: circular dependency detected")
+	ErrEvaluation           = errors.New("cortex: evaluation failed")
+	ErrShortCircuit         = errors.New("cortex: evaluation short-circuited")
+	ErrEngineClosed         = errors.New("cortex: engine is closed")
+	ErrTimeout              = errors.New("cortex: evaluation timeout")
+	ErrNilContext           = errors.New("cortex: nil evaluation context")
+	ErrDuplicateRule        = errors.New("cortex: duplicate rule ID")
+	ErrDuplicateLookup      = errors.New("cortex: duplicate lookup table name")
+	ErrOverwrite            = errors.New("cortex: value already set by another rule")
+	ErrRangeOverlap         = errors.New("cortex: lookup ranges overlap")
+	ErrCircuitOpen          = errors.New("cortex: rule circuit breaker open")
+	ErrMissingOutput        = errors.New("cortex: required output missing")
+	ErrBudgetExceeded       = errors.New("cortex: rule evaluation budget exceeded")
 )
 
 // RuleError wraps an error with rule context.
@@ -46,6 +46,7 @@ func TestSentinelErrors(t *testing.T) {
 		cortex.ErrTypeMismatch,
 		cortex.ErrDivisionByZero,
 		cortex.ErrAllocationSum,
+		cortex.ErrAllocationInfeasible,
 		cortex.ErrCircularDep,
 		cortex.ErrEvaluation,
 		cortex.ErrShortCircuit,
@@ -0,0 +1,76 @@
+package cortex
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ExplanationStep is one value written by a rule during Engine.Explain.
+type ExplanationStep struct {
+	RuleID   string
+	Type     RuleType // empty for custom rule implementations
+	Target   string   // context key written
+	OldValue any      // nil if the key was unset
+	NewValue any
+}
+
+// Explanation is the ordered log of values written by an evaluation.
+type Explanation struct {
+	Steps []ExplanationStep
+
+	// Result is the evaluation result, nil if evaluation could not start.
+	Result *Result
+}
+
+// String renders the steps one per line, e.g.
+// "2. calc-tax (formula): tax = 200 (was unset)".
+func (x *Explanation) String() string {
+	var sb strings.Builder
+	for i, step := range x.Steps {
+		fmt.Fprintf(&sb, "%d. %s", i+1, step.RuleID)
+		if step.Type != "" {
+			fmt.Fprintf(&sb, " (%s)", step.Type)
+		}
+		fmt.Fprintf(&sb, ": %s = %v", step.Target, step.NewValue)
+		if step.OldValue == nil {
+			sb.WriteString(" (was unset)\n")
+		} else {
+			fmt.Fprintf(&sb, " (was %v)\n", step.OldValue)
+		}
+	}
+	return sb.String()
+}
+
+// Explain evaluates the rules against evalCtx as Evaluate does, recording
+// every value a rule writes, in order, with the value it replaced. If
+// evaluation fails, the steps up to the failure are returned with the
+// error. evalCtx is modified as usual; pass evalCtx.DeepClone() for a dry
+// run that leaves it untouched.
+func (e *Engine) Explain(ctx context.Context, evalCtx *EvalContext) (*Explanation, error) {
+	if evalCtx == nil {
+		return nil, ErrNilContext
+	}
+
+	evalCtx.startSteps()
+	result, err := e.Evaluate(ctx, evalCtx)
+	return &Explanation{Steps: evalCtx.stopSteps(), Result: result}, err
+}
+
+// startSteps begins recording the step log, discarding any earlier one.
+func (e *EvalContext) startSteps() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.steps = nil
+	e.trackSteps.Store(true)
+}
+
+// stopSteps stops recording and returns the step log.
+func (e *EvalContext) stopSteps() []ExplanationStep {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.trackSteps.Store(false)
+	steps := e.steps
+	e.steps = nil
+	return steps
+}
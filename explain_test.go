@@ -0,0 +1,82 @@
+package cortex_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/kolosys/cortex"
+)
+
+func TestEngineExplainSteps(t *testing.T) {
+	engine := cortex.New("payroll", cortex.DefaultConfig())
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "set-rate", Target: "rate", Value: 0.2}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "calc-tax", Target: "tax", Expression: "gross * rate"}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "calc-net", Target: "net", Expression: "gross - tax"}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "round-tax", Target: "tax", Expression: "round(tax)"}),
+	)
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("gross", 1001.0)
+	evalCtx.Set("rate", 0.1)
+
+	explanation, err := engine.Explain(context.Background(), evalCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []cortex.ExplanationStep{
+		{RuleID: "set-rate", Type: cortex.RuleTypeAssignment, Target: "rate", OldValue: 0.1, NewValue: 0.2},
+		{RuleID: "calc-tax", Type: cortex.RuleTypeFormula, Target: "tax", NewValue: 200.20000000000002},
+		{RuleID: "calc-net", Type: cortex.RuleTypeFormula, Target: "net", NewValue: 800.8},
+		{RuleID: "round-tax", Type: cortex.RuleTypeFormula, Target: "tax", OldValue: 200.20000000000002, NewValue: 200.0},
+	}
+	if !reflect.DeepEqual(explanation.Steps, want) {
+		t.Errorf("expected steps\n%+v\ngot\n%+v", want, explanation.Steps)
+	}
+	if explanation.Result == nil || !explanation.Result.Success {
+		t.Errorf("expected a successful result, got %+v", explanation.Result)
+	}
+
+	wantText := "1. set-rate (assignment): rate = 0.2 (was 0.1)\n" +
+		"2. calc-tax (formula): tax = 200.20000000000002 (was unset)\n" +
+		"3. calc-net (formula): net = 800.8 (was unset)\n" +
+		"4. round-tax (formula): tax = 200 (was 200.20000000000002)\n"
+	if got := explanation.String(); got != wantText {
+		t.Errorf("expected\n%s\ngot\n%s", wantText, got)
+	}
+
+	// Ordinary evaluations record nothing, and each Explain starts a fresh log.
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	again, err := engine.Explain(context.Background(), evalCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(again.Steps) != 4 {
+		t.Errorf("expected 4 steps from a fresh Explain, got %d", len(again.Steps))
+	}
+}
+
+func TestEngineExplainStepsFailure(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "set-x", Target: "x", Value: 1.0}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "calc-y", Target: "y", Expression: "x + missing"}),
+	)
+
+	explanation, err := engine.Explain(context.Background(), cortex.NewEvalContext())
+	if err == nil {
+		t.Fatal("expected calc-y to fail")
+	}
+	if len(explanation.Steps) != 1 || explanation.Steps[0].RuleID != "set-x" {
+		t.Errorf("expected the step before the failure, got %+v", explanation.Steps)
+	}
+
+	if _, err := engine.Explain(context.Background(), nil); !errors.Is(err, cortex.ErrNilContext) {
+		t.Errorf("expected ErrNilContext, got %v", err)
+	}
+}
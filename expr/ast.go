@@ -8,6 +8,7 @@ type Node interface {
 // NumberLit represents a numeric literal.
 type NumberLit struct {
 	Value float64
+	Int   bool // written without a fraction or suffix; evaluates to an int
 }
 
 func (*NumberLit) node() {}
@@ -29,6 +30,7 @@ func (*BoolLit) node() {}
 // Ident represents an identifier (variable reference).
 type Ident struct {
 	Name string
+	Pos  int // byte offset in the source
 }
 
 func (*Ident) node() {}
@@ -38,6 +40,7 @@ type BinaryExpr struct {
 	Op    TokenType
 	Left  Node
 	Right Node
+	Pos   int // byte offset of the operator
 }
 
 func (*BinaryExpr) node() {}
@@ -46,14 +49,34 @@ func (*BinaryExpr) node() {}
 type UnaryExpr struct {
 	Op   TokenType
 	Expr Node
+	Pos  int // byte offset of the operator
 }
 
 func (*UnaryExpr) node() {}
 
+// ListLit represents a list literal ([a, b, c]).
+type ListLit struct {
+	Elems []Node
+	Pos   int // byte offset of '['
+}
+
+func (*ListLit) node() {}
+
+// CondExpr represents a conditional expression (cond ? then : else).
+type CondExpr struct {
+	Cond Node
+	Then Node
+	Else Node
+	Pos  int // byte offset of '?'
+}
+
+func (*CondExpr) node() {}
+
 // CallExpr represents a function call.
 type CallExpr struct {
 	Name string
 	Args []Node
+	Pos  int // byte offset of the function name
 }
 
 func (*CallExpr) node() {}
@@ -4,6 +4,11 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 // ValueGetter retrieves values by name (e.g., from EvalContext).
@@ -13,16 +18,29 @@ type ValueGetter interface {
 
 // Evaluator evaluates an AST against a value getter.
 type Evaluator struct {
-	funcs map[string]Func
+	funcs    map[string]Func
+	ctxFuncs map[string]ContextFunc
+	custom   map[string]bool // names registered by the caller, replacing any builtin
+	rounding RoundingMode
+
+	division         DivisionPolicy
+	divisionFallback float64
+
+	gen uint64 // bumped on every change that can alter folded constants
 }
 
 // Func is a built-in function type.
 type Func func(args ...any) (any, error)
 
+// ContextFunc is a function that also receives the evaluation context.
+type ContextFunc func(ctx context.Context, args ...any) (any, error)
+
 // NewEvaluator creates a new evaluator with built-in functions.
 func NewEvaluator() *Evaluator {
 	e := &Evaluator{
-		funcs: make(map[string]Func),
+		funcs:    make(map[string]Func),
+		ctxFuncs: make(map[string]ContextFunc),
+		custom:   make(map[string]bool),
 	}
 	e.registerBuiltins()
 	return e
@@ -34,15 +52,51 @@ func (e *Evaluator) registerBuiltins() {
 	e.funcs["abs"] = funcAbs
 	e.funcs["floor"] = funcFloor
 	e.funcs["ceil"] = funcCeil
-	e.funcs["round"] = funcRound
+	e.funcs["round"] = e.funcRound
 	e.funcs["if"] = funcIf
 	e.funcs["sqrt"] = funcSqrt
 	e.funcs["pow"] = funcPow
+	e.funcs["idiv"] = e.funcIdiv
+	e.funcs["concat"] = funcConcat
+	e.funcs["len"] = funcLen
+	e.funcs["upper"] = funcUpper
+	e.funcs["lower"] = funcLower
+	e.funcs["substr"] = funcSubstr
+	e.funcs["contains"] = funcContains
+	e.funcs["sprintf"] = funcSprintf
+}
+
+// SetRoundingMode sets the mode used by round when none is given.
+// The default is RoundHalfUp.
+func (e *Evaluator) SetRoundingMode(mode RoundingMode) {
+	e.rounding = mode
+	e.gen++
+}
+
+// SetDivisionPolicy sets what division and modulo by zero produce.
+// fallback is the result under DivisionFallback and is otherwise ignored.
+// The default is DivisionError.
+func (e *Evaluator) SetDivisionPolicy(policy DivisionPolicy, fallback float64) {
+	e.division = policy
+	e.divisionFallback = fallback
+	e.gen++
 }
 
 // RegisterFunc registers a custom function.
 func (e *Evaluator) RegisterFunc(name string, fn Func) {
+	e.custom[name] = true
+	delete(e.ctxFuncs, name)
 	e.funcs[name] = fn
+	e.gen++
+}
+
+// RegisterContextFunc registers a custom function that receives the
+// evaluation context.
+func (e *Evaluator) RegisterContextFunc(name string, fn ContextFunc) {
+	e.custom[name] = true
+	delete(e.funcs, name)
+	e.ctxFuncs[name] = fn
+	e.gen++
 }
 
 // Eval evaluates an AST node against a value getter.
@@ -53,6 +107,9 @@ func (e *Evaluator) Eval(ctx context.Context, node Node, getter ValueGetter) (an
 func (e *Evaluator) eval(ctx context.Context, node Node, getter ValueGetter) (any, error) {
 	switch n := node.(type) {
 	case *NumberLit:
+		if n.Int {
+			return int(n.Value), nil
+		}
 		return n.Value, nil
 
 	case *StringLit:
@@ -64,7 +121,7 @@ func (e *Evaluator) eval(ctx context.Context, node Node, getter ValueGetter) (an
 	case *Ident:
 		val, ok := getter.Get(n.Name)
 		if !ok {
-			return nil, fmt.Errorf("undefined variable: %s", n.Name)
+			return nil, errorAt(n.Pos, fmt.Errorf("undefined variable '%s'", n.Name))
 		}
 		return val, nil
 
@@ -73,7 +130,8 @@ func (e *Evaluator) eval(ctx context.Context, node Node, getter ValueGetter) (an
 		if err != nil {
 			return nil, err
 		}
-		return e.evalUnary(n.Op, val)
+		val, err = e.evalUnary(n.Op, val)
+		return val, errorAt(n.Pos, err)
 
 	case *BinaryExpr:
 		left, err := e.eval(ctx, n.Left, getter)
@@ -84,12 +142,42 @@ func (e *Evaluator) eval(ctx context.Context, node Node, getter ValueGetter) (an
 		if err != nil {
 			return nil, err
 		}
-		return e.evalBinary(n.Op, left, right)
+		val, err := e.evalBinary(n.Op, left, right)
+		return val, errorAt(n.Pos, err)
+
+	case *ListLit:
+		list := make([]any, len(n.Elems))
+		for i, elem := range n.Elems {
+			val, err := e.eval(ctx, elem, getter)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = val
+		}
+		return list, nil
+
+	case *CondExpr:
+		cond, err := e.eval(ctx, n.Cond, getter)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := cond.(bool)
+		if !ok {
+			return nil, errorAt(n.Pos, fmt.Errorf("condition of ?: must be bool, got %T", cond))
+		}
+		if b {
+			return e.eval(ctx, n.Then, getter)
+		}
+		return e.eval(ctx, n.Else, getter)
 
 	case *CallExpr:
+		if n.Name == "if" && !e.custom["if"] { // a custom if is called eagerly
+			return e.evalIf(ctx, n, getter)
+		}
 		fn, ok := e.funcs[n.Name]
-		if !ok {
-			return nil, fmt.Errorf("undefined function: %s", n.Name)
+		ctxFn, ctxOk := e.ctxFuncs[n.Name]
+		if !ok && !ctxOk {
+			return nil, errorAt(n.Pos, fmt.Errorf("undefined function '%s'", n.Name))
 		}
 		args := make([]any, len(n.Args))
 		for i, arg := range n.Args {
@@ -99,13 +187,63 @@ func (e *Evaluator) eval(ctx context.Context, node Node, getter ValueGetter) (an
 			}
 			args[i] = val
 		}
-		return fn(args...)
+		var val any
+		var err error
+		if ctxOk {
+			val, err = ctxFn(ctx, args...)
+		} else {
+			val, err = fn(args...)
+		}
+		return val, errorAt(n.Pos, err)
 
 	default:
 		return nil, fmt.Errorf("unknown node type: %T", node)
 	}
 }
 
+// PosError is an evaluation error located at a byte offset in the
+// expression source, such as the undefined variable or failing operator.
+type PosError struct {
+	Pos int
+	Err error
+}
+
+func (e *PosError) Error() string {
+	return fmt.Sprintf("%v at position %d", e.Err, e.Pos)
+}
+
+func (e *PosError) Unwrap() error {
+	return e.Err
+}
+
+// errorAt wraps a non-nil err with the position pos.
+func errorAt(pos int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PosError{Pos: pos, Err: err}
+}
+
+// evalIf evaluates the built-in if lazily: only the branch selected by
+// the condition is evaluated.
+func (e *Evaluator) evalIf(ctx context.Context, n *CallExpr, getter ValueGetter) (any, error) {
+	if len(n.Args) != 3 {
+		return nil, errorAt(n.Pos, fmt.Errorf("if requires 3 arguments (condition, then, else)"))
+	}
+	val, err := e.eval(ctx, n.Args[0], getter)
+	if err != nil {
+		return nil, err
+	}
+	cond, ok := val.(bool)
+	if !ok {
+		return nil, errorAt(n.Pos, fmt.Errorf("if condition must be bool"))
+	}
+	if cond {
+		return e.eval(ctx, n.Args[1], getter)
+	}
+	return e.eval(ctx, n.Args[2], getter)
+}
+
 func (e *Evaluator) evalUnary(op TokenType, val any) (any, error) {
 	switch op {
 	case TokenNot:
@@ -116,6 +254,16 @@ func (e *Evaluator) evalUnary(op TokenType, val any) (any, error) {
 		return !b, nil
 
 	case TokenMinus:
+		switch n := val.(type) {
+		case int:
+			if n != math.MinInt {
+				return -n, nil
+			}
+		case int64:
+			if n != math.MinInt64 {
+				return -n, nil
+			}
+		}
 		f, err := toFloat(val)
 		if err != nil {
 			return nil, err
@@ -128,7 +276,27 @@ func (e *Evaluator) evalUnary(op TokenType, val any) (any, error) {
 }
 
 func (e *Evaluator) evalBinary(op TokenType, left, right any) (any, error) {
+	if v, ok := evalInt(op, left, right); ok {
+		return v, nil
+	}
+
 	switch op {
+	case TokenIn:
+		list, ok := right.([]any)
+		if !ok {
+			return nil, fmt.Errorf("expected list for in, got %T", right)
+		}
+		for _, item := range list {
+			eq, err := equals(left, item)
+			if err != nil {
+				return nil, err
+			}
+			if eq {
+				return true, nil
+			}
+		}
+		return false, nil
+
 	case TokenAnd:
 		l, lok := left.(bool)
 		r, rok := right.(bool)
@@ -145,13 +313,23 @@ func (e *Evaluator) evalBinary(op TokenType, left, right any) (any, error) {
 		}
 		return l || r, nil
 
-	case TokenEq:
-		return equals(left, right), nil
-
-	case TokenNe:
-		return !equals(left, right), nil
+	case TokenEq, TokenNe:
+		if err := checkDates(left, right); err != nil {
+			return nil, err
+		}
+		eq, err := equals(left, right)
+		if err != nil {
+			return nil, err
+		}
+		return eq == (op == TokenEq), nil
 
 	case TokenLt, TokenLe, TokenGt, TokenGe:
+		if err := checkDates(left, right); err != nil {
+			return nil, err
+		}
+		if lt, ok := left.(time.Time); ok {
+			return compareTimes(op, lt, right.(time.Time)), nil
+		}
 		lf, err := toFloat(left)
 		if err != nil {
 			return nil, err
@@ -220,10 +398,16 @@ func (e *Evaluator) evalBinary(op TokenType, left, right any) (any, error) {
 			return nil, err
 		}
 		if rf == 0 {
-			return nil, fmt.Errorf("division by zero")
+			return e.divideByZero("division", lf/rf)
 		}
 		return lf / rf, nil
 
+	case TokenIntDiv:
+		return e.funcIdiv(left, right)
+
+	case TokenPow:
+		return funcPow(left, right)
+
 	case TokenPercent:
 		lf, err := toFloat(left)
 		if err != nil {
@@ -234,7 +418,7 @@ func (e *Evaluator) evalBinary(op TokenType, left, right any) (any, error) {
 			return nil, err
 		}
 		if rf == 0 {
-			return nil, fmt.Errorf("modulo by zero")
+			return e.divideByZero("modulo", math.Mod(lf, rf))
 		}
 		return math.Mod(lf, rf), nil
 	}
@@ -242,6 +426,76 @@ func (e *Evaluator) evalBinary(op TokenType, left, right any) (any, error) {
 	return nil, fmt.Errorf("unknown binary operator: %s", op)
 }
 
+// evalInt applies +, -, *, % or // to two integer operands. The result is
+// an int when both operands are ints and an int64 otherwise. It reports
+// false for other operators, non-integer operands, overflow and division
+// or modulo by zero, leaving those to the float64 arithmetic in evalBinary.
+func evalInt(op TokenType, left, right any) (any, bool) {
+	if op != TokenPlus && op != TokenMinus && op != TokenStar && op != TokenPercent && op != TokenIntDiv {
+		return nil, false
+	}
+	l, lok := toInt64(left)
+	r, rok := toInt64(right)
+	if !lok || !rok {
+		return nil, false
+	}
+
+	var n int64
+	switch op {
+	case TokenPlus:
+		n = l + r
+		if (r > 0 && n < l) || (r < 0 && n > l) {
+			return nil, false
+		}
+	case TokenMinus:
+		n = l - r
+		if (r > 0 && n > l) || (r < 0 && n < l) {
+			return nil, false
+		}
+	case TokenStar:
+		n = l * r
+		if l != 0 && (n/l != r || (l == -1 && r == math.MinInt64)) {
+			return nil, false
+		}
+	case TokenPercent:
+		if r == 0 {
+			return nil, false
+		}
+		if r != -1 { // MinInt64 % -1 panics; the result is always 0
+			n = l % r
+		}
+	case TokenIntDiv:
+		if r == 0 || (l == math.MinInt64 && r == -1) {
+			return nil, false
+		}
+		n = l / r
+		if l%r != 0 && (l < 0) != (r < 0) {
+			n-- // floor rather than truncate
+		}
+	}
+
+	_, lint := left.(int)
+	_, rint := right.(int)
+	if lint && rint && n >= math.MinInt && n <= math.MaxInt {
+		return int(n), true
+	}
+	return n, true
+}
+
+// toInt64 returns v as an int64 if it is a Go integer type.
+func toInt64(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int32:
+		return int64(n), true
+	default:
+		return 0, false
+	}
+}
+
 func toFloat(v any) (float64, error) {
 	switch n := v.(type) {
 	case float64:
@@ -259,13 +513,52 @@ func toFloat(v any) (float64, error) {
 	}
 }
 
-func equals(a, b any) bool {
-	af, aok := toFloat(a)
-	bf, bok := toFloat(b)
-	if aok == nil && bok == nil {
-		return af == bf
+// checkDates returns an error if exactly one of a comparison's operands
+// is a time.Time.
+func checkDates(left, right any) error {
+	_, lok := left.(time.Time)
+	_, rok := right.(time.Time)
+	if lok != rok {
+		return fmt.Errorf("cannot compare %T with %T: dates compare only with dates", left, right)
 	}
-	return a == b
+	return nil
+}
+
+// equals reports whether a and b are equal. Lists are compared element by
+// element; other operands Go cannot compare, such as maps, are an error.
+func equals(a, b any) (bool, error) {
+	al, aok := a.([]any)
+	bl, bok := b.([]any)
+	if aok || bok {
+		if !aok || !bok || len(al) != len(bl) {
+			return false, nil
+		}
+		for i := range al {
+			if eq, err := equals(al[i], bl[i]); err != nil || !eq {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	if at, ok := a.(time.Time); ok {
+		if bt, ok := b.(time.Time); ok {
+			return at.Equal(bt), nil
+		}
+	}
+	af, aerr := toFloat(a)
+	bf, berr := toFloat(b)
+	if aerr == nil && berr == nil {
+		return af == bf, nil
+	}
+	if !isComparable(a) || !isComparable(b) {
+		return false, fmt.Errorf("cannot compare %T with %T", a, b)
+	}
+	return a == b, nil
+}
+
+// isComparable reports whether v can be compared with ==.
+func isComparable(v any) bool {
+	return v == nil || reflect.ValueOf(v).Comparable()
 }
 
 // Built-in functions
@@ -343,23 +636,142 @@ func funcCeil(args ...any) (any, error) {
 	return math.Ceil(f), nil
 }
 
-func funcRound(args ...any) (any, error) {
-	if len(args) < 1 || len(args) > 2 {
-		return nil, fmt.Errorf("round requires 1 or 2 arguments")
+// DivisionPolicy selects what /, // and % produce when the divisor is zero.
+type DivisionPolicy int
+
+const (
+	// DivisionError fails the evaluation.
+	DivisionError DivisionPolicy = iota
+
+	// DivisionIEEE returns what Go float64 arithmetic gives: +Inf or -Inf,
+	// or NaN for 0 / 0 and for modulo.
+	DivisionIEEE
+
+	// DivisionFallback returns the fallback value set with the policy,
+	// e.g. 0 so that "divide by zero yields 0".
+	DivisionFallback
+)
+
+func (p DivisionPolicy) String() string {
+	switch p {
+	case DivisionError:
+		return "error"
+	case DivisionIEEE:
+		return "ieee"
+	case DivisionFallback:
+		return "fallback"
+	default:
+		return "unknown"
+	}
+}
+
+// divideByZero returns the result of a division or modulo by zero under
+// the evaluator's policy; ieee is the float64 arithmetic result.
+func (e *Evaluator) divideByZero(op string, ieee float64) (any, error) {
+	switch e.division {
+	case DivisionIEEE:
+		return ieee, nil
+	case DivisionFallback:
+		return e.divisionFallback, nil
+	default:
+		return nil, fmt.Errorf("%s by zero", op)
+	}
+}
+
+// RoundingMode selects how round resolves values between two candidates.
+type RoundingMode int
+
+const (
+	// RoundHalfUp rounds halves away from zero (2.5 to 3, -2.5 to -3).
+	RoundHalfUp RoundingMode = iota
+
+	// RoundHalfEven rounds halves to the nearest even digit (2.5 to 2,
+	// 3.5 to 4), also known as banker's rounding.
+	RoundHalfEven
+
+	// RoundFloor rounds toward negative infinity.
+	RoundFloor
+
+	// RoundCeil rounds toward positive infinity.
+	RoundCeil
+)
+
+func (m RoundingMode) String() string {
+	switch m {
+	case RoundHalfUp:
+		return "half_up"
+	case RoundHalfEven:
+		return "half_even"
+	case RoundFloor:
+		return "floor"
+	case RoundCeil:
+		return "ceil"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseRoundingMode parses a rounding mode name as accepted by round.
+func ParseRoundingMode(s string) (RoundingMode, error) {
+	switch s {
+	case "half_up":
+		return RoundHalfUp, nil
+	case "half_even":
+		return RoundHalfEven, nil
+	case "floor":
+		return RoundFloor, nil
+	case "ceil":
+		return RoundCeil, nil
+	default:
+		return 0, fmt.Errorf("unknown rounding mode %q", s)
+	}
+}
+
+// roundWith rounds f to the given number of decimal places using mode.
+func roundWith(f float64, precision int, mode RoundingMode) float64 {
+	multiplier := math.Pow(10, float64(precision))
+	v := f * multiplier
+	switch mode {
+	case RoundHalfEven:
+		v = math.RoundToEven(v)
+	case RoundFloor:
+		v = math.Floor(v)
+	case RoundCeil:
+		v = math.Ceil(v)
+	default:
+		v = math.Round(v)
+	}
+	return v / multiplier
+}
+
+// funcRound implements round(x), round(x, precision) and
+// round(x, precision, mode), using the evaluator's default mode when
+// none is given.
+func (e *Evaluator) funcRound(args ...any) (any, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return nil, fmt.Errorf("round requires 1 to 3 arguments")
 	}
 	f, err := toFloat(args[0])
 	if err != nil {
 		return nil, err
 	}
-	if len(args) == 1 {
-		return math.Round(f), nil
+	var precision float64
+	if len(args) >= 2 {
+		if precision, err = toFloat(args[1]); err != nil {
+			return nil, err
+		}
 	}
-	precision, err := toFloat(args[1])
-	if err != nil {
-		return nil, err
+	mode := e.rounding
+	if len(args) == 3 {
+		name, ok := args[2].(string)
+		if !ok {
+			return nil, fmt.Errorf("round mode must be string, got %T", args[2])
+		}
+		if mode, err = ParseRoundingMode(name); err != nil {
+			return nil, err
+		}
 	}
-	multiplier := math.Pow(10, precision)
-	return math.Round(f*multiplier) / multiplier, nil
+	return roundWith(f, int(precision), mode), nil
 }
 
 func funcIf(args ...any) (any, error) {
@@ -401,3 +813,193 @@ func funcPow(args ...any) (any, error) {
 	}
 	return math.Pow(base, exp), nil
 }
+
+func (e *Evaluator) funcIdiv(args ...any) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("idiv requires 2 arguments")
+	}
+	if v, ok := evalInt(TokenIntDiv, args[0], args[1]); ok {
+		return v, nil
+	}
+	lf, err := toFloat(args[0])
+	if err != nil {
+		return nil, err
+	}
+	rf, err := toFloat(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if rf == 0 {
+		return e.divideByZero("division", math.Floor(lf/rf))
+	}
+	return math.Floor(lf / rf), nil
+}
+
+func funcConcat(args ...any) (any, error) {
+	var sb strings.Builder
+	for _, arg := range args {
+		sb.WriteString(toString(arg))
+	}
+	return sb.String(), nil
+}
+
+// stringArg returns args[i] as a string, or an error naming the function.
+func stringArg(name string, args []any, i int) (string, error) {
+	s, ok := args[i].(string)
+	if !ok {
+		return "", fmt.Errorf("%s argument %d must be string, got %T", name, i+1, args[i])
+	}
+	return s, nil
+}
+
+func funcLen(args ...any) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("len requires 1 argument")
+	}
+	s, err := stringArg("len", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return float64(utf8.RuneCountInString(s)), nil
+}
+
+func funcUpper(args ...any) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("upper requires 1 argument")
+	}
+	s, err := stringArg("upper", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return strings.ToUpper(s), nil
+}
+
+func funcLower(args ...any) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("lower requires 1 argument")
+	}
+	s, err := stringArg("lower", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return strings.ToLower(s), nil
+}
+
+// funcSubstr returns the characters of s from start up to, but not
+// including, end.
+func funcSubstr(args ...any) (any, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("substr requires 3 arguments (string, start, end)")
+	}
+	s, err := stringArg("substr", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	start, err := toFloat(args[1])
+	if err != nil {
+		return nil, err
+	}
+	end, err := toFloat(args[2])
+	if err != nil {
+		return nil, err
+	}
+	runes := []rune(s)
+	if start < 0 || end > float64(len(runes)) || start > end || start != math.Trunc(start) || end != math.Trunc(end) {
+		return nil, fmt.Errorf("substr range [%v, %v) invalid for string of length %d", start, end, len(runes))
+	}
+	return string(runes[int(start):int(end)]), nil
+}
+
+func funcContains(args ...any) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("contains requires 2 arguments")
+	}
+	s, err := stringArg("contains", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	substr, err := stringArg("contains", args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Contains(s, substr), nil
+}
+
+func funcSprintf(args ...any) (any, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("sprintf requires a format argument")
+	}
+	format, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("sprintf format must be string, got %T", args[0])
+	}
+	verbs, err := formatVerbs(format)
+	if err != nil {
+		return nil, err
+	}
+	rest := args[1:]
+	if len(verbs) != len(rest) {
+		return nil, fmt.Errorf("sprintf format %q expects %d arguments, got %d", format, len(verbs), len(rest))
+	}
+	coerced := make([]any, len(rest))
+	for i, arg := range rest {
+		coerced[i] = coerceForVerb(verbs[i], arg)
+	}
+	return fmt.Sprintf(format, coerced...), nil
+}
+
+// formatVerbs returns what each argument of a format string is for, in
+// order: a verb letter, or '*' for a width or precision. %% takes no
+// argument. Explicit argument indexes such as %[1]d are not supported.
+func formatVerbs(format string) ([]byte, error) {
+	var verbs []byte
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		for i < len(format) && strings.IndexByte("+-# 0123456789.*[", format[i]) >= 0 {
+			switch format[i] {
+			case '*':
+				verbs = append(verbs, '*')
+			case '[':
+				return nil, fmt.Errorf("sprintf format %q: argument indexes are not supported", format)
+			}
+			i++
+		}
+		if i < len(format) && format[i] != '%' {
+			verbs = append(verbs, format[i])
+		}
+	}
+	return verbs, nil
+}
+
+// coerceForVerb converts numeric arguments to suit the formatting verb:
+// integer verbs receive an int64, a * width or precision an int, and %s
+// the shortest decimal form.
+func coerceForVerb(verb byte, arg any) any {
+	f, err := toFloat(arg)
+	if err != nil {
+		return arg
+	}
+	switch verb {
+	case '*':
+		return int(f)
+	case 'd', 'x', 'X', 'o', 'b', 'c':
+		return int64(f)
+	case 's', 'q':
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return f
+}
+
+// toString formats a value for string concatenation.
+func toString(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	if f, err := toFloat(v); err == nil {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
@@ -1,10 +1,42 @@
 // Package expr provides a simple expression DSL for cortex formulas.
 //
 // Supported operations:
-//   - Arithmetic: +, -, *, /, %
+//   - Arithmetic: +, -, *, /, // (integer division), %, ^ or ** (exponent)
 //   - Comparison: ==, !=, <, >, <=, >=
+//   - Membership: x in [1, 2, 3]
 //   - Logical: &&, ||, !
-//   - Functions: min, max, abs, floor, ceil, round, if, sqrt, pow
+//   - Conditional: cond ? a : b (binds looser than ||)
+//   - Functions: min, max, abs, floor, ceil, round, if, sqrt, pow, idiv
+//   - String functions: concat, sprintf, len, upper, lower, substr, contains
+//   - Time functions (opt-in via RegisterTimeFuncs): now, days_between, add_days
+//
+// Integer literals (5, but not 5.0, 1e3 or 15%) are ints, and +, -, *, %
+// and integer division keep integer operands as integers: 5 + 5 is the int
+// 10, and an int64 variable stays int64. Mixing in a float, dividing with
+// /, or overflowing produces a float64, so 10 / 4 is 2.5. Integer division
+// (a // b, or idiv(a, b)) returns the floored quotient, so 7 // 2 is the
+// int 3 and -7 // 2 is -4; with a float operand it is a whole-valued
+// float64. The % operator truncates like Go's math.Mod, so for
+// non-negative operands a == (a // b) * b + a % b. Apart from idiv, the
+// built-in functions that return numbers, len included, return float64,
+// so len("abc") is 3.0.
+//
+// The conditional operator and the if function evaluate only the branch
+// taken, so "qty > 0 ? total / qty : 0" and "if(qty == 0, 0, total / qty)"
+// do not fail when qty is zero.
+//
+// round(x, precision, mode) takes an optional rounding mode: "half_up"
+// (the default, halves away from zero), "half_even", "floor" or "ceil".
+// The default mode can be changed with SetRoundingMode.
+//
+// Division and modulo by zero fail by default. SetDivisionPolicy can make
+// them return IEEE results (+Inf, -Inf or NaN) or a fallback such as 0.
+//
+// Number literals accept a percent (15% == 0.15) or basis-point
+// (50bps == 0.005) suffix. A % written directly after a number is the
+// percent suffix unless an operand follows it, so 15% * salary scales
+// salary while 10 % 3 and 10%3 are modulo. A sign written directly before
+// an operand starts it, so 10%-3 is modulo too, while 15% - x subtracts.
 //
 // Example expressions:
 //
@@ -16,27 +48,130 @@ package expr
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 )
 
 // Expression represents a compiled expression.
 type Expression struct {
 	raw       string
-	ast       Node
+	parsed    Node                    // AST as written
+	folded    atomic.Pointer[folding] // AST evaluated, with constants folded
 	evaluator *Evaluator
 }
 
-// Compile parses and compiles an expression string.
+// folding is an AST folded under one generation of its evaluator.
+type folding struct {
+	ast Node
+	gen uint64
+}
+
+func newExpression(raw string, parsed Node, ev *Evaluator) *Expression {
+	e := &Expression{raw: raw, parsed: parsed, evaluator: ev}
+	e.folded.Store(&folding{ast: fold(parsed, ev), gen: ev.gen})
+	return e
+}
+
+// ast returns the folded AST, folding again if the evaluator's functions,
+// rounding mode or division policy changed since it was last folded.
+func (e *Expression) ast() Node {
+	f := e.folded.Load()
+	if gen := e.evaluator.gen; f.gen != gen {
+		f = &folding{ast: fold(e.parsed, e.evaluator), gen: gen}
+		e.folded.Store(f)
+	}
+	return f.ast
+}
+
+// Compile parses and compiles an expression string. Constant subtrees,
+// including calls to built-in functions with literal arguments, are
+// folded so they are not recomputed on every evaluation.
 func Compile(input string) (*Expression, error) {
-	ast, err := Parse(input)
+	return CompileWith(input, NewEvaluator())
+}
+
+// CompileWith compiles an expression that evaluates with ev, which may be
+// shared by many expressions so they use one function table. Functions,
+// rounding modes and division policies set on ev, including through any of
+// its expressions, apply to all of them; each expression folds its
+// constants again on its next evaluation after such a change. A nil ev is
+// the same as Compile.
+func CompileWith(input string, ev *Evaluator) (*Expression, error) {
+	if ev == nil {
+		ev = NewEvaluator()
+	}
+
+	parsed, err := Parse(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return newExpression(input, parsed, ev), nil
+}
+
+// ErrNotAllowed is returned by CompileWithPolicy for a function call or
+// variable outside its whitelist.
+var ErrNotAllowed = errors.New("expr: not allowed by policy")
+
+// CompileWithPolicy compiles an expression that may only call the
+// functions in allowedFuncs and read the variables in allowedVars, for
+// formulas written by untrusted users. Any other call or variable fails
+// compilation with a PosError wrapping ErrNotAllowed. Built-in functions
+// such as if and round must be listed to be allowed.
+func CompileWithPolicy(input string, allowedFuncs, allowedVars []string) (*Expression, error) {
+	parsed, err := Parse(input)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkPolicy(parsed, allowedFuncs, allowedVars); err != nil {
+		return nil, err
+	}
 
-	return &Expression{
-		raw:       input,
-		ast:       ast,
-		evaluator: NewEvaluator(),
-	}, nil
+	return newExpression(input, parsed, NewEvaluator()), nil
+}
+
+// checkPolicy returns an error for the first call or variable in n that
+// is not in the allowed lists.
+func checkPolicy(n Node, allowedFuncs, allowedVars []string) error {
+	switch n := n.(type) {
+	case *Ident:
+		if !slices.Contains(allowedVars, n.Name) {
+			return errorAt(n.Pos, fmt.Errorf("%w: variable '%s'", ErrNotAllowed, n.Name))
+		}
+	case *UnaryExpr:
+		return checkPolicy(n.Expr, allowedFuncs, allowedVars)
+	case *BinaryExpr:
+		if err := checkPolicy(n.Left, allowedFuncs, allowedVars); err != nil {
+			return err
+		}
+		return checkPolicy(n.Right, allowedFuncs, allowedVars)
+	case *ListLit:
+		for _, elem := range n.Elems {
+			if err := checkPolicy(elem, allowedFuncs, allowedVars); err != nil {
+				return err
+			}
+		}
+	case *CondExpr:
+		for _, sub := range []Node{n.Cond, n.Then, n.Else} {
+			if err := checkPolicy(sub, allowedFuncs, allowedVars); err != nil {
+				return err
+			}
+		}
+	case *CallExpr:
+		if !slices.Contains(allowedFuncs, n.Name) {
+			return errorAt(n.Pos, fmt.Errorf("%w: function '%s'", ErrNotAllowed, n.Name))
+		}
+		for _, arg := range n.Args {
+			if err := checkPolicy(arg, allowedFuncs, allowedVars); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 // MustCompile compiles an expression, panicking on error.
@@ -48,14 +183,93 @@ func MustCompile(input string) *Expression {
 	return e
 }
 
+// Evaluator returns the evaluator the expression evaluates with.
+func (e *Expression) Evaluator() *Evaluator {
+	return e.evaluator
+}
+
 // Raw returns the original expression string.
 func (e *Expression) Raw() string {
 	return e.raw
 }
 
+// Variables returns the distinct variable names the expression reads, in
+// order of first appearance. Function names are not included.
+func (e *Expression) Variables() []string {
+	seen := make(map[string]struct{})
+	var names []string
+	var walk func(n Node)
+	walk = func(n Node) {
+		switch n := n.(type) {
+		case *Ident:
+			if _, ok := seen[n.Name]; !ok {
+				seen[n.Name] = struct{}{}
+				names = append(names, n.Name)
+			}
+		case *UnaryExpr:
+			walk(n.Expr)
+		case *BinaryExpr:
+			walk(n.Left)
+			walk(n.Right)
+		case *ListLit:
+			for _, elem := range n.Elems {
+				walk(elem)
+			}
+		case *CondExpr:
+			walk(n.Cond)
+			walk(n.Then)
+			walk(n.Else)
+		case *CallExpr:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		}
+	}
+	walk(e.parsed)
+	return names
+}
+
+// Substitute returns the expression source with each variable replaced
+// by its current value, e.g. "x + y" becomes "3 + 4". Variables the
+// getter does not have are left as written.
+func (e *Expression) Substitute(getter ValueGetter) string {
+	var sb strings.Builder
+	last := 0
+	tokens := Tokenize(e.raw)
+	for i, tok := range tokens {
+		if tok.Type != TokenIdent {
+			continue
+		}
+		if i+1 < len(tokens) && tokens[i+1].Type == TokenLParen {
+			continue // function name
+		}
+		v, ok := getter.Get(tok.Literal)
+		if !ok {
+			continue
+		}
+		sb.WriteString(e.raw[last:tok.Pos])
+		sb.WriteString(formatValue(v))
+		last = tok.Pos + len(tok.Literal)
+	}
+	sb.WriteString(e.raw[last:])
+	return sb.String()
+}
+
+// formatValue renders a value as it would be written in an expression.
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
 // Eval evaluates the expression against a value getter.
 func (e *Expression) Eval(ctx context.Context, getter ValueGetter) (any, error) {
-	return e.evaluator.Eval(ctx, e.ast, getter)
+	return e.evaluator.Eval(ctx, e.ast(), getter)
 }
 
 // EvalFloat64 evaluates the expression and returns a float64.
@@ -80,7 +294,22 @@ func (e *Expression) EvalBool(ctx context.Context, getter ValueGetter) (bool, er
 	return b, nil
 }
 
+// SetRoundingMode sets the mode round uses when none is given.
+// Constants are folded again under the new mode.
+func (e *Expression) SetRoundingMode(mode RoundingMode) {
+	e.evaluator.SetRoundingMode(mode)
+}
+
+// SetDivisionPolicy sets what division and modulo by zero produce; see
+// Evaluator.SetDivisionPolicy. Constants are folded again under the new
+// policy.
+func (e *Expression) SetDivisionPolicy(policy DivisionPolicy, fallback float64) {
+	e.evaluator.SetDivisionPolicy(policy, fallback)
+}
+
 // RegisterFunc registers a custom function for this expression.
+// Constants are folded again so the function applies everywhere it is
+// called.
 func (e *Expression) RegisterFunc(name string, fn Func) {
 	e.evaluator.RegisterFunc(name, fn)
 }
@@ -2,7 +2,12 @@ package expr_test
 
 import (
 	"context"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/kolosys/cortex/expr"
 )
@@ -21,6 +26,12 @@ func TestLexer(t *testing.T) {
 		{"x != y", []expr.TokenType{expr.TokenIdent, expr.TokenNe, expr.TokenIdent, expr.TokenEOF}},
 		{"3.14", []expr.TokenType{expr.TokenNumber, expr.TokenEOF}},
 		{`"hello"`, []expr.TokenType{expr.TokenString, expr.TokenEOF}},
+		{"a // b", []expr.TokenType{expr.TokenIdent, expr.TokenIntDiv, expr.TokenIdent, expr.TokenEOF}},
+		{"15% * x", []expr.TokenType{expr.TokenNumber, expr.TokenStar, expr.TokenIdent, expr.TokenEOF}},
+		{"10%3", []expr.TokenType{expr.TokenNumber, expr.TokenPercent, expr.TokenNumber, expr.TokenEOF}},
+		{"10%-3", []expr.TokenType{expr.TokenNumber, expr.TokenPercent, expr.TokenMinus, expr.TokenNumber, expr.TokenEOF}},
+		{"15% - x", []expr.TokenType{expr.TokenNumber, expr.TokenMinus, expr.TokenIdent, expr.TokenEOF}},
+		{"50bps", []expr.TokenType{expr.TokenNumber, expr.TokenEOF}},
 	}
 
 	for _, tt := range tests {
@@ -70,12 +81,12 @@ func TestEval(t *testing.T) {
 		values   map[string]any
 		expected any
 	}{
-		{"1 + 2", nil, 3.0},
-		{"10 - 3", nil, 7.0},
-		{"4 * 5", nil, 20.0},
+		{"1 + 2", nil, 3},
+		{"10 - 3", nil, 7},
+		{"4 * 5", nil, 20},
 		{"20 / 4", nil, 5.0},
-		{"10 % 3", nil, 1.0},
-		{"-5", nil, -5.0},
+		{"10 % 3", nil, 1},
+		{"-5", nil, -5},
 		{"x + y", map[string]any{"x": 10.0, "y": 20.0}, 30.0},
 		{"x * y", map[string]any{"x": 3.0, "y": 4.0}, 12.0},
 		{"a + b * c", map[string]any{"a": 1.0, "b": 2.0, "c": 3.0}, 7.0},
@@ -152,10 +163,10 @@ func TestEvalIf(t *testing.T) {
 		values   map[string]any
 		expected any
 	}{
-		{"if(true, 1, 2)", nil, 1.0},
-		{"if(false, 1, 2)", nil, 2.0},
+		{"if(true, 1, 2)", nil, 1},
+		{"if(false, 1, 2)", nil, 2},
 		{"if(x > 10, x, 10)", map[string]any{"x": 15.0}, 15.0},
-		{"if(x > 10, x, 10)", map[string]any{"x": 5.0}, 10.0},
+		{"if(x > 10, x, 10)", map[string]any{"x": 5.0}, 10},
 	}
 
 	for _, tt := range tests {
@@ -200,6 +211,46 @@ func TestEvalErrors(t *testing.T) {
 	}
 }
 
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		pos      int
+	}{
+		{"x + 1", "undefined variable 'x' at position 0", 0},
+		{"a + b * missing", "undefined variable 'missing' at position 8", 8},
+		{"a / zero", "division by zero at position 2", 2},
+		{"a + foo(1)", "undefined function 'foo' at position 4", 4},
+		{"1 + (a ? 1 : 2)", "condition of ?: must be bool, got int at position 7", 7},
+		{`a + sqrt("x")`, "expected number, got string at position 4", 4},
+	}
+
+	values := map[string]any{"a": 1, "b": 2.0, "zero": 0.0}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := expr.MustCompile(tt.input).EvalWithMap(context.Background(), values)
+			if err == nil || err.Error() != tt.expected {
+				t.Fatalf("expected %q, got %v", tt.expected, err)
+			}
+			var posErr *expr.PosError
+			if !errors.As(err, &posErr) || posErr.Pos != tt.pos {
+				t.Errorf("expected PosError at %d, got %#v", tt.pos, err)
+			}
+		})
+	}
+
+	parseTests := map[string]string{
+		"(1 + 2":  "expected ')' at position 6",
+		"1 + * 2": "unexpected token: * at position 4",
+		"[1, 2":   "expected ']' at position 5",
+	}
+	for input, want := range parseTests {
+		if _, err := expr.Parse(input); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected error containing %q, got %v", input, want, err)
+		}
+	}
+}
+
 func TestParseErrors(t *testing.T) {
 	tests := []string{
 		"1 +",
@@ -245,9 +296,837 @@ func TestStringConcat(t *testing.T) {
 	}
 }
 
+func TestTimeFuncs(t *testing.T) {
+	fixed := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	hired := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	ctx := expr.WithNow(context.Background(), fixed)
+	values := map[string]any{"hired": hired, "start": "2024-01-01"}
+
+	tests := []struct {
+		expr     string
+		expected any
+	}{
+		{"now()", fixed},
+		{"days_between(hired, now())", 14.0},
+		{"days_between(start, hired)", 60.0},
+		{"add_days(hired, 14) == now()", true},
+		{"add_days(hired, 30)", time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{"hired < now()", true},
+		{"hired >= now()", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e := expr.MustCompile(tt.expr)
+			e.RegisterTimeFuncs()
+			result, err := e.EvalWithMap(ctx, values)
+			if err != nil {
+				t.Fatalf("eval error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestDateComparison(t *testing.T) {
+	values := map[string]any{
+		"hire_date":   time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC),
+		"cutoff_date": time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		"same_cutoff": time.Date(2023, 12, 31, 19, 0, 0, 0, time.FixedZone("EST", -5*3600)),
+		"salary":      50000.0,
+		"name":        "ada",
+	}
+
+	tests := []struct {
+		expr     string
+		expected bool
+	}{
+		{"hire_date < cutoff_date", true},
+		{"hire_date > cutoff_date", false},
+		{"cutoff_date <= same_cutoff", true},
+		{"cutoff_date >= same_cutoff", true},
+		{"cutoff_date == same_cutoff", true}, // same instant in another zone
+		{"hire_date == cutoff_date", false},
+		{"hire_date != cutoff_date", true},
+		{"salary > 40000", true},
+		{`name == "ada"`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			result, err := expr.MustCompile(tt.expr).EvalWithMap(context.Background(), values)
+			if err != nil {
+				t.Fatalf("eval error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+
+	for _, input := range []string{"hire_date < salary", "salary >= hire_date", "hire_date == salary", `name != hire_date`} {
+		_, err := expr.MustCompile(input).EvalWithMap(context.Background(), values)
+		if err == nil || !strings.Contains(err.Error(), "cannot compare") || !strings.Contains(err.Error(), "time.Time") {
+			t.Errorf("%s: expected a date/number comparison error, got %v", input, err)
+		}
+	}
+}
+
+func TestTimeFuncsOptIn(t *testing.T) {
+	e := expr.MustCompile("now()")
+	if _, err := e.EvalWithMap(context.Background(), nil); err == nil {
+		t.Error("expected now() to be undefined without RegisterTimeFuncs")
+	}
+}
+
+func TestStringInterpolation(t *testing.T) {
+	values := map[string]any{"region": "us", "tier": 2.0, "rate": 0.075, "count": 3}
+
+	tests := []struct {
+		expr     string
+		expected string
+	}{
+		{`sprintf("%s-%d", region, tier)`, "us-2"},
+		{`sprintf("%.2f%%", rate * 100)`, "7.50%"},
+		{`sprintf("%03d", count)`, "003"},
+		{`sprintf("tier %s", tier)`, "tier 2"},
+		{`sprintf("static")`, "static"},
+		{`sprintf("%*d|", 4, count)`, "   3|"},
+		{`sprintf("%-*s|%.*f", tier * 2, region, 1, rate * 100)`, "us  |7.5"},
+		{`concat(region, "-", tier, "-", rate)`, "us-2-0.075"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e := expr.MustCompile(tt.expr)
+			result, err := e.EvalWithMap(context.Background(), values)
+			if err != nil {
+				t.Fatalf("eval error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %q, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestSprintfArgMismatch(t *testing.T) {
+	for _, input := range []string{`sprintf("%s-%d", "us")`, `sprintf("%s", "a", "b")`, `sprintf(1)`, `sprintf("%*d", 3)`} {
+		t.Run(input, func(t *testing.T) {
+			_, err := expr.MustCompile(input).EvalWithMap(context.Background(), nil)
+			if err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	_, err := expr.MustCompile(`sprintf("%[2]s %[1]s", "a", "b")`).EvalWithMap(context.Background(), nil)
+	if err == nil || !strings.Contains(err.Error(), "argument indexes are not supported") {
+		t.Errorf("expected argument indexes to be rejected, got %v", err)
+	}
+}
+
+func TestIntegerDivision(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"7 // 2", 3},
+		{"-7 // 2", -4},
+		{"7 // -2", -4},
+		{"-7 // -2", 3},
+		{"7 // 2 == 3", true},
+		{"6 // 3", 2},
+		{"7 // 2 * 2 + 7 % 2", 7},
+		{"10 - 7 // 2", 7},
+		{"idiv(7, 2)", 3},
+		{"idiv(x, 4)", 2},
+		{"7.0 // 2", 3.0},
+		{"idiv(-7.5, 2)", -4.0},
+		{"big // 3", int64(3074457345618258602)},
+		{"-big // 2", int64(-4611686018427387904)},
+	}
+
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			e := expr.MustCompile(tt.input)
+			result, err := e.EvalWithMap(ctx, map[string]any{"x": 11, "big": int64(math.MaxInt64)})
+			if err != nil {
+				t.Fatalf("eval error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+
+	for _, input := range []string{"7 // 0", "idiv(7, 0)"} {
+		if _, err := expr.MustCompile(input).EvalWithMap(ctx, nil); err == nil {
+			t.Errorf("%s: expected division by zero error", input)
+		}
+	}
+}
+
+func TestPercentLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"15%", 0.15},
+		{"15% * salary", 7500.0},
+		{"salary * 15%", 7500.0},
+		{"2.5%", 0.025},
+		{"50bps", 0.005},
+		{"salary * 50bps", 250.0},
+		{"15% + 1", 1.15},
+		{"10 % 3", 1},
+		{"10%3", 1},
+		{"10 %(3)", 1},
+		{"10%-3", 1},
+		{"10 % -3", 1},
+		{"salary %-(7)", 6.0},
+		{"15% - 0.15", 0.0},
+		{"15% + -0.15", 0.0},
+		{"salary % 7", 6.0},
+		{"15% == 0.15", true},
+	}
+
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			e := expr.MustCompile(tt.input)
+			result, err := e.EvalWithMap(ctx, map[string]any{"salary": 50000.0})
+			if err != nil {
+				t.Fatalf("eval error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestSubstitute(t *testing.T) {
+	values := map[string]any{"x": 3.0, "name": "bob", "flag": true}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x + y", "3 + y"},
+		{"max(x, 10) * x", "max(3, 10) * 3"},
+		{`concat(name, "x")`, `concat("bob", "x")`},
+		{"flag && x>2", "true && 3>2"},
+	}
+
+	for _, tt := range tests {
+		got := expr.MustCompile(tt.input).Substitute(mapGetter(values))
+		if got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
 type mapGetter map[string]any
 
 func (m mapGetter) Get(key string) (any, bool) {
 	v, ok := m[key]
 	return v, ok
 }
+
+func TestConditionalOperator(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"x > 0 ? 100 / x : 0", 25.0},
+		{"y > 0 ? 100 / y : 0", 0},
+		{"true ? 1 : 2", 1},
+		{"false || x == 4 ? 1 : 2", 1},
+		{"x > 10 ? 1 : x > 3 ? 2 : 3", 2},
+		{"(x > 0 ? 1 : 2) + 10", 11},
+		{"1 + (y == 0 ? 2 : 3) * 2", 5},
+		{"y == 0 ? \"zero\" : \"nonzero\"", "zero"},
+	}
+
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := expr.MustCompile(tt.input).EvalWithMap(ctx, map[string]any{"x": 4.0, "y": 0.0})
+			if err != nil {
+				t.Fatalf("eval error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+
+	for _, input := range []string{"x ? 1", "x ? 1 2", "? 1 : 2", "1 : 2"} {
+		if _, err := expr.Compile(input); err == nil {
+			t.Errorf("%s: expected parse error", input)
+		}
+	}
+	if _, err := expr.MustCompile("1 ? 2 : 3").EvalWithMap(ctx, nil); err == nil {
+		t.Error("expected error for non-bool condition")
+	}
+}
+
+func TestIfIsLazy(t *testing.T) {
+	ctx := context.Background()
+	values := map[string]any{"qty": 0.0, "total": 50.0}
+
+	result, err := expr.MustCompile("if(qty == 0, 0, total / qty)").EvalWithMap(ctx, values)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if result != 0 {
+		t.Errorf("expected 0, got %v", result)
+	}
+
+	// The untaken branch may reference undefined variables.
+	result, err = expr.MustCompile("if(qty > 0, missing, total)").EvalWithMap(ctx, values)
+	if err != nil || result != 50.0 {
+		t.Errorf("expected 50, got %v (%v)", result, err)
+	}
+
+	for _, input := range []string{"if(true, 1)", "if(1, 2, 3)"} {
+		if _, err := expr.MustCompile(input).EvalWithMap(ctx, values); err == nil {
+			t.Errorf("%s: expected error", input)
+		}
+	}
+}
+
+func TestIfOverride(t *testing.T) {
+	e := expr.MustCompile("if(1, 2, 3)")
+	e.RegisterFunc("if", func(args ...any) (any, error) {
+		return len(args), nil
+	})
+	result, err := e.EvalWithMap(context.Background(), nil)
+	if err != nil || result != 3 {
+		t.Errorf("expected custom if to receive 3 args, got %v (%v)", result, err)
+	}
+}
+
+func TestStringFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`len(name)`, 5.0},
+		{`len("héllo")`, 5.0},
+		{`upper(region) == "EMEA"`, true},
+		{`lower("ABC")`, "abc"},
+		{`substr(name, 1, 3)`, "li"},
+		{`substr(name, 0, len(name))`, "Alice"},
+		{`contains(name, "lic")`, true},
+		{`contains(name, "bob")`, false},
+		{`concat(upper(region), "-", 7)`, "EMEA-7"},
+	}
+
+	ctx := context.Background()
+	values := map[string]any{"name": "Alice", "region": "emea"}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := expr.MustCompile(tt.input).EvalWithMap(ctx, values)
+			if err != nil {
+				t.Fatalf("eval error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+
+	for _, input := range []string{
+		`len(5)`, `upper(true)`, `lower()`, `contains("a")`, `contains("a", 1)`,
+		`substr(name, 2, 1)`, `substr(name, 0, 10)`, `substr(name, -1, 2)`, `substr(name, 0.5, 2)`,
+	} {
+		if _, err := expr.MustCompile(input).EvalWithMap(ctx, values); err == nil {
+			t.Errorf("%s: expected error", input)
+		}
+	}
+}
+
+func TestInOperator(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`x in [1, 2, 3]`, true},
+		{`x in [4, 5]`, false},
+		{`x in []`, false},
+		{`code in ["A", "B"]`, true},
+		{`code in ["a", "b"]`, false},
+		{`x + 1 in [3, 4]`, true},
+		{`x in [1, 2] && code in ["B"]`, true},
+		{`!(x in [x * 2, 7])`, true},
+		{`(x in [2]) == true`, true},
+		{`[1, 2] == [1, 2]`, true},
+		{`[1, 2] != [1, 2]`, false},
+		{`[1, 2] == [1, 2, 3]`, false},
+		{`[x, "B"] == [2, code]`, true},
+		{`x == [2]`, false},
+		{`[x] != x`, true},
+		{`[x] in [[1], [2]]`, true},
+		{`[3] in [[1], [2]]`, false},
+		{`x in [[2], 2]`, true},
+	}
+
+	ctx := context.Background()
+	values := map[string]any{"x": 2, "code": "B"}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := expr.MustCompile(tt.input).EvalWithMap(ctx, values)
+			if err != nil {
+				t.Fatalf("eval error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+
+	for _, input := range []string{`x in [1, 2`, `x in`, `[1, 2 3]`} {
+		if _, err := expr.Compile(input); err == nil {
+			t.Errorf("%s: expected parse error", input)
+		}
+	}
+	if _, err := expr.MustCompile(`x in 3`).EvalWithMap(ctx, values); err == nil {
+		t.Error("expected error for non-list right operand")
+	}
+	m := map[string]any{"m": map[string]any{}}
+	if _, err := expr.MustCompile(`m == m`).EvalWithMap(ctx, m); err == nil || !strings.Contains(err.Error(), "cannot compare") {
+		t.Errorf("expected error comparing maps, got %v", err)
+	}
+}
+
+func TestRoundingModes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{`round(2.5)`, 3},
+		{`round(3.5)`, 4},
+		{`round(2.5, 0, "half_up")`, 3},
+		{`round(3.5, 0, "half_up")`, 4},
+		{`round(-2.5, 0, "half_up")`, -3},
+		{`round(2.5, 0, "half_even")`, 2},
+		{`round(3.5, 0, "half_even")`, 4},
+		{`round(0.125, 2, "half_even")`, 0.12},
+		{`round(2.7, 0, "floor")`, 2},
+		{`round(-2.1, 0, "floor")`, -3},
+		{`round(2.11, 1, "ceil")`, 2.2},
+	}
+
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := expr.MustCompile(tt.input).EvalFloat64(ctx, mapGetter(nil))
+			if err != nil {
+				t.Fatalf("eval error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+
+	for _, input := range []string{`round(2.5, 0, "up")`, `round(2.5, 0, 1)`, `round(1, 2, "floor", 4)`} {
+		if _, err := expr.MustCompile(input).EvalWithMap(ctx, nil); err == nil {
+			t.Errorf("%s: expected error", input)
+		}
+	}
+}
+
+func TestDefaultRoundingMode(t *testing.T) {
+	e := expr.MustCompile("round(x)")
+	e.SetRoundingMode(expr.RoundHalfEven)
+
+	ctx := context.Background()
+	for x, want := range map[float64]float64{2.5: 2, 3.5: 4} {
+		result, err := e.EvalFloat64(ctx, mapGetter{"x": x})
+		if err != nil {
+			t.Fatalf("eval error: %v", err)
+		}
+		if result != want {
+			t.Errorf("round(%v) = %v, want %v", x, result, want)
+		}
+	}
+
+	// An explicit mode overrides the default.
+	result, _ := expr.MustCompile(`round(2.5, 0, "half_up")`).EvalFloat64(ctx, mapGetter(nil))
+	if result != 3 {
+		t.Errorf("expected 3, got %v", result)
+	}
+	if mode, err := expr.ParseRoundingMode("half_even"); err != nil || mode != expr.RoundHalfEven || mode.String() != "half_even" {
+		t.Errorf("ParseRoundingMode: got %v, %v", mode, err)
+	}
+}
+
+func TestExponentOperator(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"2 ^ 3", 8},
+		{"2 ** 3", 8},
+		{"2 ^ 3 ^ 2", 512},
+		{"(2 ^ 3) ^ 2", 64},
+		{"2 * 3 ^ 2", 18},
+		{"-2 ^ 2", -4},
+		{"(-2) ^ 2", 4},
+		{"2 ^ -1", 0.5},
+		{"4 ^ 0.5", 2},
+		{"1000 * (1 + 1) ^ 2", 4000},
+		{"x ** 2 + 1", 10},
+		{"pow(2, 3)", 8},
+	}
+
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := expr.MustCompile(tt.input).EvalFloat64(ctx, mapGetter{"x": 3.0})
+			if err != nil {
+				t.Fatalf("eval error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestVariables(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"1 + 2", nil},
+		{"x + y * x", []string{"x", "y"}},
+		{"round(total * rate, 2)", []string{"total", "rate"}},
+		{"if(a > 0, b, -c)", []string{"a", "b", "c"}},
+		{"flag ? hi : lo", []string{"flag", "hi", "lo"}},
+		{"code in [a, \"b\"]", []string{"code", "a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got := expr.MustCompile(tt.input).Variables()
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestExpressionString(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a+b*c", "a + b * c"},
+		{"(a+b)*c", "(a + b) * c"},
+		{"a-(b-c)", "a - (b - c)"},
+		{"(a-b)-c", "a - b - c"},
+		{"a/(b*c)", "a / (b * c)"},
+		{"2^3^2", "2 ^ 3 ^ 2"},
+		{"(2^3)^2", "(2 ^ 3) ^ 2"},
+		{"-x^2", "-x ^ 2"},
+		{"(-x)^2", "(-x) ^ 2"},
+		{"-(a+b)", "-(a + b)"},
+		{"!(a && b) || c", "!(a && b) || c"},
+		{"a || b && c", "a || b && c"},
+		{"(a || b) && c", "(a || b) && c"},
+		{"x>0?1:(y?2:3)", "x > 0 ? 1 : y ? 2 : 3"},
+		{"(x?1:2)+3", "(x ? 1 : 2) + 3"},
+		{"round(total*1.5,2)", "round(total * 1.5, 2)"},
+		{"code in ['a',\"b\"]", `code in ["a", "b"]`},
+		{"15%", "0.15"},
+		{"x * 2.0 + 100%", "x * 2.0 + 1.0"},
+		{"x == true", "x == true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got := expr.MustCompile(tt.input).String()
+			if got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+			// The canonical form parses back to itself.
+			if again := expr.MustCompile(got).String(); again != got {
+				t.Errorf("round trip: %q became %q", got, again)
+			}
+		})
+	}
+}
+
+func TestConstantFolding(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"x * (2 + 3)", 20.0},
+		{"round(x * 0.0825, 2)", 0.33},
+		{"x + max(1, 2) * sqrt(16)", 12.0},
+		{"upper(\"ab\") == \"AB\" ? x : 0", 4.0},
+		{"if(1 > 2, 1 / 0, x)", 4.0},
+		{"false && x > 0", false},
+		{"x in [1 + 3, 5]", true},
+	}
+
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			e := expr.MustCompile(tt.input)
+			result, err := e.EvalWithMap(ctx, map[string]any{"x": 4.0})
+			if err != nil {
+				t.Fatalf("eval error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+			// Folding does not change the printed form.
+			if again := expr.MustCompile(e.String()).String(); again != e.String() {
+				t.Errorf("String changed by folding: %q vs %q", e.String(), again)
+			}
+		})
+	}
+}
+
+func TestConstantFoldingErrorsAtEval(t *testing.T) {
+	// A constant subexpression that fails still fails at evaluation, not compile.
+	e, err := expr.Compile("x + 1 / 0")
+	if err != nil {
+		t.Fatalf("unexpected compile error: %v", err)
+	}
+	if _, err := e.EvalWithMap(context.Background(), map[string]any{"x": 1.0}); err == nil {
+		t.Error("expected division by zero at evaluation")
+	}
+}
+
+func TestConstantFoldingRespectsOverrides(t *testing.T) {
+	e := expr.MustCompile("min(1, 2) + round(2.5)")
+	e.RegisterFunc("min", func(args ...any) (any, error) { return 10.0, nil })
+	e.SetRoundingMode(expr.RoundHalfEven)
+
+	result, err := e.EvalFloat64(context.Background(), mapGetter(nil))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if result != 12 {
+		t.Errorf("expected 12 from the overridden min and half-even round, got %v", result)
+	}
+}
+
+func TestConstantFoldingSkipsCustomBuiltinNames(t *testing.T) {
+	calls := 0
+	ev := expr.NewEvaluator()
+	ev.RegisterFunc("round", func(args ...any) (any, error) {
+		calls++
+		return float64(calls), nil
+	})
+	e, err := expr.CompileWith("round(2.5) + 0", ev)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+
+	ctx := context.Background()
+	for want := 1.0; want <= 2; want++ {
+		if v, err := e.EvalFloat64(ctx, mapGetter(nil)); err != nil || v != want {
+			t.Errorf("expected the custom round to run on every evaluation, got %v (%v)", v, err)
+		}
+	}
+}
+
+func TestCompileWithSharedEvaluator(t *testing.T) {
+	ev := expr.NewEvaluator()
+	ev.RegisterFunc("double", func(args ...any) (any, error) {
+		f, _ := args[0].(float64)
+		return f * 2, nil
+	})
+
+	a, err := expr.CompileWith("double(x)", ev)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	b := expr.MustCompile("x + 1")
+	c, err := expr.CompileWith("double(x) + min(x, 1)", ev)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+
+	ctx := context.Background()
+	values := map[string]any{"x": 3.0}
+	if v, _ := a.EvalWithMap(ctx, values); v != 6.0 {
+		t.Errorf("expected 6, got %v", v)
+	}
+	if v, _ := c.EvalWithMap(ctx, values); v != 7.0 {
+		t.Errorf("expected 7, got %v", v)
+	}
+
+	// Functions registered through one expression apply to all sharing ev.
+	a.RegisterFunc("triple", func(args ...any) (any, error) {
+		f, _ := args[0].(float64)
+		return f * 3, nil
+	})
+	d, _ := expr.CompileWith("triple(x)", ev)
+	if v, err := d.EvalWithMap(ctx, values); err != nil || v != 9.0 {
+		t.Errorf("expected 9, got %v (%v)", v, err)
+	}
+
+	// Expressions compiled without ev are unaffected.
+	if _, err := b.EvalWithMap(ctx, values); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := expr.MustCompile("double(x)").EvalWithMap(ctx, values); err == nil {
+		t.Error("expected undefined function without shared evaluator")
+	}
+
+	if e, err := expr.CompileWith("1 + 1", nil); err != nil || e == nil {
+		t.Errorf("expected nil evaluator to compile, got %v", err)
+	}
+}
+
+func TestSharedEvaluatorRefolds(t *testing.T) {
+	ev := expr.NewEvaluator()
+	a, _ := expr.CompileWith("round(2.5) + x", ev)
+	b, _ := expr.CompileWith("min(1, 2) + round(0.5)", ev)
+
+	ctx := context.Background()
+	values := map[string]any{"x": 0.0}
+	if v, _ := a.EvalFloat64(ctx, mapGetter(values)); v != 3 {
+		t.Fatalf("expected 3 before the change, got %v", v)
+	}
+
+	// A change made through a keeps b's folded constants current too.
+	a.SetRoundingMode(expr.RoundHalfEven)
+	a.RegisterFunc("min", func(args ...any) (any, error) { return 10.0, nil })
+	if v, _ := a.EvalFloat64(ctx, mapGetter(values)); v != 2 {
+		t.Errorf("expected 2 under half-even, got %v", v)
+	}
+	if v, _ := b.EvalFloat64(ctx, mapGetter(values)); v != 10 {
+		t.Errorf("expected 10 from the overridden min and half-even round, got %v", v)
+	}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	values := map[string]any{"n": 7, "big": int64(1) << 40, "f": 2.0}
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"5 + 5", 10},
+		{"10 / 4", 2.5},
+		{"10 % 3", 1},
+		{"n * 3 - 1", 20},
+		{"-n", -7},
+		{"big + 1", int64(1)<<40 + 1},
+		{"n + big", int64(1)<<40 + 7},
+		{"n * f", 14.0},
+		{"5 + 5.0", 10.0},
+		{"n + 1%", 7.01},
+		{"9223372036854775807 * 2", 1.8446744073709552e19},
+	}
+
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := expr.MustCompile(tt.input).EvalWithMap(ctx, values)
+			if err != nil {
+				t.Fatalf("eval error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %v (%T), got %v (%T)", tt.expected, tt.expected, result, result)
+			}
+		})
+	}
+}
+
+func TestDivisionPolicy(t *testing.T) {
+	ctx := context.Background()
+	values := map[string]any{"x": 10.0, "zero": 0.0}
+	inputs := []string{"x / zero", "x // zero", "x % zero", "idiv(x, zero)", "7 % 0", "0 / zero"}
+
+	for _, input := range inputs {
+		if _, err := expr.MustCompile(input).EvalWithMap(ctx, values); err == nil {
+			t.Errorf("%s: expected error by default", input)
+		}
+	}
+
+	for _, input := range inputs {
+		e := expr.MustCompile(input)
+		e.SetDivisionPolicy(expr.DivisionFallback, 0)
+		result, err := e.EvalWithMap(ctx, values)
+		if err != nil || result != 0.0 {
+			t.Errorf("%s: expected fallback 0, got %v (%v)", input, result, err)
+		}
+	}
+
+	ieee := map[string]float64{"x / zero": math.Inf(1), "-x // zero": math.Inf(-1)}
+	for input, want := range ieee {
+		e := expr.MustCompile(input)
+		e.SetDivisionPolicy(expr.DivisionIEEE, 0)
+		if result, err := e.EvalWithMap(ctx, values); err != nil || result != want {
+			t.Errorf("%s: expected %v, got %v (%v)", input, want, result, err)
+		}
+	}
+	for _, input := range []string{"0 / zero", "x % zero"} {
+		e := expr.MustCompile(input)
+		e.SetDivisionPolicy(expr.DivisionIEEE, 0)
+		if result, err := e.EvalFloat64(ctx, mapGetter(values)); err != nil || !math.IsNaN(result) {
+			t.Errorf("%s: expected NaN, got %v (%v)", input, result, err)
+		}
+	}
+
+	// The policy applies to constants folded at compile time.
+	ev := expr.NewEvaluator()
+	ev.SetDivisionPolicy(expr.DivisionFallback, -1)
+	e, err := expr.CompileWith("1 / 0 + 1", ev)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	if result, err := e.EvalWithMap(ctx, nil); err != nil || result != 0.0 {
+		t.Errorf("expected 0, got %v (%v)", result, err)
+	}
+}
+
+func TestCompileWithPolicy(t *testing.T) {
+	funcs := []string{"min", "round"}
+	vars := []string{"price", "qty"}
+
+	e, err := expr.CompileWithPolicy("round(min(price * qty, 100), 2)", funcs, vars)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := e.EvalWithMap(context.Background(), map[string]any{"price": 12.5, "qty": 3})
+	if err != nil || got != 37.5 {
+		t.Errorf("expected 37.5, got %v (%v)", got, err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"price * secret", "expr: not allowed by policy: variable 'secret' at position 8"},
+		{"max(price, qty)", "expr: not allowed by policy: function 'max' at position 0"},
+		{"min(price, sqrt(qty))", "expr: not allowed by policy: function 'sqrt' at position 11"},
+		{"qty > 0 ? price : fallback", "expr: not allowed by policy: variable 'fallback' at position 18"},
+		{"qty in [1, limit]", "expr: not allowed by policy: variable 'limit' at position 11"},
+		{"if(qty > 0, price, 0)", "expr: not allowed by policy: function 'if' at position 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := expr.CompileWithPolicy(tt.input, funcs, vars)
+			if !errors.Is(err, expr.ErrNotAllowed) || err.Error() != tt.expected {
+				t.Errorf("expected %q, got %v", tt.expected, err)
+			}
+		})
+	}
+
+	if _, err := expr.CompileWithPolicy("1 +", funcs, vars); err == nil || errors.Is(err, expr.ErrNotAllowed) {
+		t.Errorf("expected a syntax error, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package expr
+
+import "context"
+
+// pureFuncs lists the built-in functions whose result depends only on
+// their arguments, so calls with literal arguments can be folded.
+var pureFuncs = map[string]bool{
+	"min": true, "max": true, "abs": true, "floor": true, "ceil": true,
+	"round": true, "sqrt": true, "pow": true, "idiv": true,
+	"concat": true, "sprintf": true, "len": true, "upper": true,
+	"lower": true, "substr": true, "contains": true,
+}
+
+// fold returns a copy of n with subtrees that have only literal operands
+// collapsed into a single literal, evaluated with ev. A subtree whose
+// evaluation fails, such as 1 / 0, is left as is so the error surfaces at
+// evaluation time. Calls are folded only for the builtins in pureFuncs, not
+// for functions registered on ev under the same names.
+func fold(n Node, ev *Evaluator) Node {
+	switch n := n.(type) {
+	case *UnaryExpr:
+		out := &UnaryExpr{Op: n.Op, Expr: fold(n.Expr, ev), Pos: n.Pos}
+		if isLiteral(out.Expr) {
+			return foldNode(out, ev)
+		}
+		return out
+
+	case *BinaryExpr:
+		out := &BinaryExpr{Op: n.Op, Left: fold(n.Left, ev), Right: fold(n.Right, ev), Pos: n.Pos}
+		if isLiteral(out.Left) && isLiteral(out.Right) {
+			return foldNode(out, ev)
+		}
+		return out
+
+	case *CondExpr:
+		cond := fold(n.Cond, ev)
+		if b, ok := cond.(*BoolLit); ok {
+			if b.Value {
+				return fold(n.Then, ev)
+			}
+			return fold(n.Else, ev)
+		}
+		return &CondExpr{Cond: cond, Then: fold(n.Then, ev), Else: fold(n.Else, ev), Pos: n.Pos}
+
+	case *ListLit:
+		return &ListLit{Elems: foldAll(n.Elems, ev), Pos: n.Pos}
+
+	case *CallExpr:
+		out := &CallExpr{Name: n.Name, Args: foldAll(n.Args, ev), Pos: n.Pos}
+		if out.Name == "if" && len(out.Args) == 3 {
+			if b, ok := out.Args[0].(*BoolLit); ok {
+				if b.Value {
+					return out.Args[1]
+				}
+				return out.Args[2]
+			}
+		}
+		if !pureFuncs[out.Name] || ev.custom[out.Name] {
+			return out
+		}
+		for _, arg := range out.Args {
+			if !isLiteral(arg) {
+				return out
+			}
+		}
+		return foldNode(out, ev)
+
+	default:
+		return n
+	}
+}
+
+func foldAll(nodes []Node, ev *Evaluator) []Node {
+	if nodes == nil {
+		return nil
+	}
+	out := make([]Node, len(nodes))
+	for i, n := range nodes {
+		out[i] = fold(n, ev)
+	}
+	return out
+}
+
+// foldNode evaluates a constant node, returning it unchanged if
+// evaluation fails or the result has no literal form.
+func foldNode(n Node, ev *Evaluator) Node {
+	v, err := ev.eval(context.Background(), n, nil)
+	if err != nil {
+		return n
+	}
+	switch v := v.(type) {
+	case float64:
+		return &NumberLit{Value: v}
+	case int:
+		if int64(v) <= -1<<53 || int64(v) >= 1<<53 {
+			return n // not exactly representable in NumberLit.Value
+		}
+		return &NumberLit{Value: float64(v), Int: true}
+	case string:
+		return &StringLit{Value: v}
+	case bool:
+		return &BoolLit{Value: v}
+	default:
+		return n
+	}
+}
+
+func isLiteral(n Node) bool {
+	switch n.(type) {
+	case *NumberLit, *StringLit, *BoolLit:
+		return true
+	default:
+		return false
+	}
+}
@@ -1,6 +1,7 @@
 package expr
 
 import (
+	"strings"
 	"unicode"
 )
 
@@ -57,10 +58,23 @@ func (l *Lexer) NextToken() Token {
 		tok = Token{Type: TokenMinus, Literal: "-", Pos: pos}
 		l.readChar()
 	case '*':
-		tok = Token{Type: TokenStar, Literal: "*", Pos: pos}
+		if l.peekChar() == '*' {
+			l.readChar()
+			tok = Token{Type: TokenPow, Literal: "**", Pos: pos}
+		} else {
+			tok = Token{Type: TokenStar, Literal: "*", Pos: pos}
+		}
+		l.readChar()
+	case '^':
+		tok = Token{Type: TokenPow, Literal: "^", Pos: pos}
 		l.readChar()
 	case '/':
-		tok = Token{Type: TokenSlash, Literal: "/", Pos: pos}
+		if l.peekChar() == '/' {
+			l.readChar()
+			tok = Token{Type: TokenIntDiv, Literal: "//", Pos: pos}
+		} else {
+			tok = Token{Type: TokenSlash, Literal: "/", Pos: pos}
+		}
 		l.readChar()
 	case '%':
 		tok = Token{Type: TokenPercent, Literal: "%", Pos: pos}
@@ -74,6 +88,18 @@ func (l *Lexer) NextToken() Token {
 	case ',':
 		tok = Token{Type: TokenComma, Literal: ",", Pos: pos}
 		l.readChar()
+	case '[':
+		tok = Token{Type: TokenLBracket, Literal: "[", Pos: pos}
+		l.readChar()
+	case ']':
+		tok = Token{Type: TokenRBracket, Literal: "]", Pos: pos}
+		l.readChar()
+	case '?':
+		tok = Token{Type: TokenQuestion, Literal: "?", Pos: pos}
+		l.readChar()
+	case ':':
+		tok = Token{Type: TokenColon, Literal: ":", Pos: pos}
+		l.readChar()
 	case '=':
 		if l.peekChar() == '=' {
 			l.readChar()
@@ -155,7 +181,45 @@ func (l *Lexer) readNumber() Token {
 		l.readChar()
 	}
 
-	return Token{Type: TokenNumber, Literal: l.input[start : l.pos-1]}
+	literal := l.input[start : l.pos-1]
+
+	// Percent and basis-point suffixes scale the literal: 15% is 0.15 and
+	// 50bps is 0.005. A '%' followed by an operand is the modulo operator.
+	switch {
+	case l.ch == '%' && !l.operandAt(l.pos):
+		l.readChar()
+		literal += "e-2"
+	case strings.HasPrefix(l.input[l.pos-1:], "bps") && !isIdentChar(l.charAt(l.pos+2)):
+		l.readChar()
+		l.readChar()
+		l.readChar()
+		literal += "e-4"
+	}
+
+	return Token{Type: TokenNumber, Literal: literal}
+}
+
+// charAt returns the input byte at i, or 0 past the end.
+func (l *Lexer) charAt(i int) rune {
+	if i >= len(l.input) {
+		return 0
+	}
+	return rune(l.input[i])
+}
+
+// operandAt reports whether the next non-space input from i starts an
+// operand. A sign directly followed by a number, name or '(' is a unary
+// operator starting one, so 10%-3 is modulo while 15% - x is not.
+func (l *Lexer) operandAt(i int) bool {
+	for i < len(l.input) && strings.ContainsRune(" \t\n\r", rune(l.input[i])) {
+		i++
+	}
+	ch := l.charAt(i)
+	if ch == '-' || ch == '+' {
+		next := l.charAt(i + 1)
+		return isDigit(next) || isLetter(next) || next == '(' || next == '.'
+	}
+	return isDigit(ch) || isLetter(ch) || ch == '(' || ch == '.' || ch == '"' || ch == '\''
 }
 
 func (l *Lexer) readIdentifier() Token {
@@ -171,6 +235,9 @@ func (l *Lexer) readIdentifier() Token {
 	if literal == "true" || literal == "false" {
 		return Token{Type: TokenBool, Literal: literal}
 	}
+	if literal == "in" {
+		return Token{Type: TokenIn, Literal: literal}
+	}
 
 	return Token{Type: TokenIdent, Literal: literal}
 }
@@ -201,6 +268,10 @@ func isLetter(ch rune) bool {
 	return unicode.IsLetter(ch) || ch == '_'
 }
 
+func isIdentChar(ch rune) bool {
+	return isLetter(ch) || isDigit(ch)
+}
+
 // Tokenize returns all tokens from the input.
 func Tokenize(input string) []Token {
 	l := NewLexer(input)
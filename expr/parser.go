@@ -26,8 +26,9 @@ func (p *Parser) advance() {
 	p.peek = p.lexer.NextToken()
 }
 
+// addError records an error at the current token's position.
 func (p *Parser) addError(msg string) {
-	p.errors = append(p.errors, msg)
+	p.errors = append(p.errors, fmt.Sprintf("%s at position %d", msg, p.current.Pos))
 }
 
 // Errors returns any parsing errors.
@@ -53,25 +54,31 @@ func (p *Parser) Parse() (Node, error) {
 // Precedence levels
 const (
 	precLowest  = 0
-	precOr      = 1
-	precAnd     = 2
-	precCompare = 3
-	precSum     = 4
-	precProduct = 5
+	precCond    = 1
+	precOr      = 2
+	precAnd     = 3
+	precCompare = 4
+	precSum     = 5
+	precProduct = 6
+	precPower   = 7
 )
 
 func precedence(t TokenType) int {
 	switch t {
+	case TokenQuestion:
+		return precCond
 	case TokenOr:
 		return precOr
 	case TokenAnd:
 		return precAnd
-	case TokenEq, TokenNe, TokenLt, TokenLe, TokenGt, TokenGe:
+	case TokenEq, TokenNe, TokenLt, TokenLe, TokenGt, TokenGe, TokenIn:
 		return precCompare
 	case TokenPlus, TokenMinus:
 		return precSum
-	case TokenStar, TokenSlash, TokenPercent:
+	case TokenStar, TokenSlash, TokenIntDiv, TokenPercent:
 		return precProduct
+	case TokenPow:
+		return precPower
 	default:
 		return precLowest
 	}
@@ -81,21 +88,43 @@ func (p *Parser) parseExpression(prec int) Node {
 	left := p.parseUnary()
 
 	for prec < precedence(p.current.Type) {
-		op := p.current.Type
+		if p.current.Type == TokenQuestion {
+			left = p.parseCond(left)
+			continue
+		}
+		op, pos := p.current.Type, p.current.Pos
 		opPrec := precedence(op)
+		if op == TokenPow {
+			opPrec-- // right-associative: 2 ^ 3 ^ 2 is 2 ^ (3 ^ 2)
+		}
 		p.advance()
 		right := p.parseExpression(opPrec)
-		left = &BinaryExpr{Op: op, Left: left, Right: right}
+		left = &BinaryExpr{Op: op, Left: left, Right: right, Pos: pos}
 	}
 
 	return left
 }
 
+// parseCond parses the branches of cond ? then : else. It is
+// right-associative, so a ? b : c ? d : e groups as a ? b : (c ? d : e).
+func (p *Parser) parseCond(cond Node) Node {
+	pos := p.current.Pos
+	p.advance() // consume '?'
+	then := p.parseExpression(precLowest)
+	if p.current.Type != TokenColon {
+		p.addError("expected ':'")
+		return nil
+	}
+	p.advance()
+	return &CondExpr{Cond: cond, Then: then, Else: p.parseExpression(precLowest), Pos: pos}
+}
+
 func (p *Parser) parseUnary() Node {
 	if p.current.Type == TokenNot || p.current.Type == TokenMinus {
-		op := p.current.Type
+		op, pos := p.current.Type, p.current.Pos
 		p.advance()
-		return &UnaryExpr{Op: op, Expr: p.parseUnary()}
+		// Exponents bind tighter than unary operators: -2 ^ 2 is -(2 ^ 2).
+		return &UnaryExpr{Op: op, Expr: p.parseExpression(precProduct), Pos: pos}
 	}
 	return p.parsePrimary()
 }
@@ -103,6 +132,10 @@ func (p *Parser) parseUnary() Node {
 func (p *Parser) parsePrimary() Node {
 	switch p.current.Type {
 	case TokenNumber:
+		if n, err := strconv.ParseInt(p.current.Literal, 10, strconv.IntSize); err == nil && n < 1<<53 {
+			p.advance()
+			return &NumberLit{Value: float64(n), Int: true}
+		}
 		val, err := strconv.ParseFloat(p.current.Literal, 64)
 		if err != nil {
 			p.addError(fmt.Sprintf("invalid number: %s", p.current.Literal))
@@ -122,15 +155,18 @@ func (p *Parser) parsePrimary() Node {
 		return &BoolLit{Value: val}
 
 	case TokenIdent:
-		name := p.current.Literal
+		name, pos := p.current.Literal, p.current.Pos
 		p.advance()
 
 		// Check if it's a function call
 		if p.current.Type == TokenLParen {
-			return p.parseCall(name)
+			return p.parseCall(name, pos)
 		}
 
-		return &Ident{Name: name}
+		return &Ident{Name: name, Pos: pos}
+
+	case TokenLBracket:
+		return p.parseList()
 
 	case TokenLParen:
 		p.advance()
@@ -148,7 +184,7 @@ func (p *Parser) parsePrimary() Node {
 	}
 }
 
-func (p *Parser) parseCall(name string) Node {
+func (p *Parser) parseCall(name string, pos int) Node {
 	p.advance() // consume '('
 
 	var args []Node
@@ -168,7 +204,31 @@ func (p *Parser) parseCall(name string) Node {
 	}
 	p.advance()
 
-	return &CallExpr{Name: name, Args: args}
+	return &CallExpr{Name: name, Args: args, Pos: pos}
+}
+
+func (p *Parser) parseList() Node {
+	pos := p.current.Pos
+	p.advance() // consume '['
+
+	var elems []Node
+
+	if p.current.Type != TokenRBracket {
+		elems = append(elems, p.parseExpression(precLowest))
+
+		for p.current.Type == TokenComma {
+			p.advance()
+			elems = append(elems, p.parseExpression(precLowest))
+		}
+	}
+
+	if p.current.Type != TokenRBracket {
+		p.addError("expected ']'")
+		return nil
+	}
+	p.advance()
+
+	return &ListLit{Elems: elems, Pos: pos}
 }
 
 // Parse parses an expression string into an AST.
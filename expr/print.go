@@ -0,0 +1,105 @@
+package expr
+
+import (
+	"strconv"
+	"strings"
+)
+
+// String renders the expression in canonical form: operators spaced,
+// numbers normalized, and parentheses only where precedence requires
+// them. Compile("(a+b)*c").String() is "(a + b) * c".
+func (e *Expression) String() string {
+	var sb strings.Builder
+	writeNode(&sb, e.parsed)
+	return sb.String()
+}
+
+// nodePrec returns the binding strength of a node when it appears as an
+// operand. Unary operators bind like ^: they take a power as operand
+// (-x ^ 2 is -(x ^ 2)) but are parenthesized as its base.
+func nodePrec(n Node) int {
+	switch n := n.(type) {
+	case *BinaryExpr:
+		return precedence(n.Op)
+	case *CondExpr:
+		return precCond
+	case *UnaryExpr:
+		return precPower
+	default:
+		return precPower + 1
+	}
+}
+
+// writeOperand writes n, parenthesized if it binds looser than prec, or
+// equally loosely when tight is set.
+func writeOperand(sb *strings.Builder, n Node, prec int, tight bool) {
+	p := nodePrec(n)
+	if p < prec || (tight && p == prec) {
+		sb.WriteByte('(')
+		writeNode(sb, n)
+		sb.WriteByte(')')
+		return
+	}
+	writeNode(sb, n)
+}
+
+func writeNode(sb *strings.Builder, node Node) {
+	switch n := node.(type) {
+	case *NumberLit:
+		s := strconv.FormatFloat(n.Value, 'f', -1, 64)
+		if !n.Int && !strings.Contains(s, ".") {
+			s += ".0" // keep whole floats from reading back as ints
+		}
+		sb.WriteString(s)
+
+	case *StringLit:
+		quote := `"`
+		if strings.Contains(n.Value, quote) {
+			quote = "'"
+		}
+		sb.WriteString(quote + n.Value + quote)
+
+	case *BoolLit:
+		sb.WriteString(strconv.FormatBool(n.Value))
+
+	case *Ident:
+		sb.WriteString(n.Name)
+
+	case *UnaryExpr:
+		sb.WriteString(n.Op.String())
+		writeOperand(sb, n.Expr, precPower, false)
+
+	case *BinaryExpr:
+		prec := precedence(n.Op)
+		rightAssoc := n.Op == TokenPow
+		writeOperand(sb, n.Left, prec, rightAssoc)
+		sb.WriteString(" " + n.Op.String() + " ")
+		writeOperand(sb, n.Right, prec, !rightAssoc)
+
+	case *CondExpr:
+		writeOperand(sb, n.Cond, precCond, true)
+		sb.WriteString(" ? ")
+		writeNode(sb, n.Then)
+		sb.WriteString(" : ")
+		writeNode(sb, n.Else)
+
+	case *ListLit:
+		sb.WriteByte('[')
+		writeList(sb, n.Elems)
+		sb.WriteByte(']')
+
+	case *CallExpr:
+		sb.WriteString(n.Name + "(")
+		writeList(sb, n.Args)
+		sb.WriteByte(')')
+	}
+}
+
+func writeList(sb *strings.Builder, nodes []Node) {
+	for i, n := range nodes {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		writeNode(sb, n)
+	}
+}
@@ -0,0 +1,105 @@
+package expr
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type nowKey struct{}
+
+// WithNow returns a context whose now() function returns t.
+// This makes time-dependent expressions deterministic in tests.
+func WithNow(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, nowKey{}, t)
+}
+
+// nowFrom returns the time injected via WithNow, or the current time.
+func nowFrom(ctx context.Context) time.Time {
+	if t, ok := ctx.Value(nowKey{}).(time.Time); ok {
+		return t
+	}
+	return time.Now()
+}
+
+// RegisterTimeFuncs registers the time functions now, days_between and
+// add_days. They are not part of the default built-ins.
+func (e *Evaluator) RegisterTimeFuncs() {
+	e.RegisterContextFunc("now", funcNow)
+	e.RegisterFunc("days_between", funcDaysBetween)
+	e.RegisterFunc("add_days", funcAddDays)
+}
+
+// RegisterTimeFuncs registers the time functions for this expression.
+func (e *Expression) RegisterTimeFuncs() {
+	e.evaluator.RegisterTimeFuncs()
+}
+
+func funcNow(ctx context.Context, args ...any) (any, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("now takes no arguments")
+	}
+	return nowFrom(ctx), nil
+}
+
+func funcDaysBetween(args ...any) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("days_between requires 2 arguments")
+	}
+	from, err := toTime(args[0])
+	if err != nil {
+		return nil, err
+	}
+	to, err := toTime(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return to.Sub(from).Hours() / 24, nil
+}
+
+func funcAddDays(args ...any) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("add_days requires 2 arguments")
+	}
+	t, err := toTime(args[0])
+	if err != nil {
+		return nil, err
+	}
+	n, err := toFloat(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return t.AddDate(0, 0, int(n)), nil
+}
+
+// toTime converts a time.Time or an RFC 3339 / YYYY-MM-DD string to time.Time.
+func toTime(v any) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case string:
+		if parsed, err := time.Parse(time.RFC3339, t); err == nil {
+			return parsed, nil
+		}
+		if parsed, err := time.Parse(time.DateOnly, t); err == nil {
+			return parsed, nil
+		}
+		return time.Time{}, fmt.Errorf("invalid time: %q", t)
+	default:
+		return time.Time{}, fmt.Errorf("expected time, got %T", v)
+	}
+}
+
+func compareTimes(op TokenType, l, r time.Time) bool {
+	switch op {
+	case TokenLt:
+		return l.Before(r)
+	case TokenLe:
+		return !l.After(r)
+	case TokenGt:
+		return l.After(r)
+	case TokenGe:
+		return !l.Before(r)
+	}
+	return false
+}
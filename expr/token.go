@@ -14,25 +14,32 @@ const (
 	TokenBool
 
 	// Operators
-	TokenPlus    // +
-	TokenMinus   // -
-	TokenStar    // *
-	TokenSlash   // /
-	TokenPercent // %
-	TokenEq      // ==
-	TokenNe      // !=
-	TokenLt      // <
-	TokenLe      // <=
-	TokenGt      // >
-	TokenGe      // >=
-	TokenAnd     // &&
-	TokenOr      // ||
-	TokenNot     // !
+	TokenPlus     // +
+	TokenMinus    // -
+	TokenStar     // *
+	TokenSlash    // /
+	TokenIntDiv   // //
+	TokenPercent  // %
+	TokenPow      // ^ or **
+	TokenEq       // ==
+	TokenNe       // !=
+	TokenLt       // <
+	TokenLe       // <=
+	TokenGt       // >
+	TokenGe       // >=
+	TokenAnd      // &&
+	TokenOr       // ||
+	TokenNot      // !
+	TokenIn       // in
+	TokenQuestion // ?
+	TokenColon    // :
 
 	// Delimiters
-	TokenLParen // (
-	TokenRParen // )
-	TokenComma  // ,
+	TokenLParen   // (
+	TokenRParen   // )
+	TokenComma    // ,
+	TokenLBracket // [
+	TokenRBracket // ]
 )
 
 func (t TokenType) String() string {
@@ -57,8 +64,12 @@ func (t TokenType) String() string {
 		return "*"
 	case TokenSlash:
 		return "/"
+	case TokenIntDiv:
+		return "//"
 	case TokenPercent:
 		return "%"
+	case TokenPow:
+		return "^"
 	case TokenEq:
 		return "=="
 	case TokenNe:
@@ -77,12 +88,22 @@ func (t TokenType) String() string {
 		return "||"
 	case TokenNot:
 		return "!"
+	case TokenIn:
+		return "in"
+	case TokenQuestion:
+		return "?"
+	case TokenColon:
+		return ":"
 	case TokenLParen:
 		return "("
 	case TokenRParen:
 		return ")"
 	case TokenComma:
 		return ","
+	case TokenLBracket:
+		return "["
+	case TokenRBracket:
+		return "]"
 	default:
 		return "UNKNOWN"
 	}
@@ -3,6 +3,7 @@ package cortex
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/kolosys/cortex/expr"
 )
@@ -18,6 +19,8 @@ type FormulaRule struct {
 	formula      FormulaFunc
 	expression   string           // for config-driven rules
 	compiledExpr *expr.Expression // compiled expression
+	compileTime  time.Duration    // time spent compiling expression
+	resultType   ValueType
 }
 
 // FormulaConfig configures a formula rule.
@@ -26,11 +29,14 @@ type FormulaConfig struct {
 	Name        string
 	Description string
 	Deps        []string
+	When        string // guard expression; the rule is skipped when it is false
 
 	// Target is the context key to store the result.
 	Target string
 
 	// Inputs are the required input keys (for dependency tracking).
+	// If empty for an expression formula, the expression's variables
+	// are used.
 	Inputs []string
 
 	// Formula is the Go function for complex rules.
@@ -39,6 +45,15 @@ type FormulaConfig struct {
 	// Expression is the expression string for config-driven rules.
 	// (Mutually exclusive with Formula)
 	Expression string
+
+	// ResultType, if set, converts the result before it is stored.
+	ResultType ValueType
+
+	// Evaluator, if set, compiles Expression with expr.CompileWith so it
+	// shares one function table with other formulas. Use Engine.Evaluator
+	// for the engine's, which has its expression functions, time functions
+	// and division policy.
+	Evaluator *expr.Evaluator
 }
 
 // NewFormula creates a new formula rule.
@@ -52,28 +67,47 @@ func NewFormula(cfg FormulaConfig) (*FormulaRule, error) {
 	if cfg.Formula == nil && cfg.Expression == "" {
 		return nil, fmt.Errorf("%w: formula rule %q requires formula or expression", ErrInvalidRule, cfg.ID)
 	}
+	if !cfg.ResultType.valid() {
+		return nil, fmt.Errorf("%w: formula rule %q has unknown result type %q", ErrInvalidRule, cfg.ID, string(cfg.ResultType))
+	}
 
 	var compiledExpr *expr.Expression
+	var compileTime time.Duration
 	if cfg.Expression != "" && cfg.Formula == nil {
 		var err error
-		compiledExpr, err = expr.Compile(cfg.Expression)
+		start := time.Now()
+		compiledExpr, err = expr.CompileWith(cfg.Expression, cfg.Evaluator)
+		compileTime = time.Since(start)
 		if err != nil {
 			return nil, fmt.Errorf("%w: formula rule %q expression error: %v", ErrInvalidExpression, cfg.ID, err)
 		}
 	}
 
+	inputs := cfg.Inputs
+	if len(inputs) == 0 && compiledExpr != nil {
+		inputs = compiledExpr.Variables()
+	}
+
+	when, err := compileWhen(cfg.ID, cfg.When)
+	if err != nil {
+		return nil, err
+	}
+
 	return &FormulaRule{
 		baseRule: baseRule{
 			id:          cfg.ID,
 			name:        cfg.Name,
 			description: cfg.Description,
 			deps:        cfg.Deps,
+			when:        when,
 		},
 		target:       cfg.Target,
-		inputs:       cfg.Inputs,
+		inputs:       inputs,
 		formula:      cfg.Formula,
 		expression:   cfg.Expression,
 		compiledExpr: compiledExpr,
+		compileTime:  compileTime,
+		resultType:   cfg.ResultType,
 	}, nil
 }
 
@@ -94,17 +128,29 @@ func (r *FormulaRule) Evaluate(ctx context.Context, evalCtx *EvalContext) error
 	if r.formula != nil {
 		result, err = r.formula(ctx, evalCtx)
 	} else if r.compiledExpr != nil {
-		result, err = r.compiledExpr.Eval(ctx, evalCtx)
+		result, err = r.compiledExpr.Eval(evalCtx.exprContext(ctx), evalCtx)
 	} else {
 		return NewRuleError(r.id, string(RuleTypeFormula), "evaluate",
 			fmt.Errorf("no formula or expression configured"))
 	}
 
+	if err == nil {
+		result, err = r.resultType.Convert(result)
+	}
 	if err != nil {
 		return NewRuleError(r.id, string(RuleTypeFormula), "evaluate", err)
 	}
 
+	// Capture inputs before setting, in case the target is also an input.
+	var explanation string
+	if r.formula == nil && evalCtx.explaining() {
+		explanation = fmt.Sprintf("%s = %s = %v", r.expression, r.compiledExpr.Substitute(evalCtx), result)
+	}
+
 	evalCtx.Set(r.target, result)
+	if explanation != "" {
+		evalCtx.setExplanation(r.target, explanation)
+	}
 	return nil
 }
 
@@ -123,6 +169,12 @@ func (r *FormulaRule) Expression() string {
 	return r.expression
 }
 
+// CompileDuration returns how long compiling the expression took, or 0
+// for rules without an expression.
+func (r *FormulaRule) CompileDuration() time.Duration {
+	return r.compileTime
+}
+
 // SetFormulaFunc sets the formula function (used by expression parser).
 func (r *FormulaRule) SetFormulaFunc(fn FormulaFunc) {
 	r.formula = fn
@@ -217,3 +269,33 @@ func Conditional(condition string, thenVal, elseVal any) FormulaFunc {
 		return elseVal, nil
 	}
 }
+
+// ShareOfRunningTotal returns a formula rule, with ID and target
+// targetKey, that sets targetKey to the source value divided by the
+// buildup's current value: the item's share of the total accumulated so
+// far, not of the final total. The result depends on rule order, so the
+// buildup rule that adds the item must run first; list it in Deps of any
+// rule that reads the share if rules may be reordered. It panics if
+// targetKey is empty.
+func ShareOfRunningTotal(sourceKey, buildupName, targetKey string) *FormulaRule {
+	return MustFormula(FormulaConfig{
+		ID:     targetKey,
+		Target: targetKey,
+		Inputs: []string{sourceKey},
+		Formula: func(ctx context.Context, evalCtx *EvalContext) (any, error) {
+			v, err := evalCtx.GetFloat64(sourceKey)
+			if err != nil {
+				return nil, err
+			}
+			b, ok := evalCtx.GetBuildup(buildupName)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrBuildupNotFound, buildupName)
+			}
+			total := b.Current()
+			if total == 0 {
+				return nil, ErrDivisionByZero
+			}
+			return v / total, nil
+		},
+	})
+}
@@ -3,9 +3,11 @@ package cortex_test
 import (
 	"context"
 	"errors"
+	"reflect"
 	"testing"
 
 	"github.com/kolosys/cortex"
+	"github.com/kolosys/cortex/expr"
 )
 
 func TestFormulaWithFunction(t *testing.T) {
@@ -135,6 +137,18 @@ func TestFormulaInputs(t *testing.T) {
 	}
 }
 
+func TestFormulaInputsFromExpression(t *testing.T) {
+	rule := cortex.MustFormula(cortex.FormulaConfig{
+		ID:         "calc",
+		Target:     "result",
+		Expression: "round(x * rate + x, 2)",
+	})
+
+	if inputs := rule.Inputs(); !reflect.DeepEqual(inputs, []string{"x", "rate"}) {
+		t.Errorf("expected inputs [x rate], got %v", inputs)
+	}
+}
+
 func TestFormulaHelperAdd(t *testing.T) {
 	fn := cortex.Add("a", "b")
 
@@ -244,3 +258,60 @@ func TestFormulaHelperConditional(t *testing.T) {
 		t.Errorf("expected 0.10 for non-senior, got %v", result)
 	}
 }
+
+func TestShareOfRunningTotal(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	err := engine.AddRules(
+		cortex.MustBuildup(cortex.BuildupConfig{ID: "add_a", Buildup: "total", Operation: cortex.BuildupSum, Source: "a"}),
+		cortex.ShareOfRunningTotal("a", "total", "share_a"),
+		cortex.MustBuildup(cortex.BuildupConfig{ID: "add_b", Buildup: "total", Operation: cortex.BuildupSum, Source: "b"}),
+		cortex.ShareOfRunningTotal("b", "total", "share_b"),
+		cortex.MustBuildup(cortex.BuildupConfig{ID: "add_c", Buildup: "total", Operation: cortex.BuildupSum, Source: "c"}),
+		cortex.ShareOfRunningTotal("c", "total", "share_c"),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.SetAll(map[string]any{"a": 20.0, "b": 60.0, "c": 20.0})
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// each share is of the total so far: 20/20, 60/80, 20/100
+	for key, want := range map[string]float64{"share_a": 1, "share_b": 0.75, "share_c": 0.2} {
+		if got, _ := evalCtx.GetFloat64(key); got != want {
+			t.Errorf("expected %s=%v, got %v", key, want, got)
+		}
+	}
+
+	rule := cortex.ShareOfRunningTotal("a", "missing", "share")
+	if err := rule.Evaluate(context.Background(), evalCtx); !errors.Is(err, cortex.ErrBuildupNotFound) {
+		t.Errorf("expected ErrBuildupNotFound, got %v", err)
+	}
+}
+
+func TestFormulaDivisionByZeroConfig(t *testing.T) {
+	cfg := cortex.DefaultConfig()
+	cfg.DivisionByZero = expr.DivisionFallback
+	cfg.DivisionFallback = 0
+
+	engine := cortex.New("test", cfg)
+	engine.AddRules(
+		cortex.MustFormula(cortex.FormulaConfig{ID: "avg", Target: "avg", Expression: "total / qty"}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "rem", Target: "rem", Expression: "total % qty", When: "total / qty == 0"}),
+	)
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("total", 50.0)
+	evalCtx.Set("qty", 0.0)
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("evaluate error: %v", err)
+	}
+	for _, key := range []string{"avg", "rem"} {
+		if v, err := evalCtx.GetFloat64(key); err != nil || v != 0 {
+			t.Errorf("%s: expected 0, got %v (%v)", key, v, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package cortex
+
+import (
+	"slices"
+
+	"github.com/kolosys/cortex/expr"
+)
+
+// RegisterExprFunc makes fn callable as name from every expression in the
+// engine: formula expressions, When guards and Config.StopWhen, for rules
+// already added and rules added later. A name that collides with a builtin
+// such as round or min replaces the builtin for this engine's expressions;
+// registering the same name again replaces the earlier function.
+//
+// Register functions before evaluating: functions are not swapped
+// atomically with in-flight evaluations. The function is registered on the
+// engine's rules themselves, so a rule added to several engines would get
+// every engine's functions; see AddRule.
+func (e *Engine) RegisterExprFunc(name string, fn expr.Func) error {
+	if e.closed.Load() {
+		return ErrEngineClosed
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.exprFuncs == nil {
+		e.exprFuncs = make(map[string]expr.Func)
+	}
+	e.exprFuncs[name] = fn
+	e.exprEval.RegisterFunc(name, fn)
+	for _, rule := range e.rules {
+		for _, ex := range e.ownExpressions(rule) {
+			ex.RegisterFunc(name, fn)
+		}
+	}
+	return nil
+}
+
+// configureExpressions applies the engine's division policy, time
+// functions and expression functions to rule. It changes the evaluators of
+// rule's own expressions, which is why rules are not shared between
+// engines. The caller must hold e.mu.
+func (e *Engine) configureExpressions(rule Rule) {
+	if len(e.exprFuncs) == 0 && e.config.DivisionByZero == expr.DivisionError && !e.config.TimeFuncs {
+		return
+	}
+	exprs := e.ownExpressions(rule)
+	if e.config.TimeFuncs {
+		for _, ex := range exprs {
+			ex.RegisterTimeFuncs()
+		}
+	}
+	if e.config.DivisionByZero != expr.DivisionError {
+		for _, ex := range exprs {
+			ex.SetDivisionPolicy(e.config.DivisionByZero, e.config.DivisionFallback)
+		}
+	}
+	for _, name := range sortedKeys(e.exprFuncs) {
+		for _, ex := range exprs {
+			ex.RegisterFunc(name, e.exprFuncs[name])
+		}
+	}
+}
+
+// ownExpressions returns the expressions of rule that do not use the
+// engine's evaluator and so must be configured one by one.
+func (e *Engine) ownExpressions(rule Rule) []*expr.Expression {
+	return slices.DeleteFunc(ruleExpressions(rule), func(ex *expr.Expression) bool {
+		return ex.Evaluator() == e.exprEval
+	})
+}
+
+// ruleExpressions returns every compiled expression a rule evaluates,
+// including its When guard.
+func ruleExpressions(rule Rule) []*expr.Expression {
+	var exprs []*expr.Expression
+	if er, ok := rule.(expressionRule); ok {
+		exprs = er.expressions()
+	}
+	if guard := ruleGuard(rule); guard != nil {
+		exprs = append(exprs, guard)
+	}
+	return exprs
+}
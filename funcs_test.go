@@ -0,0 +1,117 @@
+package cortex_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/kolosys/cortex"
+)
+
+func double(args ...any) (any, error) {
+	return args[0].(float64) * 2, nil
+}
+
+func TestRegisterExprFunc(t *testing.T) {
+	engine := cortex.New("test", nil)
+	engine.AddRule(cortex.MustFormula(cortex.FormulaConfig{
+		ID: "before", Target: "a", Expression: "double(x)",
+	}))
+	if err := engine.RegisterExprFunc("double", double); err != nil {
+		t.Fatalf("register error: %v", err)
+	}
+	engine.AddRule(cortex.MustFormula(cortex.FormulaConfig{
+		ID: "after", Target: "b", Expression: "double(a) + 1", When: "double(x) > 5",
+	}))
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("x", 3.0)
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("evaluate error: %v", err)
+	}
+	if a, _ := evalCtx.GetFloat64("a"); a != 6 {
+		t.Errorf("expected a=6, got %v", a)
+	}
+	if b, _ := evalCtx.GetFloat64("b"); b != 13 {
+		t.Errorf("expected b=13, got %v", b)
+	}
+}
+
+func TestRegisterExprFuncOverridesBuiltin(t *testing.T) {
+	engine := cortex.New("test", nil)
+	engine.AddRule(cortex.MustFormula(cortex.FormulaConfig{
+		ID: "r", Target: "r", Expression: "abs(-2.5)",
+	}))
+	engine.RegisterExprFunc("abs", double)
+
+	evalCtx := cortex.NewEvalContext()
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("evaluate error: %v", err)
+	}
+	if r, _ := evalCtx.GetFloat64("r"); r != -5 {
+		t.Errorf("expected the registered abs to win, got %v", r)
+	}
+}
+
+func TestEngineEvaluatorShared(t *testing.T) {
+	engine := cortex.New("test", nil)
+	for _, cfg := range []cortex.FormulaConfig{
+		{ID: "a", Target: "a", Expression: "double(x)"},
+		{ID: "b", Target: "b", Expression: "double(a) + double(1.5)", Deps: []string{"a"}},
+	} {
+		cfg.Evaluator = engine.Evaluator()
+		engine.AddRule(cortex.MustFormula(cfg))
+	}
+	engine.AddRule(cortex.MustVectorFormula(cortex.VectorFormulaConfig{
+		ID: "v", Target: "v", Inputs: []string{"xs"}, Expression: "double(xs)", Evaluator: engine.Evaluator(),
+	}))
+
+	// One registration on the shared evaluator reaches every formula.
+	if err := engine.RegisterExprFunc("double", double); err != nil {
+		t.Fatalf("register error: %v", err)
+	}
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("x", 3.0)
+	evalCtx.Set("xs", []float64{1, 2})
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("evaluate error: %v", err)
+	}
+	if a, _ := evalCtx.GetFloat64("a"); a != 6 {
+		t.Errorf("expected a=6, got %v", a)
+	}
+	if b, _ := evalCtx.GetFloat64("b"); b != 15 {
+		t.Errorf("expected b=15, got %v", b)
+	}
+	if v, _ := evalCtx.Get("v"); !reflect.DeepEqual(v, []float64{2, 4}) {
+		t.Errorf("expected v=[2 4], got %v", v)
+	}
+}
+
+func TestEngineCloneKeepsExprFuncs(t *testing.T) {
+	config := cortex.DefaultConfig()
+	config.StopWhen = "double(a) > 10"
+	engine := cortex.New("test", config)
+	if err := engine.RegisterExprFunc("double", double); err != nil {
+		t.Fatalf("register error: %v", err)
+	}
+
+	clone := engine.Clone("clone")
+	clone.AddRules(
+		cortex.MustFormula(cortex.FormulaConfig{ID: "a", Target: "a", Expression: "double(x)"}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "b", Target: "b", Expression: "a + 1", Deps: []string{"a"}}),
+	)
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("x", 3.0)
+	result, err := clone.Evaluate(context.Background(), evalCtx)
+	if err != nil {
+		t.Fatalf("evaluate error: %v", err)
+	}
+	if a, _ := evalCtx.GetFloat64("a"); a != 6 {
+		t.Errorf("expected a=6, got %v", a)
+	}
+	if !result.Success || !evalCtx.IsHalted() || evalCtx.Has("b") {
+		t.Errorf("expected the stop condition to end evaluation after a, got success=%v b=%v", result.Success, evalCtx.Has("b"))
+	}
+}
@@ -0,0 +1,88 @@
+package cortex
+
+import "fmt"
+
+// SetRuleGroup puts the rule with the given ID in group, replacing any
+// group it was in; "" removes it from its group. A rule belongs to at
+// most one group.
+func (e *Engine) SetRuleGroup(id, group string) error {
+	if e.closed.Load() {
+		return ErrEngineClosed
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.ruleIDs[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrRuleNotFound, id)
+	}
+	if group == "" {
+		delete(e.groups, id)
+	} else {
+		if e.groups == nil {
+			e.groups = make(map[string]string)
+		}
+		e.groups[id] = group
+	}
+	e.rebuildGroupSkippedLocked()
+	return nil
+}
+
+// RuleGroup returns the group of the rule with the given ID, or "" if it
+// is in none.
+func (e *Engine) RuleGroup(id string) string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.groups[id]
+}
+
+// SetGroupEnabled enables or disables every rule in group, including
+// rules put in it later. Rules in a disabled group are skipped during
+// evaluation and counted in Result.RulesSkipped. A group can be disabled
+// before any rule is in it. Enabling a group does not enable rules
+// disabled with SetRuleEnabled.
+func (e *Engine) SetGroupEnabled(group string, enabled bool) error {
+	if e.closed.Load() {
+		return ErrEngineClosed
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, off := e.groupsOff[group]; off == !enabled {
+		return nil
+	}
+	if enabled {
+		delete(e.groupsOff, group)
+	} else {
+		if e.groupsOff == nil {
+			e.groupsOff = make(map[string]struct{})
+		}
+		e.groupsOff[group] = struct{}{}
+	}
+	e.rebuildGroupSkippedLocked()
+	return nil
+}
+
+// GroupEnabled reports whether group is enabled.
+func (e *Engine) GroupEnabled(group string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	_, off := e.groupsOff[group]
+	return !off
+}
+
+// rebuildGroupSkippedLocked replaces the set of rules skipped because
+// their group is disabled. The caller must hold e.mu.
+func (e *Engine) rebuildGroupSkippedLocked() {
+	var next map[string]struct{}
+	for id, group := range e.groups {
+		if _, off := e.groupsOff[group]; off {
+			if next == nil {
+				next = make(map[string]struct{})
+			}
+			next[id] = struct{}{}
+		}
+	}
+	e.groupSkipped = next
+}
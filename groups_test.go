@@ -0,0 +1,85 @@
+package cortex_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kolosys/cortex"
+)
+
+func TestEngineSetGroupEnabled(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "base", Target: "base", Value: 100.0}),
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "promo", Target: "promo", Value: 10.0}),
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "loyalty", Target: "loyalty", Value: 5.0}),
+	)
+	engine.SetRuleGroup("promo", "experimental_discounts")
+	engine.SetRuleGroup("loyalty", "experimental_discounts")
+
+	if err := engine.SetGroupEnabled("experimental_discounts", false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if engine.GroupEnabled("experimental_discounts") || !engine.GroupEnabled("other") {
+		t.Error("expected only experimental_discounts to be disabled")
+	}
+	if got := engine.RuleGroup("promo"); got != "experimental_discounts" {
+		t.Errorf("expected promo in experimental_discounts, got %q", got)
+	}
+
+	evalCtx := cortex.NewEvalContext()
+	result, err := engine.Evaluate(context.Background(), evalCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !evalCtx.Has("base") || evalCtx.Has("promo") || evalCtx.Has("loyalty") {
+		t.Errorf("expected only the group's rules to be skipped, got keys %v", evalCtx.Keys())
+	}
+	if result.RulesEvaluated != 1 || result.RulesSkipped != 2 {
+		t.Errorf("expected 1 evaluated and 2 skipped, got %d and %d", result.RulesEvaluated, result.RulesSkipped)
+	}
+
+	// moving a rule out of the group re-enables it
+	engine.SetRuleGroup("loyalty", "")
+	evalCtx = cortex.NewEvalContext()
+	engine.Evaluate(context.Background(), evalCtx)
+	if evalCtx.Has("promo") || !evalCtx.Has("loyalty") {
+		t.Errorf("expected loyalty to run outside the group, got keys %v", evalCtx.Keys())
+	}
+
+	engine.SetGroupEnabled("experimental_discounts", true)
+	evalCtx = cortex.NewEvalContext()
+	result, _ = engine.Evaluate(context.Background(), evalCtx)
+	if !evalCtx.Has("promo") || result.RulesSkipped != 0 {
+		t.Errorf("expected re-enabled group to run, got keys %v", evalCtx.Keys())
+	}
+
+	if err := engine.SetRuleGroup("missing", "g"); !errors.Is(err, cortex.ErrRuleNotFound) {
+		t.Errorf("expected ErrRuleNotFound, got %v", err)
+	}
+}
+
+func TestEngineSetGroupEnabledSingleRule(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRule(cortex.MustAssignment(cortex.AssignmentConfig{ID: "x", Target: "x", Value: 1.0}))
+	engine.SetGroupEnabled("beta", false)
+	engine.SetRuleGroup("x", "beta")
+
+	evalCtx := cortex.NewEvalContext()
+	result, err := engine.Evaluate(context.Background(), evalCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if evalCtx.Has("x") || result.RulesSkipped != 1 {
+		t.Errorf("expected rule in disabled group to be skipped, got %+v", result)
+	}
+
+	engine.RemoveRule("x")
+	engine.AddRule(cortex.MustAssignment(cortex.AssignmentConfig{ID: "x", Target: "x", Value: 1.0}))
+	evalCtx = cortex.NewEvalContext()
+	engine.Evaluate(context.Background(), evalCtx)
+	if !evalCtx.Has("x") {
+		t.Error("expected a removed rule to lose its group")
+	}
+}
@@ -0,0 +1,55 @@
+package cortex
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// IDGenerator produces IDs for evaluation contexts and results.
+// Implementations must be safe for concurrent use.
+type IDGenerator interface {
+	NewID() string
+}
+
+// IDGeneratorFunc adapts a function to an IDGenerator.
+type IDGeneratorFunc func() string
+
+// NewID calls f.
+func (f IDGeneratorFunc) NewID() string {
+	return f()
+}
+
+// DefaultIDGenerator generates IDs of the form "eval-<unixnano>-<counter>".
+var DefaultIDGenerator IDGenerator = IDGeneratorFunc(defaultID)
+
+var idCounter atomic.Uint64
+
+func defaultID() string {
+	return fmt.Sprintf("eval-%d-%d", time.Now().UnixNano(), idCounter.Add(1))
+}
+
+type idGeneratorHolder struct{ g IDGenerator }
+
+var idGenerator atomic.Pointer[idGeneratorHolder]
+
+// SetIDGenerator replaces the generator used for new context and result
+// IDs and returns the previous one. Passing nil restores
+// DefaultIDGenerator.
+func SetIDGenerator(g IDGenerator) IDGenerator {
+	if g == nil {
+		g = DefaultIDGenerator
+	}
+	prev := idGenerator.Swap(&idGeneratorHolder{g: g})
+	if prev == nil {
+		return DefaultIDGenerator
+	}
+	return prev.g
+}
+
+func generateID() string {
+	if h := idGenerator.Load(); h != nil {
+		return h.g.NewID()
+	}
+	return DefaultIDGenerator.NewID()
+}
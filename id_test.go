@@ -0,0 +1,60 @@
+package cortex_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kolosys/cortex"
+)
+
+type sequenceGenerator struct {
+	n atomic.Int64
+}
+
+func (g *sequenceGenerator) NewID() string {
+	return fmt.Sprintf("id-%d", g.n.Add(1))
+}
+
+func TestSetIDGenerator(t *testing.T) {
+	prev := cortex.SetIDGenerator(&sequenceGenerator{})
+	defer cortex.SetIDGenerator(prev)
+
+	first := cortex.NewEvalContext()
+	second := first.Clone()
+	if first.ID != "id-1" || second.ID != "id-2" {
+		t.Fatalf("expected id-1 and id-2, got %q and %q", first.ID, second.ID)
+	}
+
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRule(cortex.MustAssignment(cortex.AssignmentConfig{ID: "x", Target: "x", Value: 1.0}))
+
+	result, err := engine.Evaluate(context.Background(), first)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.ID != "id-1" {
+		t.Errorf("expected result ID id-1, got %q", result.ID)
+	}
+}
+
+func TestSetIDGeneratorFunc(t *testing.T) {
+	prev := cortex.SetIDGenerator(cortex.IDGeneratorFunc(func() string { return "fixed" }))
+	defer cortex.SetIDGenerator(prev)
+
+	if id := cortex.NewEvalContext().ID; id != "fixed" {
+		t.Errorf("expected fixed, got %q", id)
+	}
+}
+
+func TestSetIDGeneratorNilRestoresDefault(t *testing.T) {
+	prev := cortex.SetIDGenerator(cortex.IDGeneratorFunc(func() string { return "fixed" }))
+	defer cortex.SetIDGenerator(prev)
+
+	cortex.SetIDGenerator(nil)
+	if id := cortex.NewEvalContext().ID; !strings.HasPrefix(id, "eval-") {
+		t.Errorf("expected default ID, got %q", id)
+	}
+}
@@ -0,0 +1,31 @@
+package cortex
+
+// Key is a typed handle to a context key, giving compile-time checked
+// access to well-known values:
+//
+//	var Tax = cortex.NewKey[float64]("tax")
+//
+//	Tax.Set(evalCtx, 120.5)
+//	tax, ok := Tax.Get(evalCtx)
+type Key[T any] struct {
+	name string
+}
+
+// NewKey creates a typed key for the named context value.
+func NewKey[T any](name string) Key[T] {
+	return Key[T]{name: name}
+}
+
+// Name returns the context key name.
+func (k Key[T]) Name() string { return k.name }
+
+// Get retrieves the value; it returns false if the key is missing or
+// holds a value of another type.
+func (k Key[T]) Get(evalCtx *EvalContext) (T, bool) {
+	return GetTyped[T](evalCtx, k.name)
+}
+
+// Set stores the value.
+func (k Key[T]) Set(evalCtx *EvalContext, value T) {
+	SetTyped(evalCtx, k.name, value)
+}
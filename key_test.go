@@ -0,0 +1,51 @@
+package cortex_test
+
+import (
+	"testing"
+
+	"github.com/kolosys/cortex"
+)
+
+func TestTypedKeys(t *testing.T) {
+	var (
+		tax    = cortex.NewKey[float64]("tax")
+		region = cortex.NewKey[string]("region")
+		exempt = cortex.NewKey[bool]("exempt")
+	)
+
+	evalCtx := cortex.NewEvalContext()
+	tax.Set(evalCtx, 120.5)
+	region.Set(evalCtx, "west")
+	exempt.Set(evalCtx, true)
+
+	if v, ok := tax.Get(evalCtx); !ok || v != 120.5 {
+		t.Errorf("expected tax=120.5, got %v (%v)", v, ok)
+	}
+	if v, ok := region.Get(evalCtx); !ok || v != "west" {
+		t.Errorf("expected region=west, got %v (%v)", v, ok)
+	}
+	if v, ok := exempt.Get(evalCtx); !ok || !v {
+		t.Errorf("expected exempt=true, got %v (%v)", v, ok)
+	}
+
+	// Typed keys share storage with the string API.
+	if v, _ := evalCtx.GetFloat64("tax"); v != 120.5 {
+		t.Errorf("expected string access to see 120.5, got %v", v)
+	}
+	if tax.Name() != "tax" {
+		t.Errorf("expected name 'tax', got %q", tax.Name())
+	}
+}
+
+func TestTypedKeyMismatch(t *testing.T) {
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("count", "three")
+
+	count := cortex.NewKey[float64]("count")
+	if _, ok := count.Get(evalCtx); ok {
+		t.Error("expected type mismatch to report false")
+	}
+	if _, ok := cortex.NewKey[bool]("missing").Get(evalCtx); ok {
+		t.Error("expected missing key to report false")
+	}
+}
@@ -4,6 +4,10 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // Lookup represents a lookup table.
@@ -17,8 +21,10 @@ type Lookup interface {
 
 // MapLookup provides a simple map-based lookup implementation.
 type MapLookup[K comparable, V any] struct {
-	name  string
-	items map[K]V
+	name        string
+	items       map[K]V
+	catchAll    K
+	hasCatchAll bool
 }
 
 // NewMapLookup creates a new map-based lookup table.
@@ -33,15 +39,29 @@ func NewMapLookup[K comparable, V any](name string, items map[K]V) *MapLookup[K,
 	}
 }
 
+// WithCatchAll designates an item key (such as "*") whose value is
+// returned when the requested key is absent, before any rule-level
+// default applies. It returns l and must be called before the lookup
+// is shared.
+func (l *MapLookup[K, V]) WithCatchAll(key K) *MapLookup[K, V] {
+	l.catchAll = key
+	l.hasCatchAll = true
+	return l
+}
+
 func (l *MapLookup[K, V]) Name() string { return l.name }
 
 func (l *MapLookup[K, V]) Get(key any) (any, bool) {
-	k, ok := key.(K)
-	if !ok {
-		return nil, false
+	if k, ok := key.(K); ok {
+		if v, found := l.items[k]; found {
+			return v, true
+		}
 	}
-	v, found := l.items[k]
-	return v, found
+	if l.hasCatchAll {
+		v, found := l.items[l.catchAll]
+		return v, found
+	}
+	return nil, false
 }
 
 // RangeEntry represents a single range in a range lookup.
@@ -55,16 +75,87 @@ type RangeEntry[V any] struct {
 type RangeLookup[V any] struct {
 	name   string
 	ranges []RangeEntry[V]
+	sorted bool // ranges are sorted by Min and disjoint
 }
 
-// NewRangeLookup creates a new range-based lookup table.
+// NewRangeLookup creates a new range-based lookup table. Ranges are sorted
+// by Min so Get can binary search. If ranges overlap, they are kept in the
+// given order and Get returns the first matching range; use
+// NewRangeLookupChecked to reject overlapping ranges instead.
 func NewRangeLookup[V any](name string, ranges []RangeEntry[V]) *RangeLookup[V] {
-	cp := make([]RangeEntry[V], len(ranges))
-	copy(cp, ranges)
-	return &RangeLookup[V]{
-		name:   name,
-		ranges: cp,
+	sorted := sortRanges(ranges)
+	if rangesOverlap(sorted) {
+		cp := make([]RangeEntry[V], len(ranges))
+		copy(cp, ranges)
+		return &RangeLookup[V]{name: name, ranges: cp}
+	}
+	return &RangeLookup[V]{name: name, ranges: sorted, sorted: true}
+}
+
+// NewRangeLookupChecked creates a new range-based lookup table, returning
+// ErrRangeOverlap if any ranges overlap.
+func NewRangeLookupChecked[V any](name string, ranges []RangeEntry[V]) (*RangeLookup[V], error) {
+	sorted := sortRanges(ranges)
+	for i, r := range sorted {
+		if r.Min >= r.Max {
+			return nil, fmt.Errorf("%w: lookup %q has empty range [%v, %v)", ErrInvalidRule, name, r.Min, r.Max)
+		}
+		if i > 0 && r.Min < sorted[i-1].Max {
+			return nil, fmt.Errorf("%w: lookup %q ranges [%v, %v) and [%v, %v)", ErrRangeOverlap, name,
+				sorted[i-1].Min, sorted[i-1].Max, r.Min, r.Max)
+		}
+	}
+	return &RangeLookup[V]{name: name, ranges: sorted, sorted: true}, nil
+}
+
+// ThresholdEntry maps every key up to Upto (exclusive) and at or above the
+// previous entry's Upto to Value.
+type ThresholdEntry[V any] struct {
+	Upto  float64
+	Value V
+}
+
+// NewThresholdLookup creates a range lookup from ascending upper bounds.
+// The first range starts at -Inf, each later range starts where the
+// previous one ends, and the last entry is unbounded (its Upto is
+// ignored), so the ranges cover the number line with no gaps or overlaps.
+func NewThresholdLookup[V any](name string, thresholds []ThresholdEntry[V]) (*RangeLookup[V], error) {
+	if len(thresholds) == 0 {
+		return nil, fmt.Errorf("%w: lookup %q has no thresholds", ErrInvalidRule, name)
 	}
+	ranges := make([]RangeEntry[V], len(thresholds))
+	min := math.Inf(-1)
+	for i, t := range thresholds {
+		max := t.Upto
+		if i == len(thresholds)-1 {
+			max = math.Inf(1)
+		} else if math.IsNaN(max) || max <= min {
+			return nil, fmt.Errorf("%w: lookup %q threshold %d (%v) is not above %v", ErrInvalidRule, name, i, t.Upto, min)
+		}
+		ranges[i] = RangeEntry[V]{Min: min, Max: max, Value: t.Value}
+		min = max
+	}
+	return &RangeLookup[V]{name: name, ranges: ranges, sorted: true}, nil
+}
+
+// sortRanges returns a copy of ranges sorted by Min.
+func sortRanges[V any](ranges []RangeEntry[V]) []RangeEntry[V] {
+	sorted := make([]RangeEntry[V], len(ranges))
+	copy(sorted, ranges)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Min < sorted[j].Min
+	})
+	return sorted
+}
+
+// rangesOverlap reports whether any adjacent sorted ranges overlap.
+func rangesOverlap[V any](sorted []RangeEntry[V]) bool {
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Min < sorted[i-1].Max {
+			return true
+		}
+	}
+	return false
 }
 
 func (l *RangeLookup[V]) Name() string { return l.name }
@@ -74,6 +165,18 @@ func (l *RangeLookup[V]) Get(key any) (any, bool) {
 	if err != nil {
 		return nil, false
 	}
+
+	if l.sorted {
+		// The candidate is the last range starting at or below k.
+		i := sort.Search(len(l.ranges), func(i int) bool {
+			return l.ranges[i].Min > k
+		}) - 1
+		if i >= 0 && k < l.ranges[i].Max {
+			return l.ranges[i].Value, true
+		}
+		return nil, false
+	}
+
 	for _, r := range l.ranges {
 		if k >= r.Min && k < r.Max {
 			return r.Value, true
@@ -82,14 +185,267 @@ func (l *RangeLookup[V]) Get(key any) (any, bool) {
 	return nil, false
 }
 
+// InterpolationPoint is a known key → value point in an interpolating lookup.
+type InterpolationPoint struct {
+	X float64
+	Y float64
+}
+
+// InterpolatingRangeLookup linearly interpolates between known points,
+// e.g. for pricing curves. Unlike RangeLookup, which is stepwise, a key
+// between two points yields a value proportionally between theirs.
+type InterpolatingRangeLookup struct {
+	name   string
+	points []InterpolationPoint // sorted by X
+	clamp  bool
+}
+
+// NewInterpolatingRangeLookup creates an interpolating lookup. Keys
+// outside the points clamp to the nearest endpoint; see WithClamp.
+func NewInterpolatingRangeLookup(name string, points []InterpolationPoint) *InterpolatingRangeLookup {
+	cp := make([]InterpolationPoint, len(points))
+	copy(cp, points)
+	sort.SliceStable(cp, func(i, j int) bool {
+		return cp[i].X < cp[j].X
+	})
+	return &InterpolatingRangeLookup{
+		name:   name,
+		points: cp,
+		clamp:  true,
+	}
+}
+
+// WithClamp sets whether keys below the first or above the last point
+// clamp to that endpoint's value (the default) or are not found. It
+// returns l and must be called before the lookup is shared.
+func (l *InterpolatingRangeLookup) WithClamp(clamp bool) *InterpolatingRangeLookup {
+	l.clamp = clamp
+	return l
+}
+
+func (l *InterpolatingRangeLookup) Name() string { return l.name }
+
+func (l *InterpolatingRangeLookup) Get(key any) (any, bool) {
+	k, err := toFloat64(key)
+	if err != nil || math.IsNaN(k) || len(l.points) == 0 {
+		return nil, false
+	}
+
+	first, last := l.points[0], l.points[len(l.points)-1]
+	if k < first.X || k > last.X {
+		if !l.clamp {
+			return nil, false
+		}
+		if k < first.X {
+			return first.Y, true
+		}
+		return last.Y, true
+	}
+
+	// i is the first point at or beyond k.
+	i := sort.Search(len(l.points), func(i int) bool {
+		return l.points[i].X >= k
+	})
+	hi := l.points[i]
+	if hi.X == k || i == 0 {
+		return hi.Y, true
+	}
+	lo := l.points[i-1]
+	return lo.Y + (k-lo.X)*(hi.Y-lo.Y)/(hi.X-lo.X), true
+}
+
+// CompositeEntry is a single entry in a composite-key lookup.
+type CompositeEntry[V any] struct {
+	Key   []any // key components, in order
+	Value V
+}
+
+// CompositeLookup matches on several key components, e.g. (region,
+// product) → rate. Components are compared by position; numbers compare
+// by value regardless of type, so 1, int64(1) and 1.0 are equal.
+type CompositeLookup[V any] struct {
+	name  string
+	arity int
+	items map[string]V
+}
+
+// NewCompositeLookup creates a composite-key lookup table. Every entry
+// must have the same number of key components.
+func NewCompositeLookup[V any](name string, entries []CompositeEntry[V]) (*CompositeLookup[V], error) {
+	l := &CompositeLookup[V]{
+		name:  name,
+		items: make(map[string]V, len(entries)),
+	}
+	for i, e := range entries {
+		if i == 0 {
+			l.arity = len(e.Key)
+		}
+		if len(e.Key) == 0 || len(e.Key) != l.arity {
+			return nil, fmt.Errorf("%w: lookup %q entry %d has %d key components, expected %d",
+				ErrInvalidRule, name, i, len(e.Key), l.arity)
+		}
+		k, ok := CompositeKey(e.Key...)
+		if !ok {
+			return nil, fmt.Errorf("%w: lookup %q entry %d has a nil key component", ErrInvalidRule, name, i)
+		}
+		l.items[k] = e.Value
+	}
+	return l, nil
+}
+
+func (l *CompositeLookup[V]) Name() string { return l.name }
+
+// Get accepts a []any or []string key, or a struct whose exported fields
+// are the components in declaration order.
+func (l *CompositeLookup[V]) Get(key any) (any, bool) {
+	parts, ok := compositeParts(key)
+	if !ok || len(parts) != l.arity {
+		return nil, false
+	}
+	k, ok := CompositeKey(parts...)
+	if !ok {
+		return nil, false
+	}
+	v, found := l.items[k]
+	if !found {
+		return nil, false
+	}
+	return v, true
+}
+
+// CompositeKey encodes key components into a single comparable string.
+// It returns false if any component is nil.
+func CompositeKey(parts ...any) (string, bool) {
+	var sb strings.Builder
+	for i, p := range parts {
+		if p == nil {
+			return "", false
+		}
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		switch v := p.(type) {
+		case string:
+			sb.WriteString(strconv.Quote(v))
+		case bool:
+			sb.WriteString(strconv.FormatBool(v))
+		default:
+			if f, err := toFloat64(v); err == nil {
+				sb.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+			} else {
+				fmt.Fprintf(&sb, "%T(%v)", v, v)
+			}
+		}
+	}
+	return sb.String(), true
+}
+
+// compositeParts splits a composite key into its components.
+func compositeParts(key any) ([]any, bool) {
+	switch k := key.(type) {
+	case []any:
+		return k, true
+	case []string:
+		parts := make([]any, len(k))
+		for i, s := range k {
+			parts[i] = s
+		}
+		return parts, true
+	}
+
+	rv := reflect.ValueOf(key)
+	if rv.Kind() != reflect.Struct {
+		return nil, false
+	}
+	var parts []any
+	for i := range rv.NumField() {
+		if rv.Type().Field(i).IsExported() {
+			parts = append(parts, rv.Field(i).Interface())
+		}
+	}
+	return parts, true
+}
+
+// Lookup2D is implemented by lookups keyed on two axes.
+type Lookup2D interface {
+	Lookup
+
+	// Get2D retrieves the value at the intersection of row and col.
+	Get2D(row, col any) (any, bool)
+}
+
+// RangeMapEntry is a numeric band whose values are keyed by a second,
+// string key.
+type RangeMapEntry[V any] struct {
+	Min    float64 // inclusive
+	Max    float64 // exclusive (use math.Inf(1) for unbounded)
+	Values map[string]V
+}
+
+// RangeMapLookup is a two-key lookup combining a numeric range with a
+// string key, such as income band × filing status in a tax table.
+type RangeMapLookup[V any] struct {
+	name  string
+	bands *RangeLookup[map[string]V]
+}
+
+// NewRangeMapLookup creates a range-of-maps lookup. Bands follow the same
+// rules as NewRangeLookup.
+func NewRangeMapLookup[V any](name string, entries []RangeMapEntry[V]) *RangeMapLookup[V] {
+	ranges := make([]RangeEntry[map[string]V], len(entries))
+	for i, e := range entries {
+		values := make(map[string]V, len(e.Values))
+		for k, v := range e.Values {
+			values[k] = v
+		}
+		ranges[i] = RangeEntry[map[string]V]{Min: e.Min, Max: e.Max, Value: values}
+	}
+	return &RangeMapLookup[V]{
+		name:  name,
+		bands: NewRangeLookup(name, ranges),
+	}
+}
+
+func (l *RangeMapLookup[V]) Name() string { return l.name }
+
+// Get retrieves a value by a two-part key (the numeric then the string
+// key), given as []any, []string or a struct as for CompositeLookup.
+func (l *RangeMapLookup[V]) Get(key any) (any, bool) {
+	parts, ok := compositeParts(key)
+	if !ok || len(parts) != 2 {
+		return nil, false
+	}
+	return l.Get2D(parts[0], parts[1])
+}
+
+// Get2D retrieves the value for the band containing row under key col.
+func (l *RangeMapLookup[V]) Get2D(row, col any) (any, bool) {
+	band, ok := l.bands.Get(row)
+	if !ok {
+		return nil, false
+	}
+	k, ok := col.(string)
+	if !ok {
+		return nil, false
+	}
+	v, ok := band.(map[string]V)[k]
+	if !ok {
+		return nil, false
+	}
+	return v, true
+}
+
 // LookupRule retrieves a value from a lookup table.
 type LookupRule struct {
 	baseRule
-	table      string
-	keySource  string // context key to use as lookup key
-	target     string
-	defaultVal any
-	required   bool
+	table       string
+	keySource   string   // context key to use as lookup key
+	keySources  []string // context keys forming a composite key
+	target      string
+	defaultVal  any
+	required    bool
+	requiredKey bool
+	onMissing   bool // store defaultVal when a key source is missing
 }
 
 // LookupConfig configures a lookup rule.
@@ -98,6 +454,7 @@ type LookupConfig struct {
 	Name        string
 	Description string
 	Deps        []string
+	When        string // guard expression; the rule is skipped when it is false
 
 	// Table is the lookup table name (must be registered).
 	Table string
@@ -105,14 +462,31 @@ type LookupConfig struct {
 	// Key is the context key to use as lookup key.
 	Key string
 
+	// Keys are context keys whose values form a composite key, in order
+	// (mutually exclusive with Key). If any of them is missing from the
+	// context, the lookup is treated as not found.
+	Keys []string
+
 	// Target is the context key to store the result.
 	Target string
 
-	// Default is the value if not found (ignored if Required is true).
+	// Default is the value stored when the key is not found in the table
+	// (ignored if Required is true).
 	Default any
 
-	// Required causes an error if the lookup key is not found.
+	// Required causes an ErrKeyNotFound error if the key is not found in
+	// the table.
 	Required bool
+
+	// RequiredKey causes an ErrValueNotFound error if Key, or any of Keys,
+	// is missing from the context. A missing Key is always an error
+	// unless DefaultOnMissingKey is set.
+	RequiredKey bool
+
+	// DefaultOnMissingKey stores Default instead of failing when Key, or
+	// any of Keys, is missing from the context, even if Required is set.
+	// It requires a Default and cannot be combined with RequiredKey.
+	DefaultOnMissingKey bool
 }
 
 // NewLookup creates a new lookup rule.
@@ -123,12 +497,26 @@ func NewLookup(cfg LookupConfig) (*LookupRule, error) {
 	if cfg.Table == "" {
 		return nil, fmt.Errorf("%w: lookup rule %q requires table", ErrInvalidRule, cfg.ID)
 	}
-	if cfg.Key == "" {
+	if cfg.Key == "" && len(cfg.Keys) == 0 {
 		return nil, fmt.Errorf("%w: lookup rule %q requires key", ErrInvalidRule, cfg.ID)
 	}
+	if cfg.Key != "" && len(cfg.Keys) > 0 {
+		return nil, fmt.Errorf("%w: lookup rule %q has both key and keys", ErrInvalidRule, cfg.ID)
+	}
 	if cfg.Target == "" {
 		return nil, fmt.Errorf("%w: lookup rule %q requires target", ErrInvalidRule, cfg.ID)
 	}
+	if cfg.DefaultOnMissingKey && cfg.Default == nil {
+		return nil, fmt.Errorf("%w: lookup rule %q has default on missing key but no default", ErrInvalidRule, cfg.ID)
+	}
+	if cfg.DefaultOnMissingKey && cfg.RequiredKey {
+		return nil, fmt.Errorf("%w: lookup rule %q has both required key and default on missing key", ErrInvalidRule, cfg.ID)
+	}
+
+	when, err := compileWhen(cfg.ID, cfg.When)
+	if err != nil {
+		return nil, err
+	}
 
 	return &LookupRule{
 		baseRule: baseRule{
@@ -136,12 +524,16 @@ func NewLookup(cfg LookupConfig) (*LookupRule, error) {
 			name:        cfg.Name,
 			description: cfg.Description,
 			deps:        cfg.Deps,
+			when:        when,
 		},
-		table:      cfg.Table,
-		keySource:  cfg.Key,
-		target:     cfg.Target,
-		defaultVal: cfg.Default,
-		required:   cfg.Required,
+		table:       cfg.Table,
+		keySource:   cfg.Key,
+		keySources:  cfg.Keys,
+		target:      cfg.Target,
+		defaultVal:  cfg.Default,
+		required:    cfg.Required,
+		requiredKey: cfg.RequiredKey,
+		onMissing:   cfg.DefaultOnMissingKey,
 	}, nil
 }
 
@@ -156,19 +548,27 @@ func MustLookup(cfg LookupConfig) *LookupRule {
 
 // Evaluate performs the lookup and sets the result.
 func (r *LookupRule) Evaluate(ctx context.Context, evalCtx *EvalContext) error {
-	key, ok := evalCtx.Get(r.keySource)
-	if !ok {
+	key, missing := r.lookupKey(evalCtx)
+	if missing != "" && !r.onMissing && (r.requiredKey || r.keySources == nil) {
 		return NewRuleError(r.id, string(RuleTypeLookup), "evaluate",
-			fmt.Errorf("%w: %s", ErrValueNotFound, r.keySource))
+			fmt.Errorf("%w: %s", ErrValueNotFound, missing))
 	}
 
-	value, found, err := evalCtx.Lookup(r.table, key)
-	if err != nil {
-		return NewRuleError(r.id, string(RuleTypeLookup), "evaluate", err)
+	var value any
+	var found bool
+	if missing == "" {
+		var err error
+		value, found, err = evalCtx.Lookup(r.table, key)
+		if err != nil {
+			return NewRuleError(r.id, string(RuleTypeLookup), "evaluate", err)
+		}
+	} else if !evalCtx.HasLookup(r.table) {
+		return NewRuleError(r.id, string(RuleTypeLookup), "evaluate",
+			fmt.Errorf("%w: %s", ErrLookupNotFound, r.table))
 	}
 
 	if !found {
-		if r.required {
+		if r.required && (missing == "" || !r.onMissing) {
 			return NewRuleError(r.id, string(RuleTypeLookup), "evaluate",
 				fmt.Errorf("%w: %v in table %s", ErrKeyNotFound, key, r.table))
 		}
@@ -179,6 +579,29 @@ func (r *LookupRule) Evaluate(ctx context.Context, evalCtx *EvalContext) error {
 	return nil
 }
 
+// lookupKey reads the lookup key from the context, also returning the
+// first key source missing from the context, if any. A composite key
+// with a missing component is returned as far as it was read.
+func (r *LookupRule) lookupKey(evalCtx *EvalContext) (any, string) {
+	if r.keySources == nil {
+		key, ok := evalCtx.Get(r.keySource)
+		if !ok {
+			return nil, r.keySource
+		}
+		return key, ""
+	}
+
+	parts := make([]any, len(r.keySources))
+	for i, k := range r.keySources {
+		v, ok := evalCtx.Get(k)
+		if !ok {
+			return parts, k
+		}
+		parts[i] = v
+	}
+	return parts, ""
+}
+
 // Table returns the lookup table name.
 func (r *LookupRule) Table() string {
 	return r.table
@@ -207,3 +630,54 @@ func NewTaxBracketLookup(name string, brackets []TaxBracket) *RangeLookup[float6
 	}
 	return NewRangeLookup(name, ranges)
 }
+
+// ProgressiveTaxLookup computes the total tax on an income by applying
+// each bracket's rate to the portion of income that falls within it.
+// Unlike NewTaxBracketLookup, which returns the marginal rate, Get
+// returns the cumulative tax amount.
+type ProgressiveTaxLookup struct {
+	name     string
+	brackets []TaxBracket
+}
+
+// NewProgressiveTaxLookup creates a progressive tax lookup. As with
+// NewTaxBracketLookup, a Max of 0 means the bracket is unbounded.
+func NewProgressiveTaxLookup(name string, brackets []TaxBracket) *ProgressiveTaxLookup {
+	cp := make([]TaxBracket, len(brackets))
+	for i, b := range brackets {
+		if b.Max == 0 {
+			b.Max = math.Inf(1)
+		}
+		cp[i] = b
+	}
+	sort.SliceStable(cp, func(i, j int) bool {
+		return cp[i].Min < cp[j].Min
+	})
+	return &ProgressiveTaxLookup{
+		name:     name,
+		brackets: cp,
+	}
+}
+
+func (l *ProgressiveTaxLookup) Name() string { return l.name }
+
+// Get returns the total tax for a numeric income key.
+func (l *ProgressiveTaxLookup) Get(key any) (any, bool) {
+	income, err := toFloat64(key)
+	if err != nil || math.IsNaN(income) {
+		return nil, false
+	}
+	return l.Tax(income), true
+}
+
+// Tax returns the total tax owed on income.
+func (l *ProgressiveTaxLookup) Tax(income float64) float64 {
+	var tax float64
+	for _, b := range l.brackets {
+		if income <= b.Min {
+			break
+		}
+		tax += (math.Min(income, b.Max) - b.Min) * b.Rate
+	}
+	return tax
+}
@@ -2,6 +2,7 @@ package cortex_test
 
 import (
 	"context"
+	"errors"
 	"math"
 	"testing"
 
@@ -39,6 +40,46 @@ func TestMapLookup(t *testing.T) {
 	}
 }
 
+func TestMapLookupCatchAll(t *testing.T) {
+	items := map[string]float64{"food": 0.05, "luxury": 0.20, "*": 0.10}
+
+	rule := func() *cortex.LookupRule {
+		return cortex.MustLookup(cortex.LookupConfig{
+			ID:      "rate",
+			Table:   "rates",
+			Key:     "category",
+			Target:  "rate",
+			Default: 0.0,
+		})
+	}
+
+	tests := []struct {
+		name     string
+		lookup   cortex.Lookup
+		category string
+		expected float64
+	}{
+		{"specific key", cortex.NewMapLookup("rates", items).WithCatchAll("*"), "food", 0.05},
+		{"catch-all", cortex.NewMapLookup("rates", items).WithCatchAll("*"), "books", 0.10},
+		{"rule default without catch-all", cortex.NewMapLookup("rates", items), "books", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evalCtx := cortex.NewEvalContext()
+			evalCtx.RegisterLookup(tt.lookup)
+			evalCtx.Set("category", tt.category)
+
+			if err := rule().Evaluate(context.Background(), evalCtx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if rate, _ := evalCtx.GetFloat64("rate"); rate != tt.expected {
+				t.Errorf("expected rate=%v, got %v", tt.expected, rate)
+			}
+		})
+	}
+}
+
 func TestRangeLookup(t *testing.T) {
 	lookup := cortex.NewRangeLookup("brackets", []cortex.RangeEntry[float64]{
 		{Min: 0, Max: 50000, Value: 0.10},
@@ -86,6 +127,280 @@ func TestRangeLookupWrongType(t *testing.T) {
 	}
 }
 
+func TestRangeLookupUnsorted(t *testing.T) {
+	lookup := cortex.NewRangeLookup("bands", []cortex.RangeEntry[string]{
+		{Min: 80, Max: math.Inf(1), Value: "A"},
+		{Min: 0, Max: 50, Value: "F"},
+		{Min: 65, Max: 80, Value: "B"},
+		{Min: 50, Max: 60, Value: "C"},
+	})
+
+	tests := []struct {
+		input    float64
+		expected any
+		found    bool
+	}{
+		{0, "F", true},
+		{49.9, "F", true},
+		{50, "C", true},
+		{62, nil, false}, // gap between 60 and 65
+		{65, "B", true},
+		{80, "A", true},
+		{1e9, "A", true},
+		{-1, nil, false},
+		{math.NaN(), nil, false},
+	}
+
+	for _, tt := range tests {
+		val, ok := lookup.Get(tt.input)
+		if ok != tt.found || val != tt.expected {
+			t.Errorf("input %v: expected (%v, %v), got (%v, %v)", tt.input, tt.expected, tt.found, val, ok)
+		}
+	}
+}
+
+func TestRangeLookupOverlapping(t *testing.T) {
+	// Overlapping ranges keep first-match semantics in declaration order.
+	lookup := cortex.NewRangeLookup("overlap", []cortex.RangeEntry[int]{
+		{Min: 10, Max: 100, Value: 1},
+		{Min: 0, Max: 50, Value: 2},
+	})
+
+	if val, _ := lookup.Get(20.0); val != 1 {
+		t.Errorf("expected first declared range to win, got %v", val)
+	}
+	if val, _ := lookup.Get(5.0); val != 2 {
+		t.Errorf("expected 2, got %v", val)
+	}
+}
+
+func TestRangeLookupChecked(t *testing.T) {
+	_, err := cortex.NewRangeLookupChecked("overlap", []cortex.RangeEntry[int]{
+		{Min: 0, Max: 50, Value: 1},
+		{Min: 40, Max: 100, Value: 2},
+	})
+	if !errors.Is(err, cortex.ErrRangeOverlap) {
+		t.Errorf("expected ErrRangeOverlap, got %v", err)
+	}
+
+	_, err = cortex.NewRangeLookupChecked("empty", []cortex.RangeEntry[int]{
+		{Min: 10, Max: 10, Value: 1},
+	})
+	if !errors.Is(err, cortex.ErrInvalidRule) {
+		t.Errorf("expected ErrInvalidRule for empty range, got %v", err)
+	}
+
+	lookup, err := cortex.NewRangeLookupChecked("ok", []cortex.RangeEntry[int]{
+		{Min: 50, Max: 100, Value: 2},
+		{Min: 0, Max: 50, Value: 1},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if val, _ := lookup.Get(50); val != 2 {
+		t.Errorf("expected 2, got %v", val)
+	}
+}
+
+func TestThresholdLookup(t *testing.T) {
+	lookup, err := cortex.NewThresholdLookup("rates", []cortex.ThresholdEntry[string]{
+		{Upto: 0, Value: "negative"},
+		{Upto: 100, Value: "low"},
+		{Upto: 1000, Value: "mid"},
+		{Upto: 5, Value: "high"}, // last Upto is ignored
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		key      float64
+		expected string
+	}{
+		{-1e9, "negative"},
+		{-0.001, "negative"},
+		{0, "low"},
+		{99.999, "low"},
+		{100, "mid"},
+		{999, "mid"},
+		{1000, "high"},
+		{1e12, "high"},
+	}
+	for _, tt := range tests {
+		val, ok := lookup.Get(tt.key)
+		if !ok || val != tt.expected {
+			t.Errorf("Get(%v) = %v, %v; expected %q", tt.key, val, ok, tt.expected)
+		}
+	}
+
+	single, err := cortex.NewThresholdLookup("flat", []cortex.ThresholdEntry[int]{{Value: 7}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if val, ok := single.Get(-42); !ok || val != 7 {
+		t.Errorf("expected a single threshold to cover every key, got %v, %v", val, ok)
+	}
+}
+
+func TestThresholdLookupInvalid(t *testing.T) {
+	if _, err := cortex.NewThresholdLookup[int]("empty", nil); !errors.Is(err, cortex.ErrInvalidRule) {
+		t.Errorf("expected ErrInvalidRule for no thresholds, got %v", err)
+	}
+
+	_, err := cortex.NewThresholdLookup("unsorted", []cortex.ThresholdEntry[int]{
+		{Upto: 100, Value: 1},
+		{Upto: 50, Value: 2},
+		{Value: 3},
+	})
+	if !errors.Is(err, cortex.ErrInvalidRule) {
+		t.Errorf("expected ErrInvalidRule for descending thresholds, got %v", err)
+	}
+
+	_, err = cortex.NewThresholdLookup("repeated", []cortex.ThresholdEntry[int]{
+		{Upto: 100, Value: 1},
+		{Upto: 100, Value: 2},
+		{Value: 3},
+	})
+	if !errors.Is(err, cortex.ErrInvalidRule) {
+		t.Errorf("expected ErrInvalidRule for repeated thresholds, got %v", err)
+	}
+}
+
+func TestInterpolatingRangeLookup(t *testing.T) {
+	lookup := cortex.NewInterpolatingRangeLookup("curve", []cortex.InterpolationPoint{
+		{X: 200, Y: 20},
+		{X: 100, Y: 10},
+		{X: 400, Y: 0},
+	})
+
+	tests := []struct {
+		input    any
+		expected float64
+	}{
+		{150.0, 15},
+		{100.0, 10}, // exactly on a point
+		{200, 20},
+		{300.0, 10}, // decreasing segment
+		{50.0, 10},  // clamped to first point
+		{500.0, 0},  // clamped to last point
+	}
+
+	for _, tt := range tests {
+		val, ok := lookup.Get(tt.input)
+		if !ok || val != tt.expected {
+			t.Errorf("input %v: expected %v, got %v (found=%v)", tt.input, tt.expected, val, ok)
+		}
+	}
+
+	lookup.WithClamp(false)
+	if _, ok := lookup.Get(50.0); ok {
+		t.Error("expected key below the first point not to be found without clamping")
+	}
+	if _, ok := lookup.Get(500.0); ok {
+		t.Error("expected key above the last point not to be found without clamping")
+	}
+	if val, ok := lookup.Get(150.0); !ok || val != 15.0 {
+		t.Errorf("expected 15 within range, got %v", val)
+	}
+
+	if _, ok := lookup.Get("x"); ok {
+		t.Error("expected non-numeric key not to be found")
+	}
+	if _, ok := cortex.NewInterpolatingRangeLookup("empty", nil).Get(1.0); ok {
+		t.Error("expected empty lookup not to find anything")
+	}
+}
+
+func TestCompositeLookup(t *testing.T) {
+	lookup, err := cortex.NewCompositeLookup("rates", []cortex.CompositeEntry[float64]{
+		{Key: []any{"us", "widget"}, Value: 0.10},
+		{Key: []any{"us", "gadget"}, Value: 0.15},
+		{Key: []any{"eu", 1}, Value: 0.20},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	type regionProduct struct {
+		Region  string
+		Product string
+	}
+
+	tests := []struct {
+		name     string
+		key      any
+		expected any
+		found    bool
+	}{
+		{"any slice", []any{"us", "widget"}, 0.10, true},
+		{"string slice", []string{"us", "gadget"}, 0.15, true},
+		{"struct", regionProduct{"us", "widget"}, 0.10, true},
+		{"numeric component", []any{"eu", 1.0}, 0.20, true},
+		{"component order matters", []any{"widget", "us"}, nil, false},
+		{"missing entry", []any{"eu", "widget"}, nil, false},
+		{"nil component", []any{"us", nil}, nil, false},
+		{"wrong arity", []any{"us"}, nil, false},
+		{"scalar key", "us", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			val, ok := lookup.Get(tt.key)
+			if ok != tt.found || val != tt.expected {
+				t.Errorf("expected (%v, %v), got (%v, %v)", tt.expected, tt.found, val, ok)
+			}
+		})
+	}
+
+	_, err = cortex.NewCompositeLookup("bad", []cortex.CompositeEntry[int]{
+		{Key: []any{"a", "b"}, Value: 1},
+		{Key: []any{"a"}, Value: 2},
+	})
+	if !errors.Is(err, cortex.ErrInvalidRule) {
+		t.Errorf("expected ErrInvalidRule for mismatched arity, got %v", err)
+	}
+}
+
+func TestLookupRuleCompositeKeys(t *testing.T) {
+	lookup, _ := cortex.NewCompositeLookup("rates", []cortex.CompositeEntry[float64]{
+		{Key: []any{"us", "widget"}, Value: 0.10},
+	})
+	rule := cortex.MustLookup(cortex.LookupConfig{
+		ID:      "rate",
+		Table:   "rates",
+		Keys:    []string{"region", "product"},
+		Target:  "rate",
+		Default: 0.0,
+	})
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.RegisterLookup(lookup)
+	evalCtx.SetAll(map[string]any{"region": "us", "product": "widget"})
+	if err := rule.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rate, _ := evalCtx.GetFloat64("rate"); rate != 0.10 {
+		t.Errorf("expected rate=0.10, got %v", rate)
+	}
+
+	// A missing component is a clean miss, falling back to the default.
+	evalCtx = cortex.NewEvalContext()
+	evalCtx.RegisterLookup(lookup)
+	evalCtx.Set("region", "us")
+	if err := rule.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rate, ok := evalCtx.GetFloat64("rate"); ok != nil || rate != 0 {
+		t.Errorf("expected default rate=0, got %v", rate)
+	}
+
+	if _, err := cortex.NewLookup(cortex.LookupConfig{
+		ID: "x", Table: "rates", Key: "region", Keys: []string{"region"}, Target: "rate",
+	}); !errors.Is(err, cortex.ErrInvalidRule) {
+		t.Errorf("expected ErrInvalidRule for both key and keys, got %v", err)
+	}
+}
+
 func TestLookupRule(t *testing.T) {
 	rule := cortex.MustLookup(cortex.LookupConfig{
 		ID:     "get-rate",
@@ -198,6 +513,120 @@ func TestLookupRuleMissingKey(t *testing.T) {
 	}
 }
 
+func TestLookupRuleDefaultOnMissingKey(t *testing.T) {
+	newRule := func(cfg cortex.LookupConfig) *cortex.LookupRule {
+		cfg.ID, cfg.Table, cfg.Key, cfg.Target = "get-rate", "rates", "amount", "rate"
+		return cortex.MustLookup(cfg)
+	}
+	newCtx := func() *cortex.EvalContext {
+		evalCtx := cortex.NewEvalContext()
+		evalCtx.RegisterLookup(cortex.NewMapLookup("rates", map[string]float64{"a": 1}))
+		return evalCtx
+	}
+
+	// A Default alone does not cover a missing key.
+	evalCtx := newCtx()
+	err := newRule(cortex.LookupConfig{Default: 0.5}).Evaluate(context.Background(), evalCtx)
+	if !errors.Is(err, cortex.ErrValueNotFound) {
+		t.Errorf("expected ErrValueNotFound, got %v", err)
+	}
+	if evalCtx.Has("rate") {
+		t.Error("expected no value written for a missing key")
+	}
+
+	for _, required := range []bool{false, true} {
+		evalCtx = newCtx()
+		rule := newRule(cortex.LookupConfig{Default: 0.5, Required: required, DefaultOnMissingKey: true})
+		if err := rule.Evaluate(context.Background(), evalCtx); err != nil {
+			t.Fatalf("required=%v: unexpected error: %v", required, err)
+		}
+		if rate, _ := evalCtx.GetFloat64("rate"); rate != 0.5 {
+			t.Errorf("required=%v: expected the default 0.5, got %v", required, rate)
+		}
+	}
+
+	// Required still applies to keys present but not in the table.
+	evalCtx = newCtx()
+	evalCtx.Set("amount", "b")
+	err = newRule(cortex.LookupConfig{Default: 0.5, Required: true, DefaultOnMissingKey: true}).Evaluate(context.Background(), evalCtx)
+	if !errors.Is(err, cortex.ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	invalid := []cortex.LookupConfig{
+		{ID: "r", Table: "rates", Key: "amount", Target: "rate", DefaultOnMissingKey: true},
+		{ID: "r", Table: "rates", Key: "amount", Target: "rate", Default: 0.5, DefaultOnMissingKey: true, RequiredKey: true},
+	}
+	for _, cfg := range invalid {
+		if _, err := cortex.NewLookup(cfg); !errors.Is(err, cortex.ErrInvalidRule) {
+			t.Errorf("expected ErrInvalidRule for %+v, got %v", cfg, err)
+		}
+	}
+}
+
+func TestLookupRuleRequiredCombinations(t *testing.T) {
+	found := map[string]any{"region": "us", "product": "widget"}
+	missingKey := map[string]any{"region": "us"}
+	notInTable := map[string]any{"region": "us", "product": "gadget"}
+
+	tests := []struct {
+		name        string
+		values      map[string]any
+		required    bool
+		requiredKey bool
+		onMissing   bool
+		target      error
+	}{
+		{"found", found, true, true, false, nil},
+		{"missing key, optional", missingKey, false, false, false, nil},
+		{"missing key, table required", missingKey, true, false, false, cortex.ErrKeyNotFound},
+		{"missing key, table required, default on missing", missingKey, true, false, true, nil},
+		{"missing key, key required", missingKey, false, true, false, cortex.ErrValueNotFound},
+		{"missing key, both required", missingKey, true, true, false, cortex.ErrValueNotFound},
+		{"not in table, optional", notInTable, false, false, false, nil},
+		{"not in table, key required", notInTable, false, true, false, nil},
+		{"not in table, default on missing", notInTable, false, false, true, nil},
+		{"not in table, table required", notInTable, true, false, false, cortex.ErrKeyNotFound},
+		{"not in table, both required", notInTable, true, true, false, cortex.ErrKeyNotFound},
+	}
+
+	lookup, _ := cortex.NewCompositeLookup("rates", []cortex.CompositeEntry[float64]{
+		{Key: []any{"us", "widget"}, Value: 0.1},
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := cortex.MustLookup(cortex.LookupConfig{
+				ID: "rate", Table: "rates", Keys: []string{"region", "product"}, Target: "rate",
+				Default: -1.0, Required: tt.required, RequiredKey: tt.requiredKey, DefaultOnMissingKey: tt.onMissing,
+			})
+
+			evalCtx := cortex.NewEvalContext()
+			evalCtx.RegisterLookup(lookup)
+			evalCtx.SetAll(tt.values)
+
+			err := rule.Evaluate(context.Background(), evalCtx)
+			if tt.target != nil {
+				if !errors.Is(err, tt.target) {
+					t.Errorf("expected %v, got %v", tt.target, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			expected := -1.0 // the default
+			if tt.values["product"] == "widget" {
+				expected = 0.1
+			}
+			if rate, _ := evalCtx.GetFloat64("rate"); rate != expected {
+				t.Errorf("expected rate=%v, got %v", expected, rate)
+			}
+		})
+	}
+}
+
 func TestTaxBracketLookup(t *testing.T) {
 	lookup := cortex.NewTaxBracketLookup("tax", []cortex.TaxBracket{
 		{Min: 0, Max: 50000, Rate: 0.10},
@@ -216,6 +645,61 @@ func TestTaxBracketLookup(t *testing.T) {
 	}
 }
 
+func TestProgressiveTaxLookup(t *testing.T) {
+	lookup := cortex.NewProgressiveTaxLookup("tax", []cortex.TaxBracket{
+		{Min: 50000, Max: 100000, Rate: 0.20},
+		{Min: 0, Max: 50000, Rate: 0.10},
+		{Min: 100000, Max: 0, Rate: 0.30}, // 0 = infinity
+	})
+
+	tests := []struct {
+		income   float64
+		expected float64
+	}{
+		{0, 0},
+		{-100, 0},
+		{25000, 2500},
+		{50000, 5000},  // exactly on a boundary
+		{75000, 10000}, // 5000 + 25000*0.20
+		{100000, 15000},
+		{200000, 45000}, // unbounded top bracket: 15000 + 100000*0.30
+	}
+
+	for _, tt := range tests {
+		val, ok := lookup.Get(tt.income)
+		if !ok || math.Abs(val.(float64)-tt.expected) > 1e-9 {
+			t.Errorf("income %v: expected tax %v, got %v (found=%v)", tt.income, tt.expected, val, ok)
+		}
+	}
+
+	if _, ok := lookup.Get("abc"); ok {
+		t.Error("expected non-numeric key not to be found")
+	}
+}
+
+func TestProgressiveTaxLookupRule(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.RegisterLookup(cortex.NewProgressiveTaxLookup("income_tax", []cortex.TaxBracket{
+		{Min: 0, Max: 10000, Rate: 0},
+		{Min: 10000, Max: 0, Rate: 0.25},
+	}))
+	engine.AddRule(cortex.MustLookup(cortex.LookupConfig{
+		ID:     "tax",
+		Table:  "income_tax",
+		Key:    "salary",
+		Target: "tax",
+	}))
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("salary", 50000.0)
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tax, _ := evalCtx.GetFloat64("tax"); tax != 10000 {
+		t.Errorf("expected tax=10000, got %v", tax)
+	}
+}
+
 func TestLookupValidation(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -241,3 +725,50 @@ func TestLookupValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestRangeMapLookup(t *testing.T) {
+	lookup := cortex.NewRangeMapLookup("std_deduction", []cortex.RangeMapEntry[float64]{
+		{Min: 0, Max: 50000, Values: map[string]float64{"single": 0.10, "joint": 0.08}},
+		{Min: 50000, Max: math.Inf(1), Values: map[string]float64{"single": 0.22, "joint": 0.18}},
+	})
+
+	var l2 cortex.Lookup2D = lookup
+	tests := []struct {
+		income float64
+		status string
+		want   float64
+	}{
+		{20000, "single", 0.10},
+		{20000, "joint", 0.08},
+		{50000, "single", 0.22},
+		{1e6, "joint", 0.18},
+	}
+	for _, tt := range tests {
+		if v, ok := l2.Get2D(tt.income, tt.status); !ok || v != tt.want {
+			t.Errorf("Get2D(%v, %q) = %v, want %v", tt.income, tt.status, v, tt.want)
+		}
+		if v, ok := lookup.Get([]any{tt.income, tt.status}); !ok || v != tt.want {
+			t.Errorf("Get([%v %q]) = %v, want %v", tt.income, tt.status, v, tt.want)
+		}
+	}
+
+	for _, key := range []any{[]any{-1.0, "single"}, []any{1000.0, "widowed"}, []any{1000.0}, 1000.0} {
+		if _, ok := lookup.Get(key); ok {
+			t.Errorf("Get(%v): expected miss", key)
+		}
+	}
+
+	engine := cortex.New("test", nil)
+	engine.RegisterLookup(lookup)
+	engine.AddRule(cortex.MustLookup(cortex.LookupConfig{
+		ID: "rate", Table: "std_deduction", Keys: []string{"income", "status"}, Target: "rate",
+	}))
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.SetAll(map[string]any{"income": 75000, "status": "joint"})
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("evaluation error: %v", err)
+	}
+	if rate, _ := evalCtx.GetFloat64("rate"); rate != 0.18 {
+		t.Errorf("expected rate=0.18, got %v", rate)
+	}
+}
@@ -0,0 +1,76 @@
+package cortex
+
+// RuleInfo describes a rule for generated documentation and admin tooling.
+type RuleInfo struct {
+	ID          string
+	Type        RuleType // empty for custom rule implementations
+	Name        string
+	Description string
+	Deps        []string
+	Outputs     []string // context keys the rule sets, if known
+	When        string   // guard expression, if any
+	Group       string   // group set with SetRuleGroup, if any
+	Enabled     bool
+}
+
+// RuleMetadata returns information about each rule in evaluation order.
+func (e *Engine) RuleMetadata() []RuleInfo {
+	e.mu.RLock()
+	rules := e.rules
+	disabled := e.disabled
+	groups := make(map[string]string, len(e.groups))
+	for id, group := range e.groups {
+		groups[id] = group
+	}
+	e.mu.RUnlock()
+
+	infos := make([]RuleInfo, len(rules))
+	for i, rule := range rules {
+		_, off := disabled[rule.ID()]
+		info := RuleInfo{
+			ID:      rule.ID(),
+			Type:    ruleTypeOf(rule),
+			Deps:    ruleDeps(rule),
+			Group:   groups[rule.ID()],
+			Enabled: !off,
+		}
+		if m, ok := rule.(RuleMetadata); ok {
+			info.Name = m.Name()
+			info.Description = m.Description()
+		}
+		if or, ok := rule.(outputRule); ok {
+			info.Outputs = or.outputs()
+		}
+		if guard := ruleGuard(rule); guard != nil {
+			info.When = guard.Raw()
+		}
+		infos[i] = info
+	}
+	return infos
+}
+
+// ruleTypeOf returns the RuleType of a built-in rule, or "" for custom rules.
+func ruleTypeOf(rule Rule) RuleType {
+	switch rule.(type) {
+	case *AssignmentRule:
+		return RuleTypeAssignment
+	case *FormulaRule:
+		return RuleTypeFormula
+	case *AllocationRule:
+		return RuleTypeAllocation
+	case *LookupRule:
+		return RuleTypeLookup
+	case *BuildupRule:
+		return RuleTypeBuildup
+	case *RecordLookupRule:
+		return RuleTypeRecordLookup
+	case *SubEngineRule:
+		return RuleTypeSubEngine
+	case *VectorFormulaRule:
+		return RuleTypeVectorFormula
+	case *BuildupResetRule:
+		return RuleTypeBuildupReset
+	default:
+		return ""
+	}
+}
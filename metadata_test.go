@@ -0,0 +1,86 @@
+package cortex_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/kolosys/cortex"
+)
+
+type customRule struct{}
+
+func (customRule) ID() string { return "custom" }
+
+func (customRule) Evaluate(ctx context.Context, evalCtx *cortex.EvalContext) error { return nil }
+
+func TestEngineRuleMetadata(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{
+			ID:          "salary",
+			Name:        "Base Salary",
+			Description: "Sets the base salary",
+			Target:      "salary",
+			Value:       1000.0,
+		}),
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID:         "tax",
+			Deps:       []string{"salary"},
+			Target:     "tax",
+			Expression: "salary * 0.2",
+			When:       "salary > 0",
+		}),
+		cortex.MustAllocation(cortex.AllocationConfig{
+			ID:       "split",
+			Source:   "salary",
+			Strategy: cortex.StrategyPercentage,
+			Targets: []cortex.AllocationTarget{
+				{Key: "a", Amount: 50},
+				{Key: "b", Amount: 50},
+			},
+		}),
+		cortex.MustBuildup(cortex.BuildupConfig{
+			ID:        "total",
+			Buildup:   "payroll",
+			Operation: cortex.BuildupSum,
+			Source:    "salary",
+			Target:    "running",
+		}),
+		customRule{},
+	)
+	engine.SetRuleEnabled("total", false)
+
+	infos := engine.RuleMetadata()
+	if len(infos) != 5 {
+		t.Fatalf("expected 5 rules, got %d", len(infos))
+	}
+
+	salary := infos[0]
+	if salary.Type != cortex.RuleTypeAssignment || salary.Name != "Base Salary" || salary.Description != "Sets the base salary" {
+		t.Errorf("unexpected salary info: %+v", salary)
+	}
+
+	tax := infos[1]
+	if tax.Type != cortex.RuleTypeFormula || !slices.Equal(tax.Deps, []string{"salary"}) || tax.When != "salary > 0" {
+		t.Errorf("unexpected tax info: %+v", tax)
+	}
+	if !slices.Equal(tax.Outputs, []string{"tax"}) {
+		t.Errorf("expected tax outputs [tax], got %v", tax.Outputs)
+	}
+
+	split := infos[2]
+	if split.Type != cortex.RuleTypeAllocation || !slices.Contains(split.Outputs, "a") || !slices.Contains(split.Outputs, "b") {
+		t.Errorf("unexpected split info: %+v", split)
+	}
+
+	total := infos[3]
+	if total.Type != cortex.RuleTypeBuildup || total.Enabled {
+		t.Errorf("expected disabled buildup, got %+v", total)
+	}
+
+	custom := infos[4]
+	if custom.ID != "custom" || custom.Type != "" || custom.Outputs != nil || !custom.Enabled {
+		t.Errorf("unexpected custom info: %+v", custom)
+	}
+}
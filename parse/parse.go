@@ -4,19 +4,24 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"time"
 
 	"github.com/kolosys/cortex"
+	"github.com/kolosys/cortex/expr"
 )
 
 // Parser parses config into rules.
 type Parser struct {
-	formulas map[string]cortex.FormulaFunc
+	formulas  map[string]cortex.FormulaFunc
+	exprFuncs map[string]expr.Func
+	strict    bool
 }
 
 // NewParser creates a new parser.
 func NewParser() *Parser {
 	return &Parser{
-		formulas: make(map[string]cortex.FormulaFunc),
+		formulas:  make(map[string]cortex.FormulaFunc),
+		exprFuncs: make(map[string]expr.Func),
 	}
 }
 
@@ -25,10 +30,25 @@ func (p *Parser) RegisterFormula(name string, fn cortex.FormulaFunc) {
 	p.formulas[name] = fn
 }
 
+// RegisterExprFunc registers a function that formula expressions and When
+// guards can call, such as myfunc(x). It is registered on engines built by
+// ParseAndBuildEngine; see cortex.Engine.RegisterExprFunc for how it
+// interacts with builtins of the same name.
+func (p *Parser) RegisterExprFunc(name string, fn expr.Func) {
+	p.exprFuncs[name] = fn
+}
+
+// Strict enables or disables strict mode. In strict mode, unknown fields
+// in the rule set or in any rule config are rejected instead of ignored.
+func (p *Parser) Strict(strict bool) *Parser {
+	p.strict = strict
+	return p
+}
+
 // ParseJSON parses a rule set from JSON.
 func (p *Parser) ParseJSON(data []byte) (*RuleSet, error) {
 	var rs RuleSet
-	if err := json.Unmarshal(data, &rs); err != nil {
+	if err := decodeJSON(data, &rs, p.strict); err != nil {
 		return nil, fmt.Errorf("parse error: %w", err)
 	}
 	return &rs, nil
@@ -55,7 +75,14 @@ func (p *Parser) buildLookup(def LookupDef) (cortex.Lookup, error) {
 		if len(def.Items) == 0 {
 			return nil, fmt.Errorf("map lookup requires items")
 		}
-		return cortex.NewMapLookup(def.Name, def.Items), nil
+		lookup := cortex.NewMapLookup(def.Name, def.Items)
+		if def.CatchAll != "" {
+			if _, ok := def.Items[def.CatchAll]; !ok {
+				return nil, fmt.Errorf("catch-all key %q not in items", def.CatchAll)
+			}
+			lookup.WithCatchAll(def.CatchAll)
+		}
+		return lookup, nil
 
 	case "range":
 		if len(def.Entries) == 0 {
@@ -79,13 +106,95 @@ func (p *Parser) buildLookup(def LookupDef) (cortex.Lookup, error) {
 		}
 		return cortex.NewRangeLookup(def.Name, ranges), nil
 
+	case "threshold":
+		if len(def.Entries) == 0 {
+			return nil, fmt.Errorf("threshold lookup requires entries")
+		}
+		thresholds := make([]cortex.ThresholdEntry[float64], len(def.Entries))
+		for i, e := range def.Entries {
+			if e.Upto == nil && i < len(def.Entries)-1 {
+				return nil, fmt.Errorf("entry %d: upto is required on all but the last entry", i)
+			}
+			val, err := toFloat64(e.Value)
+			if err != nil {
+				return nil, fmt.Errorf("entry %d: %w", i, err)
+			}
+			thresholds[i].Value = val
+			if e.Upto != nil {
+				thresholds[i].Upto = *e.Upto
+			}
+		}
+		lookup, err := cortex.NewThresholdLookup(def.Name, thresholds)
+		if err != nil {
+			return nil, err
+		}
+		return lookup, nil
+
+	case "progressive":
+		if len(def.Entries) == 0 {
+			return nil, fmt.Errorf("progressive lookup requires entries")
+		}
+		brackets := make([]cortex.TaxBracket, len(def.Entries))
+		for i, e := range def.Entries {
+			max := math.Inf(1)
+			if e.Max != nil {
+				max = *e.Max
+			}
+			rate, err := toFloat64(e.Value)
+			if err != nil {
+				return nil, fmt.Errorf("entry %d: %w", i, err)
+			}
+			brackets[i] = cortex.TaxBracket{Min: e.Min, Max: max, Rate: rate}
+		}
+		return cortex.NewProgressiveTaxLookup(def.Name, brackets), nil
+
+	case "range_map":
+		if len(def.Entries) == 0 {
+			return nil, fmt.Errorf("range_map lookup requires entries")
+		}
+		entries := make([]cortex.RangeMapEntry[any], len(def.Entries))
+		for i, e := range def.Entries {
+			max := math.Inf(1)
+			if e.Max != nil {
+				max = *e.Max
+			}
+			values, ok := e.Value.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("entry %d: expected object value, got %T", i, e.Value)
+			}
+			entries[i] = cortex.RangeMapEntry[any]{Min: e.Min, Max: max, Values: values}
+		}
+		return cortex.NewRangeMapLookup(def.Name, entries), nil
+
+	case "composite":
+		if len(def.Entries) == 0 {
+			return nil, fmt.Errorf("composite lookup requires entries")
+		}
+		entries := make([]cortex.CompositeEntry[any], len(def.Entries))
+		for i, e := range def.Entries {
+			entries[i] = cortex.CompositeEntry[any]{Key: e.Key, Value: e.Value}
+		}
+		lookup, err := cortex.NewCompositeLookup(def.Name, entries)
+		if err != nil {
+			return nil, err
+		}
+		return lookup, nil
+
 	default:
 		return nil, fmt.Errorf("unknown lookup type: %s", def.Type)
 	}
 }
 
-// ToRules converts rule definitions to Rule instances.
+// ToRules converts rule definitions to Rule instances. Each formula
+// expression is compiled with its own evaluator; ParseAndBuildEngine
+// compiles them with the engine's instead.
 func (p *Parser) ToRules(rs *RuleSet) ([]cortex.Rule, error) {
+	return p.toRules(rs, nil)
+}
+
+// toRules converts rule definitions to Rule instances, compiling formula
+// expressions with ev.
+func (p *Parser) toRules(rs *RuleSet, ev *expr.Evaluator) ([]cortex.Rule, error) {
 	rules := make([]cortex.Rule, 0, len(rs.Rules))
 
 	for _, def := range rs.Rules {
@@ -93,7 +202,7 @@ func (p *Parser) ToRules(rs *RuleSet) ([]cortex.Rule, error) {
 			continue
 		}
 
-		rule, err := p.buildRule(def)
+		rule, err := p.buildRule(def, ev)
 		if err != nil {
 			return nil, fmt.Errorf("rule %q: %w", def.ID, err)
 		}
@@ -103,18 +212,24 @@ func (p *Parser) ToRules(rs *RuleSet) ([]cortex.Rule, error) {
 	return rules, nil
 }
 
-func (p *Parser) buildRule(def RuleDefinition) (cortex.Rule, error) {
+func (p *Parser) buildRule(def RuleDefinition, ev *expr.Evaluator) (cortex.Rule, error) {
 	switch def.Type {
 	case "assignment":
 		return p.buildAssignment(def)
 	case "formula":
-		return p.buildFormula(def)
+		return p.buildFormula(def, ev)
+	case "vector_formula":
+		return p.buildVectorFormula(def, ev)
 	case "lookup":
 		return p.buildLookupRule(def)
+	case "record_lookup":
+		return p.buildRecordLookup(def)
 	case "allocation":
 		return p.buildAllocation(def)
 	case "buildup":
 		return p.buildBuildup(def)
+	case "buildup_reset":
+		return p.buildBuildupReset(def)
 	default:
 		return nil, fmt.Errorf("unknown rule type: %s", def.Type)
 	}
@@ -122,7 +237,7 @@ func (p *Parser) buildRule(def RuleDefinition) (cortex.Rule, error) {
 
 func (p *Parser) buildAssignment(def RuleDefinition) (*cortex.AssignmentRule, error) {
 	var cfg AssignmentDef
-	if err := unmarshalConfig(def.Config, &cfg); err != nil {
+	if err := unmarshalConfig(def.Config, &cfg, p.strict); err != nil {
 		return nil, err
 	}
 
@@ -131,14 +246,16 @@ func (p *Parser) buildAssignment(def RuleDefinition) (*cortex.AssignmentRule, er
 		Name:        def.Name,
 		Description: def.Description,
 		Deps:        def.Deps,
+		When:        def.When,
 		Target:      cfg.Target,
 		Value:       cfg.Value,
+		ValueType:   cortex.ValueType(cfg.ValueType),
 	})
 }
 
-func (p *Parser) buildFormula(def RuleDefinition) (*cortex.FormulaRule, error) {
+func (p *Parser) buildFormula(def RuleDefinition, ev *expr.Evaluator) (*cortex.FormulaRule, error) {
 	var cfg FormulaDef
-	if err := unmarshalConfig(def.Config, &cfg); err != nil {
+	if err := unmarshalConfig(def.Config, &cfg, p.strict); err != nil {
 		return nil, err
 	}
 
@@ -147,9 +264,12 @@ func (p *Parser) buildFormula(def RuleDefinition) (*cortex.FormulaRule, error) {
 		Name:        def.Name,
 		Description: def.Description,
 		Deps:        def.Deps,
+		When:        def.When,
 		Target:      cfg.Target,
 		Inputs:      cfg.Inputs,
 		Expression:  cfg.Expression,
+		ResultType:  cortex.ValueType(cfg.ValueType),
+		Evaluator:   ev,
 	}
 
 	// Use registered function if specified
@@ -164,9 +284,28 @@ func (p *Parser) buildFormula(def RuleDefinition) (*cortex.FormulaRule, error) {
 	return cortex.NewFormula(config)
 }
 
+func (p *Parser) buildVectorFormula(def RuleDefinition, ev *expr.Evaluator) (*cortex.VectorFormulaRule, error) {
+	var cfg VectorFormulaDef
+	if err := unmarshalConfig(def.Config, &cfg, p.strict); err != nil {
+		return nil, err
+	}
+
+	return cortex.NewVectorFormula(cortex.VectorFormulaConfig{
+		ID:          def.ID,
+		Name:        def.Name,
+		Description: def.Description,
+		Deps:        def.Deps,
+		When:        def.When,
+		Target:      cfg.Target,
+		Inputs:      cfg.Inputs,
+		Expression:  cfg.Expression,
+		Evaluator:   ev,
+	})
+}
+
 func (p *Parser) buildLookupRule(def RuleDefinition) (*cortex.LookupRule, error) {
 	var cfg LookupRuleDef
-	if err := unmarshalConfig(def.Config, &cfg); err != nil {
+	if err := unmarshalConfig(def.Config, &cfg, p.strict); err != nil {
 		return nil, err
 	}
 
@@ -175,17 +314,55 @@ func (p *Parser) buildLookupRule(def RuleDefinition) (*cortex.LookupRule, error)
 		Name:        def.Name,
 		Description: def.Description,
 		Deps:        def.Deps,
+		When:        def.When,
 		Table:       cfg.Table,
 		Key:         cfg.Key,
+		Keys:        cfg.Keys,
 		Target:      cfg.Target,
 		Default:     cfg.Default,
 		Required:    cfg.Required,
+		RequiredKey: cfg.RequiredKey,
+
+		DefaultOnMissingKey: cfg.DefaultOnMissingKey,
+	})
+}
+
+func (p *Parser) buildRecordLookup(def RuleDefinition) (*cortex.RecordLookupRule, error) {
+	var cfg RecordLookupDef
+	if err := unmarshalConfig(def.Config, &cfg, p.strict); err != nil {
+		return nil, err
+	}
+
+	fields := make([]cortex.RecordField, len(cfg.Fields))
+	for i, f := range cfg.Fields {
+		ft, err := cortex.ParseFieldType(f.Type)
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = cortex.RecordField{
+			Field:    f.Field,
+			Target:   f.Target,
+			Type:     ft,
+			Required: f.Required,
+		}
+	}
+
+	return cortex.NewRecordLookup(cortex.RecordLookupConfig{
+		ID:          def.ID,
+		Name:        def.Name,
+		Description: def.Description,
+		Deps:        def.Deps,
+		When:        def.When,
+		Table:       cfg.Table,
+		Key:         cfg.Key,
+		Fields:      fields,
+		Required:    cfg.Required,
 	})
 }
 
 func (p *Parser) buildAllocation(def RuleDefinition) (*cortex.AllocationRule, error) {
 	var cfg AllocationDef
-	if err := unmarshalConfig(def.Config, &cfg); err != nil {
+	if err := unmarshalConfig(def.Config, &cfg, p.strict); err != nil {
 		return nil, err
 	}
 
@@ -193,31 +370,47 @@ func (p *Parser) buildAllocation(def RuleDefinition) (*cortex.AllocationRule, er
 	if err != nil {
 		return nil, err
 	}
+	rounding, err := cortex.ParseRoundingMethod(cfg.Rounding)
+	if err != nil {
+		return nil, err
+	}
 
 	targets := make([]cortex.AllocationTarget, len(cfg.Targets))
 	for i, t := range cfg.Targets {
 		targets[i] = cortex.AllocationTarget{
-			Key:    t.Key,
-			Amount: t.Amount,
+			Key:        t.Key,
+			Amount:     t.Amount,
+			AmountFrom: t.AmountFrom,
+			Min:        t.Min,
+			Max:        t.Max,
 		}
 	}
 
 	return cortex.NewAllocation(cortex.AllocationConfig{
-		ID:          def.ID,
-		Name:        def.Name,
-		Description: def.Description,
-		Deps:        def.Deps,
-		Source:      cfg.Source,
-		Strategy:    strategy,
-		Targets:     targets,
-		Remainder:   cfg.Remainder,
-		Precision:   cfg.Precision,
+		ID:                     def.ID,
+		Name:                   def.Name,
+		Description:            def.Description,
+		Deps:                   def.Deps,
+		When:                   def.When,
+		Source:                 cfg.Source,
+		Strategy:               strategy,
+		Targets:                targets,
+		WeightsFrom:            cfg.WeightsFrom,
+		Remainder:              cfg.Remainder,
+		Target:                 cfg.Target,
+		ShareSuffix:            cfg.ShareSuffix,
+		Precision:              cfg.Precision,
+		Rounding:               rounding,
+		IntegerOnly:            cfg.IntegerOnly,
+		AbsorbRemainder:        cfg.AbsorbRemainder,
+		WriteRemainderToSource: cfg.WriteRemainderToSource,
+		RequiredTargets:        cfg.RequiredTargets,
 	})
 }
 
 func (p *Parser) buildBuildup(def RuleDefinition) (*cortex.BuildupRule, error) {
 	var cfg BuildupDef
-	if err := unmarshalConfig(def.Config, &cfg); err != nil {
+	if err := unmarshalConfig(def.Config, &cfg, p.strict); err != nil {
 		return nil, err
 	}
 
@@ -227,15 +420,36 @@ func (p *Parser) buildBuildup(def RuleDefinition) (*cortex.BuildupRule, error) {
 	}
 
 	return cortex.NewBuildup(cortex.BuildupConfig{
+		ID:            def.ID,
+		Name:          def.Name,
+		Description:   def.Description,
+		Deps:          def.Deps,
+		When:          def.When,
+		Buildup:       cfg.Buildup,
+		Operation:     op,
+		Source:        cfg.Source,
+		Initial:       cfg.Initial,
+		Target:        cfg.Target,
+		Quantile:      cfg.Quantile,
+		MaxSamples:    cfg.MaxSamples,
+		DistinctLimit: cfg.DistinctLimit,
+	})
+}
+
+func (p *Parser) buildBuildupReset(def RuleDefinition) (*cortex.BuildupResetRule, error) {
+	var cfg BuildupResetDef
+	if err := unmarshalConfig(def.Config, &cfg, p.strict); err != nil {
+		return nil, err
+	}
+
+	return cortex.NewBuildupReset(cortex.BuildupResetConfig{
 		ID:          def.ID,
 		Name:        def.Name,
 		Description: def.Description,
 		Deps:        def.Deps,
+		When:        def.When,
 		Buildup:     cfg.Buildup,
-		Operation:   op,
-		Source:      cfg.Source,
 		Initial:     cfg.Initial,
-		Target:      cfg.Target,
 	})
 }
 
@@ -262,30 +476,79 @@ func ParseAndBuild(name string, data []byte, config *cortex.Config) (*cortex.Eng
 	return parser.ParseAndBuildEngine(name, data, config)
 }
 
-// ParseAndBuildEngine parses JSON and builds an Engine.
+// ParseAndBuildEngine parses JSON and builds an Engine. Formula
+// expressions are compiled with the engine's evaluator, so functions
+// registered with RegisterExprFunc are shared by all of them.
 func (p *Parser) ParseAndBuildEngine(name string, data []byte, config *cortex.Config) (*cortex.Engine, error) {
+	engine, _, err := p.ParseAndBuildEngineWithStats(name, data, config)
+	return engine, err
+}
+
+// BuildStats describes the work done building an engine from config.
+type BuildStats struct {
+	Rules           int           // rules added (disabled rules excluded)
+	Lookups         int           // lookup tables registered
+	Expressions     int           // formula expressions compiled
+	CompileDuration time.Duration // total time compiling expressions
+	Duration        time.Duration // total build time, including parsing
+}
+
+// ParseAndBuildEngineWithStats parses JSON and builds an Engine, also
+// returning statistics to help diagnose slow startup.
+func (p *Parser) ParseAndBuildEngineWithStats(name string, data []byte, config *cortex.Config) (*cortex.Engine, BuildStats, error) {
+	var stats BuildStats
+	start := time.Now()
+
 	rs, err := p.ParseJSON(data)
 	if err != nil {
-		return nil, err
+		return nil, stats, err
 	}
 
 	engine := cortex.New(name, config)
+	for fname, fn := range p.exprFuncs {
+		if err := engine.RegisterExprFunc(fname, fn); err != nil {
+			return nil, stats, err
+		}
+	}
 
 	lookups, err := p.ToLookups(rs)
 	if err != nil {
-		return nil, err
+		return nil, stats, err
 	}
 	if err := engine.RegisterLookups(lookups...); err != nil {
-		return nil, err
+		return nil, stats, err
 	}
 
-	rules, err := p.ToRules(rs)
+	rules, err := p.toRules(rs, engine.Evaluator())
 	if err != nil {
-		return nil, err
+		return nil, stats, err
 	}
 	if err := engine.AddRules(rules...); err != nil {
-		return nil, err
+		return nil, stats, err
+	}
+	for _, def := range rs.Rules {
+		if def.Group == "" || def.Disabled {
+			continue
+		}
+		if err := engine.SetRuleGroup(def.ID, def.Group); err != nil {
+			return nil, stats, err
+		}
+	}
+	for _, group := range rs.DisabledGroups {
+		if err := engine.SetGroupEnabled(group, false); err != nil {
+			return nil, stats, err
+		}
+	}
+
+	stats.Rules = len(rules)
+	stats.Lookups = len(lookups)
+	for _, rule := range rules {
+		if f, ok := rule.(*cortex.FormulaRule); ok && f.Expression() != "" {
+			stats.Expressions++
+			stats.CompileDuration += f.CompileDuration()
+		}
 	}
+	stats.Duration = time.Since(start)
 
-	return engine, nil
+	return engine, stats, nil
 }
@@ -2,6 +2,8 @@ package parse_test
 
 import (
 	"context"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/kolosys/cortex"
@@ -310,3 +312,548 @@ func TestParseErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestRecordLookupRule(t *testing.T) {
+	data := `{
+		"lookups": [
+			{
+				"name": "employees",
+				"type": "map",
+				"items": {
+					"e1": {"salary": 85000, "title": "Engineer", "exempt": true}
+				}
+			}
+		],
+		"rules": [
+			{"id": "set-id", "type": "assignment", "config": {"target": "employee_id", "value": "e1"}},
+			{
+				"id": "load-employee",
+				"type": "record_lookup",
+				"config": {
+					"table": "employees",
+					"key": "employee_id",
+					"required": true,
+					"fields": [
+						{"field": "salary", "target": "salary", "type": "float", "required": true},
+						{"field": "title", "target": "title", "type": "string"},
+						{"field": "exempt", "target": "exempt", "type": "bool"}
+					]
+				}
+			}
+		]
+	}`
+
+	engine, err := parse.ParseAndBuild("test", []byte(data), nil)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+
+	evalCtx := cortex.NewEvalContext()
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("evaluation error: %v", err)
+	}
+
+	if salary, _ := evalCtx.GetFloat64("salary"); salary != 85000 {
+		t.Errorf("expected salary=85000, got %v", salary)
+	}
+	if title, _ := evalCtx.GetString("title"); title != "Engineer" {
+		t.Errorf("expected title=Engineer, got %q", title)
+	}
+	if exempt, _ := evalCtx.GetBool("exempt"); !exempt {
+		t.Error("expected exempt=true")
+	}
+}
+
+func TestWhenGuard(t *testing.T) {
+	data := `{
+		"rules": [
+			{"id": "set-tier", "type": "assignment", "config": {"target": "tier", "value": "silver"}},
+			{"id": "gold-bonus", "type": "assignment", "when": "tier == \"gold\"", "config": {"target": "bonus", "value": 100}},
+			{"id": "silver-bonus", "type": "assignment", "when": "tier == \"silver\"", "config": {"target": "bonus", "value": 50}}
+		]
+	}`
+
+	engine, err := parse.ParseAndBuild("test", []byte(data), nil)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+
+	evalCtx := cortex.NewEvalContext()
+	result, err := engine.Evaluate(context.Background(), evalCtx)
+	if err != nil {
+		t.Fatalf("evaluation error: %v", err)
+	}
+	if bonus, _ := evalCtx.GetFloat64("bonus"); bonus != 50 {
+		t.Errorf("expected bonus=50, got %v", bonus)
+	}
+	if result.RulesSkipped != 1 {
+		t.Errorf("expected 1 skipped rule, got %d", result.RulesSkipped)
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	data := `{
+		"rules": [
+			{"id": "calc", "type": "formula", "config": {"target": "x", "expresion": "1 + 2"}}
+		]
+	}`
+
+	// Lenient mode ignores the typo and fails later on the missing expression.
+	_, err := parse.NewParser().ParseAndBuildEngine("test", []byte(data), nil)
+	if err == nil || strings.Contains(err.Error(), "expresion") {
+		t.Errorf("expected lenient mode to ignore the unknown field, got %v", err)
+	}
+
+	_, err = parse.NewParser().Strict(true).ParseAndBuildEngine("test", []byte(data), nil)
+	if err == nil || !strings.Contains(err.Error(), `"expresion"`) {
+		t.Errorf("expected strict mode to name the unknown field, got %v", err)
+	}
+}
+
+func TestStrictModeRuleSet(t *testing.T) {
+	data := `{"rules": [{"id": "x", "type": "assignment", "dependson": ["y"], "config": {"target": "x", "value": 1}}]}`
+
+	if _, err := parse.NewParser().ParseJSON([]byte(data)); err != nil {
+		t.Errorf("unexpected error in lenient mode: %v", err)
+	}
+	_, err := parse.NewParser().Strict(true).ParseJSON([]byte(data))
+	if err == nil || !strings.Contains(err.Error(), `"dependson"`) {
+		t.Errorf("expected strict mode to name the unknown field, got %v", err)
+	}
+}
+
+func TestProgressiveLookup(t *testing.T) {
+	data := `{
+		"lookups": [
+			{
+				"name": "income_tax",
+				"type": "progressive",
+				"entries": [
+					{"min": 0, "max": 50000, "value": 0.10},
+					{"min": 50000, "max": null, "value": 0.20}
+				]
+			}
+		],
+		"rules": [
+			{"id": "set-salary", "type": "assignment", "config": {"target": "salary", "value": 80000}},
+			{"id": "tax", "type": "lookup", "config": {"table": "income_tax", "key": "salary", "target": "tax"}}
+		]
+	}`
+
+	engine, err := parse.ParseAndBuild("test", []byte(data), nil)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+
+	evalCtx := cortex.NewEvalContext()
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("evaluation error: %v", err)
+	}
+	if tax, _ := evalCtx.GetFloat64("tax"); tax != 11000 {
+		t.Errorf("expected tax=11000, got %v", tax)
+	}
+}
+
+func TestThresholdLookup(t *testing.T) {
+	data := `{
+		"lookups": [
+			{
+				"name": "rates",
+				"type": "threshold",
+				"entries": [
+					{"upto": 50000, "value": 0.10},
+					{"upto": 100000, "value": 0.22},
+					{"value": 0.32}
+				]
+			}
+		],
+		"rules": [
+			{"id": "rate", "type": "lookup", "config": {"table": "rates", "key": "income", "target": "rate"}}
+		]
+	}`
+
+	engine, err := parse.ParseAndBuild("test", []byte(data), nil)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+
+	for income, expected := range map[float64]float64{-10: 0.10, 50000: 0.22, 99999: 0.22, 100000: 0.32, 1e9: 0.32} {
+		evalCtx := cortex.NewEvalContext()
+		evalCtx.Set("income", income)
+		if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+			t.Fatalf("evaluation error: %v", err)
+		}
+		if rate, _ := evalCtx.GetFloat64("rate"); rate != expected {
+			t.Errorf("income %v: expected rate=%v, got %v", income, expected, rate)
+		}
+	}
+
+	missing := `{"lookups": [{"name": "rates", "type": "threshold", "entries": [{"value": 1}, {"value": 2}]}], "rules": []}`
+	if _, err := parse.ParseAndBuild("test", []byte(missing), nil); err == nil || !strings.Contains(err.Error(), "upto") {
+		t.Errorf("expected missing upto error, got %v", err)
+	}
+}
+
+func TestBuildupResetRule(t *testing.T) {
+	data := `{
+		"rules": [
+			{"id": "add-a", "type": "buildup", "config": {"buildup": "total", "operation": "sum", "source": "a"}},
+			{"id": "add-b", "type": "buildup", "config": {"buildup": "total", "operation": "sum", "source": "b", "target": "group1"}},
+			{"id": "reset", "type": "buildup_reset", "config": {"buildup": "total"}},
+			{"id": "add-c", "type": "buildup", "config": {"buildup": "total", "operation": "sum", "source": "c", "target": "group2"}}
+		]
+	}`
+
+	engine, err := parse.ParseAndBuild("test", []byte(data), nil)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("a", 1.0)
+	evalCtx.Set("b", 2.0)
+	evalCtx.Set("c", 5.0)
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("evaluation error: %v", err)
+	}
+	if v, _ := evalCtx.GetFloat64("group1"); v != 3 {
+		t.Errorf("expected group1=3, got %v", v)
+	}
+	if v, _ := evalCtx.GetFloat64("group2"); v != 5 {
+		t.Errorf("expected group2=5, got %v", v)
+	}
+}
+
+func TestMapLookupCatchAll(t *testing.T) {
+	data := `{
+		"lookups": [
+			{"name": "rates", "type": "map", "catch_all": "*", "items": {"food": 0.05, "*": 0.10}}
+		],
+		"rules": [
+			{"id": "rate", "type": "lookup", "config": {"table": "rates", "key": "category", "target": "rate", "default": 0}}
+		]
+	}`
+
+	engine, err := parse.ParseAndBuild("test", []byte(data), nil)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("category", "books")
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("evaluation error: %v", err)
+	}
+	if rate, _ := evalCtx.GetFloat64("rate"); rate != 0.10 {
+		t.Errorf("expected catch-all rate 0.10, got %v", rate)
+	}
+
+	bad := `{"lookups": [{"name": "rates", "type": "map", "catch_all": "*", "items": {"food": 0.05}}], "rules": []}`
+	if _, err := parse.ParseAndBuild("test", []byte(bad), nil); err == nil {
+		t.Error("expected error for catch-all key missing from items")
+	}
+}
+
+func TestCompositeLookup(t *testing.T) {
+	data := `{
+		"lookups": [
+			{
+				"name": "rates",
+				"type": "composite",
+				"entries": [
+					{"key": ["us", "widget"], "value": 0.10},
+					{"key": ["eu", "widget"], "value": 0.20}
+				]
+			}
+		],
+		"rules": [
+			{"id": "rate", "type": "lookup", "config": {"table": "rates", "keys": ["region", "product"], "target": "rate"}}
+		]
+	}`
+
+	engine, err := parse.ParseAndBuild("test", []byte(data), nil)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.SetAll(map[string]any{"region": "eu", "product": "widget"})
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("evaluation error: %v", err)
+	}
+	if rate, _ := evalCtx.GetFloat64("rate"); rate != 0.20 {
+		t.Errorf("expected rate=0.20, got %v", rate)
+	}
+}
+
+func TestParseAndBuildEngineWithStats(t *testing.T) {
+	data := `{
+		"lookups": [
+			{"name": "rates", "type": "map", "items": {"a": 0.1}}
+		],
+		"rules": [
+			{"id": "base", "type": "assignment", "config": {"target": "x", "value": 2}},
+			{"id": "double", "type": "formula", "config": {"target": "y", "expression": "x * 2"}},
+			{"id": "total", "type": "formula", "config": {"target": "z", "expression": "round(x + y * 1.5, 2)"}},
+			{"id": "off", "type": "formula", "disabled": true, "config": {"target": "w", "expression": "x"}}
+		]
+	}`
+
+	engine, stats, err := parse.NewParser().ParseAndBuildEngineWithStats("test", []byte(data), nil)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+	if engine == nil {
+		t.Fatal("expected engine")
+	}
+	if stats.Rules != 3 {
+		t.Errorf("expected 3 rules, got %d", stats.Rules)
+	}
+	if stats.Lookups != 1 {
+		t.Errorf("expected 1 lookup, got %d", stats.Lookups)
+	}
+	if stats.Expressions != 2 {
+		t.Errorf("expected 2 expressions, got %d", stats.Expressions)
+	}
+	if stats.CompileDuration <= 0 || stats.Duration < stats.CompileDuration {
+		t.Errorf("unexpected durations: compile=%v total=%v", stats.CompileDuration, stats.Duration)
+	}
+}
+
+func TestRangeMapLookup(t *testing.T) {
+	data := `{
+		"lookups": [
+			{
+				"name": "rates",
+				"type": "range_map",
+				"entries": [
+					{"min": 0, "max": 50000, "value": {"single": 0.10, "joint": 0.08}},
+					{"min": 50000, "value": {"single": 0.22, "joint": 0.18}}
+				]
+			}
+		],
+		"rules": [
+			{"id": "rate", "type": "lookup", "config": {"table": "rates", "keys": ["income", "status"], "target": "rate"}}
+		]
+	}`
+
+	engine, err := parse.ParseAndBuild("test", []byte(data), nil)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.SetAll(map[string]any{"income": 20000.0, "status": "joint"})
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("evaluation error: %v", err)
+	}
+	if rate, _ := evalCtx.GetFloat64("rate"); rate != 0.08 {
+		t.Errorf("expected rate=0.08, got %v", rate)
+	}
+
+	bad := strings.Replace(data, `"value": {"single": 0.10, "joint": 0.08}`, `"value": 0.1`, 1)
+	if _, err := parse.ParseAndBuild("test", []byte(bad), nil); err == nil {
+		t.Error("expected error for non-object range_map value")
+	}
+}
+
+func TestParserRegisterExprFunc(t *testing.T) {
+	data := `{
+		"rules": [
+			{"id": "x", "type": "assignment", "config": {"target": "x", "value": 4}},
+			{"id": "y", "type": "formula", "config": {"target": "y", "expression": "triple(x) + 1"}}
+		]
+	}`
+
+	parser := parse.NewParser()
+	parser.RegisterExprFunc("triple", func(args ...any) (any, error) {
+		return args[0].(float64) * 3, nil
+	})
+	engine, err := parser.ParseAndBuildEngine("test", []byte(data), nil)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+
+	evalCtx := cortex.NewEvalContext()
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("evaluate error: %v", err)
+	}
+	if y, _ := evalCtx.GetFloat64("y"); y != 13 {
+		t.Errorf("expected y=13, got %v", y)
+	}
+}
+
+func TestParseAndBuildEngineSharesEvaluator(t *testing.T) {
+	data := `{
+		"rules": [
+			{"id": "a", "type": "formula", "config": {"target": "a", "expression": "scale(x)"}},
+			{"id": "b", "type": "formula", "config": {"target": "b", "expression": "scale(a) - 1"}, "deps": ["a"]}
+		]
+	}`
+
+	engine, err := parse.ParseAndBuild("test", []byte(data), nil)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+	// Registering on the engine's evaluator alone reaches both formulas.
+	engine.Evaluator().RegisterFunc("scale", func(args ...any) (any, error) {
+		return args[0].(float64) * 10, nil
+	})
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("x", 2.0)
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("evaluate error: %v", err)
+	}
+	if a, _ := evalCtx.GetFloat64("a"); a != 20 {
+		t.Errorf("expected a=20, got %v", a)
+	}
+	if b, _ := evalCtx.GetFloat64("b"); b != 199 {
+		t.Errorf("expected b=199, got %v", b)
+	}
+}
+
+func TestValueTypeCoercion(t *testing.T) {
+	data := `{
+		"rules": [
+			{"id": "i", "type": "assignment", "config": {"target": "i", "value": 1, "value_type": "int"}},
+			{"id": "f", "type": "assignment", "config": {"target": "f", "value": "2.5", "value_type": "float"}},
+			{"id": "s", "type": "assignment", "config": {"target": "s", "value": 100, "value_type": "string"}},
+			{"id": "b", "type": "assignment", "config": {"target": "b", "value": "true", "value_type": "bool"}},
+			{"id": "n", "type": "formula", "config": {"target": "n", "expression": "f * 4", "value_type": "int"}}
+		]
+	}`
+
+	engine, err := parse.ParseAndBuild("test", []byte(data), nil)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+	evalCtx := cortex.NewEvalContext()
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("evaluate error: %v", err)
+	}
+
+	expected := map[string]any{"i": 1, "f": 2.5, "s": "100", "b": true, "n": 10}
+	for key, want := range expected {
+		if got, _ := evalCtx.Get(key); got != want {
+			t.Errorf("%s: expected %v (%T), got %v (%T)", key, want, want, got, got)
+		}
+	}
+	if i, err := evalCtx.GetInt("i"); err != nil || i != 1 {
+		t.Errorf("GetInt: expected 1, got %v (%v)", i, err)
+	}
+
+	invalid := map[string]string{
+		"fractional int": `{"target": "x", "value": 1.5, "value_type": "int"}`,
+		"non-bool":       `{"target": "x", "value": "maybe", "value_type": "bool"}`,
+		"unknown type":   `{"target": "x", "value": 1, "value_type": "decimal"}`,
+	}
+	for name, cfg := range invalid {
+		data := `{"rules": [{"id": "x", "type": "assignment", "config": ` + cfg + `}]}`
+		_, err := parse.ParseAndBuild("test", []byte(data), nil)
+		if err == nil || !strings.Contains(err.Error(), `rule "x"`) {
+			t.Errorf("%s: expected an error naming the rule, got %v", name, err)
+		}
+	}
+}
+
+func TestAllocationRounding(t *testing.T) {
+	build := func(rounding string) (*cortex.EvalContext, error) {
+		data := `{"rules": [{"id": "split", "type": "allocation", "config": {
+			"source": "total", "strategy": "equal", "rounding": "` + rounding + `", "remainder": "leftover",
+			"targets": [{"key": "a"}, {"key": "b"}, {"key": "c"}]
+		}}]}`
+		engine, err := parse.ParseAndBuild("test", []byte(data), nil)
+		if err != nil {
+			return nil, err
+		}
+		evalCtx := cortex.NewEvalContext()
+		evalCtx.Set("total", 100.0)
+		_, err = engine.Evaluate(context.Background(), evalCtx)
+		return evalCtx, err
+	}
+
+	evalCtx, err := build("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a, _ := evalCtx.GetFloat64("a"); a != 33.34 || evalCtx.Has("leftover") {
+		t.Errorf("expected largest remainder by default, got a=%v", a)
+	}
+
+	evalCtx, err = build("round_down")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a, _ := evalCtx.GetFloat64("a"); a != 33.33 || !evalCtx.Has("leftover") {
+		t.Errorf("expected independent rounding, got a=%v", a)
+	}
+
+	if _, err := build("banker"); err == nil {
+		t.Error("expected error for unknown rounding method")
+	}
+}
+
+func TestAllocationMapTarget(t *testing.T) {
+	data := `{"rules": [{"id": "split", "type": "allocation", "config": {
+		"source": "total", "strategy": "weighted", "target": "allocations",
+		"targets": [{"key": "a", "amount": 1}, {"key": "b", "amount": 3}]
+	}}]}`
+	engine, err := parse.ParseAndBuild("test", []byte(data), nil)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("total", 100.0)
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("evaluate error: %v", err)
+	}
+	want := map[string]float64{"a": 25, "b": 75}
+	if got, _ := evalCtx.Get("allocations"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestRuleGroups(t *testing.T) {
+	data := `{"disabled_groups": ["experimental"], "rules": [
+		{"id": "base", "type": "assignment", "config": {"target": "base", "value": 1}},
+		{"id": "promo", "type": "assignment", "group": "experimental", "config": {"target": "promo", "value": 2}}
+	]}`
+	engine, err := parse.ParseAndBuild("test", []byte(data), nil)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+	if engine.RuleGroup("promo") != "experimental" || engine.GroupEnabled("experimental") {
+		t.Fatal("expected promo in the disabled experimental group")
+	}
+
+	evalCtx := cortex.NewEvalContext()
+	result, err := engine.Evaluate(context.Background(), evalCtx)
+	if err != nil {
+		t.Fatalf("evaluate error: %v", err)
+	}
+	if !evalCtx.Has("base") || evalCtx.Has("promo") || result.RulesSkipped != 1 {
+		t.Errorf("expected promo to be skipped, got keys %v", evalCtx.Keys())
+	}
+}
+
+func TestVectorFormulaRule(t *testing.T) {
+	data := `{"rules": [
+		{"id": "p", "type": "vector_formula", "config": {"target": "p", "expression": "a * b"}}
+	]}`
+	engine, err := parse.ParseAndBuild("test", []byte(data), nil)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("a", []any{1.0, 2.0})
+	evalCtx.Set("b", []any{3.0, 4.0})
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("evaluate error: %v", err)
+	}
+	if p, _ := evalCtx.Get("p"); !reflect.DeepEqual(p, []float64{3, 8}) {
+		t.Errorf("expected [3 8], got %v", p)
+	}
+}
@@ -1,7 +1,11 @@
 // Package parse provides JSON parsing for cortex rule definitions.
 package parse
 
-import "encoding/json"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
 
 // RuleSet represents a collection of rules from config.
 type RuleSet struct {
@@ -9,38 +13,50 @@ type RuleSet struct {
 	Name    string           `json:"name"`
 	Lookups []LookupDef      `json:"lookups,omitempty"`
 	Rules   []RuleDefinition `json:"rules"`
+
+	// DisabledGroups lists rule groups whose rules are skipped.
+	DisabledGroups []string `json:"disabled_groups,omitempty"`
 }
 
 // LookupDef defines a lookup table in config.
 type LookupDef struct {
 	Name    string         `json:"name"`
-	Type    string         `json:"type"` // "map" or "range"
+	Type    string         `json:"type"` // "map", "range", "threshold", "range_map", "progressive" or "composite"
 	Entries []LookupEntry  `json:"entries,omitempty"`
 	Items   map[string]any `json:"items,omitempty"` // for map type
+
+	// CatchAll names the map item used when a key is absent (e.g. "*").
+	CatchAll string `json:"catch_all,omitempty"`
 }
 
-// LookupEntry defines a single entry in a range lookup.
+// LookupEntry defines a single entry in a range or composite lookup. For
+// range_map lookups, Value is an object keyed by the second key.
 type LookupEntry struct {
 	Min   float64  `json:"min"`
-	Max   *float64 `json:"max"` // nil means +infinity
+	Max   *float64 `json:"max"`            // nil means +infinity
+	Upto  *float64 `json:"upto,omitempty"` // for threshold type; omit on the last entry
+	Key   []any    `json:"key,omitempty"`  // for composite type
 	Value any      `json:"value"`
 }
 
 // RuleDefinition is a config-driven rule.
 type RuleDefinition struct {
 	ID          string         `json:"id"`
-	Type        string         `json:"type"` // assignment, formula, allocation, lookup, buildup
+	Type        string         `json:"type"` // assignment, formula, vector_formula, allocation, lookup, record_lookup, buildup, buildup_reset
 	Name        string         `json:"name,omitempty"`
 	Description string         `json:"description,omitempty"`
 	Deps        []string       `json:"deps,omitempty"`
 	Disabled    bool           `json:"disabled,omitempty"`
+	Group       string         `json:"group,omitempty"` // see cortex.Engine.SetRuleGroup
+	When        string         `json:"when,omitempty"`  // guard expression; skip the rule when false
 	Config      map[string]any `json:"config"`
 }
 
 // AssignmentDef is the config structure for assignment rules.
 type AssignmentDef struct {
-	Target string `json:"target"`
-	Value  any    `json:"value"`
+	Target    string `json:"target"`
+	Value     any    `json:"value"`
+	ValueType string `json:"value_type,omitempty"` // int, float, string or bool
 }
 
 // FormulaDef is the config structure for formula rules.
@@ -49,46 +65,108 @@ type FormulaDef struct {
 	Expression string   `json:"expression,omitempty"`
 	Function   string   `json:"function,omitempty"` // named registered function
 	Inputs     []string `json:"inputs,omitempty"`
+	ValueType  string   `json:"value_type,omitempty"` // converts the result: int, float, string or bool
+}
+
+// VectorFormulaDef is the config structure for vector formula rules.
+type VectorFormulaDef struct {
+	Target     string   `json:"target"`
+	Expression string   `json:"expression"`
+	Inputs     []string `json:"inputs,omitempty"` // slice-valued keys; defaults to all expression variables
 }
 
 // LookupRuleDef is the config structure for lookup rules.
 type LookupRuleDef struct {
-	Table    string `json:"table"`
-	Key      string `json:"key"`
+	Table       string   `json:"table"`
+	Key         string   `json:"key,omitempty"`
+	Keys        []string `json:"keys,omitempty"` // composite key
+	Target      string   `json:"target"`
+	Default     any      `json:"default,omitempty"`
+	Required    bool     `json:"required,omitempty"`     // error if the key is not in the table
+	RequiredKey bool     `json:"required_key,omitempty"` // error if the key is missing from the context
+
+	DefaultOnMissingKey bool `json:"default_on_missing_key,omitempty"` // store default if the key is missing from the context
+}
+
+// RecordLookupDef is the config structure for record lookup rules.
+type RecordLookupDef struct {
+	Table    string           `json:"table"`
+	Key      string           `json:"key"`
+	Fields   []RecordFieldDef `json:"fields"`
+	Required bool             `json:"required,omitempty"`
+}
+
+// RecordFieldDef maps a record field to a context key.
+type RecordFieldDef struct {
+	Field    string `json:"field"`
 	Target   string `json:"target"`
-	Default  any    `json:"default,omitempty"`
+	Type     string `json:"type,omitempty"` // any, float, string, bool
 	Required bool   `json:"required,omitempty"`
 }
 
 // AllocationDef is the config structure for allocation rules.
 type AllocationDef struct {
-	Source    string             `json:"source"`
-	Strategy  string             `json:"strategy"`
-	Targets   []AllocationTarget `json:"targets"`
-	Remainder string             `json:"remainder,omitempty"`
-	Precision int                `json:"precision,omitempty"`
+	Source                 string             `json:"source"`
+	Strategy               string             `json:"strategy"` // percentage, fixed, weighted, equal, ratio or waterfall
+	Targets                []AllocationTarget `json:"targets"`
+	WeightsFrom            string             `json:"weights_from,omitempty"`
+	Remainder              string             `json:"remainder,omitempty"`
+	Target                 string             `json:"target,omitempty"`       // also receives all allocations as a map
+	ShareSuffix            string             `json:"share_suffix,omitempty"` // per-target {amount, share} under key + suffix
+	Precision              int                `json:"precision,omitempty"`
+	Rounding               string             `json:"rounding,omitempty"` // largest_remainder (default) or round_down
+	IntegerOnly            bool               `json:"integer_only,omitempty"`
+	AbsorbRemainder        bool               `json:"absorb_remainder,omitempty"` // waterfall only
+	WriteRemainderToSource bool               `json:"write_remainder_to_source,omitempty"`
+	RequiredTargets        []string           `json:"required_targets,omitempty"`
 }
 
 // AllocationTarget defines an allocation destination.
 type AllocationTarget struct {
-	Key    string  `json:"key"`
-	Amount float64 `json:"amount"`
+	Key        string  `json:"key"`
+	Amount     float64 `json:"amount"`
+	AmountFrom string  `json:"amount_from,omitempty"`
+	Min        float64 `json:"min,omitempty"`
+	Max        float64 `json:"max,omitempty"`
 }
 
 // BuildupDef is the config structure for buildup rules.
 type BuildupDef struct {
-	Buildup   string  `json:"buildup"`
-	Operation string  `json:"operation"`
-	Source    string  `json:"source,omitempty"`
-	Initial   float64 `json:"initial,omitempty"`
-	Target    string  `json:"target,omitempty"`
+	Buildup       string  `json:"buildup"`
+	Operation     string  `json:"operation"` // sum, min, max, avg, count, product, variance, stddev, percentile, collect, distinct_count, first or last
+	Source        string  `json:"source,omitempty"`
+	Initial       float64 `json:"initial,omitempty"`
+	Target        string  `json:"target,omitempty"`
+	Quantile      float64 `json:"quantile,omitempty"`       // percentile only, default 0.5
+	MaxSamples    int     `json:"max_samples,omitempty"`    // percentile only, 0 = exact
+	DistinctLimit int     `json:"distinct_limit,omitempty"` // distinct_count only, 0 = exact
+}
+
+// BuildupResetDef is the config structure for buildup reset rules.
+type BuildupResetDef struct {
+	Buildup string  `json:"buildup"`
+	Initial float64 `json:"initial,omitempty"`
 }
 
-// unmarshalConfig unmarshals a map into a struct.
-func unmarshalConfig(cfg map[string]any, target any) error {
+// unmarshalConfig unmarshals a map into a struct. In strict mode, keys
+// that do not match a field of target are an error.
+func unmarshalConfig(cfg map[string]any, target any, strict bool) error {
 	data, err := json.Marshal(cfg)
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(data, target)
+	if err := decodeJSON(data, target, strict); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	return nil
+}
+
+// decodeJSON decodes data into target, rejecting unknown fields if strict.
+func decodeJSON(data []byte, target any, strict bool) error {
+	if !strict {
+		return json.Unmarshal(data, target)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(target)
 }
@@ -0,0 +1,282 @@
+package cortex
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// FieldType is the type a record field is converted to.
+type FieldType int
+
+const (
+	// FieldAny stores the field value unchanged.
+	FieldAny FieldType = iota
+
+	// FieldFloat converts the field to float64.
+	FieldFloat
+
+	// FieldString converts the field to string.
+	FieldString
+
+	// FieldBool converts the field to bool.
+	FieldBool
+)
+
+func (t FieldType) String() string {
+	switch t {
+	case FieldAny:
+		return "any"
+	case FieldFloat:
+		return "float"
+	case FieldString:
+		return "string"
+	case FieldBool:
+		return "bool"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseFieldType parses a string into a FieldType.
+func ParseFieldType(s string) (FieldType, error) {
+	switch s {
+	case "", "any":
+		return FieldAny, nil
+	case "float", "number":
+		return FieldFloat, nil
+	case "string":
+		return FieldString, nil
+	case "bool":
+		return FieldBool, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown field type %q", ErrInvalidRule, s)
+	}
+}
+
+// RecordField maps a single field of a lookup record to a context key.
+type RecordField struct {
+	Field    string    // field name in the record
+	Target   string    // context key to set
+	Type     FieldType // conversion applied before setting
+	Required bool      // error if the field is missing
+}
+
+// RecordLookupRule looks up a record and unpacks its fields into
+// several context keys.
+type RecordLookupRule struct {
+	baseRule
+	table     string
+	keySource string
+	fields    []RecordField
+	required  bool
+}
+
+// RecordLookupConfig configures a record lookup rule.
+type RecordLookupConfig struct {
+	ID          string
+	Name        string
+	Description string
+	Deps        []string
+	When        string // guard expression; the rule is skipped when it is false
+
+	// Table is the lookup table name (must be registered).
+	Table string
+
+	// Key is the context key to use as lookup key.
+	Key string
+
+	// Fields are the record fields to unpack.
+	Fields []RecordField
+
+	// Required causes an error if the lookup key is not found.
+	Required bool
+}
+
+// NewRecordLookup creates a new record lookup rule.
+func NewRecordLookup(cfg RecordLookupConfig) (*RecordLookupRule, error) {
+	if cfg.ID == "" {
+		return nil, fmt.Errorf("%w: record lookup rule requires ID", ErrInvalidRule)
+	}
+	if cfg.Table == "" {
+		return nil, fmt.Errorf("%w: record lookup rule %q requires table", ErrInvalidRule, cfg.ID)
+	}
+	if cfg.Key == "" {
+		return nil, fmt.Errorf("%w: record lookup rule %q requires key", ErrInvalidRule, cfg.ID)
+	}
+	if len(cfg.Fields) == 0 {
+		return nil, fmt.Errorf("%w: record lookup rule %q requires at least one field", ErrInvalidRule, cfg.ID)
+	}
+	for _, f := range cfg.Fields {
+		if f.Field == "" || f.Target == "" {
+			return nil, fmt.Errorf("%w: record lookup rule %q fields require field and target", ErrInvalidRule, cfg.ID)
+		}
+	}
+
+	when, err := compileWhen(cfg.ID, cfg.When)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecordLookupRule{
+		baseRule: baseRule{
+			id:          cfg.ID,
+			name:        cfg.Name,
+			description: cfg.Description,
+			deps:        cfg.Deps,
+			when:        when,
+		},
+		table:     cfg.Table,
+		keySource: cfg.Key,
+		fields:    cfg.Fields,
+		required:  cfg.Required,
+	}, nil
+}
+
+// MustRecordLookup creates a new record lookup rule, panicking on error.
+func MustRecordLookup(cfg RecordLookupConfig) *RecordLookupRule {
+	r, err := NewRecordLookup(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Evaluate looks up the record and sets each configured field.
+func (r *RecordLookupRule) Evaluate(ctx context.Context, evalCtx *EvalContext) error {
+	key, ok := evalCtx.Get(r.keySource)
+	if !ok {
+		return NewRuleError(r.id, string(RuleTypeRecordLookup), "evaluate",
+			fmt.Errorf("%w: %s", ErrValueNotFound, r.keySource))
+	}
+
+	record, found, err := evalCtx.Lookup(r.table, key)
+	if err != nil {
+		return NewRuleError(r.id, string(RuleTypeRecordLookup), "evaluate", err)
+	}
+	if !found {
+		if r.required {
+			return NewRuleError(r.id, string(RuleTypeRecordLookup), "evaluate",
+				fmt.Errorf("%w: %v in table %s", ErrKeyNotFound, key, r.table))
+		}
+		return nil
+	}
+
+	// Convert all fields before setting any so a failure leaves no partial output.
+	type fieldValue struct {
+		target string
+		value  any
+	}
+	values := make([]fieldValue, 0, len(r.fields))
+	for _, f := range r.fields {
+		v, ok := recordField(record, f.Field)
+		if !ok {
+			if f.Required {
+				return NewRuleError(r.id, string(RuleTypeRecordLookup), "evaluate",
+					fmt.Errorf("%w: field %q in record %v", ErrValueNotFound, f.Field, key))
+			}
+			continue
+		}
+		converted, err := convertField(v, f.Type)
+		if err != nil {
+			return NewRuleError(r.id, string(RuleTypeRecordLookup), "evaluate",
+				fmt.Errorf("field %q: %w", f.Field, err))
+		}
+		values = append(values, fieldValue{target: f.Target, value: converted})
+	}
+
+	for _, fv := range values {
+		evalCtx.Set(fv.target, fv.value)
+	}
+	return nil
+}
+
+// Table returns the lookup table name.
+func (r *RecordLookupRule) Table() string {
+	return r.table
+}
+
+// Fields returns the record fields unpacked by this rule.
+func (r *RecordLookupRule) Fields() []RecordField {
+	return r.fields
+}
+
+// recordField extracts a named field from a map or struct record.
+func recordField(record any, field string) (any, bool) {
+	if m, ok := record.(map[string]any); ok {
+		v, ok := m[field]
+		return v, ok
+	}
+
+	rv := reflect.ValueOf(record)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, false
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		v := rv.MapIndex(reflect.ValueOf(field).Convert(rv.Type().Key()))
+		if !v.IsValid() {
+			return nil, false
+		}
+		return v.Interface(), true
+	case reflect.Struct:
+		v := rv.FieldByName(field)
+		if !v.IsValid() || !v.CanInterface() {
+			return nil, false
+		}
+		return v.Interface(), true
+	default:
+		return nil, false
+	}
+}
+
+// convertField converts a record field value to the requested type.
+func convertField(v any, t FieldType) (any, error) {
+	switch t {
+	case FieldFloat:
+		if s, ok := v.(string); ok {
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: cannot convert %q to float", ErrTypeMismatch, s)
+			}
+			return f, nil
+		}
+		return toFloat64(v)
+	case FieldString:
+		switch s := v.(type) {
+		case string:
+			return s, nil
+		case fmt.Stringer:
+			return s.String(), nil
+		}
+		if f, err := toFloat64(v); err == nil {
+			return strconv.FormatFloat(f, 'f', -1, 64), nil
+		}
+		if b, ok := v.(bool); ok {
+			return strconv.FormatBool(b), nil
+		}
+		return nil, fmt.Errorf("%w: cannot convert %T to string", ErrTypeMismatch, v)
+	case FieldBool:
+		switch b := v.(type) {
+		case bool:
+			return b, nil
+		case string:
+			parsed, err := strconv.ParseBool(b)
+			if err != nil {
+				return nil, fmt.Errorf("%w: cannot convert %q to bool", ErrTypeMismatch, b)
+			}
+			return parsed, nil
+		}
+		return nil, fmt.Errorf("%w: cannot convert %T to bool", ErrTypeMismatch, v)
+	default:
+		return v, nil
+	}
+}
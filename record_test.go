@@ -0,0 +1,191 @@
+package cortex_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kolosys/cortex"
+)
+
+type employee struct {
+	Salary float64
+	Title  string
+	Exempt bool
+}
+
+func TestRecordLookupMap(t *testing.T) {
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.RegisterLookup(cortex.NewMapLookup("employees", map[string]map[string]any{
+		"e1": {"salary": "85000.50", "grade": 7, "exempt": "true", "title": "Engineer"},
+	}))
+	evalCtx.Set("employee_id", "e1")
+
+	rule := cortex.MustRecordLookup(cortex.RecordLookupConfig{
+		ID:    "load-employee",
+		Table: "employees",
+		Key:   "employee_id",
+		Fields: []cortex.RecordField{
+			{Field: "salary", Target: "salary", Type: cortex.FieldFloat, Required: true},
+			{Field: "grade", Target: "grade", Type: cortex.FieldString},
+			{Field: "exempt", Target: "exempt", Type: cortex.FieldBool},
+			{Field: "title", Target: "title"},
+			{Field: "bonus", Target: "bonus", Type: cortex.FieldFloat},
+		},
+	})
+
+	if err := rule.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if salary, _ := evalCtx.GetFloat64("salary"); salary != 85000.50 {
+		t.Errorf("expected salary=85000.50, got %v", salary)
+	}
+	if grade, _ := evalCtx.GetString("grade"); grade != "7" {
+		t.Errorf("expected grade=\"7\", got %q", grade)
+	}
+	if exempt, _ := evalCtx.GetBool("exempt"); !exempt {
+		t.Error("expected exempt=true")
+	}
+	if title, _ := evalCtx.GetString("title"); title != "Engineer" {
+		t.Errorf("expected title=Engineer, got %q", title)
+	}
+	if evalCtx.Has("bonus") {
+		t.Error("optional missing field should not be set")
+	}
+}
+
+func TestRecordLookupStruct(t *testing.T) {
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.RegisterLookup(cortex.NewMapLookup("employees", map[string]employee{
+		"e1": {Salary: 50000, Title: "Analyst", Exempt: false},
+	}))
+	evalCtx.Set("employee_id", "e1")
+
+	rule := cortex.MustRecordLookup(cortex.RecordLookupConfig{
+		ID:    "load-employee",
+		Table: "employees",
+		Key:   "employee_id",
+		Fields: []cortex.RecordField{
+			{Field: "Salary", Target: "salary", Type: cortex.FieldFloat},
+			{Field: "Title", Target: "title", Type: cortex.FieldString},
+			{Field: "Exempt", Target: "exempt", Type: cortex.FieldBool},
+		},
+	})
+
+	if err := rule.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if salary, _ := evalCtx.GetFloat64("salary"); salary != 50000 {
+		t.Errorf("expected salary=50000, got %v", salary)
+	}
+	if title, _ := evalCtx.GetString("title"); title != "Analyst" {
+		t.Errorf("expected title=Analyst, got %q", title)
+	}
+	if exempt, err := evalCtx.GetBool("exempt"); err != nil || exempt {
+		t.Errorf("expected exempt=false, got %v (%v)", exempt, err)
+	}
+}
+
+func TestRecordLookupErrors(t *testing.T) {
+	newCtx := func() *cortex.EvalContext {
+		evalCtx := cortex.NewEvalContext()
+		evalCtx.RegisterLookup(cortex.NewMapLookup("employees", map[string]map[string]any{
+			"e1": {"salary": "n/a", "title": "Engineer"},
+		}))
+		return evalCtx
+	}
+
+	tests := []struct {
+		name   string
+		key    string
+		fields []cortex.RecordField
+		target error
+	}{
+		{"missing required field", "e1", []cortex.RecordField{
+			{Field: "title", Target: "title"},
+			{Field: "grade", Target: "grade", Required: true},
+		}, cortex.ErrValueNotFound},
+		{"bad conversion", "e1", []cortex.RecordField{
+			{Field: "salary", Target: "salary", Type: cortex.FieldFloat},
+		}, cortex.ErrTypeMismatch},
+		{"missing record", "e2", []cortex.RecordField{
+			{Field: "title", Target: "title"},
+		}, cortex.ErrKeyNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evalCtx := newCtx()
+			evalCtx.Set("employee_id", tt.key)
+
+			rule := cortex.MustRecordLookup(cortex.RecordLookupConfig{
+				ID:       "load-employee",
+				Table:    "employees",
+				Key:      "employee_id",
+				Fields:   tt.fields,
+				Required: true,
+			})
+
+			err := rule.Evaluate(context.Background(), evalCtx)
+			if !errors.Is(err, tt.target) {
+				t.Fatalf("expected %v, got %v", tt.target, err)
+			}
+			if evalCtx.Has("title") {
+				t.Error("no fields should be set when the rule fails")
+			}
+		})
+	}
+}
+
+func TestRecordLookupValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  cortex.RecordLookupConfig
+	}{
+		{"missing ID", cortex.RecordLookupConfig{Table: "t", Key: "k", Fields: []cortex.RecordField{{Field: "f", Target: "t"}}}},
+		{"missing table", cortex.RecordLookupConfig{ID: "r", Key: "k", Fields: []cortex.RecordField{{Field: "f", Target: "t"}}}},
+		{"missing key", cortex.RecordLookupConfig{ID: "r", Table: "t", Fields: []cortex.RecordField{{Field: "f", Target: "t"}}}},
+		{"no fields", cortex.RecordLookupConfig{ID: "r", Table: "t", Key: "k"}},
+		{"field without target", cortex.RecordLookupConfig{ID: "r", Table: "t", Key: "k", Fields: []cortex.RecordField{{Field: "f"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := cortex.NewRecordLookup(tt.cfg)
+			if !errors.Is(err, cortex.ErrInvalidRule) {
+				t.Errorf("expected ErrInvalidRule, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRecordLookupFieldOrder(t *testing.T) {
+	rule := cortex.MustRecordLookup(cortex.RecordLookupConfig{
+		ID:    "load",
+		Table: "records",
+		Key:   "id",
+		Fields: []cortex.RecordField{
+			{Field: "z", Target: "z"},
+			{Field: "a", Target: "a"},
+			{Field: "m", Target: "m"},
+		},
+	})
+
+	for range 20 {
+		evalCtx := cortex.NewOrderedEvalContext()
+		evalCtx.RegisterLookup(cortex.NewMapLookup("records", map[string]map[string]any{
+			"r1": {"a": 1, "m": 2, "z": 3},
+		}))
+		evalCtx.Set("id", "r1")
+
+		if err := rule.Evaluate(context.Background(), evalCtx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		keys := evalCtx.Keys()
+		if len(keys) != 4 || keys[1] != "z" || keys[2] != "a" || keys[3] != "m" {
+			t.Fatalf("expected fields set in config order, got %v", keys)
+		}
+	}
+}
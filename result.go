@@ -13,22 +13,65 @@ type Result struct {
 	// RulesEvaluated is the number of rules that were run.
 	RulesEvaluated int
 
+	// RulesSkipped is the number of rules whose When guard was false.
+	RulesSkipped int
+
 	// RulesFailed is the number of rules that failed.
 	RulesFailed int
 
 	// Errors contains all collected errors (in CollectAll mode).
 	Errors []RuleError
 
+	// Warnings contains the non-fatal issues rules recorded with
+	// EvalContext.AddWarning.
+	Warnings []Warning
+
 	// Duration is the total evaluation time.
 	Duration time.Duration
 
+	// RuleTimings lists how long each rule that ran took, in evaluation
+	// order, if Config.TrackRuleTimings is set.
+	RuleTimings []RuleTiming
+
 	// HaltedBy is the rule ID that halted evaluation (if any).
 	HaltedBy string
 
+	// TimedOut is set on the partial result returned with ErrTimeout in
+	// ModeCollectAll.
+	TimedOut bool
+
+	// RolledBack is set when a Config.Transactional evaluation failed and
+	// the context was restored to its state before evaluation.
+	RolledBack bool
+
 	// Context is the final evaluation context state.
 	Context *EvalContext
 }
 
+// RuleTiming is the duration of one rule's evaluation.
+type RuleTiming struct {
+	RuleID   string
+	Duration time.Duration
+}
+
+// Explain returns how the key's value was derived, if the evaluation ran
+// in explain mode. See EvalContext.Explain.
+func (r *Result) Explain(key string) (string, bool) {
+	if r.Context == nil {
+		return "", false
+	}
+	return r.Context.Explain(key)
+}
+
+// Provenance returns the ID of the rule that last wrote each key, if the
+// evaluation tracked provenance (see Config.TrackProvenance), or nil.
+func (r *Result) Provenance() map[string]string {
+	if r.Context == nil {
+		return nil
+	}
+	return r.Context.Provenance()
+}
+
 // HasErrors returns true if any errors were collected.
 func (r *Result) HasErrors() bool {
 	return len(r.Errors) > 0
@@ -57,8 +100,10 @@ func newResult(evalCtx *EvalContext, errors []RuleError) *Result {
 		ID:             evalCtx.ID,
 		Success:        len(errors) == 0 && !evalCtx.IsHalted(),
 		RulesEvaluated: int(evalCtx.RulesEvaluated()),
+		RulesSkipped:   int(evalCtx.RulesSkipped()),
 		RulesFailed:    len(errors),
 		Errors:         errors,
+		Warnings:       evalCtx.Warnings(),
 		Duration:       evalCtx.Duration(),
 		HaltedBy:       evalCtx.HaltedBy(),
 		Context:        evalCtx,
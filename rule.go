@@ -1,6 +1,11 @@
 package cortex
 
-import "context"
+import (
+	"context"
+	"fmt"
+
+	"github.com/kolosys/cortex/expr"
+)
 
 // Rule represents any rule that can be evaluated against an EvalContext.
 type Rule interface {
@@ -34,6 +39,12 @@ const (
 	RuleTypeAllocation RuleType = "allocation"
 	RuleTypeLookup     RuleType = "lookup"
 	RuleTypeBuildup    RuleType = "buildup"
+
+	RuleTypeRecordLookup RuleType = "record_lookup"
+	RuleTypeSubEngine    RuleType = "sub_engine"
+
+	RuleTypeVectorFormula RuleType = "vector_formula"
+	RuleTypeBuildupReset  RuleType = "buildup_reset"
 )
 
 // baseRule provides common fields for all rule types.
@@ -42,9 +53,64 @@ type baseRule struct {
 	name        string
 	description string
 	deps        []string
+	when        *expr.Expression
 }
 
 func (r *baseRule) ID() string             { return r.id }
 func (r *baseRule) Name() string           { return r.name }
 func (r *baseRule) Description() string    { return r.description }
 func (r *baseRule) Dependencies() []string { return r.deps }
+
+// When returns the rule's guard expression, or "" if it has none.
+func (r *baseRule) When() string {
+	if r.when == nil {
+		return ""
+	}
+	return r.when.Raw()
+}
+
+func (r *baseRule) guard() *expr.Expression { return r.when }
+
+// guardedRule is implemented by rules that may carry a When guard.
+type guardedRule interface {
+	guard() *expr.Expression
+}
+
+// compileWhen compiles a rule's When guard. An empty guard yields nil.
+func compileWhen(id, when string) (*expr.Expression, error) {
+	if when == "" {
+		return nil, nil
+	}
+	compiled, err := expr.Compile(when)
+	if err != nil {
+		return nil, fmt.Errorf("%w: rule %q when error: %v", ErrInvalidExpression, id, err)
+	}
+	return compiled, nil
+}
+
+// ruleGuard returns the rule's compiled When guard, if any.
+func ruleGuard(rule Rule) *expr.Expression {
+	if g, ok := rule.(guardedRule); ok {
+		return g.guard()
+	}
+	return nil
+}
+
+// shouldRun evaluates the rule's When guard. Rules without a guard always run.
+func shouldRun(ctx context.Context, rule Rule, evalCtx *EvalContext) (bool, error) {
+	guard := ruleGuard(rule)
+	if guard == nil {
+		return true, nil
+	}
+
+	v, err := guard.Eval(evalCtx.exprContext(ctx), evalCtx)
+	if err != nil {
+		return false, NewRuleError(rule.ID(), "", "when", err)
+	}
+	ok, isBool := v.(bool)
+	if !isBool {
+		return false, NewRuleError(rule.ID(), "", "when",
+			fmt.Errorf("%w: guard returned %T, expected bool", ErrTypeMismatch, v))
+	}
+	return ok, nil
+}
@@ -0,0 +1,127 @@
+package cortex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// MaxSubEngineDepth limits how deeply sub-engines may nest, guarding
+// against engines that (directly or indirectly) invoke themselves.
+const MaxSubEngineDepth = 16
+
+type subEngineDepthKey struct{}
+
+// SubEngineRule evaluates another engine as a single step.
+type SubEngineRule struct {
+	baseRule
+	engine    *Engine
+	namespace string
+}
+
+// SubEngineConfig configures a sub-engine rule.
+type SubEngineConfig struct {
+	ID          string
+	Name        string
+	Description string
+	Deps        []string
+	When        string // guard expression; the rule is skipped when it is false
+
+	// Engine is the engine to evaluate.
+	Engine *Engine
+
+	// Namespace, if set, evaluates the engine against a copy of the
+	// context and merges new or changed values back under
+	// "<namespace>.<key>". If empty, the engine shares the parent context.
+	Namespace string
+}
+
+// NewSubEngine creates a new sub-engine rule.
+func NewSubEngine(cfg SubEngineConfig) (*SubEngineRule, error) {
+	if cfg.ID == "" {
+		return nil, fmt.Errorf("%w: sub-engine rule requires ID", ErrInvalidRule)
+	}
+	if cfg.Engine == nil {
+		return nil, fmt.Errorf("%w: sub-engine rule %q requires engine", ErrInvalidRule, cfg.ID)
+	}
+
+	when, err := compileWhen(cfg.ID, cfg.When)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SubEngineRule{
+		baseRule: baseRule{
+			id:          cfg.ID,
+			name:        cfg.Name,
+			description: cfg.Description,
+			deps:        cfg.Deps,
+			when:        when,
+		},
+		engine:    cfg.Engine,
+		namespace: cfg.Namespace,
+	}, nil
+}
+
+// MustSubEngine creates a new sub-engine rule, panicking on error.
+func MustSubEngine(cfg SubEngineConfig) *SubEngineRule {
+	r, err := NewSubEngine(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Evaluate runs the sub-engine. Any error collected by the sub-engine is
+// returned so the parent engine handles it according to its own mode.
+func (r *SubEngineRule) Evaluate(ctx context.Context, evalCtx *EvalContext) error {
+	depth, _ := ctx.Value(subEngineDepthKey{}).(int)
+	if depth >= MaxSubEngineDepth {
+		return NewRuleError(r.id, string(RuleTypeSubEngine), "evaluate",
+			fmt.Errorf("%w: sub-engine depth exceeds %d", ErrCircularDep, MaxSubEngineDepth))
+	}
+	ctx = context.WithValue(ctx, subEngineDepthKey{}, depth+1)
+
+	target := evalCtx
+	var before map[string]any
+	if r.namespace != "" {
+		before = evalCtx.Values()
+		target = evalCtx.Clone()
+	}
+
+	result, err := r.engine.Evaluate(ctx, target)
+	if err != nil {
+		return NewRuleError(r.id, string(RuleTypeSubEngine), "evaluate", err)
+	}
+	if result.HasErrors() {
+		errs := make([]error, len(result.Errors))
+		for i := range result.Errors {
+			errs[i] = &result.Errors[i]
+		}
+		return NewRuleError(r.id, string(RuleTypeSubEngine), "evaluate", errors.Join(errs...))
+	}
+
+	if r.namespace != "" {
+		after := target.Values()
+		for _, k := range sortedKeys(after) {
+			v := after[k]
+			if old, ok := before[k]; ok && reflect.DeepEqual(old, v) {
+				continue
+			}
+			evalCtx.Set(r.namespace+"."+k, v)
+		}
+	}
+
+	return nil
+}
+
+// Engine returns the wrapped engine.
+func (r *SubEngineRule) Engine() *Engine {
+	return r.engine
+}
+
+// Namespace returns the namespace for merged results (if any).
+func (r *SubEngineRule) Namespace() string {
+	return r.namespace
+}
@@ -0,0 +1,168 @@
+package cortex_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kolosys/cortex"
+)
+
+func newTaxSubEngine(t *testing.T) *cortex.Engine {
+	t.Helper()
+	tax := cortex.New("tax", cortex.DefaultConfig())
+	err := tax.AddRules(
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID:         "calc-tax",
+			Target:     "tax",
+			Expression: "gross * 0.2",
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return tax
+}
+
+func TestSubEngineShared(t *testing.T) {
+	payroll := cortex.New("payroll", cortex.DefaultConfig())
+	payroll.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{
+			ID:     "set-gross",
+			Target: "gross",
+			Value:  1000.0,
+		}),
+		cortex.MustSubEngine(cortex.SubEngineConfig{
+			ID:     "tax",
+			Engine: newTaxSubEngine(t),
+		}),
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID:         "calc-net",
+			Target:     "net",
+			Expression: "gross - tax",
+		}),
+	)
+
+	evalCtx := cortex.NewEvalContext()
+	if _, err := payroll.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if net, _ := evalCtx.GetFloat64("net"); net != 800 {
+		t.Errorf("expected net=800, got %f", net)
+	}
+}
+
+func TestSubEngineNamespaced(t *testing.T) {
+	payroll := cortex.New("payroll", cortex.DefaultConfig())
+	payroll.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{
+			ID:     "set-gross",
+			Target: "gross",
+			Value:  1000.0,
+		}),
+		cortex.MustSubEngine(cortex.SubEngineConfig{
+			ID:        "tax",
+			Engine:    newTaxSubEngine(t),
+			Namespace: "tax",
+		}),
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID:     "calc-net",
+			Target: "net",
+			Formula: func(ctx context.Context, evalCtx *cortex.EvalContext) (any, error) {
+				gross, _ := evalCtx.GetFloat64("gross")
+				tax, err := evalCtx.GetFloat64("tax.tax")
+				return gross - tax, err
+			},
+		}),
+	)
+
+	evalCtx := cortex.NewEvalContext()
+	if _, err := payroll.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if evalCtx.Has("tax") {
+		t.Error("namespaced output should not be set at the top level")
+	}
+	if evalCtx.Has("tax.gross") {
+		t.Error("unchanged inputs should not be merged back")
+	}
+	if net, _ := evalCtx.GetFloat64("net"); net != 800 {
+		t.Errorf("expected net=800, got %f", net)
+	}
+}
+
+func TestSubEngineErrorPropagation(t *testing.T) {
+	childConfig := cortex.DefaultConfig()
+	childConfig.Mode = cortex.ModeCollectAll
+	child := cortex.New("child", childConfig)
+	child.AddRule(cortex.MustFormula(cortex.FormulaConfig{
+		ID:     "fail",
+		Target: "x",
+		Formula: func(ctx context.Context, evalCtx *cortex.EvalContext) (any, error) {
+			return nil, errors.New("intentional error")
+		},
+	}))
+
+	parentConfig := cortex.DefaultConfig()
+	parentConfig.Mode = cortex.ModeCollectAll
+	parent := cortex.New("parent", parentConfig)
+	parent.AddRules(
+		cortex.MustSubEngine(cortex.SubEngineConfig{ID: "child", Engine: child}),
+		cortex.MustAssignment(cortex.AssignmentConfig{
+			ID:     "after",
+			Target: "y",
+			Value:  1.0,
+		}),
+	)
+
+	evalCtx := cortex.NewEvalContext()
+	result, err := parent.Evaluate(context.Background(), evalCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].RuleID != "child" {
+		t.Fatalf("expected one error from sub-engine rule, got %v", result.Errors)
+	}
+	if !evalCtx.Has("y") {
+		t.Error("parent in collect-all mode should continue after sub-engine failure")
+	}
+}
+
+func TestSubEngineRecursionGuard(t *testing.T) {
+	engine := cortex.New("loop", cortex.DefaultConfig())
+	engine.AddRule(cortex.MustSubEngine(cortex.SubEngineConfig{ID: "self", Engine: engine}))
+
+	_, err := engine.Evaluate(context.Background(), cortex.NewEvalContext())
+	if !errors.Is(err, cortex.ErrCircularDep) {
+		t.Errorf("expected ErrCircularDep, got %v", err)
+	}
+}
+
+func TestSubEngineNamespacedDeterministic(t *testing.T) {
+	child := cortex.New("child", cortex.DefaultConfig())
+	for _, key := range []string{"d", "b", "e", "a", "c"} {
+		child.AddRule(cortex.MustAssignment(cortex.AssignmentConfig{ID: key, Target: key, Value: 1.0}))
+	}
+	parent := cortex.New("parent", cortex.DefaultConfig())
+	parent.AddRule(cortex.MustSubEngine(cortex.SubEngineConfig{ID: "child", Engine: child, Namespace: "ns"}))
+
+	var first []string
+	for i := range 20 {
+		evalCtx := cortex.NewOrderedEvalContext()
+		if _, err := parent.Evaluate(context.Background(), evalCtx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		keys := evalCtx.Keys()
+		if i == 0 {
+			first = keys
+			continue
+		}
+		for j := range first {
+			if keys[j] != first[j] {
+				t.Fatalf("run %d: expected %v, got %v", i, first, keys)
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package cortex
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kolosys/cortex/expr"
+)
+
+// outputRule is implemented by rules that can report the context keys they set.
+type outputRule interface {
+	outputs() []string
+}
+
+// expressionRule is implemented by rules that carry compiled expressions.
+// A rule must return every expression it compiles other than its When
+// guard, so that ValidateExpressions, RegisterExprFunc and the dependency
+// graph all see it; ruleExpressions adds the guard.
+type expressionRule interface {
+	expressions() []*expr.Expression
+}
+
+func (r *AssignmentRule) outputs() []string { return []string{r.target} }
+func (r *FormulaRule) outputs() []string    { return []string{r.target} }
+func (r *LookupRule) outputs() []string     { return []string{r.target} }
+
+func (r *VectorFormulaRule) outputs() []string { return []string{r.target} }
+
+func (r *BuildupRule) outputs() []string {
+	if r.target == "" {
+		return nil
+	}
+	return []string{r.target}
+}
+
+func (r *AllocationRule) outputs() []string {
+	keys := make([]string, 0, len(r.targets)+1)
+	for _, t := range r.targets {
+		keys = append(keys, t.Key)
+	}
+	if r.remainder != "" {
+		keys = append(keys, r.remainder)
+	}
+	if r.target != "" {
+		keys = append(keys, r.target)
+	}
+	if r.shareSuffix != "" {
+		for _, t := range r.targets {
+			keys = append(keys, t.Key+r.shareSuffix)
+		}
+	}
+	if r.drawDown {
+		keys = append(keys, r.source)
+	}
+	return keys
+}
+
+func (r *RecordLookupRule) outputs() []string {
+	keys := make([]string, len(r.fields))
+	for i, f := range r.fields {
+		keys[i] = f.Target
+	}
+	return keys
+}
+
+func (r *SubEngineRule) outputs() []string {
+	r.engine.mu.RLock()
+	rules := r.engine.rules
+	r.engine.mu.RUnlock()
+
+	var keys []string
+	for _, k := range producedKeys(rules) {
+		if r.namespace != "" {
+			k = r.namespace + "." + k
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (r *FormulaRule) expressions() []*expr.Expression {
+	if r.compiledExpr == nil {
+		return nil
+	}
+	return []*expr.Expression{r.compiledExpr}
+}
+
+func (r *VectorFormulaRule) expressions() []*expr.Expression {
+	return []*expr.Expression{r.compiledExpr}
+}
+
+// producedKeys returns every key set by the given rules.
+func producedKeys(rules []Rule) []string {
+	var keys []string
+	for _, rule := range rules {
+		if or, ok := rule.(outputRule); ok {
+			keys = append(keys, or.outputs()...)
+		}
+	}
+	return keys
+}
+
+// ValidateExpressions checks every compiled expression in the engine, from
+// formulas and When guards of any rule type to Config.StopWhen, against
+// the keys the rule set can produce. Inputs lists keys the caller seeds
+// into the context before evaluation. Each reference to a variable that is
+// neither an input nor set by any rule is reported as an error.
+func (e *Engine) ValidateExpressions(inputs ...string) error {
+	e.mu.RLock()
+	rules := e.rules
+	e.mu.RUnlock()
+
+	known := make(map[string]struct{}, len(inputs))
+	for _, k := range inputs {
+		known[k] = struct{}{}
+	}
+	for _, k := range producedKeys(rules) {
+		known[k] = struct{}{}
+	}
+
+	var errs []error
+	for _, rule := range rules {
+		for _, ex := range ruleExpressions(rule) {
+			for _, name := range ex.Variables() {
+				if _, ok := known[name]; !ok {
+					errs = append(errs, NewRuleError(rule.ID(), "", "validate",
+						fmt.Errorf("%w: %q references %q, which no rule sets", ErrInvalidExpression, ex.Raw(), name)))
+				}
+			}
+		}
+	}
+	if e.stopWhen != nil {
+		for _, name := range e.stopWhen.Variables() {
+			if _, ok := known[name]; !ok {
+				errs = append(errs, fmt.Errorf("%w: stop condition %q references %q, which no rule sets",
+					ErrInvalidExpression, e.stopWhen.Raw(), name))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,140 @@
+package cortex_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kolosys/cortex"
+)
+
+func TestValidateExpressions(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{
+			ID:     "set-rate",
+			Target: "rate",
+			Value:  0.2,
+		}),
+		cortex.MustAllocation(cortex.AllocationConfig{
+			ID:       "split",
+			Source:   "salary",
+			Strategy: cortex.StrategyEqual,
+			Targets:  []cortex.AllocationTarget{{Key: "a"}, {Key: "b"}},
+		}),
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID:         "calc-tax",
+			Target:     "tax",
+			Expression: "round(salary * rate + a + b, 2)",
+		}),
+	)
+
+	if err := engine.ValidateExpressions("salary"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err := engine.ValidateExpressions()
+	if !errors.Is(err, cortex.ErrInvalidExpression) {
+		t.Fatalf("expected ErrInvalidExpression, got %v", err)
+	}
+	if !strings.Contains(err.Error(), `"salary"`) {
+		t.Errorf("expected error to mention salary, got %v", err)
+	}
+}
+
+func TestValidateExpressionsTypo(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{
+			ID:     "set-salary",
+			Target: "salary",
+			Value:  1000.0,
+		}),
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID:         "calc-tax",
+			Target:     "tax",
+			Expression: "salray * 0.2",
+		}),
+	)
+
+	err := engine.ValidateExpressions()
+	var ruleErr *cortex.RuleError
+	if !errors.As(err, &ruleErr) || ruleErr.RuleID != "calc-tax" {
+		t.Fatalf("expected calc-tax rule error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), `"salray"`) {
+		t.Errorf("expected error to mention salray, got %v", err)
+	}
+}
+
+func TestValidateExpressionsGuardsAndStopWhen(t *testing.T) {
+	config := cortex.DefaultConfig()
+	config.StopWhen = "totl > 100"
+	engine := cortex.New("test", config)
+	engine.AddRules(
+		cortex.MustAllocation(cortex.AllocationConfig{
+			ID:       "split",
+			Source:   "salary",
+			Strategy: cortex.StrategyEqual,
+			Targets:  []cortex.AllocationTarget{{Key: "a"}, {Key: "b"}},
+			When:     "regoin == \"us\"",
+		}),
+		cortex.MustLookup(cortex.LookupConfig{
+			ID:     "rate",
+			Table:  "rates",
+			Key:    "grade",
+			Target: "rate",
+			When:   "a > treshold",
+		}),
+	)
+
+	err := engine.ValidateExpressions("salary", "grade")
+	for _, name := range []string{`"regoin"`, `"treshold"`, `"totl"`} {
+		if err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("expected error to mention %s, got %v", name, err)
+		}
+	}
+	if !errors.Is(err, cortex.ErrInvalidExpression) {
+		t.Errorf("expected ErrInvalidExpression, got %v", err)
+	}
+}
+
+func TestValidateExpressionsSubEngine(t *testing.T) {
+	child := cortex.New("child", cortex.DefaultConfig())
+	child.AddRule(cortex.MustAssignment(cortex.AssignmentConfig{
+		ID:     "set-rate",
+		Target: "rate",
+		Value:  0.2,
+	}))
+
+	engine := cortex.New("parent", cortex.DefaultConfig())
+	engine.AddRules(
+		cortex.MustSubEngine(cortex.SubEngineConfig{ID: "child", Engine: child}),
+		cortex.MustFormula(cortex.FormulaConfig{
+			ID:         "calc",
+			Target:     "x",
+			Expression: "rate * 2",
+		}),
+	)
+
+	if err := engine.ValidateExpressions(); err != nil {
+		t.Errorf("expected sub-engine outputs to be known, got %v", err)
+	}
+}
+
+func TestValidateExpressionsWhenGuard(t *testing.T) {
+	engine := cortex.New("test", cortex.DefaultConfig())
+	engine.AddRule(cortex.MustAssignment(cortex.AssignmentConfig{
+		ID:     "bonus",
+		Target: "bonus",
+		Value:  100.0,
+		When:   `teir == "gold"`,
+	}))
+
+	if err := engine.ValidateExpressions("tier"); err == nil || !strings.Contains(err.Error(), `"teir"`) {
+		t.Errorf("expected guard typo to be reported, got %v", err)
+	}
+	if err := engine.ValidateExpressions("teir"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
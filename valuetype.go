@@ -0,0 +1,92 @@
+package cortex
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// ValueType names the type a rule converts its value to before storing it.
+type ValueType string
+
+const (
+	ValueAny    ValueType = ""       // store values as produced
+	ValueInt    ValueType = "int"    // whole numbers and integer strings
+	ValueFloat  ValueType = "float"  // numbers and numeric strings
+	ValueString ValueType = "string" // strings, numbers and bools
+	ValueBool   ValueType = "bool"   // bools and "true"/"false" strings
+)
+
+// valid reports whether t is a known value type.
+func (t ValueType) valid() bool {
+	switch t {
+	case ValueAny, ValueInt, ValueFloat, ValueString, ValueBool:
+		return true
+	}
+	return false
+}
+
+// Convert returns v as type t. Conversions that would lose information,
+// such as 1.5 to int or "yes" to bool, fail with ErrTypeMismatch.
+func (t ValueType) Convert(v any) (any, error) {
+	switch t {
+	case ValueAny:
+		return v, nil
+
+	case ValueInt:
+		switch n := v.(type) {
+		case float64:
+			if n == math.Trunc(n) && n >= math.MinInt64 && n < math.MaxInt64 {
+				return int(n), nil
+			}
+		case float32:
+			return ValueInt.Convert(float64(n))
+		case string:
+			if i, err := strconv.Atoi(n); err == nil {
+				return i, nil
+			}
+		case bool:
+		default:
+			if i, err := toInt(v); err == nil {
+				return i, nil
+			}
+		}
+
+	case ValueFloat:
+		if s, ok := v.(string); ok {
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return f, nil
+			}
+			break
+		}
+		if f, err := toFloat64(v); err == nil {
+			return f, nil
+		}
+
+	case ValueString:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		if _, err := toFloat64(v); err == nil {
+			return fmt.Sprint(v), nil
+		}
+		if b, ok := v.(bool); ok {
+			return strconv.FormatBool(b), nil
+		}
+
+	case ValueBool:
+		switch b := v.(type) {
+		case bool:
+			return b, nil
+		case string:
+			if parsed, err := strconv.ParseBool(b); err == nil {
+				return parsed, nil
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("%w: unknown value type %q", ErrInvalidRule, string(t))
+	}
+
+	return nil, fmt.Errorf("%w: cannot convert %T %v to %s", ErrTypeMismatch, v, v, string(t))
+}
@@ -0,0 +1,86 @@
+package cortex_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kolosys/cortex"
+)
+
+func TestValueTypeConvert(t *testing.T) {
+	tests := []struct {
+		typ      cortex.ValueType
+		in       any
+		expected any
+	}{
+		{cortex.ValueAny, 1.5, 1.5},
+		{cortex.ValueInt, 3.0, 3},
+		{cortex.ValueInt, int64(7), 7},
+		{cortex.ValueInt, "42", 42},
+		{cortex.ValueFloat, 2, 2.0},
+		{cortex.ValueFloat, "2.5", 2.5},
+		{cortex.ValueString, 1.5, "1.5"},
+		{cortex.ValueString, 3, "3"},
+		{cortex.ValueString, true, "true"},
+		{cortex.ValueBool, "false", false},
+		{cortex.ValueBool, true, true},
+	}
+	for _, tt := range tests {
+		got, err := tt.typ.Convert(tt.in)
+		if err != nil {
+			t.Errorf("%s(%v): unexpected error: %v", tt.typ, tt.in, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("%s(%v): expected %v (%T), got %v (%T)", tt.typ, tt.in, tt.expected, tt.expected, got, got)
+		}
+	}
+
+	invalid := []struct {
+		typ cortex.ValueType
+		in  any
+	}{
+		{cortex.ValueInt, 1.5},
+		{cortex.ValueInt, "abc"},
+		{cortex.ValueInt, true},
+		{cortex.ValueFloat, "abc"},
+		{cortex.ValueString, []any{1}},
+		{cortex.ValueBool, 1.0},
+		{cortex.ValueBool, "yes"},
+	}
+	for _, tt := range invalid {
+		if _, err := tt.typ.Convert(tt.in); !errors.Is(err, cortex.ErrTypeMismatch) {
+			t.Errorf("%s(%v): expected ErrTypeMismatch, got %v", tt.typ, tt.in, err)
+		}
+	}
+}
+
+func TestRuleValueTypes(t *testing.T) {
+	if _, err := cortex.NewAssignment(cortex.AssignmentConfig{
+		ID: "a", Target: "a", Value: 1.5, ValueType: cortex.ValueInt,
+	}); !errors.Is(err, cortex.ErrTypeMismatch) {
+		t.Errorf("expected ErrTypeMismatch, got %v", err)
+	}
+	if _, err := cortex.NewFormula(cortex.FormulaConfig{
+		ID: "f", Target: "f", Expression: "1", ResultType: "decimal",
+	}); !errors.Is(err, cortex.ErrInvalidRule) {
+		t.Errorf("expected ErrInvalidRule, got %v", err)
+	}
+
+	engine := cortex.New("test", nil)
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "a", Target: "a", Value: 4.0, ValueType: cortex.ValueInt}),
+		cortex.MustFormula(cortex.FormulaConfig{ID: "f", Target: "f", Expression: "a * 2.5", ResultType: cortex.ValueString}),
+	)
+	evalCtx := cortex.NewEvalContext()
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("evaluate error: %v", err)
+	}
+	if a, _ := evalCtx.Get("a"); a != 4 {
+		t.Errorf("expected int 4, got %v (%T)", a, a)
+	}
+	if f, _ := evalCtx.Get("f"); f != "10" {
+		t.Errorf("expected \"10\", got %v (%T)", f, f)
+	}
+}
@@ -0,0 +1,193 @@
+package cortex
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/kolosys/cortex/expr"
+)
+
+// VectorFormulaRule applies an expression element-wise across slice-valued
+// context keys, storing the results as a []float64.
+type VectorFormulaRule struct {
+	baseRule
+	target       string
+	inputs       []string // slice-valued keys, indexed per element
+	expression   string
+	compiledExpr *expr.Expression
+}
+
+// VectorFormulaConfig configures a vector formula rule.
+type VectorFormulaConfig struct {
+	ID          string
+	Name        string
+	Description string
+	Deps        []string
+	When        string // guard expression; the rule is skipped when it is false
+
+	// Target is the context key to store the result slice.
+	Target string
+
+	// Inputs are the slice-valued context keys. Within the expression
+	// each names the current element; other variables read the context
+	// as usual, so scalars can be combined with every element. If empty,
+	// every variable in the expression is an input.
+	Inputs []string
+
+	// Expression is evaluated once per index and must produce a number.
+	Expression string
+
+	// Evaluator, if set, compiles Expression with it; see
+	// FormulaConfig.Evaluator.
+	Evaluator *expr.Evaluator
+}
+
+// NewVectorFormula creates a new vector formula rule.
+func NewVectorFormula(cfg VectorFormulaConfig) (*VectorFormulaRule, error) {
+	if cfg.ID == "" {
+		return nil, fmt.Errorf("%w: vector formula rule requires ID", ErrInvalidRule)
+	}
+	if cfg.Target == "" {
+		return nil, fmt.Errorf("%w: vector formula rule %q requires target", ErrInvalidRule, cfg.ID)
+	}
+	if cfg.Expression == "" {
+		return nil, fmt.Errorf("%w: vector formula rule %q requires expression", ErrInvalidRule, cfg.ID)
+	}
+
+	compiledExpr, err := expr.CompileWith(cfg.Expression, cfg.Evaluator)
+	if err != nil {
+		return nil, fmt.Errorf("%w: vector formula rule %q expression error: %v", ErrInvalidExpression, cfg.ID, err)
+	}
+
+	inputs := cfg.Inputs
+	if len(inputs) == 0 {
+		inputs = compiledExpr.Variables()
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("%w: vector formula rule %q has no slice inputs", ErrInvalidRule, cfg.ID)
+	}
+
+	when, err := compileWhen(cfg.ID, cfg.When)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VectorFormulaRule{
+		baseRule: baseRule{
+			id:          cfg.ID,
+			name:        cfg.Name,
+			description: cfg.Description,
+			deps:        cfg.Deps,
+			when:        when,
+		},
+		target:       cfg.Target,
+		inputs:       inputs,
+		expression:   cfg.Expression,
+		compiledExpr: compiledExpr,
+	}, nil
+}
+
+// MustVectorFormula creates a new vector formula rule, panicking on error.
+func MustVectorFormula(cfg VectorFormulaConfig) *VectorFormulaRule {
+	r, err := NewVectorFormula(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Evaluate applies the expression to each index of the input slices.
+func (r *VectorFormulaRule) Evaluate(ctx context.Context, evalCtx *EvalContext) error {
+	columns := make(map[string][]float64, len(r.inputs))
+	n := -1
+	for _, key := range r.inputs {
+		v, ok := evalCtx.Get(key)
+		if !ok {
+			return NewRuleError(r.id, string(RuleTypeVectorFormula), "evaluate",
+				fmt.Errorf("%w: %s", ErrValueNotFound, key))
+		}
+		column, err := toFloat64Slice(v)
+		if err != nil {
+			return NewRuleError(r.id, string(RuleTypeVectorFormula), "evaluate",
+				fmt.Errorf("input %q: %w", key, err))
+		}
+		if n >= 0 && len(column) != n {
+			return NewRuleError(r.id, string(RuleTypeVectorFormula), "evaluate",
+				fmt.Errorf("%w: input %q has %d elements, %q has %d", ErrTypeMismatch, key, len(column), r.inputs[0], n))
+		}
+		n = len(column)
+		columns[key] = column
+	}
+
+	ctx = evalCtx.exprContext(ctx)
+	results := make([]float64, n)
+	getter := &elementGetter{evalCtx: evalCtx, columns: columns}
+	for i := range results {
+		getter.index = i
+		v, err := r.compiledExpr.Eval(ctx, getter)
+		if err != nil {
+			return NewRuleError(r.id, string(RuleTypeVectorFormula), "evaluate",
+				fmt.Errorf("element %d: %w", i, err))
+		}
+		f, err := toFloat64(v)
+		if err != nil {
+			return NewRuleError(r.id, string(RuleTypeVectorFormula), "evaluate",
+				fmt.Errorf("element %d: %w", i, err))
+		}
+		results[i] = f
+	}
+
+	evalCtx.Set(r.target, results)
+	return nil
+}
+
+// elementGetter resolves input keys to one element of their column and
+// everything else from the evaluation context.
+type elementGetter struct {
+	evalCtx *EvalContext
+	columns map[string][]float64
+	index   int
+}
+
+func (g *elementGetter) Get(key string) (any, bool) {
+	if column, ok := g.columns[key]; ok {
+		return column[g.index], true
+	}
+	return g.evalCtx.Get(key)
+}
+
+// toFloat64Slice converts a slice or array of numbers to []float64.
+func toFloat64Slice(v any) ([]float64, error) {
+	if f, ok := v.([]float64); ok {
+		return f, nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("%w: expected slice, got %T", ErrTypeMismatch, v)
+	}
+	out := make([]float64, rv.Len())
+	for i := range out {
+		f, err := toFloat64(rv.Index(i).Interface())
+		if err != nil {
+			return nil, fmt.Errorf("element %d: %w", i, err)
+		}
+		out[i] = f
+	}
+	return out, nil
+}
+
+// Target returns the target key for this rule.
+func (r *VectorFormulaRule) Target() string {
+	return r.target
+}
+
+// Inputs returns the slice-valued input keys.
+func (r *VectorFormulaRule) Inputs() []string {
+	return r.inputs
+}
+
+// Expression returns the expression string.
+func (r *VectorFormulaRule) Expression() string {
+	return r.expression
+}
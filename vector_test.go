@@ -0,0 +1,84 @@
+package cortex_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/kolosys/cortex"
+)
+
+func TestVectorFormula(t *testing.T) {
+	rule := cortex.MustVectorFormula(cortex.VectorFormulaConfig{
+		ID:         "product",
+		Target:     "products",
+		Expression: "a * b",
+	})
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("a", []float64{1, 2, 3})
+	evalCtx.Set("b", []int{4, 5, 6})
+
+	if err := rule.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, _ := evalCtx.Get("products")
+	if !reflect.DeepEqual(got, []float64{4, 10, 18}) {
+		t.Errorf("expected [4 10 18], got %v", got)
+	}
+}
+
+func TestVectorFormulaScalars(t *testing.T) {
+	engine := cortex.New("test", nil)
+	engine.AddRules(
+		cortex.MustAssignment(cortex.AssignmentConfig{ID: "rate", Target: "rate", Value: 0.5}),
+		cortex.MustVectorFormula(cortex.VectorFormulaConfig{
+			ID:         "scaled",
+			Target:     "scaled",
+			Inputs:     []string{"scores"},
+			Expression: "round(scores * rate, 1)",
+		}),
+	)
+
+	evalCtx := cortex.NewEvalContext()
+	evalCtx.Set("scores", []any{1.0, 3, int64(5)})
+	if _, err := engine.Evaluate(context.Background(), evalCtx); err != nil {
+		t.Fatalf("evaluate error: %v", err)
+	}
+	got, _ := evalCtx.Get("scaled")
+	if !reflect.DeepEqual(got, []float64{0.5, 1.5, 2.5}) {
+		t.Errorf("expected [0.5 1.5 2.5], got %v", got)
+	}
+}
+
+func TestVectorFormulaErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   any
+		target error
+	}{
+		{"length mismatch", []float64{1, 2}, []float64{1}, cortex.ErrTypeMismatch},
+		{"non-numeric element", []any{1.0, "x"}, []float64{1, 2}, cortex.ErrTypeMismatch},
+		{"not a slice", 3.0, []float64{1}, cortex.ErrTypeMismatch},
+		{"missing input", nil, []float64{1}, cortex.ErrValueNotFound},
+	}
+
+	rule := cortex.MustVectorFormula(cortex.VectorFormulaConfig{ID: "v", Target: "v", Expression: "a * b"})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evalCtx := cortex.NewEvalContext()
+			if tt.a != nil {
+				evalCtx.Set("a", tt.a)
+			}
+			evalCtx.Set("b", tt.b)
+			if err := rule.Evaluate(context.Background(), evalCtx); !errors.Is(err, tt.target) {
+				t.Errorf("expected %v, got %v", tt.target, err)
+			}
+		})
+	}
+
+	if _, err := cortex.NewVectorFormula(cortex.VectorFormulaConfig{ID: "v", Target: "v", Expression: "1 + 2"}); !errors.Is(err, cortex.ErrInvalidRule) {
+		t.Errorf("expected ErrInvalidRule without inputs, got %v", err)
+	}
+}